@@ -21,10 +21,12 @@ import (
 	"go/token"
 	"go/types"
 	"log"
+	"math"
 	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/goplus/gogen/internal"
 	"github.com/goplus/gogen/internal/typesalias"
@@ -172,7 +174,7 @@ retry:
 	case *typesalias.Alias:
 		return toAliasType(pkg, t)
 	}
-	log.Panicln("TODO: toType -", reflect.TypeOf(typ))
+	panicln("TODO: toType -", reflect.TypeOf(typ))
 	return nil
 }
 
@@ -220,6 +222,8 @@ func toArrayType(pkg *Package, t *types.Array) ast.Expr {
 	var len ast.Expr
 	if n := t.Len(); n < 0 {
 		len = &ast.Ellipsis{}
+	} else if e, ok := pkg.arrayLens[t]; ok {
+		len = e
 	} else {
 		len = &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(t.Len(), 10)}
 	}
@@ -259,6 +263,38 @@ func toInterface(pkg *Package, t *types.Interface) ast.Expr {
 // -----------------------------------------------------------------------------
 // expression
 
+// Literal represents a basic literal with its original textual form, eg.
+// 0xFF, 1_000_000, 1e9, 0o755 or '\n'. The text is kept in generated code,
+// while Value is used for constant folding and type checking.
+type Literal struct {
+	Kind  token.Token    // token.INT, token.FLOAT, token.IMAG, token.CHAR or token.STRING
+	Text  string         // literal text, eg. 0xFF
+	Value constant.Value // value of the literal (nil means evaluating it from Text)
+}
+
+func toBasicLit(pkg *Package, lit *ast.BasicLit, val constant.Value, src ast.Node) *internal.Elem {
+	cval := constant.MakeFromLiteral(lit.Value, lit.Kind, 0)
+	if cval.Kind() == constant.Unknown {
+		pkg.cb.panicCodeErrorf(getSrcPos(src), getSrcEnd(src), "invalid %v literal %s", lit.Kind, lit.Value)
+	}
+	if val != nil && !constEqual(val, cval) {
+		pkg.cb.panicCodeErrorf(getSrcPos(src), getSrcEnd(src), "literal %s doesn't match its value %v", lit.Value, val)
+	}
+	return &internal.Elem{
+		Val:  lit,
+		Type: types.Typ[toBasicKind(lit.Kind)],
+		CVal: cval,
+		Src:  src,
+	}
+}
+
+func constEqual(a, b constant.Value) bool {
+	if ka, kb := a.Kind(), b.Kind(); ka != kb && (ka < constant.Int || kb < constant.Int) {
+		return false // numeric kinds can be compared with each other
+	}
+	return constant.Compare(a, token.EQL, b)
+}
+
 func toExpr(pkg *Package, val interface{}, src ast.Node) *internal.Elem {
 	if val == nil {
 		return &internal.Elem{
@@ -269,12 +305,9 @@ func toExpr(pkg *Package, val interface{}, src ast.Node) *internal.Elem {
 	}
 	switch v := val.(type) {
 	case *ast.BasicLit:
-		return &internal.Elem{
-			Val:  v,
-			Type: types.Typ[toBasicKind(v.Kind)],
-			CVal: constant.MakeFromLiteral(v.Value, v.Kind, 0),
-			Src:  src,
-		}
+		return toBasicLit(pkg, v, nil, src)
+	case *Literal:
+		return toBasicLit(pkg, &ast.BasicLit{Kind: v.Kind, Value: v.Text}, v.Value, src)
 	case *types.TypeName:
 		switch typ := v.Type(); typ.(type) {
 		case *TyInstruction: // instruction as a type
@@ -336,20 +369,241 @@ func toExpr(pkg *Package, val interface{}, src ast.Node) *internal.Elem {
 			Src:  src,
 		}
 	case float64:
-		val := strconv.FormatFloat(v, 'g', -1, 64)
-		if !strings.ContainsAny(val, ".e") {
-			val += ".0"
+		if isSpecialFloat(v) {
+			return specialFloatExpr(pkg, v, src)
 		}
 		return &internal.Elem{
-			Val:  &ast.BasicLit{Kind: token.FLOAT, Value: val},
+			Val:  floatLit(strconv.FormatFloat(v, 'g', -1, 64)),
 			Type: types.Typ[types.UntypedFloat],
 			CVal: constant.MakeFloat64(v),
 			Src:  src,
 		}
+	case complex128:
+		if isSpecialFloat(real(v)) || isSpecialFloat(imag(v)) {
+			return specialComplexExpr(pkg, v, src)
+		}
+		return constExpr(complexConst(v), src)
+	case int8:
+		return typedConstExpr(pkg, types.Int8, constant.MakeInt64(int64(v)), src)
+	case int16:
+		return typedConstExpr(pkg, types.Int16, constant.MakeInt64(int64(v)), src)
+	case int64:
+		return typedConstExpr(pkg, types.Int64, constant.MakeInt64(v), src)
+	case uint:
+		return typedConstExpr(pkg, types.Uint, constant.MakeUint64(uint64(v)), src)
+	case uint8:
+		return typedConstExpr(pkg, types.Uint8, constant.MakeUint64(uint64(v)), src)
+	case uint16:
+		return typedConstExpr(pkg, types.Uint16, constant.MakeUint64(uint64(v)), src)
+	case uint32:
+		return typedConstExpr(pkg, types.Uint32, constant.MakeUint64(uint64(v)), src)
+	case uint64:
+		return typedConstExpr(pkg, types.Uint64, constant.MakeUint64(v), src)
+	case uintptr:
+		return typedConstExpr(pkg, types.Uintptr, constant.MakeUint64(uint64(v)), src)
+	case float32:
+		if f := float64(v); isSpecialFloat(f) {
+			ret := specialFloatExpr(pkg, f, src)
+			ret.Val = &ast.CallExpr{Fun: ident("float32"), Args: []ast.Expr{ret.Val}}
+			ret.Type = types.Typ[types.Float32]
+			return ret
+		}
+		return typedConstExpr(pkg, types.Float32, constant.MakeFloat64(float64(v)), src)
+	case complex64:
+		if c := complex128(v); isSpecialFloat(real(c)) || isSpecialFloat(imag(c)) {
+			ret := specialComplexExpr(pkg, c, src)
+			ret.Val = &ast.CallExpr{Fun: ident("complex64"), Args: []ast.Expr{ret.Val}}
+			ret.Type = types.Typ[types.Complex64]
+			return ret
+		}
+		return typedConstExpr(pkg, types.Complex64, complexConst(complex128(v)), src)
+	case constant.Value:
+		return constExpr(v, src)
 	}
 	panic("unexpected: unsupport value type")
 }
 
+// typedConstExpr converts a constant to a typed constant of basic kind, which
+// is rendered with a conversion (eg. uint8(7)), since a literal alone is of
+// the default type.
+func typedConstExpr(pkg *Package, kind types.BasicKind, val constant.Value, src ast.Node) *internal.Elem {
+	t := types.Typ[kind]
+	lit := constLit(pkg, t, val)
+	if lit == nil { // complex, or integer out of range on the target platform
+		lit = constExpr(val, nil).Val
+	}
+	return &internal.Elem{
+		Val: &ast.CallExpr{Fun: ident(t.Name()), Args: []ast.Expr{lit}}, Type: t, CVal: val, Src: src,
+	}
+}
+
+// stringConstExpr returns the literal of a string constant val of type typ:
+// "..." if typ is untyped, and T("...") otherwise.
+func stringConstExpr(pkg *Package, typ types.Type, val constant.Value) ast.Expr {
+	lit := stringLit(constant.StringVal(val))
+	if t, ok := typ.(*types.Basic); ok && t.Kind() == types.UntypedString {
+		return lit
+	}
+	return &ast.CallExpr{Fun: toType(pkg, typ), Args: []ast.Expr{lit}}
+}
+
+func complexConst(v complex128) constant.Value {
+	re, im := constant.MakeFloat64(real(v)), constant.MakeFloat64(imag(v))
+	if re.Kind() == constant.Unknown || im.Kind() == constant.Unknown {
+		panic("unexpected: complex value isn't finite")
+	}
+	return constant.BinaryOp(re, token.ADD, constant.MakeImag(im))
+}
+
+// isSpecialFloat reports whether v has no constant literal: NaN, ±Inf or -0.
+func isSpecialFloat(v float64) bool {
+	return math.IsNaN(v) || math.IsInf(v, 0) || (v == 0 && math.Signbit(v))
+}
+
+// specialFloatExpr returns an expression of NaN, ±Inf or -0, which can't be
+// represented by a constant: math.NaN(), math.Inf(±1) or math.Copysign(0, -1).
+func specialFloatExpr(pkg *Package, v float64, src ast.Node) *internal.Elem {
+	var name string
+	var args []ast.Expr
+	switch {
+	case math.IsNaN(v):
+		name = "NaN"
+	case math.IsInf(v, 1):
+		name, args = "Inf", []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "1"}}
+	case math.IsInf(v, -1):
+		name, args = "Inf", []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "-1"}}
+	default:
+		name, args = "Copysign", []ast.Expr{
+			&ast.BasicLit{Kind: token.INT, Value: "0"}, &ast.BasicLit{Kind: token.INT, Value: "-1"}}
+	}
+	fn := toObjectExpr(pkg, pkg.Import("math").Ref(name))
+	return &internal.Elem{
+		Val:  &ast.CallExpr{Fun: fn, Args: args},
+		Type: types.Typ[types.Float64],
+		Src:  src,
+	}
+}
+
+// specialComplexExpr converts a complex128 value with a NaN, ±Inf or -0 part
+// to complex(re, im), since such a value has no constant literal.
+func specialComplexExpr(pkg *Package, v complex128, src ast.Node) *internal.Elem {
+	part := func(f float64) ast.Expr {
+		if isSpecialFloat(f) {
+			return specialFloatExpr(pkg, f, nil).Val
+		}
+		return floatLit(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return &internal.Elem{
+		Val:  &ast.CallExpr{Fun: ident("complex"), Args: []ast.Expr{part(real(v)), part(imag(v))}},
+		Type: types.Typ[types.Complex128],
+		Src:  src,
+	}
+}
+
+// constExpr converts an untyped constant to an expression. Floats keep their
+// full precision, so the value can feed further constant arithmetic exactly.
+func constExpr(val constant.Value, src ast.Node) *internal.Elem {
+	var expr ast.Expr
+	switch val.Kind() {
+	case constant.Bool:
+		expr = boolean(constant.BoolVal(val))
+	case constant.String:
+		expr = stringLit(constant.StringVal(val))
+	case constant.Int:
+		expr = &ast.BasicLit{Kind: token.INT, Value: val.ExactString()}
+	case constant.Float:
+		expr = floatLit(exactFloatText(val))
+	case constant.Complex:
+		im := &ast.BasicLit{Kind: token.IMAG, Value: exactFloatText(constant.ToFloat(constant.Imag(val))) + "i"}
+		if re := constant.Real(val); constant.Sign(re) != 0 {
+			expr = &ast.BinaryExpr{X: floatLit(exactFloatText(constant.ToFloat(re))), Op: token.ADD, Y: im}
+		} else {
+			expr = im
+		}
+	default:
+		panic("unexpected: unknown constant")
+	}
+	return &internal.Elem{Val: expr, Type: untypedConstType(val), CVal: val, Src: src}
+}
+
+// exactFloatText returns the text of a float constant without losing
+// precision beyond float64 where possible.
+func exactFloatText(val constant.Value) string {
+	if f, exact := constant.Float64Val(val); exact {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	switch v := constant.Val(val).(type) {
+	case *big.Float:
+		return v.Text('g', -1)
+	case *big.Rat:
+		return new(big.Float).SetPrec(512).SetRat(v).Text('g', -1)
+	}
+	return val.ExactString()
+}
+
+func floatLit(val string) *ast.BasicLit {
+	if !strings.ContainsAny(val, ".e") {
+		val += ".0"
+	}
+	return &ast.BasicLit{Kind: token.FLOAT, Value: val}
+}
+
+// constLit converts a constant to a literal of basic type t. It returns nil
+// if val isn't representable by t.
+func constLit(pkg *Package, t *types.Basic, val constant.Value) ast.Expr {
+	info := t.Info()
+	switch {
+	case info&types.IsBoolean != 0:
+		if val.Kind() == constant.Bool {
+			return boolean(constant.BoolVal(val))
+		}
+	case info&types.IsString != 0:
+		if val.Kind() == constant.String {
+			return stringLit(constant.StringVal(val))
+		}
+	case info&types.IsInteger != 0:
+		if cv := constant.ToInt(val); cv.Kind() == constant.Int {
+			if kind := t.Kind(); kind >= types.Int && kind <= types.Uintptr && outOfRange(pkg, kind, cv) {
+				return nil
+			}
+			return &ast.BasicLit{Kind: token.INT, Value: cv.ExactString()}
+		}
+	case info&types.IsFloat != 0:
+		if cv := constant.ToFloat(val); cv.Kind() == constant.Float {
+			switch t.Kind() {
+			case types.Float32: // round to float32 directly, not via float64
+				if f, _ := constant.Float32Val(cv); !math.IsInf(float64(f), 0) {
+					return floatLit(strconv.FormatFloat(float64(f), 'g', -1, 32))
+				}
+			case types.Float64:
+				if f, _ := constant.Float64Val(cv); !math.IsInf(f, 0) {
+					return floatLit(strconv.FormatFloat(f, 'g', -1, 64))
+				}
+			default:
+				return floatLit(exactFloatText(cv))
+			}
+		}
+	}
+	return nil
+}
+
+// untypedConstType returns the untyped type of a constant value.
+func untypedConstType(val constant.Value) types.Type {
+	switch val.Kind() {
+	case constant.Bool:
+		return types.Typ[types.UntypedBool]
+	case constant.String:
+		return types.Typ[types.UntypedString]
+	case constant.Int:
+		return types.Typ[types.UntypedInt]
+	case constant.Float:
+		return types.Typ[types.UntypedFloat]
+	case constant.Complex:
+		return types.Typ[types.UntypedComplex]
+	}
+	return types.Typ[types.Invalid]
+}
+
 var (
 	iotaObj types.Object
 )
@@ -377,6 +631,18 @@ func chgObject(pkg *Package, v types.Object, old *internal.Elem) *internal.Elem
 }
 
 func toObject(pkg *Package, v types.Object, src ast.Node) *internal.Elem {
+	if t, ok := v.Type().(*TyLaterRef); ok { // forward reference
+		if o := t.Resolve(); o != nil {
+			return toObject(pkg, o, src)
+		}
+		return t.use(src)
+	}
+	if v.Pkg() == pkg.builtin.Types {
+		if minor := builtinGoVersions[v.Name()]; !pkg.allowGoVersion(minor) {
+			pkg.cb.panicCodeErrorf(getSrcPos(src), getSrcEnd(src),
+				"cannot use builtin %s: requires go1.%d or later (GoVersion is %s)", v.Name(), minor, pkg.conf.GoVersion)
+		}
+	}
 	var cval constant.Value
 	if cv, ok := v.(*types.Const); ok {
 		cval = cv.Val()
@@ -596,6 +862,38 @@ func getParam1st(sig *types.Signature) int {
 	return 0
 }
 
+// matchOverloadByResults returns the only candidate of fns which matches
+// args and returns want results, see Config.OverloadByResults. It returns nil
+// if no such candidate, and a call with an error if more than one.
+func matchOverloadByResults(pkg *Package, fn *internal.Elem, fns []types.Object,
+	args []*internal.Elem, backup []backupElem, flags InstrFlags, want int) (ret *byResultsCall) {
+	for _, o := range fns {
+		if numResults(o) != want {
+			continue
+		}
+		restoreArgs(args, backup)
+		alt, err := matchFuncCall(pkg, chgObject(pkg, o, fn), args, flags)
+		if err != nil {
+			continue
+		}
+		if ret != nil {
+			src, pos, end := pkg.cb.loadExpr(fn.Src)
+			ret.err = pkg.cb.newCodeErrorf(
+				pos, end, "ambiguous call of %s: both %s and %s return %d results", src, ret.obj.Name(), o.Name(), want)
+			return
+		}
+		ret = &byResultsCall{alt: alt, obj: o}
+	}
+	return
+}
+
+func numResults(o types.Object) int {
+	if sig, ok := o.Type().(*types.Signature); ok {
+		return sig.Results().Len()
+	}
+	return -1
+}
+
 // TODO: check if fn.recv != nil
 func matchFuncCall(pkg *Package, fn *internal.Elem, args []*internal.Elem, flags InstrFlags) (ret *internal.Elem, err error) {
 	fnType := fn.Type
@@ -637,8 +935,18 @@ retry:
 			switch ft := fex.(type) {
 			case *TyOverloadFunc:
 				backup := backupArgs(args)
-				for _, o := range ft.Funcs {
+				for i, o := range ft.Funcs {
 					if ret, err = matchFuncCall(pkg, chgObject(pkg, o, fn), args, flags); err == nil {
+						if want := pkg.cb.expectRets; want > 0 && numResults(o) != want {
+							br := matchOverloadByResults(pkg, fn, ft.Funcs[i+1:], args, backup, flags, want)
+							if br != nil {
+								restoreArgs(args, backup)
+								if ret, err = matchFuncCall(pkg, chgObject(pkg, o, fn), args, flags); err != nil {
+									return
+								}
+								br.ret, pkg.cb.byResults = ret, br
+							}
+						}
 						if ret.CVal == nil && isUntyped(pkg, ret.Type) {
 							ret.CVal = builtinCall(fn, args)
 						}
@@ -720,6 +1028,11 @@ retry:
 		}
 	case *TyInstruction:
 		return t.instr.Call(pkg, args, flags, fn.Src)
+	case *TyLaterRef:
+		if o := t.Resolve(); o != nil {
+			return matchFuncCall(pkg, toObject(pkg, o, fn.Src), args, flags)
+		}
+		return t.call(pkg, fn, args, flags)
 	case *TypeType: // type convert
 		if on, ok := CheckOverloadNamed(t.typ); ok {
 			return matchOverloadNamedTypeCast(pkg, on.Obj, fn.Src, args, flags)
@@ -787,6 +1100,12 @@ func matchTypeCast(pkg *Package, typ types.Type, fn *internal.Elem, args []*inte
 		fnVal = &ast.ParenExpr{X: fnVal}
 	}
 	if len(args) == 1 && ConvertibleTo(pkg, args[0].Type, typ) {
+		if minor := sliceConvVersion(pkg, args[0].Type, typ); !pkg.allowGoVersion(minor) {
+			src, pos, end := pkg.cb.loadExpr(args[0].Src)
+			err = pkg.cb.newCodeErrorf(pos, end, "cannot convert %v (type %v) to type %v: requires go1.%d or later (GoVersion is %s)",
+				src, args[0].Type, typ, minor, pkg.conf.GoVersion)
+			return
+		}
 		if args[0].CVal != nil {
 			if t, ok := typ.(*types.Named); ok {
 				o := t.Obj()
@@ -859,8 +1178,33 @@ func matchTypeCast(pkg *Package, typ types.Type, fn *internal.Elem, args []*inte
 		// T() means to return zero value of T
 		return pkg.cb.ZeroLit(typ).stk.Pop(), nil
 	}
+	if len(args) == 1 && isSliceType(pkg, args[0].Type) && isSliceType(pkg, typ) {
+		// []T => []U is allowed only if they have identical underlying types
+		src, pos, end := pkg.cb.loadExpr(args[0].Src)
+		return nil, pkg.cb.newCodeErrorf(pos, end, "cannot convert %v (type %v) to type %v", src, args[0].Type, typ)
+	}
+	if len(args) == 1 {
+		if reason := chanDirReason(pkg, args[0].Type, typ); reason != "" {
+			src, pos, end := pkg.cb.loadExpr(args[0].Src)
+			return nil, pkg.cb.newCodeErrorf(pos, end, "cannot convert %v (type %v) to type %v (%s)", src, args[0].Type, typ, reason)
+		}
+	}
 
 finish:
+	var cval constant.Value
+	var intToStr bool
+	if len(args) == 1 {
+		cval, intToStr = intToStringConv(pkg, typ, args[0])
+	}
+	if len(args) == 1 && pkg.conf.ElideConversions {
+		arg := args[0]
+		if intToStr {
+			arg = &internal.Elem{Val: arg.Val, Type: arg.Type, CVal: cval, Src: arg.Src}
+		}
+		if ret = elideConv(pkg, typ, arg); ret != nil {
+			return
+		}
+	}
 	valArgs := make([]ast.Expr, len(args))
 	for i, v := range args { // TODO: type check
 		valArgs[i] = v.Val
@@ -869,16 +1213,117 @@ finish:
 		Val:  &ast.CallExpr{Fun: fnVal, Args: valArgs, Ellipsis: token.Pos(flags & InstrFlagEllipsis)},
 		Type: typ,
 	}
-	if len(args) == 1 { // TODO: const value may changed by type-convert
+	if intToStr {
+		ret.CVal = cval
+	} else if len(args) == 1 { // TODO: const value may changed by type-convert
 		ret.CVal = args[0].CVal
 	}
 	return
 }
 
+// intToStringConv reports whether converting arg to typ is a conversion from
+// an integer to a string, and returns the constant value of the result if arg
+// is a constant: string(x) yields the UTF-8 representation of rune x, and
+// "\uFFFD" if x isn't a valid rune. Like go vet, it warns about conversions
+// of integers which aren't runes or bytes, because they are often mistaken
+// for strconv.Itoa.
+func intToStringConv(pkg *Package, typ types.Type, arg *internal.Elem) (cval constant.Value, ok bool) {
+	if t, ok := getUnderlying(pkg, typ).(*types.Basic); !ok || t.Info()&types.IsString == 0 {
+		return nil, false
+	}
+	t, ok := getUnderlying(pkg, arg.Type).(*types.Basic)
+	if !ok || t.Info()&types.IsInteger == 0 {
+		return nil, false
+	}
+	switch t.Kind() {
+	case types.Int32, types.Uint8, types.UntypedRune:
+	default:
+		src, pos, end := pkg.cb.loadExpr(arg.Src)
+		pkg.warn(pkg.cb.newCodeErrorf(pos, end,
+			"conversion from %v (type %v) to %v yields a string of one rune, not a string of digits (did you mean strconv.Itoa(%v)?)",
+			src, arg.Type, typ, src))
+	}
+	if arg.CVal != nil {
+		r := rune(utf8.RuneError)
+		if v, exact := constant.Int64Val(constant.ToInt(arg.CVal)); exact && utf8.ValidRune(rune(v)) && int64(rune(v)) == v {
+			r = rune(v)
+		}
+		cval = constant.MakeString(string(r))
+	}
+	return cval, true
+}
+
+// elideConv returns arg itself if converting it to typ is redundant, or a
+// literal if arg is a constant whose literal defaults to typ. Conversions to
+// other types (eg. named types) are required, so it returns nil for them.
+func elideConv(pkg *Package, typ types.Type, arg *internal.Elem) *internal.Elem {
+	if types.Identical(arg.Type, typ) {
+		return &internal.Elem{Val: arg.Val, Type: typ, CVal: arg.CVal}
+	}
+	if arg.CVal == nil {
+		return nil
+	}
+	t, ok := typesalias.Unalias(typ).(*types.Basic)
+	if !ok {
+		return nil
+	}
+	cval := arg.CVal
+	switch t.Kind() {
+	case types.Int:
+		cval = constant.ToInt(cval)
+	case types.Float64:
+		cval = constant.ToFloat(cval)
+	case types.Bool, types.String:
+	default:
+		return nil
+	}
+	if lit := constLit(pkg, t, cval); lit != nil {
+		return &internal.Elem{Val: lit, Type: typ, CVal: cval}
+	}
+	return nil
+}
+
+// sliceConvVersion returns the minor Go version required to convert V to T:
+// slice to array pointer requires go1.17, and slice to array requires go1.20.
+func sliceConvVersion(pkg *Package, V, T types.Type) int {
+	if !isSliceType(pkg, V) {
+		return 0
+	}
+	switch t := getUnderlying(pkg, T).(type) {
+	case *types.Array:
+		return 20
+	case *types.Pointer:
+		if _, ok := getUnderlying(pkg, t.Elem()).(*types.Array); ok {
+			return 17
+		}
+	}
+	return 0
+}
+
+func isSliceType(pkg *Package, typ types.Type) bool {
+	_, ok := getUnderlying(pkg, typ).(*types.Slice)
+	return ok
+}
+
+// chanDirReason explains why a channel of type V can't be used as type T when
+// they have identical element types: a send-only or receive-only channel can't
+// be used as a channel of another direction.
+func chanDirReason(pkg *Package, V, T types.Type) string {
+	if v, ok := getUnderlying(pkg, V).(*types.Chan); ok && v.Dir() != types.SendRecv {
+		if t, ok := getUnderlying(pkg, T).(*types.Chan); ok && t.Dir() != v.Dir() && types.Identical(v.Elem(), t.Elem()) {
+			if v.Dir() == types.RecvOnly {
+				return "receive-only channel"
+			}
+			return "send-only channel"
+		}
+	}
+	return ""
+}
+
 func matchRcast(pkg *Package, fn *internal.Elem, m types.Object, typ types.Type, flags InstrFlags) (ret *internal.Elem, err error) {
 	sig := m.Type().(*types.Signature)
 	if sig.Params().Len() != 0 {
-		log.Panicf("TODO: method %v should haven't no arguments\n", m)
+		panicf("TODO: method %v should haven't no arguments\n", m)
 	}
 	n := 1
 	if (flags & InstrFlagTwoValue) != 0 {
@@ -1011,7 +1456,7 @@ func matchFuncType(
 	if (flags & InstrFlagTwoValue) != 0 {
 		if n := sig.Results().Len(); n != 2 {
 			caller, pos, end := getFunExpr(fn)
-			return pkg.cb.newCodeErrorf(pos, end, "assignment mismatch: 2 variables but %v returns %v values", caller, n)
+			return pkg.cb.newCodeErrorf(pos, end, "assignment mismatch: 2 variables but %v returns %s", caller, plural(n, "value"))
 		}
 	}
 	var t *types.Tuple
@@ -1108,18 +1553,14 @@ func checkFuncResults(pkg *Package, rets []*internal.Elem, results *types.Tuple,
 			}
 			for i := 0; i < need; i++ {
 				arg := &internal.Elem{Type: t.At(i).Type(), Src: src}
-				if err := matchType(pkg, arg, results.At(i).Type(), "return argument"); err != nil {
-					panic(err)
-				}
+				matchResultType(pkg, arg, results.At(i).Type())
 			}
 			return
 		}
 	}
 	if n == need {
 		for i := 0; i < need; i++ {
-			if err := matchType(pkg, rets[i], results.At(i).Type(), "return argument"); err != nil {
-				panic(err)
-			}
+			matchResultType(pkg, rets[i], results.At(i).Type())
 		}
 		return
 	}
@@ -1133,6 +1574,48 @@ func checkFuncResults(pkg *Package, rets []*internal.Elem, results *types.Tuple,
 		pos, end, "too %s arguments to return\n\thave (%v)\n\twant %v", fewOrMany, getTypes(rets), results)
 }
 
+func matchResultType(pkg *Package, arg *internal.Elem, result types.Type) {
+	if err := matchType(pkg, arg, result, "return argument"); err != nil {
+		panic(err)
+	}
+}
+
+// mismatchReason explains why arg can't be assigned to typ: an untyped
+// constant overflows or is truncated, a type doesn't implement an interface,
+// or a channel has a mismatched direction.
+func mismatchReason(pkg *Package, arg *internal.Elem, typ types.Type) string {
+	switch t := getUnderlying(pkg, typ).(type) {
+	case *types.Chan:
+		return chanDirReason(pkg, arg.Type, t)
+	case *types.Basic:
+		if arg.CVal == nil || !isUntyped(pkg, arg.Type) {
+			break
+		}
+		kind := t.Kind()
+		if kind < types.Int || kind > types.Uintptr {
+			break
+		}
+		if cv := constant.ToInt(arg.CVal); cv.Kind() != constant.Int {
+			if k := arg.CVal.Kind(); k == constant.Float || k == constant.Complex {
+				return "truncated"
+			}
+		} else if outOfRange(pkg, kind, cv) {
+			return "overflows"
+		}
+	case *types.Interface:
+		if isUntyped(pkg, arg.Type) {
+			if arg.Type == types.Typ[types.UntypedNil] {
+				break
+			}
+			arg = &internal.Elem{Type: types.Default(arg.Type)}
+		}
+		if m, _ := types.MissingMethod(arg.Type, t, true); m != nil {
+			return "missing method " + m.Name()
+		}
+	}
+	return ""
+}
+
 func getTypes(rets []*internal.Elem) string {
 	typs := make([]string, len(rets))
 	for i, ret := range rets {
@@ -1199,8 +1682,9 @@ type MatchError struct {
 	Param types.Type
 	At    interface{}
 
-	intr  NodeInterpreter
-	fstmt bool
+	intr   NodeInterpreter
+	fstmt  bool
+	reason string
 }
 
 func strval(at interface{}) string {
@@ -1215,16 +1699,22 @@ func strval(at interface{}) string {
 }
 
 func (p *MatchError) Message(fileLine string) string {
+	var msg string
 	if p.fstmt {
-		return fmt.Sprintf(
+		msg = fmt.Sprintf(
 			"%scannot use %v value as type %v in %s", fileLine, p.Arg, p.Param, strval(p.At))
+	} else {
+		src := ""
+		if p.Src != nil {
+			src = p.intr.LoadExpr(p.Src)
+		}
+		msg = fmt.Sprintf(
+			"%scannot use %s (type %v) as type %v in %s", fileLine, src, p.Arg, p.Param, strval(p.At))
 	}
-	src := ""
-	if p.Src != nil {
-		src = p.intr.LoadExpr(p.Src)
+	if p.reason != "" {
+		msg += " (" + p.reason + ")"
 	}
-	return fmt.Sprintf(
-		"%scannot use %s (type %v) as type %v in %s", fileLine, src, p.Arg, p.Param, strval(p.At))
+	return msg
 }
 
 func (p *MatchError) Pos() token.Pos {
@@ -1249,6 +1739,9 @@ func matchType(pkg *Package, arg *internal.Elem, param types.Type, at interface{
 		src, pos, end := pkg.cb.loadExpr(arg.Src)
 		return pkg.cb.newCodeError(pos, end, fmt.Sprintf("%v (no value) used as value", src))
 	}
+	if isInvalidType(arg.Type) || isInvalidType(param) {
+		return nil
+	}
 	// check untyped big int/rat/flt => interface
 	switch arg.Type {
 	case pkg.utBigInt, pkg.utBigRat, pkg.utBigFlt:
@@ -1346,7 +1839,7 @@ func matchType(pkg *Package, arg *internal.Elem, param types.Type, at interface{
 	}
 	return &MatchError{
 		Src: arg.Src, Arg: arg.Type, Param: param, At: at, fstmt: arg.Val == nil,
-		Fset: pkg.cb.fset, intr: pkg.cb.interp,
+		Fset: pkg.cb.fset, intr: pkg.cb.interp, reason: mismatchReason(pkg, arg, param),
 	}
 }
 
@@ -1409,4 +1902,67 @@ func checkUntypedOverflows(scope *types.Scope, tname string, arg *internal.Elem)
 	return false
 }
 
+// copyNode returns a deep copy of node. subst is called for each ident: if it
+// returns non nil, the result is used instead of a copy of the ident.
+func copyNode(node ast.Node, subst func(id *ast.Ident) ast.Expr) ast.Node {
+	return copyValue(reflect.ValueOf(node), subst).Interface().(ast.Node)
+}
+
+var (
+	tyIdent     = reflect.TypeOf((*ast.Ident)(nil))
+	tyAstObject = reflect.TypeOf((*ast.Object)(nil))
+	tyAstScope  = reflect.TypeOf((*ast.Scope)(nil))
+)
+
+func copyValue(v reflect.Value, subst func(id *ast.Ident) ast.Expr) reflect.Value {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.New(v.Type()).Elem()
+		ret.Set(copyValue(v.Elem(), subst))
+		return ret
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		switch v.Type() {
+		case tyAstObject, tyAstScope: // shared
+			return v
+		case tyIdent:
+			if x := subst(v.Interface().(*ast.Ident)); x != nil {
+				return reflect.ValueOf(x)
+			}
+		}
+		ret := reflect.New(v.Type().Elem())
+		ret.Elem().Set(copyValue(v.Elem(), subst))
+		return ret
+	case reflect.Struct:
+		ret := reflect.New(v.Type()).Elem()
+		for i, n := 0, v.NumField(); i < n; i++ {
+			setCopy(ret.Field(i), v.Field(i), subst)
+		}
+		return ret
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i, n := 0, v.Len(); i < n; i++ {
+			setCopy(ret.Index(i), v.Index(i), subst)
+		}
+		return ret
+	}
+	return v
+}
+
+func setCopy(to, v reflect.Value, subst func(id *ast.Ident) ast.Expr) {
+	if x := copyValue(v, subst); x.Type().AssignableTo(to.Type()) {
+		to.Set(x)
+	} else { // eg. an *ast.Ident field is substituted by a selector
+		to.Set(v)
+	}
+}
+
 // -----------------------------------------------------------------------------
@@ -19,7 +19,6 @@ import (
 	"go/constant"
 	"go/token"
 	"go/types"
-	"log"
 	"runtime"
 	"strings"
 	"syscall"
@@ -299,12 +298,14 @@ var _builtinFns = [...]struct {
 
 	{"clear", []typeTParam{{"Type", clearable}}, []typeXParam{{"t", 0}}, nil},
 	// func clear[T clearable](t T)
+}
 
-	{"max", []typeTParam{{"Type", borderable}}, []typeXParam{{"x", 0}, {"elems", xtEllipsis}}, 0},
-	//func max[T borderable](x T, y ...T) T
-
-	{"min", []typeTParam{{"Type", borderable}}, []typeXParam{{"x", 0}, {"elems", xtEllipsis}}, 0},
-	//func min[T borderable](x T, y ...T) T
+// builtinGoVersions maps builtin functions to the minor Go version which
+// introduced them.
+var builtinGoVersions = map[string]int{
+	"clear": 21,
+	"max":   21,
+	"min":   21,
 }
 
 var _builtinOverloads = [...]struct {
@@ -405,6 +406,10 @@ func initBuiltinFuncs(builtin *types.Package) {
 	// len & cap are special cases, because they may return a constant value.
 	gbl.Insert(NewInstruction(token.NoPos, builtin, "len", lenInstr{}))
 	gbl.Insert(NewInstruction(token.NoPos, builtin, "cap", capInstr{}))
+
+	// min & max mix untyped constants freely and may return a constant value.
+	gbl.Insert(NewInstruction(token.NoPos, builtin, "min", minMaxInstr{"min", token.LSS}))
+	gbl.Insert(NewInstruction(token.NoPos, builtin, "max", minMaxInstr{"max", token.GTR}))
 }
 
 func initUnsafeFuncs(pkg *Package) {
@@ -547,7 +552,7 @@ func (p lenInstr) Call(pkg *Package, args []*Element, flags InstrFlags, src ast.
 		}
 	default:
 		if !lenable.Match(pkg, t) {
-			log.Panicln("TODO: can't call len() to", t)
+			panicln("TODO: can't call len() to", t)
 		}
 	}
 	ret = &Element{
@@ -575,7 +580,7 @@ func (p capInstr) Call(pkg *Package, args []*Element, flags InstrFlags, src ast.
 		}
 	default:
 		if !capable.Match(pkg, t) {
-			log.Panicln("TODO: can't call cap() to", t)
+			panicln("TODO: can't call cap() to", t)
 		}
 	}
 	ret = &Element{
@@ -586,6 +591,108 @@ func (p capInstr) Call(pkg *Package, args []*Element, flags InstrFlags, src ast.
 	return
 }
 
+type minMaxInstr struct {
+	name string
+	tok  token.Token
+}
+
+// func [Type borderable] min(x Type, y ...Type) Type
+// func [Type borderable] max(x Type, y ...Type) Type
+func (p minMaxInstr) Call(pkg *Package, args []*Element, flags InstrFlags, src ast.Node) (ret *Element, err error) {
+	cb := &pkg.cb
+	if len(args) == 0 {
+		text, pos, end := cb.loadExpr(src)
+		return nil, cb.newCodeErrorf(pos, end, "missing argument to function call: %v", text)
+	}
+	typ, err := p.commonType(pkg, args)
+	if err != nil {
+		return
+	}
+	if !borderable.Match(pkg, typ) {
+		arg := args[0]
+		return nil, cb.newCodeErrorf(getSrcPos(arg.Src), getSrcEnd(arg.Src),
+			"invalid argument: %s (type %v) cannot be ordered", types.ExprString(arg.Val), arg.Type)
+	}
+	exprs := make([]ast.Expr, len(args))
+	cval := args[0].CVal
+	for i, arg := range args {
+		exprs[i] = arg.Val
+		if cval != nil {
+			if arg.CVal == nil {
+				cval = nil
+			} else if constant.Compare(arg.CVal, p.tok, cval) {
+				cval = arg.CVal
+			}
+		}
+	}
+	if cval != nil && isBasicType(typ, types.UntypedFloat) {
+		cval = constant.ToFloat(cval)
+	}
+	ret = &Element{
+		Val:  &ast.CallExpr{Fun: ident(p.name), Args: exprs},
+		Type: typ,
+		CVal: cval,
+	}
+	return
+}
+
+// commonType returns the type all arguments of min/max convert to: the type
+// of the typed arguments if any, or else the widest kind of the untyped ones.
+func (p minMaxInstr) commonType(pkg *Package, args []*Element) (types.Type, error) {
+	var typed, untyped *Element
+	for _, arg := range args {
+		if isUntyped(pkg, arg.Type) {
+			if untyped == nil || untypedRank(arg.Type) > untypedRank(untyped.Type) {
+				untyped = arg
+			}
+			continue
+		}
+		if typed == nil {
+			typed = arg
+		} else if !types.Identical(typed.Type, arg.Type) {
+			return nil, pkg.cb.newCodeErrorf(getSrcPos(arg.Src), getSrcEnd(arg.Src),
+				"invalid argument: mismatched types %v (previous argument) and %v (type of %s)",
+				typed.Type, arg.Type, types.ExprString(arg.Val))
+		}
+	}
+	if typed == nil {
+		for _, arg := range args {
+			if r := untypedRank(arg.Type); (r < 0 || untypedRank(untyped.Type) < 0) && !types.Identical(arg.Type, untyped.Type) {
+				return nil, pkg.cb.newCodeErrorf(getSrcPos(arg.Src), getSrcEnd(arg.Src),
+					"invalid argument: mismatched types %v (previous argument) and %v (type of %s)",
+					untyped.Type, arg.Type, types.ExprString(arg.Val))
+			}
+		}
+		return untyped.Type, nil
+	}
+	for _, arg := range args {
+		if arg != typed && isUntyped(pkg, arg.Type) && !AssignableConv(pkg, arg.Type, typed.Type, arg) {
+			return nil, pkg.cb.newCodeErrorf(getSrcPos(arg.Src), getSrcEnd(arg.Src),
+				"cannot use %s (type %v) as type %v in argument to %s",
+				types.ExprString(arg.Val), arg.Type, typed.Type, p.name)
+		}
+	}
+	return typed.Type, nil
+}
+
+// untypedRank orders untyped numeric kinds by width; non-numeric kinds
+// rank below zero.
+func untypedRank(t types.Type) int {
+	if b, ok := t.(*types.Basic); ok {
+		switch b.Kind() {
+		case types.UntypedInt:
+			return 0
+		case types.UntypedRune:
+			return 1
+		case types.UntypedFloat:
+			return 2
+		case types.UntypedComplex:
+			return 3
+		}
+	}
+	return -1
+}
+
 type incInstr struct {
 }
 
@@ -724,7 +831,7 @@ func (p makeInstr) Call(pkg *Package, args []*Element, flags InstrFlags, src ast
 	}
 	typ := ttyp.Type()
 	if !makable.Match(pkg, typ) {
-		log.Panicln("TODO: can't make this type -", typ)
+		panicln("TODO: can't make this type -", typ)
 	}
 	argsExpr := make([]ast.Expr, n)
 	for i, arg := range args {
@@ -757,7 +864,7 @@ func checkArgsCount(pkg *Package, fn string, n int, args int, src ast.Node) {
 }
 
 var (
-	std types.Sizes
+	std types.Sizes // sizes of the host platform
 )
 
 func init() {
@@ -768,6 +875,20 @@ func init() {
 	}
 }
 
+// targetSizes returns sizes of the target platform specified by conf.
+func targetSizes(conf *Config) types.Sizes {
+	if conf.Sizes != nil {
+		return conf.Sizes
+	}
+	if conf.GOARCH != "" {
+		if sizes := types.SizesFor("gc", conf.GOARCH); sizes != nil {
+			return sizes
+		}
+		panicln("NewPackage: unknown GOARCH", conf.GOARCH)
+	}
+	return std
+}
+
 func unsafeRef(name string) Ref {
 	return PkgRef{types.Unsafe}.Ref(name)
 }
@@ -783,7 +904,7 @@ func (p unsafeSizeofInstr) Call(pkg *Package, args []*Element, flags InstrFlags,
 	ret = &Element{
 		Val:  &ast.CallExpr{Fun: fn, Args: []ast.Expr{args[0].Val}},
 		Type: types.Typ[types.Uintptr],
-		CVal: constant.MakeInt64(std.Sizeof(typ)),
+		CVal: constant.MakeInt64(pkg.sizes.Sizeof(typ)),
 		Src:  src,
 	}
 	return
@@ -800,7 +921,7 @@ func (p unsafeAlignofInstr) Call(pkg *Package, args []*Element, flags InstrFlags
 	ret = &Element{
 		Val:  &ast.CallExpr{Fun: fn, Args: []ast.Expr{args[0].Val}},
 		Type: types.Typ[types.Uintptr],
-		CVal: constant.MakeInt64(std.Alignof(typ)),
+		CVal: constant.MakeInt64(pkg.sizes.Alignof(typ)),
 		Src:  src,
 	}
 	return
@@ -871,12 +992,12 @@ retry:
 	return nil
 }
 
-func offsetsof(T *types.Struct) []int64 {
+func offsetsof(pkg *Package, T *types.Struct) []int64 {
 	var fields []*types.Var
 	for i := 0; i < T.NumFields(); i++ {
 		fields = append(fields, T.Field(i))
 	}
-	return std.Offsetsof(fields)
+	return pkg.sizes.Offsetsof(fields)
 }
 
 // offsetof returns the offset of the field specified via
@@ -898,7 +1019,7 @@ func offsetof(pkg *Package, typ types.Type, index []int, recv ast.Node, sel stri
 			}
 		}
 		s := typ.(*types.Struct)
-		o += offsetsof(s)[i]
+		o += offsetsof(pkg, s)[i]
 		typ = s.Field(i).Type()
 	}
 	if indirectType > 0 {
@@ -15,10 +15,13 @@ package gogen
 
 import (
 	"bytes"
+	"errors"
 	"go/ast"
 	"go/constant"
+	"go/parser"
 	"go/token"
 	"go/types"
+	"io/fs"
 	"log"
 	"math/big"
 	"runtime"
@@ -135,6 +138,159 @@ func TestInitGopPkg(t *testing.T) {
 	pkg.initGopPkg(nil, pkg.Types)
 }
 
+type mapImporter map[string]*types.Package
+
+func (p mapImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := p[path]; ok {
+		return pkg, nil
+	}
+	return nil, errors.New("package " + path + " not found")
+}
+
+func newGopPkg(path string, deps string) *types.Package {
+	pkg := types.NewPackage(path, path[strings.LastIndexByte(path, '/')+1:])
+	pkg.Scope().Insert(types.NewConst(
+		token.NoPos, pkg, "GopPackage", types.Typ[types.UntypedString], constant.MakeString(deps),
+	))
+	return pkg
+}
+
+func TestInitGopPkgError(t *testing.T) {
+	a := newGopPkg("foo/a", "foo/b")
+	b := newGopPkg("foo/b", "")
+	b.Scope().Insert(types.NewFunc(token.NoPos, b, "Bar__1", types.NewSignatureType(nil, nil, nil, nil, nil, false)))
+	imp := mapImporter{"foo/a": a, "foo/b": b}
+	pkg := NewPackage("", "main", &Config{Importer: imp})
+	if ret := pkg.TryImport("foo/a"); ret.isValid() {
+		t.Fatal("TryImport: should fail")
+	}
+
+	pkg = NewPackage("", "main", &Config{Importer: imp})
+	_, err := importPkg(pkg, "foo/a", &ast.Ident{NamePos: 10, Name: "a"})
+	if ie, ok := err.(*ImportError); !ok || ie.Pos != 10 || ie.Path != "foo/a" {
+		t.Fatal("importPkg:", err)
+	}
+	var e *InitGopPkgError
+	if !errors.As(err, &e) {
+		t.Fatal("importPkg:", err)
+	}
+	if e.Path != "foo/b" || e.Name != "Bar" || strings.Join(e.Chain, ",") != "foo/a,foo/b" {
+		t.Fatal("InitGopPkgError:", e.Path, e.Name, e.Chain)
+	}
+	if e.Error() != "init Go+ package foo/b: symbol Bar: overload func Bar__1 out of range 0..0 (imported via foo/a -> foo/b)" {
+		t.Fatal("importPkg:", e)
+	}
+
+	c := newGopPkg("foo/c", "foo/d")
+	pkg = NewPackage("", "main", &Config{Importer: mapImporter{"foo/c": c}})
+	if err := pkg.initGopPkg(pkg.imp, c); err == nil ||
+		err.Error() != "init Go+ package foo/d: package foo/d not found (imported via foo/c -> foo/d)" {
+		t.Fatal("initGopPkg:", err)
+	}
+}
+
+func TestInitGopPkgReimport(t *testing.T) {
+	sig := types.NewSignatureType(nil, nil, nil, nil, nil, false)
+	b := newGopPkg("foo/b", "")
+	for _, name := range []string{"Add__0", "Add__1", "Bar__1"} {
+		b.Scope().Insert(types.NewFunc(token.NoPos, b, name, sig))
+	}
+	imp := mapImporter{"foo/b": b}
+	const msg = "init Go+ package foo/b: symbol Bar: overload func Bar__1 out of range 0..0"
+	for i := 0; i < 2; i++ {
+		pkg := NewPackage("", "main", &Config{Importer: imp})
+		_, err := importPkg(pkg, "foo/b", nil)
+		var e *InitGopPkgError
+		if !errors.As(err, &e) || e.Error() != msg {
+			t.Fatal("importPkg:", i, err)
+		}
+		if b.Scope().Lookup("Add") != nil || b.Scope().Lookup(xgoPkgInit) != nil {
+			t.Fatal("importPkg: foo/b is changed -", i)
+		}
+	}
+}
+
+type countImporter struct {
+	mapImporter
+	n int
+}
+
+func (p *countImporter) Import(path string) (*types.Package, error) {
+	p.n++
+	return p.mapImporter.Import(path)
+}
+
+func TestInitGopPkgDiamond(t *testing.T) {
+	imp := &countImporter{mapImporter: mapImporter{
+		"foo/a": newGopPkg("foo/a", "foo/b,foo/c"),
+		"foo/b": newGopPkg("foo/b", "foo/d"),
+		"foo/c": newGopPkg("foo/c", "foo/d"),
+		"foo/d": newGopPkg("foo/d", ""),
+	}}
+	for i, want := range []int{5, 1} { // foo/a, foo/b, foo/d, foo/c, foo/d; then foo/a only
+		pkg := NewPackage("", "main", &Config{Importer: imp})
+		imp.n = 0
+		if _, err := importPkg(pkg, "foo/a", nil); err != nil {
+			t.Fatal("importPkg:", i, err)
+		}
+		if imp.n != want {
+			t.Fatal("importPkg: imports", i, imp.n, want)
+		}
+	}
+}
+
+func TestInitGopPkgRace(t *testing.T) {
+	sig := types.NewSignatureType(nil, nil, nil, nil, nil, false)
+	a := newGopPkg("foo/a", "foo/b")
+	b := types.NewPackage("foo/b", "b")
+	b.Scope().Insert(types.NewConst(
+		token.NoPos, b, "GopPackage", types.Typ[types.UntypedBool], constant.MakeBool(true),
+	))
+	for _, name := range []string{"Foo__0", "Foo__1"} {
+		a.Scope().Insert(types.NewFunc(token.NoPos, a, name, sig))
+	}
+	for _, name := range []string{"Bar__0", "Bar__1"} {
+		b.Scope().Insert(types.NewFunc(token.NoPos, b, name, sig))
+	}
+	imp := mapImporter{"foo/a": a, "foo/b": b}
+	const n = 50
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make([]error, n)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			pkg := NewPackage("", "main", &Config{Importer: imp})
+			if _, err := importPkg(pkg, "foo/a", nil); err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = pkg.initGopPkg(imp, b)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal("TestInitGopPkgRace:", err)
+		}
+	}
+	for _, c := range []struct {
+		pkg  *types.Package
+		name string
+	}{{a, "Foo"}, {b, "Bar"}} {
+		o := c.pkg.Scope().Lookup(c.name)
+		if o == nil {
+			t.Fatal("TestInitGopPkgRace: overload not found", c.name)
+		}
+		if funcs, ok := CheckOverloadFunc(o.Type().(*types.Signature)); !ok || len(funcs) != 2 {
+			t.Fatal("TestInitGopPkgRace: unexpected overload", c.name, o.Type())
+		}
+	}
+}
+
 func TestCheckOverloads(t *testing.T) {
 	defer func() {
 		if e := recover(); e != "checkOverloads: should be string constant - foo" {
@@ -431,7 +587,7 @@ func TestComparableTo(t *testing.T) {
 		{types.Typ[types.UntypedFloat], types.Typ[types.Complex128], true},
 		{types.Typ[types.String], types.Typ[types.Bool], false},
 		{types.Typ[types.String], types.Typ[types.String], true},
-		{types.Typ[types.String], tyStr, true},
+		{types.Typ[types.String], tyStr, false},
 		{types.Typ[types.UntypedBool], types.Typ[types.Bool], true},
 		{types.Typ[types.Bool], types.Typ[types.UntypedBool], true},
 		{types.Typ[types.UntypedRune], types.Typ[types.UntypedString], false},
@@ -1179,6 +1335,40 @@ func TestErrImport(t *testing.T) {
 	}
 }
 
+func TestImportPathRules(t *testing.T) {
+	canonicals := []struct {
+		path, ret string
+	}{
+		{"example.com/m/vendor/github.com/x/y", "github.com/x/y"},
+		{"vendor/golang.org/x/net/http2", "golang.org/x/net/http2"},
+		{"example.com/m/vendorx/y", "example.com/m/vendorx/y"},
+	}
+	for _, c := range canonicals {
+		if ret := canonicalImportPath(c.path); ret != c.ret {
+			t.Fatalf("canonicalImportPath(%s) = %s", c.path, ret)
+		}
+	}
+	visibles := []struct {
+		path, dest string
+		ret        bool
+	}{
+		{"example.com/m/internal/z", "example.com/m", true},
+		{"example.com/m/internal/z", "example.com/m/cmd/gen", true},
+		{"example.com/m/internal", "example.com/m/cmd/gen", true},
+		{"example.com/m/internal/z", "example.com/mx", false},
+		{"example.com/m/a/internal/z", "example.com/m/b", false},
+		{"example.com/m/internal/a/internal/z", "example.com/m/internal/b", false},
+		{"internal/abi", "reflect", true},
+		{"internal/abi", "example.com/m", false},
+		{"example.com/m/x", "example.com/other", true},
+	}
+	for _, c := range visibles {
+		if ret := internalVisible(c.path, c.dest); ret != c.ret {
+			t.Fatalf("internalVisible(%s, %s) = %v", c.path, c.dest, ret)
+		}
+	}
+}
+
 func TestErrWriteFile(t *testing.T) {
 	pkg := NewPackage("", "foo", gblConf)
 	pkg.Types = nil
@@ -1244,6 +1434,44 @@ func TestImportError(t *testing.T) {
 	}
 }
 
+type panicPositioner struct{}
+
+func (panicPositioner) Position(pos token.Pos) token.Position {
+	panic("unknown position")
+}
+
+func TestImportErrorFset(t *testing.T) {
+	fset, impFset := token.NewFileSet(), token.NewFileSet()
+	f := fset.AddFile("main.gop", -1, 100)
+	f.SetLines([]int{0, 10, 20})
+	pkg := NewPackage("", "main", &Config{
+		Fset:       fset,
+		Importer:   packages.NewImporter(impFset),
+		ImportFset: impFset,
+	})
+	fn := pkg.Import("fmt").Ref("Println")
+	if pos := pkg.cb.fset.Position(fn.Pos()); !strings.HasSuffix(pos.Filename, "print.go") {
+		t.Fatal("TestImportErrorFset: fmt.Println at", pos)
+	}
+	if pos := pkg.cb.fset.Position(token.Pos(1 << 30)); pos.String() != "Pos(1073741824)" {
+		t.Fatal("TestImportErrorFset: unknown pos", pos)
+	}
+	func() {
+		defer func() {
+			e, ok := recover().(*ImportError)
+			if !ok || !strings.HasPrefix(e.Error(), "main.gop:2:3: ") {
+				t.Fatal("TestImportErrorFset:", e)
+			}
+		}()
+		pkg.Import("github.com/goplus/gogen/nonexistent", &ast.Ident{NamePos: f.Pos(12)})
+	}()
+
+	p := newPositioner(&Config{DbgPositioner: panicPositioner{}}, nil)
+	if pos := p.Position(10); pos.String() != "Pos(10)" {
+		t.Fatal("TestImportErrorFset: DbgPositioner", pos)
+	}
+}
+
 func TestForRangeStmtPanic(t *testing.T) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -1380,16 +1608,16 @@ func TestAssignableUntyped(t *testing.T) {
 		types.Typ[types.UntypedFloat], nil)
 	i64 := types.NewNamed(types.NewTypeName(token.NoPos, nil, "Int64", nil),
 		types.Typ[types.UntypedInt], nil)
-	if assignableTo(f64, types.Typ[types.UntypedInt], nil) {
+	if assignableTo(nil, f64, types.Typ[types.UntypedInt], nil) {
 		t.Fatal("error f2i")
 	}
-	if !assignableTo(f64, types.Typ[types.UntypedFloat], nil) {
+	if !assignableTo(nil, f64, types.Typ[types.UntypedFloat], nil) {
 		t.Fatal("must f2f")
 	}
-	if !assignableTo(i64, types.Typ[types.UntypedInt], nil) {
+	if !assignableTo(nil, i64, types.Typ[types.UntypedInt], nil) {
 		t.Fatal("must i2i")
 	}
-	if !assignableTo(i64, types.Typ[types.UntypedFloat], nil) {
+	if !assignableTo(nil, i64, types.Typ[types.UntypedFloat], nil) {
 		t.Fatal("must i2f")
 	}
 }
@@ -1582,3 +1810,118 @@ func TestAliasBasic(t *testing.T) {
 }
 
 // ----------------------------------------------------------------------------
+
+// TestLogOnlyIfDebug checks that every call to the standard logger is guarded
+// by a debug flag (see SetDebug).
+func TestLogOnlyIfDebug(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal("parser.ParseDir:", err)
+	}
+	isDebugCond := func(cond ast.Expr) (ret bool) {
+		ast.Inspect(cond, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && (strings.HasPrefix(id.Name, "debug") || strings.HasPrefix(id.Name, "DbgFlag")) {
+				ret = true
+			}
+			return !ret
+		})
+		return
+	}
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			var guards []ast.Node
+			ast.Inspect(f, func(n ast.Node) bool {
+				if n == nil {
+					guards = guards[:len(guards)-1]
+					return true
+				}
+				guards = append(guards, n)
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				sel, ok := call.Fun.(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				if x, ok := sel.X.(*ast.Ident); !ok || x.Name != "log" {
+					return true
+				}
+				for i := len(guards) - 1; i > 0; i-- {
+					if stmt, ok := guards[i-1].(*ast.IfStmt); ok && stmt.Body == guards[i] && isDebugCond(stmt.Cond) {
+						return true
+					}
+				}
+				t.Errorf("%v: log.%s isn't guarded by a debug flag", fset.Position(call.Pos()), sel.Sel.Name)
+				return true
+			})
+		}
+	}
+}
+
+func TestPanicNoLog(t *testing.T) {
+	instr, match, imp := debugInstr, debugMatch, debugImport
+	debugInstr, debugMatch, debugImport = false, false, false
+	var buf bytes.Buffer
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	defer func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+		debugInstr, debugMatch, debugImport = instr, match, imp
+	}()
+	panicMsg := func(doSth func()) (ret interface{}) {
+		defer func() {
+			ret = recover()
+		}()
+		doSth()
+		return
+	}
+	pkg := NewPackage("", "foo", nil)
+	if e := panicMsg(func() {
+		pkg.NewEqualMethod(pkg.NewType("T").InitType(pkg, types.Typ[types.Int]))
+	}); e != "NewEqualMethod: T is not a struct type\n" {
+		t.Fatal("NewEqualMethod:", e)
+	}
+	if e := panicMsg(func() {
+		pkg.CB().MapLitFromConsts(types.NewMap(types.Typ[types.String], types.Typ[types.Int]), []constant.Value{constant.MakeString("a")}, nil)
+	}); e != "MapLitFromConsts: keys and values mismatch - 1 0\n" {
+		t.Fatal("MapLitFromConsts:", e)
+	}
+	if buf.Len() != 0 {
+		t.Fatal("unexpected log output:", buf.String())
+	}
+}
+
+func TestIsAddressableIndex(t *testing.T) {
+	pkg := NewPackage("", "foo", nil)
+	tyArr := types.NewArray(types.Typ[types.Int], 3)
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(tyArr, "a").
+		NewVar(types.NewSlice(types.Typ[types.Int]), "s").
+		NewVar(types.Typ[types.String], "str").
+		NewVar(types.NewMap(types.Typ[types.Int], types.Typ[types.Int]), "m").
+		NewVar(types.NewPointer(tyArr), "p")
+	cases := []struct {
+		x    func()
+		addr bool
+	}{
+		{func() { cb.VarVal("a") }, true},
+		{func() { cb.VarVal("s") }, true},
+		{func() { cb.VarVal("p") }, true},
+		{func() { cb.VarVal("str") }, false},
+		{func() { cb.VarVal("m") }, false},
+		{func() { cb.ArrayLit(tyArr, 0) }, false},
+	}
+	for i, c := range cases {
+		c.x()
+		cb.Val(0).Index(1, false)
+		if ret := cb.isAddressable(cb.stk.Pop()); ret != c.addr {
+			t.Fatal("isAddressable:", i, ret)
+		}
+	}
+	cb.End()
+}
@@ -100,7 +100,7 @@ func (p *CodeBuilder) ValWithUnit(v *ast.BasicLit, t types.Type, unit string) *C
 	}
 	named, ok := t.(*types.Named)
 	if !ok {
-		log.Panicf("TODO: ValWithUnit: `%v` isn't a named type", t)
+		panicf("TODO: ValWithUnit: `%v` isn't a named type", t)
 	}
 	pkg := p.pkg
 	e := toExpr(pkg, v, v)
@@ -108,11 +108,11 @@ func (p *CodeBuilder) ValWithUnit(v *ast.BasicLit, t types.Type, unit string) *C
 	id := objectID{ot.Pkg().Path(), ot.Name()}
 	units, ok := pkg.getUnits(id, token.INT) // TODO(xsw): INT or FLOAT
 	if !ok {
-		log.Panicf("TODO: ValWithUnit: no units of `%s.%s` found", id.pkg, id.name)
+		panicf("TODO: ValWithUnit: no units of `%s.%s` found", id.pkg, id.name)
 	}
 	u, ok := units[unit]
 	if !ok {
-		log.Panicf("TODO: ValWithUnit: unknown unit `%s` for `%s.%s`", unit, id.pkg, id.name)
+		panicf("TODO: ValWithUnit: unknown unit `%s` for `%s.%s`", unit, id.pkg, id.name)
 	}
 	val := constant.BinaryOp(e.CVal, token.MUL, u)
 	e.CVal = val
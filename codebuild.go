@@ -23,9 +23,11 @@ import (
 	"log"
 	"math/big"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"unicode/utf8"
 
 	"github.com/goplus/gogen/internal"
 	"github.com/goplus/gogen/internal/typesalias"
@@ -103,6 +105,7 @@ type codeBlockCtx struct {
 	stmts []ast.Stmt
 	label *ast.LabeledStmt
 	flows int // flow flags
+	depth int // block nesting depth
 }
 
 const (
@@ -120,8 +123,34 @@ type Label struct {
 
 type funcBodyCtx struct {
 	codeBlockCtx
-	fn     *Func
-	labels map[string]*Label
+	fn      *Func
+	labels  map[string]*Label
+	targets []branchTarget // enclosing for, switch and select statements
+	autoIdx int            // counter of auto names in current top-level func
+	recv    *types.Var     // implicit receiver, see SetImplicitRecv
+}
+
+// branchTarget is a for, switch or select statement that a break statement
+// (or a continue statement, if it is a loop) can refer to.
+type branchTarget struct {
+	label string // "" means the statement isn't labeled
+	loop  bool
+}
+
+func (p *CodeBuilder) startBranchTarget(loop bool) {
+	var label string
+	if l := p.current.label; l != nil {
+		label = l.Label.Name
+	}
+	p.current.targets = append(p.current.targets, branchTarget{label, loop})
+}
+
+func isBranchTarget(block codeBlock) bool {
+	switch block.(type) {
+	case *forStmt, *forRangeStmt, *switchStmt, *typeSwitchStmt, *selectStmt:
+		return true
+	}
+	return false
 }
 
 func (p *funcBodyCtx) checkLabels(cb *CodeBuilder) {
@@ -161,16 +190,20 @@ type CodeBuilder struct {
 	iotav       int
 	commentOnce bool
 	noSkipConst bool
+	nstmts      int // number of statements emitted, see FuncStats
+	maxDepth    int // max block nesting depth of current func, see FuncStats
+	mapKeyLess  func(x, y interface{}) bool
+	loopVars    map[types.Object]*loopVar // loop variables of for statements being built (before go1.22)
+	expectRets  int                       // results expected by the call being matched, see Config.OverloadByResults
+	byResults   *byResultsCall            // the last call which may be dispatched by expectRets
 }
 
 func (p *CodeBuilder) init(pkg *Package) {
 	conf := pkg.conf
 	p.pkg = pkg
-	p.fset = conf.DbgPositioner
-	if p.fset == nil {
-		p.fset = conf.Fset
-	}
+	p.fset = newPositioner(conf, pkg.Fset)
 	p.noSkipConst = conf.NoSkipConstant
+	p.mapKeyLess = conf.MapKeyLess
 	p.handleErr = conf.HandleErr
 	if p.handleErr == nil {
 		p.handleErr = defaultHandleErr
@@ -252,6 +285,15 @@ func (p *CodeBuilder) panicCodeErrorf(pos, end token.Pos, format string, args ..
 	panic(p.newCodeError(pos, end, fmt.Sprintf(format, args...)))
 }
 
+// plural returns n followed by noun, which is pluralized unless n is 1, eg.
+// "1 value" and "2 values".
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
+
 // Scope returns current scope.
 func (p *CodeBuilder) Scope() *types.Scope {
 	return p.current.scope
@@ -270,41 +312,104 @@ func (p *CodeBuilder) Pkg() *Package {
 func (p *CodeBuilder) startFuncBody(fn *Func, src []ast.Node, old *funcBodyCtx) *CodeBuilder {
 	p.current.fn, old.fn = fn, p.current.fn
 	p.current.labels, old.labels = nil, p.current.labels
+	p.current.targets, old.targets = nil, p.current.targets
+	if old.fn == nil && !fn.isInline() { // top-level func: auto names are numbered per func
+		p.current.autoIdx, old.autoIdx = 0, p.current.autoIdx
+	}
+	old.recv = p.current.recv // closures inherit the implicit receiver
+	if old.fn == nil {
+		p.current.recv = nil
+	}
+	fn.stats0 = FuncStats{Stmts: p.nstmts, Exprs: p.stk.Pushed(), MaxDepth: p.maxDepth}
+	fn.depth0, p.maxDepth = p.current.depth, p.current.depth
 	p.startBlockStmt(fn, src, "func "+fn.Name(), &old.codeBlockCtx)
 	scope := p.current.scope
+	if fn.stream != nil {
+		fn.stream.scope = scope
+	}
 	sig := fn.Type().(*types.Signature)
-	insertParams(scope, sig.Params())
-	insertParams(scope, sig.Results())
+	p.insertParams(scope, sig.Params())
+	p.insertParams(scope, sig.Results())
 	if recv := sig.Recv(); recv != nil {
-		scope.Insert(recv)
+		if name := recv.Name(); name != "" && name != "_" {
+			p.useLocalName(name, recv.Pos(), recv.Pos())
+			scope.Insert(recv)
+		}
 	}
 	return p
 }
 
-func insertParams(scope *types.Scope, params *types.Tuple) {
+func (p *CodeBuilder) insertParams(scope *types.Scope, params *types.Tuple) {
 	for i, n := 0, params.Len(); i < n; i++ {
 		v := params.At(i)
 		if name := v.Name(); name != "" && name != "_" {
+			p.useLocalName(name, v.Pos(), v.Pos())
 			scope.Insert(v)
 		}
 	}
 }
 
+// useLocalName is called when a local name (a variable, parameter, etc.) is
+// declared. A local name which is the name of an imported package shadows the
+// package, so the import is renamed (eg. `fmt1 "fmt"`), or it is reported as
+// an error if Config.ErrShadowImport is set and the current file imports the
+// package.
+func (p *CodeBuilder) useLocalName(name string, pos, end token.Pos) {
+	if name == "_" {
+		return
+	}
+	pkg := p.pkg
+	if pkg.conf.ErrShadowImport {
+		for pkgPath, id := range pkg.file.imps {
+			if id != nil && id.Name == name {
+				p.panicCodeErrorf(pos, end, "%s shadows imported package %q", name, canonicalImportPath(pkgPath))
+			}
+		}
+	}
+	pkg.useName(name)
+}
+
 func (p *CodeBuilder) endFuncBody(old funcBodyCtx) []ast.Stmt {
 	p.current.checkLabels(p)
+	p.endFuncStats(p.current.fn, old.fn == nil)
+	if old.fn == nil && !p.current.fn.isInline() {
+		p.current.autoIdx = old.autoIdx
+	}
 	p.current.fn = old.fn
 	p.current.labels = old.labels
+	p.current.targets = old.targets
+	p.current.recv = old.recv
 	stmts, _ := p.endBlockStmt(&old.codeBlockCtx)
 	return stmts
 }
 
+// endFuncStats collects statistics of fn whose body is ending, see FuncStats.
+func (p *CodeBuilder) endFuncStats(fn *Func, toplevel bool) {
+	start := fn.stats0
+	fn.stats = FuncStats{
+		Stmts:    p.nstmts - start.Stmts,
+		Exprs:    p.stk.Pushed() - start.Exprs,
+		MaxDepth: p.maxDepth - fn.depth0,
+	}
+	if p.maxDepth < start.MaxDepth {
+		p.maxDepth = start.MaxDepth
+	}
+	if toplevel && fn.snippet == nil {
+		p.pkg.stats.add(fn.stats)
+	}
+}
+
 func (p *CodeBuilder) startBlockStmt(current codeBlock, src []ast.Node, comment string, old *codeBlockCtx) *CodeBuilder {
 	var start, end token.Pos
 	if src != nil {
 		start, end = src[0].Pos(), src[0].End()
 	}
 	scope := types.NewScope(p.current.scope, start, end, comment)
-	p.current.codeBlockCtx, *old = codeBlockCtx{current, scope, p.stk.Len(), nil, nil, 0}, p.current.codeBlockCtx
+	depth := p.current.depth + 1
+	p.current.codeBlockCtx, *old = codeBlockCtx{current, scope, p.stk.Len(), nil, nil, 0, depth}, p.current.codeBlockCtx
+	if depth > p.maxDepth {
+		p.maxDepth = depth
+	}
 	return p
 }
 
@@ -345,6 +450,9 @@ func (p *CodeBuilder) popStmt() ast.Stmt {
 }
 
 func (p *CodeBuilder) startStmtAt(stmt ast.Stmt) int {
+	if s := p.streamOf(); s != nil { // don't flush until commitStmt
+		s.open++
+	}
 	idx := len(p.current.stmts)
 	p.emitStmt(stmt)
 	return idx
@@ -356,6 +464,9 @@ func (p *CodeBuilder) startStmtAt(stmt ast.Stmt) int {
 //	...
 //	cb.commitStmt(idx)
 func (p *CodeBuilder) commitStmt(idx int) {
+	if s := p.streamOf(); s != nil {
+		s.open--
+	}
 	stmts := p.current.stmts
 	n := len(stmts) - 1
 	if n > idx {
@@ -377,6 +488,19 @@ func (p *CodeBuilder) emitStmt(stmt ast.Stmt) {
 		stmt, p.current.label = p.current.label, nil
 	}
 	p.current.stmts = append(p.current.stmts, stmt)
+	p.nstmts++
+	if s := p.streamOf(); s != nil && s.open == 0 { // append-only func
+		p.current.stmts = s.flush(p.current.stmts)
+	}
+}
+
+// streamOf returns stream of the append-only func if current block is its
+// function body.
+func (p *CodeBuilder) streamOf() *funcStream {
+	if fn := p.current.fn; fn != nil && fn.stream != nil && fn.stream.scope == p.current.scope {
+		return fn.stream
+	}
+	return nil
 }
 
 func (p *CodeBuilder) startInitExpr(current codeBlock) (old codeBlock) {
@@ -484,6 +608,35 @@ func (p *CodeBuilder) Call(n int, ellipsis ...bool) *CodeBuilder {
 	return p.CallWith(n, flags)
 }
 
+// ChainCall calls the method name with n arguments on the top of stack, and
+// the receiver is just below them, eg. to build fluent chains like
+// `b.WriteString(x).WriteString(y)`. It is MemberVal + Call, and requires the
+// method to return exactly one value, which remains on the stack as receiver
+// of the next ChainCall. A call result isn't addressable, so calling a pointer
+// method on a value returned by the previous call is an error.
+func (p *CodeBuilder) ChainCall(name string, n int, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("ChainCall", name, n)
+	}
+	args := append([]*internal.Elem(nil), p.stk.GetArgs(n)...)
+	p.stk.PopN(n)
+	p.MemberVal(name, src...)
+	for _, arg := range args {
+		p.stk.Push(arg)
+	}
+	p.CallWith(n, 0, src...)
+	ret := p.stk.Get(-1)
+	switch t := ret.Type.(type) {
+	case nil:
+		_, pos, end := p.loadExpr(ret.Src)
+		p.panicCodeErrorf(pos, end, "%v (no value) used as value", types.ExprString(ret.Val))
+	case *types.Tuple:
+		_, pos, end := p.loadExpr(ret.Src)
+		p.panicCodeErrorf(pos, end, "multiple-value %v (value of type %v) in single-value context", types.ExprString(ret.Val), t)
+	}
+	return p
+}
+
 // CallWith always panics on error, while CallWithEx returns err if match function call failed.
 func (p *CodeBuilder) CallWith(n int, flags InstrFlags, src ...ast.Node) *CodeBuilder {
 	if err := p.CallWithEx(n, flags, src...); err != nil {
@@ -513,7 +666,28 @@ func (p *CodeBuilder) CallWithEx(n int, flags InstrFlags, src ...ast.Node) error
 		log.Println("Call", n, int(flags), "//", fn.Type)
 	}
 	s := getSrc(src)
+	if isInvalidType(fn.Type) || hasInvalidType(args) {
+		p.pushInvalid(n+1, s, false)
+		return nil
+	}
+	if p.pkg.conf.AutoCtxArg {
+		if ctx := p.ctxArg(fn, args); ctx != nil {
+			args = append([]*internal.Elem(nil), args...)
+			p.stk.PopN(n)
+			p.Val(ctx)
+			for _, arg := range args {
+				p.stk.Push(arg)
+			}
+			n++
+			args = p.stk.GetArgs(n)
+		}
+	}
 	fn.Src = s
+	if p.pkg.conf.OverloadByResults {
+		old := p.expectRets
+		p.expectRets = p.expectedResults(n)
+		defer func() { p.expectRets = old }()
+	}
 	ret, err := matchFuncCall(p.pkg, fn, args, flags)
 	if err != nil {
 		p.stk.PopN(n)
@@ -524,6 +698,60 @@ func (p *CodeBuilder) CallWithEx(n int, flags InstrFlags, src ...ast.Node) error
 	return nil
 }
 
+// expectedResults returns the number of results expected by the call of the
+// function with n arguments on the top of stack: the number of names if the
+// call is the (first) value of a declaration, or the number of VarRefs if it
+// is the right-hand side of an assignment. It returns 0 if unknown. The call
+// is dispatched by its results only if it turns out to be the only value on
+// the right-hand side, see dispatchByResults.
+func (p *CodeBuilder) expectedResults(n int) int {
+	base, idx := p.current.base, p.stk.Len()-(n+1)
+	if decl, ok := p.current.codeBlock.(*ValueDecl); ok {
+		if idx == base {
+			return len(decl.names)
+		}
+		return 0
+	}
+	for i := base; i < idx; i++ {
+		if _, ok := p.stk.Get(i - p.stk.Len()).Type.(*refType); !ok {
+			return 0
+		}
+	}
+	return idx - base
+}
+
+// byResultsCall is a call of an overload function which calls the first
+// matched candidate (ret), and another candidate (alt) which returns the
+// results expected by the left-hand side, see Config.OverloadByResults.
+type byResultsCall struct {
+	ret *internal.Elem
+	alt *internal.Elem
+	obj types.Object // the candidate called by alt
+	err error        // not nil if alt is ambiguous
+}
+
+// dispatchByResults replaces the value of the right-hand side of rhs values
+// with the alternative call which returns the expected results, if the value
+// is a byResultsCall and it is the only one.
+func (p *CodeBuilder) dispatchByResults(rhs int) {
+	br := p.byResults
+	if br == nil {
+		return
+	}
+	p.byResults = nil
+	if rhs != 1 || p.stk.Get(-1) != br.ret {
+		return
+	}
+	if br.err != nil {
+		panic(br.err)
+	}
+	br.alt.Src = br.ret.Src
+	p.stk.Set(-1, br.alt)
+	if p.rec != nil {
+		p.rec.Call(br.alt.Src, br.obj)
+	}
+}
+
 type closureParamInst struct {
 	inst  *Func
 	param *types.Var
@@ -537,12 +765,26 @@ func (p *closureParamInsts) init() {
 	p.paramInsts = make(map[closureParamInst]*types.Var)
 }
 
+// autoName returns an auto generated name. Names are numbered per top-level
+// func, so that adding a temporary name in one func doesn't renumber the
+// names in other funcs. Other synthesized names (overloads, Gopo_ constants)
+// are derived from the overload key already, but temporary names have no
+// content to derive a name from (hashing the enclosing func would rename all
+// of its temporaries whenever it changes), so they stay numbered.
+func (p *CodeBuilder) autoName() string {
+	if p.current.fn == nil {
+		return p.pkg.autoName()
+	}
+	p.current.autoIdx++
+	return goxAutoPrefix + strconv.Itoa(p.current.autoIdx)
+}
+
 func (p *CodeBuilder) getEndingLabel(fn *Func) *Label {
 	key := closureParamInst{fn, nil}
 	if v, ok := p.paramInsts[key]; ok {
 		return p.current.labels[v.Name()]
 	}
-	ending := p.pkg.autoName()
+	ending := p.autoName()
 	p.paramInsts[key] = types.NewParam(token.NoPos, nil, ending, nil)
 	return p.NewLabel(token.NoPos, token.NoPos, ending)
 }
@@ -605,7 +847,7 @@ func (p *CodeBuilder) CallInlineClosureStart(sig *types.Signature, arity int, el
 }
 
 func (p *CodeBuilder) emitVar(pkg *Package, closure *Func, param *types.Var, withInit bool) {
-	name := pkg.autoName()
+	name := p.autoName()
 	if withInit {
 		p.NewVarStart(param.Type(), name).EndInit(1)
 	} else {
@@ -707,6 +949,7 @@ func (p *CodeBuilder) NewAutoVar(pos, end token.Pos, name string, pv **types.Var
 	p.emitStmt(stmt)
 	typ := &unboundType{ptypes: []*ast.Expr{&spec.Type}}
 	*pv = types.NewVar(pos, p.pkg.Types, name, typ)
+	p.useLocalName(name, pos, end)
 	if old := p.current.scope.Insert(*pv); old != nil {
 		oldPos := p.fset.Position(old.Pos())
 		p.panicCodeErrorf(
@@ -730,12 +973,15 @@ func (p *CodeBuilder) doVarRef(ref interface{}, src ast.Node, allowDebug bool) *
 		})
 	} else {
 		if v, ok := ref.(string); ok {
-			_, ref = p.Scope().LookupParent(v, token.NoPos)
+			if _, ref = p.Scope().LookupParent(v, token.NoPos); ref == nil && p.implicitMember(v, MemberFlagRef, src) {
+				return p
+			}
 		}
 		if v, ok := ref.(*types.Var); ok {
 			if allowDebug && debugInstr {
 				log.Println("VarRef", v.Name(), v.Type())
 			}
+			p.checkVarName(v, src)
 			fn := p.current.fn
 			if fn != nil && fn.isInline() { // is in an inline call
 				key := closureParamInst{fn, v}
@@ -743,8 +989,12 @@ func (p *CodeBuilder) doVarRef(ref interface{}, src ast.Node, allowDebug bool) *
 					v = arg
 				}
 			}
+			x := toObjectExpr(p.pkg, v)
+			p.snippetRef(v, x)
+			p.sessionRef(v)
+			p.loopVarRef(v, x, true)
 			p.stk.Push(&internal.Elem{
-				Val: toObjectExpr(p.pkg, v), Type: &refType{typ: v.Type()}, Src: src,
+				Val: x, Type: &refType{typ: v.Type()}, Src: src,
 			})
 		} else {
 			code, pos, end := p.loadExpr(src)
@@ -874,6 +1124,7 @@ func (p *CodeBuilder) MapLitEx(typ types.Type, arity int, src ...ast.Node) error
 		typ = t
 		typExpr = toMapType(pkg, t)
 	}
+	var keys constKeys
 	elts := make([]ast.Expr, arity>>1)
 	for i := 0; i < arity; i += 2 {
 		elts[i>>1] = &ast.KeyValueExpr{Key: args[i].Val, Value: args[i+1].Val}
@@ -888,6 +1139,9 @@ func (p *CodeBuilder) MapLitEx(typ types.Type, arity int, src ...ast.Node) error
 					pos, end, "cannot use %s (type %v) as type %v in map value", code, args[i+1].Type, val)
 			}
 		}
+		if err := p.checkConstKey(&keys, args[i], t.Key()); err != nil {
+			return err
+		}
 	}
 	p.stk.Ret(arity, &internal.Elem{
 		Type: typ, Val: &ast.CompositeLit{Type: typExpr, Elts: elts}, Src: getSrc(src),
@@ -895,6 +1149,201 @@ func (p *CodeBuilder) MapLitEx(typ types.Type, arity int, src ...ast.Node) error
 	return nil
 }
 
+// constKeys records the constant keys of a map literal by their values
+// converted to the key type.
+type constKeys map[string]token.Pos
+
+// checkConstKey reports an error if the constant key arg collides with a
+// previous key of the map literal. Keys are compared after conversion to the
+// key type, so different expressions (eg. "a" + "b" and "ab") denoting the
+// same key are detected, as Go does. A key not representable by the key type
+// is reported too, instead of being truncated silently.
+func (p *CodeBuilder) checkConstKey(keys *constKeys, arg *internal.Elem, key types.Type) error {
+	if arg.CVal == nil {
+		return nil
+	}
+	t, ok := getUnderlying(p.pkg, key).(*types.Basic)
+	if !ok {
+		return nil
+	}
+	lit := constLit(p.pkg, t, arg.CVal)
+	if lit == nil {
+		code, pos, end := p.loadExpr(arg.Src)
+		if reason := mismatchReason(p.pkg, arg, key); reason != "" {
+			return p.newCodeErrorf(
+				pos, end, "cannot use %s (type %v) as type %v in map key (%s)", code, arg.Type, key, reason)
+		}
+		return p.newCodeErrorf(pos, end, "cannot use %s (type %v) as type %v in map key", code, arg.Type, key)
+	}
+	v := types.ExprString(lit)
+	code, pos, end := p.loadExpr(arg.Src)
+	if oldPos, ok := (*keys)[v]; ok {
+		return p.newCodeErrorf(
+			pos, end, "duplicate key %s (value %s of type %v) in map literal\n\tprevious key at %v",
+			code, v, key, p.fset.Position(oldPos))
+	}
+	if *keys == nil {
+		*keys = make(constKeys)
+	}
+	(*keys)[v] = pos
+	return nil
+}
+
+// MapLitFromMap creates a map literal of type typ from a Go map m, whose keys
+// and values are anything accepted by Val. Because iterating a Go map is
+// nondeterministic, the entries are sorted by key so that the generated code
+// is reproducible: numbers, strings and bools in ascending order (false before
+// true), keys of different kinds by reflect.Kind, and others by their fmt.Sprint
+// forms. Config.MapKeyLess can be used to customize the order. Numbers and
+// strings of named Go types are generated as untyped constants.
+func (p *CodeBuilder) MapLitFromMap(typ types.Type, m interface{}, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("MapLitFromMap", typ, reflect.TypeOf(m))
+	}
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map {
+		panicln("MapLitFromMap: m isn't a map -", v.Type())
+	}
+	keys := v.MapKeys()
+	if less := p.mapKeyLess; less != nil {
+		sort.SliceStable(keys, func(i, j int) bool {
+			return less(keys[i].Interface(), keys[j].Interface())
+		})
+	} else {
+		sort.SliceStable(keys, func(i, j int) bool {
+			return mapKeyLess(keys[i], keys[j])
+		})
+	}
+	var tkey, tval types.Type
+	if typ != nil {
+		if t, ok := getUnderlying(p.pkg, typ).(*types.Map); ok {
+			tkey, tval = t.Key(), t.Elem()
+		}
+	}
+	for _, key := range keys {
+		p.pushGoVal(key, tkey)
+		p.pushGoVal(v.MapIndex(key), tval)
+	}
+	return p.MapLit(typ, len(keys)<<1, src...)
+}
+
+// pushGoVal pushes a Go value converted by goValOf. If it is assigned to an
+// interface, a constant is converted to its Go type (eg. uint(7)) explicitly.
+func (p *CodeBuilder) pushGoVal(v reflect.Value, target types.Type) {
+	val := goValOf(v)
+	if c, ok := val.(constant.Value); ok && target != nil && types.IsInterface(target) {
+		if v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+		if t := goBasicTypes[v.Kind()]; t != nil && v.Type().PkgPath() == "" {
+			p.Typ(t).Val(c).Call(1)
+			return
+		}
+	}
+	p.pushVal(val, nil)
+}
+
+var goBasicTypes = map[reflect.Kind]types.Type{
+	reflect.Int8:    types.Typ[types.Int8],
+	reflect.Int16:   types.Typ[types.Int16],
+	reflect.Int64:   types.Typ[types.Int64],
+	reflect.Uint:    types.Typ[types.Uint],
+	reflect.Uint8:   types.Typ[types.Uint8],
+	reflect.Uint16:  types.Typ[types.Uint16],
+	reflect.Uint32:  types.Typ[types.Uint32],
+	reflect.Uint64:  types.Typ[types.Uint64],
+	reflect.Uintptr: types.Typ[types.Uintptr],
+	reflect.Float32: types.Typ[types.Float32],
+}
+
+// mapKeyLess is the default order of MapLitFromMap.
+func mapKeyLess(x, y reflect.Value) bool {
+	if x.Kind() == reflect.Interface {
+		x = x.Elem()
+	}
+	if y.Kind() == reflect.Interface {
+		y = y.Elem()
+	}
+	if kx, ky := x.Kind(), y.Kind(); kx != ky {
+		return kx < ky
+	}
+	switch x.Kind() {
+	case reflect.Invalid:
+		return false
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return x.Int() < y.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return x.Uint() < y.Uint()
+	case reflect.Float32, reflect.Float64:
+		return x.Float() < y.Float()
+	case reflect.String:
+		return x.String() < y.String()
+	case reflect.Bool:
+		return !x.Bool() && y.Bool()
+	}
+	return fmt.Sprint(x.Interface()) < fmt.Sprint(y.Interface())
+}
+
+// goValOf converts a map key or value to a value accepted by Val: numbers,
+// strings and bools of other types than int, rune, float64, string and bool
+// are converted to constants.
+func goValOf(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	switch x := v.Interface().(type) {
+	case int, rune, float64, string, bool:
+		return x
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return constant.MakeInt64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return constant.MakeUint64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return constant.MakeFloat64(v.Float())
+	case reflect.String:
+		return constant.MakeString(v.String())
+	case reflect.Bool:
+		return constant.MakeBool(v.Bool())
+	}
+	return v.Interface()
+}
+
+// MapLitFromConsts creates a map literal of type typ from constant keys and
+// values, see SliceLitFromConsts.
+func (p *CodeBuilder) MapLitFromConsts(typ types.Type, keys, vals []constant.Value, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("MapLitFromConsts", typ, len(keys))
+	}
+	if len(keys) != len(vals) {
+		panicln("MapLitFromConsts: keys and values mismatch -", len(keys), len(vals))
+	}
+	t, ok := getUnderlying(p.pkg, typ).(*types.Map)
+	if !ok {
+		p.panicCodeErrorf(getPos(src), getEnd(src), "type %v isn't a map", typ)
+	}
+	ks := p.constLits(t.Key(), keys, "map key", src)
+	vs := p.constLits(t.Elem(), vals, "map value", src)
+	seen := make(map[string]null, len(ks))
+	elts := make([]ast.Expr, len(ks))
+	for i, k := range ks {
+		key := types.ExprString(k)
+		if _, ok := seen[key]; ok {
+			p.panicCodeErrorf(getPos(src), getEnd(src), "duplicate key %s in map literal", key)
+		}
+		seen[key] = null{}
+		elts[i] = &ast.KeyValueExpr{Key: k, Value: vs[i]}
+	}
+	p.stk.Push(&internal.Elem{
+		Type: typ, Val: &ast.CompositeLit{Type: toType(p.pkg, typ), Elts: elts}, Src: getSrc(src),
+	})
+	return p
+}
+
 func (p *CodeBuilder) toBoundArrayLen(elts []*internal.Elem, arity, limit int) int {
 	n := -1
 	max := -1
@@ -958,21 +1407,23 @@ func (p *CodeBuilder) SliceLitEx(typ types.Type, arity int, keyVal bool, src ...
 	if typ != nil {
 		typExpr = toType(pkg, typ)
 	retry:
+		var ok bool
 		switch tt := typ.(type) {
 		case *types.Named:
-			t = p.getUnderlying(tt).(*types.Slice)
+			t, ok = p.getUnderlying(tt).(*types.Slice)
 		case *types.Slice:
-			t = tt
+			t, ok = tt, true
 		case *typesalias.Alias:
 			typ = typesalias.Unalias(typ)
 			goto retry
-		default:
+		}
+		if !ok {
 			p.panicCodeErrorf(getPos(src), getEnd(src), "type %v isn't a slice", typ)
 		}
 	}
 	if keyVal { // in keyVal mode
 		if (arity & 1) != 0 {
-			log.Panicln("SliceLit: invalid arity, can't be odd in keyVal mode -", arity)
+			panicln("SliceLit: invalid arity, can't be odd in keyVal mode -", arity)
 		}
 		args := p.stk.GetArgs(arity)
 		val := t.Elem()
@@ -1028,6 +1479,77 @@ func (p *CodeBuilder) SliceLitEx(typ types.Type, arity int, keyVal bool, src ...
 	return p
 }
 
+// SliceConcat pops two slices a, b of the same type T from the stack, and
+// pushes their concatenation append(append(T{}, a...), b...). The result
+// never shares its backing array with a, so it suits lowering spread syntax
+// such as [...a, ...b] of other languages.
+func (p *CodeBuilder) SliceConcat(src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("SliceConcat")
+	}
+	args := p.stk.GetArgs(2)
+	a, b := args[0], args[1]
+	if _, ok := getUnderlying(p.pkg, a.Type).(*types.Slice); !ok {
+		code, pos, end := p.loadExpr(a.Src)
+		p.panicCodeErrorf(pos, end, "cannot concat %s (type %v): not a slice", code, a.Type)
+	}
+	if !types.Identical(a.Type, b.Type) {
+		code, pos, end := p.loadExpr(b.Src)
+		p.panicCodeErrorf(pos, end, "cannot concat %s (type %v) to type %v", code, b.Type, a.Type)
+	}
+	empty := &ast.CompositeLit{Type: toType(p.pkg, a.Type)}
+	head := &ast.CallExpr{Fun: identAppend, Args: []ast.Expr{empty, a.Val}, Ellipsis: 1}
+	p.stk.Ret(2, &internal.Elem{
+		Type: a.Type,
+		Val:  &ast.CallExpr{Fun: identAppend, Args: []ast.Expr{head, b.Val}, Ellipsis: 1},
+		Src:  getSrc(src),
+	})
+	return p
+}
+
+// SliceLitFromConsts creates a slice literal of type typ from constant values.
+// Unlike SliceLit, the elements don't go through the stack: they are checked
+// and converted to literals directly, so it suits large generated data tables.
+func (p *CodeBuilder) SliceLitFromConsts(typ types.Type, values []constant.Value, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("SliceLitFromConsts", typ, len(values))
+	}
+	t, ok := getUnderlying(p.pkg, typ).(*types.Slice)
+	if !ok {
+		p.panicCodeErrorf(getPos(src), getEnd(src), "type %v isn't a slice", typ)
+	}
+	elts := p.constLits(t.Elem(), values, "slice literal", src)
+	p.stk.Push(&internal.Elem{
+		Type: typ, Val: &ast.CompositeLit{Type: toType(p.pkg, typ), Elts: elts}, Src: getSrc(src),
+	})
+	return p
+}
+
+// constLits converts constant values to literals of type typ. The element
+// type is resolved once, and each value is checked for representability.
+func (p *CodeBuilder) constLits(typ types.Type, values []constant.Value, ctx string, src []ast.Node) []ast.Expr {
+	t, ok := getUnderlying(p.pkg, typ).(*types.Basic)
+	if !ok {
+		p.panicCodeErrorf(getPos(src), getEnd(src), "type %v isn't a basic type", typ)
+	}
+	elts := make([]ast.Expr, len(values))
+	for i, val := range values {
+		if elts[i] = constLit(p.pkg, t, val); elts[i] == nil {
+			p.panicConstMismatch(val, typ, ctx, src)
+		}
+	}
+	return elts
+}
+
+func (p *CodeBuilder) panicConstMismatch(val constant.Value, typ types.Type, ctx string, src []ast.Node) {
+	pos, end := getPos(src), getEnd(src)
+	arg := &internal.Elem{Type: untypedConstType(val), CVal: val}
+	if reason := mismatchReason(p.pkg, arg, typ); reason != "" {
+		p.panicCodeErrorf(pos, end, "cannot use %v (type %v) as type %v in %s (%s)", val, arg.Type, typ, ctx, reason)
+	}
+	p.panicCodeErrorf(pos, end, "cannot use %v (type %v) as type %v in %s", val, arg.Type, typ, ctx)
+}
+
 // ArrayLit func
 func (p *CodeBuilder) ArrayLit(typ types.Type, arity int, keyVal ...bool) *CodeBuilder {
 	var keyValMode = (keyVal != nil && keyVal[0])
@@ -1043,21 +1565,23 @@ func (p *CodeBuilder) ArrayLitEx(typ types.Type, arity int, keyVal bool, src ...
 	var t *types.Array
 	var pkg = p.pkg
 	typExpr := toType(pkg, typ)
+	var ok bool
 retry:
 	switch tt := typ.(type) {
 	case *types.Named:
-		t = p.getUnderlying(tt).(*types.Array)
+		t, ok = p.getUnderlying(tt).(*types.Array)
 	case *types.Array:
-		t = tt
+		t, ok = tt, true
 	case *typesalias.Alias:
 		typ = typesalias.Unalias(tt)
 		goto retry
-	default:
+	}
+	if !ok {
 		p.panicCodeErrorf(getPos(src), getEnd(src), "type %v isn't a array", typ)
 	}
 	if keyVal { // in keyVal mode
 		if (arity & 1) != 0 {
-			log.Panicln("ArrayLit: invalid arity, can't be odd in keyVal mode -", arity)
+			panicln("ArrayLit: invalid arity, can't be odd in keyVal mode -", arity)
 		}
 		n := int(t.Len())
 		args := p.stk.GetArgs(arity)
@@ -1103,34 +1627,159 @@ retry:
 	return p
 }
 
-// StructLit func
-func (p *CodeBuilder) StructLit(typ types.Type, arity int, keyVal bool, src ...ast.Node) *CodeBuilder {
+// StructLitByName creates a keyed struct literal of the fields named by names,
+// whose values are the len(names) items on the top of stack. Omitted fields
+// are zero values. Fields are rendered (and so evaluated) in the order of the
+// struct fields, so the result doesn't depend on the order of names, eg. when
+// they are keys of a map.
+func (p *CodeBuilder) StructLitByName(typ types.Type, names []string, src ...ast.Node) *CodeBuilder {
 	if debugInstr {
-		log.Println("StructLit", typ, arity, keyVal)
+		log.Println("StructLitByName", typ, names)
 	}
+	t := p.structOf(typ, src)
+	arity := len(names)
+	args := p.stk.GetArgs(arity)
+	flds := make([]structFieldVal, arity)
+	for i, name := range names {
+		idx := fieldIndex(t, name)
+		if idx < 0 {
+			pos, end := getSrcPos(args[i].Src), getSrcEnd(args[i].Src)
+			p.panicCodeErrorf(pos, end, "unknown field %s in struct literal of type %v", name, typ)
+		}
+		flds[i] = structFieldVal{idx, args[i]}
+	}
+	return p.structLitFields(typ, arity, flds, src)
+}
+
+// StructLitSparse creates a keyed struct literal from the n name/value pairs
+// on the top of stack, where each name is a string constant of a field name,
+// eg. pushed by Val("Timeout"). Fields are rendered in the order of the
+// struct fields like StructLitByName does. If dropZero is true, a pair whose
+// value is a constant (or nil) equal to the zero value of the field is
+// dropped, after the field name and the value are checked.
+func (p *CodeBuilder) StructLitSparse(typ types.Type, n int, dropZero bool, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("StructLitSparse", typ, n, dropZero)
+	}
+	t := p.structOf(typ, src)
+	args := p.stk.GetArgs(n << 1)
+	flds := make([]structFieldVal, 0, n)
+	used := make(map[int]none, n)
+	for i := 0; i < len(args); i += 2 {
+		key, val := args[i], args[i+1]
+		if key.CVal == nil || key.CVal.Kind() != constant.String {
+			code, pos, end := p.loadExpr(key.Src)
+			p.panicCodeErrorf(pos, end, "cannot use %s as field name which must be a string constant", code)
+		}
+		name := constant.StringVal(key.CVal)
+		idx := fieldIndex(t, name)
+		if idx < 0 {
+			pos, end := getSrcPos(key.Src), getSrcEnd(key.Src)
+			p.panicCodeErrorf(pos, end, "unknown field %s in struct literal of type %v", name, typ)
+		}
+		if _, ok := used[idx]; ok {
+			pos, end := getSrcPos(key.Src), getSrcEnd(key.Src)
+			p.panicCodeErrorf(pos, end, "duplicate field name %s in struct literal", name)
+		}
+		used[idx] = none{}
+		if fld := t.Field(idx); dropZero && p.isZeroConst(val, fld.Type()) {
+			if !AssignableTo(p.pkg, val.Type, fld.Type()) { // kept values are checked by StructLit
+				code, pos, end := p.loadExpr(val.Src)
+				p.panicCodeErrorf(
+					pos, end, "cannot use %s (type %v) as type %v in value of field %s", code, val.Type, fld.Type(), name)
+			}
+			continue
+		}
+		flds = append(flds, structFieldVal{idx, val})
+	}
+	return p.structLitFields(typ, n<<1, flds, src)
+}
+
+// structFieldVal is a value of the field idx in a struct literal.
+type structFieldVal struct {
+	idx int
+	val *internal.Elem
+}
+
+// structLitFields replaces the arity items on the top of stack with a keyed
+// struct literal of flds, which are rendered in the order of struct fields.
+func (p *CodeBuilder) structLitFields(typ types.Type, arity int, flds []structFieldVal, src []ast.Node) *CodeBuilder {
+	sort.SliceStable(flds, func(i, j int) bool {
+		return flds[i].idx < flds[j].idx
+	})
+	p.stk.PopN(arity)
+	for _, fld := range flds {
+		p.Val(fld.idx)
+		p.stk.Push(fld.val)
+	}
+	return p.StructLit(typ, len(flds)<<1, true, src...)
+}
+
+// structOf returns the underlying struct of typ, or reports an error if typ
+// isn't a struct.
+func (p *CodeBuilder) structOf(typ types.Type, src []ast.Node) *types.Struct {
 	var t *types.Struct
-	var pkg = p.pkg
-	typExpr := toType(pkg, typ)
-retry:
-	switch tt := typ.(type) {
+	switch tt := typesalias.Unalias(typ).(type) {
 	case *types.Named:
-		t = p.getUnderlying(tt).(*types.Struct)
+		t, _ = p.getUnderlying(tt).(*types.Struct)
 	case *types.Struct:
 		t = tt
-	case *typesalias.Alias:
-		typ = typesalias.Unalias(tt)
-		goto retry
-	default:
+	}
+	if t == nil {
 		p.panicCodeErrorf(getPos(src), getEnd(src), "type %v isn't a struct", typ)
 	}
+	return t
+}
+
+// fieldIndex returns the index of the field name of struct t, or -1 if t has
+// no such field.
+func fieldIndex(t *types.Struct, name string) int {
+	for i, n := 0, t.NumFields(); i < n; i++ {
+		if t.Field(i).Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// isZeroConst reports whether v is a constant (or nil) equal to the zero
+// value of type typ.
+func (p *CodeBuilder) isZeroConst(v *internal.Elem, typ types.Type) bool {
+	if v.Type == types.Typ[types.UntypedNil] {
+		return true
+	}
+	if _, ok := getUnderlying(p.pkg, typ).(*types.Basic); !ok || v.CVal == nil {
+		return false
+	}
+	switch cval := v.CVal; cval.Kind() {
+	case constant.Bool:
+		return !constant.BoolVal(cval)
+	case constant.String:
+		return constant.StringVal(cval) == ""
+	case constant.Int, constant.Float, constant.Complex:
+		return constant.Sign(cval) == 0
+	}
+	return false
+}
+
+// StructLit func
+func (p *CodeBuilder) StructLit(typ types.Type, arity int, keyVal bool, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("StructLit", typ, arity, keyVal)
+	}
+	var pkg = p.pkg
+	typExpr := toType(pkg, typ)
+	typ = typesalias.Unalias(typ)
+	t := p.structOf(typ, src)
 	var elts []ast.Expr
 	var n = t.NumFields()
 	var args = p.stk.GetArgs(arity)
 	if keyVal {
 		if (arity & 1) != 0 {
-			log.Panicln("StructLit: invalid arity, can't be odd in keyVal mode -", arity)
+			panicln("StructLit: invalid arity, can't be odd in keyVal mode -", arity)
 		}
 		elts = make([]ast.Expr, arity>>1)
+		used := make(map[int]none, arity>>1)
 		for i := 0; i < arity; i += 2 {
 			idx := p.toIntVal(args[i], "field which must be non-negative integer constant")
 			if idx >= n {
@@ -1138,6 +1787,11 @@ retry:
 			}
 			elt := t.Field(idx)
 			eltTy, eltName := elt.Type(), elt.Name()
+			if _, ok := used[idx]; ok {
+				pos, end := getSrcPos(args[i+1].Src), getSrcEnd(args[i+1].Src)
+				p.panicCodeErrorf(pos, end, "duplicate field name %s in struct literal", eltName)
+			}
+			used[idx] = none{}
 			if !AssignableTo(pkg, args[i+1].Type, eltTy) {
 				src, pos, end := p.loadExpr(args[i+1].Src)
 				p.panicCodeErrorf(
@@ -1186,6 +1840,10 @@ func (p *CodeBuilder) Slice(slice3 bool, src ...ast.Node) *CodeBuilder { // a[i:
 	}
 	srcExpr := getSrc(src)
 	args := p.stk.GetArgs(n)
+	if hasInvalidType(args) {
+		p.pushInvalid(n, srcExpr, false)
+		return p
+	}
 	x := args[0]
 	typ := x.Type
 	switch t := typ.(type) {
@@ -1235,6 +1893,10 @@ func (p *CodeBuilder) Index(nidx int, twoValue bool, src ...ast.Node) *CodeBuild
 		log.Println("Index", nidx, twoValue)
 	}
 	args := p.stk.GetArgs(nidx + 1)
+	if hasInvalidType(args) {
+		p.pushInvalid(nidx+1, getSrc(src), false)
+		return p
+	}
 	if nidx > 0 {
 		if _, ok := args[1].Type.(*TypeType); ok {
 			return p.instantiate(nidx, args, src...)
@@ -1250,7 +1912,7 @@ func (p *CodeBuilder) Index(nidx int, twoValue bool, src ...ast.Node) *CodeBuild
 		if !allowTwoValue {
 			pos := getSrcPos(srcExpr)
 			end := getSrcEnd(srcExpr)
-			p.panicCodeErrorf(pos, end, "assignment mismatch: 2 variables but 1 values")
+			p.panicCodeErrorf(pos, end, "assignment mismatch: 2 variables but 1 value")
 		}
 		pkg := p.pkg
 		tyRet = types.NewTuple(
@@ -1259,9 +1921,8 @@ func (p *CodeBuilder) Index(nidx int, twoValue bool, src ...ast.Node) *CodeBuild
 	} else { // elem = a[key]
 		tyRet = typs[1]
 	}
-	elem := &internal.Elem{
-		Val: &ast.IndexExpr{X: args[0].Val, Index: args[1].Val}, Type: tyRet, Src: srcExpr,
-	}
+	expr := &ast.IndexExpr{X: args[0].Val, Index: args[1].Val}
+	elem := &internal.Elem{Val: expr, Type: tyRet, Src: srcExpr, Idx: args[0]}
 	// TODO: check index type
 	p.stk.Ret(2, elem)
 	return p
@@ -1276,6 +1937,10 @@ func (p *CodeBuilder) IndexRef(nidx int, src ...ast.Node) *CodeBuilder {
 		panic("IndexRef doesn't support a[i, j...] = val yet")
 	}
 	args := p.stk.GetArgs(2)
+	if hasInvalidType(args) {
+		p.pushInvalid(2, getSrc(src), true)
+		return p
+	}
 	typ := args[0].Type
 	elemRef := &internal.Elem{
 		Val: &ast.IndexExpr{X: args[0].Val, Index: args[1].Val},
@@ -1344,6 +2009,185 @@ func (p *CodeBuilder) Typ(typ types.Type, src ...ast.Node) *CodeBuilder {
 	return p
 }
 
+// ArrayType pops the array length, a constant expression such as a reference
+// to a declared const, and returns an array type of elem with the folded
+// length. The length expression is kept when the array type is rendered.
+func (p *CodeBuilder) ArrayType(elem types.Type, src ...ast.Node) *types.Array {
+	if debugInstr {
+		log.Println("ArrayType", elem)
+	}
+	arg := p.stk.Pop()
+	code, pos, end := p.loadExpr(arg.Src)
+	if arg.CVal == nil {
+		p.panicCodeErrorf(pos, end, "array length %s (value of type %v) must be constant", code, arg.Type)
+	}
+	cv := constant.ToInt(arg.CVal)
+	if t, ok := arg.Type.Underlying().(*types.Basic); !ok || cv.Kind() != constant.Int ||
+		(t.Info()&types.IsUntyped == 0 && t.Info()&types.IsInteger == 0) {
+		p.panicCodeErrorf(pos, end, "array length %s (type %v) must be integer", code, arg.Type)
+	}
+	n, exact := constant.Int64Val(cv)
+	if !exact || n < 0 {
+		p.panicCodeErrorf(pos, end, "invalid array length %s", code)
+	}
+	t := types.NewArray(elem, n)
+	p.pkg.setArrayLen(t, arg.Val)
+	return t
+}
+
+// RawEnv describes how a pre-built ast fragment passed to RawStmt or RawExpr
+// binds to the package being built.
+type RawEnv struct {
+	// Imports maps package names used by the fragment (the X of `X.Sel`) to
+	// their import paths. These packages are imported by the generated file,
+	// and the references are renamed if the import is renamed.
+	Imports map[string]string
+
+	// Rename maps identifiers of the fragment to new names, so that the
+	// fragment binds to generated variables. Identifiers are renamed
+	// syntactically: only selected names (`x.Sel`) and keys of composite
+	// literals (`T{Key: v}`) are left unchanged.
+	Rename map[string]string
+}
+
+// RawStmt appends a pre-built statement (eg. parsed from user-supplied Go
+// source) to the current block. The statement isn't type checked, and its
+// nodes are modified in place as env specifies.
+func (p *CodeBuilder) RawStmt(stmt ast.Stmt, env ...*RawEnv) *CodeBuilder {
+	if debugInstr {
+		log.Println("RawStmt", stmt)
+	}
+	if env != nil {
+		p.bindRaw(stmt, env[0])
+	}
+	p.emitStmt(stmt)
+	return p
+}
+
+// RawExpr pushes a pre-built expression of type typ, see RawStmt.
+func (p *CodeBuilder) RawExpr(expr ast.Expr, typ types.Type, env ...*RawEnv) *CodeBuilder {
+	if debugInstr {
+		log.Println("RawExpr", expr, typ)
+	}
+	if env != nil {
+		p.bindRaw(expr, env[0])
+	}
+	p.stk.Push(&internal.Elem{Val: expr, Type: typ})
+	return p
+}
+
+// Snippet instantiates the snippet s in the current block: statements of s
+// are deep copied, and each free variable of s is replaced by the object of
+// the same index in args, which should have an identical type.
+//
+// Objects declared by s aren't renamed. Instead, it is an error if one of
+// its body block is already in scope (so s can be instantiated once per
+// block), or if one of its nested blocks shadows an object of args. All
+// errors are reported at once by a SnippetError.
+func (p *CodeBuilder) Snippet(s *Snippet, args []types.Object, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("Snippet", len(s.stmts))
+	}
+	srcExpr := getSrc(src)
+	pos, end := getSrcPos(srcExpr), getSrcEnd(srcExpr)
+	if len(args) != len(s.vars) {
+		p.panicCodeErrorf(pos, end, "snippet has %s but %s given",
+			plural(len(s.vars), "variable"), plural(len(args), "object"))
+	}
+	var errs SnippetError
+	for i, v := range s.vars {
+		if t := args[i].Type(); !types.Identical(t, v.Type()) {
+			errs = append(errs, p.newCodeErrorf(pos, end, "cannot use %s (type %v) as type %v for snippet variable %s",
+				args[i].Name(), t, v.Type(), v.Name()))
+		}
+	}
+	top, inner := s.locals()
+	for _, o := range top {
+		if _, obj := p.current.scope.LookupParent(o.Name(), token.NoPos); obj != nil {
+			errs = append(errs, p.newCodeErrorf(pos, end, "snippet declares %s, which is already in scope", o.Name()))
+		}
+	}
+	for _, o := range inner {
+		for i, arg := range args {
+			if o.Name() == arg.Name() {
+				errs = append(errs, p.newCodeErrorf(pos, end,
+					"snippet declares %s, which shadows the object for snippet variable %s", o.Name(), s.vars[i].Name()))
+			}
+		}
+	}
+	if errs != nil {
+		panic(errs)
+	}
+	for _, o := range top {
+		p.current.scope.Insert(o)
+	}
+	var imps map[*ast.Ident]string
+	if s.file != p.pkg.file {
+		imps = make(map[*ast.Ident]string, len(s.file.imps))
+		for pkgPath, id := range s.file.imps {
+			if id != nil {
+				imps[id] = pkgPath
+			}
+		}
+	}
+	subst := func(id *ast.Ident) ast.Expr {
+		if i, ok := s.refs[id]; ok {
+			return toObjectExpr(p.pkg, args[i])
+		}
+		if id.Obj != nil {
+			if _, ok := id.Obj.Data.(importUsed); ok { // imported package
+				if pkgPath, ok := imps[id]; ok {
+					return p.pkg.file.newImport(id.Name, pkgPath)
+				}
+				return id
+			}
+		}
+		return nil
+	}
+	for _, stmt := range s.stmts {
+		p.emitStmt(copyNode(stmt, subst).(ast.Stmt))
+	}
+	return p
+}
+
+func (p *CodeBuilder) bindRaw(node ast.Node, env *RawEnv) {
+	var bind func(node ast.Node) bool
+	bind = func(node ast.Node) bool {
+		switch v := node.(type) {
+		case *ast.SelectorExpr:
+			if x, ok := v.X.(*ast.Ident); ok {
+				if pkgPath, ok := env.Imports[x.Name]; ok {
+					v.X = p.pkg.file.newImport(p.pkg.Import(pkgPath).Types.Name(), pkgPath)
+					return false
+				}
+			}
+			ast.Inspect(v.X, bind)
+			return false
+		case *ast.CompositeLit:
+			if v.Type != nil {
+				ast.Inspect(v.Type, bind)
+			}
+			for _, elt := range v.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if _, ok := kv.Key.(*ast.Ident); !ok {
+						ast.Inspect(kv.Key, bind)
+					}
+					ast.Inspect(kv.Value, bind)
+				} else {
+					ast.Inspect(elt, bind)
+				}
+			}
+			return false
+		case *ast.Ident:
+			if name, ok := env.Rename[v.Name]; ok {
+				v.Name = name
+			}
+		}
+		return true
+	}
+	ast.Inspect(node, bind)
+}
+
 // UntypedBigInt func
 func (p *CodeBuilder) UntypedBigInt(v *big.Int, src ...ast.Node) *CodeBuilder {
 	pkg := p.pkg
@@ -1395,12 +2239,51 @@ func (p *CodeBuilder) UntypedBigRat(v *big.Rat, src ...ast.Node) *CodeBuilder {
 func (p *CodeBuilder) VarVal(name string, src ...ast.Node) *CodeBuilder {
 	_, o := p.Scope().LookupParent(name, token.NoPos)
 	if o == nil {
-		log.Panicf("VarVal: variable `%v` not found\n", name)
+		if p.implicitMember(name, MemberFlagAutoProperty, getSrc(src)) {
+			return p
+		}
+		panicf("VarVal: variable `%v` not found\n", name)
 	}
 	return p.Val(o, src...)
 }
 
+// SetImplicitRecv sets the implicit receiver of current function body (and
+// closures in it), eg. `this` of a Go+ classfile. If a name isn't found in
+// any scope, VarVal and VarRef retry it as a member of the implicit receiver
+// and emit `recv.name`. Names declared in scope always shadow the members.
+// SetImplicitRecv(nil) disables it.
+func (p *CodeBuilder) SetImplicitRecv(v *types.Var) *CodeBuilder {
+	p.current.recv = v
+	return p
+}
+
+// ImplicitRecv returns the implicit receiver of current function body.
+func (p *CodeBuilder) ImplicitRecv() *types.Var {
+	return p.current.recv
+}
+
+// implicitMember pushes `recv.name` where recv is the implicit receiver. It
+// returns false if there is no implicit receiver or it has no such member.
+func (p *CodeBuilder) implicitMember(name string, flag MemberFlag, src ast.Node) bool {
+	recv := p.current.recv
+	if recv == nil {
+		return false
+	}
+	p.Val(recv)
+	if _, err := p.Member(name, flag, src); err != nil {
+		p.stk.Pop()
+		return false
+	}
+	return true
+}
+
 // Val func
+//
+// Go values of int, float64, complex128, string, bool and rune are pushed as
+// untyped constants, and a rune (int32) is rendered as a character literal
+// (eg. 'a'). Go values of other basic types are pushed as typed constants with
+// a conversion, eg. uint8(7), float32(1.5) or complex64(1 + 2i). To push a
+// typed int32, use Typ(types.Typ[types.Int32]).Val(v).Call(1).
 func (p *CodeBuilder) Val(v interface{}, src ...ast.Node) *CodeBuilder {
 	if debugInstr {
 		if o, ok := v.(types.Object); ok {
@@ -1409,6 +2292,9 @@ func (p *CodeBuilder) Val(v interface{}, src ...ast.Node) *CodeBuilder {
 			log.Println("Val", v, reflect.TypeOf(v))
 		}
 	}
+	if param, ok := v.(*types.Var); ok {
+		p.checkVarName(param, getSrc(src))
+	}
 	fn := p.current.fn
 	if fn != nil && fn.isInline() { // is in an inline call
 		if param, ok := v.(*types.Var); ok {
@@ -1418,7 +2304,32 @@ func (p *CodeBuilder) Val(v interface{}, src ...ast.Node) *CodeBuilder {
 			}
 		}
 	}
-	return p.pushVal(v, getSrc(src))
+	p.pushVal(v, getSrc(src))
+	if o, ok := v.(*types.Var); ok {
+		x := p.stk.Get(-1).Val
+		p.snippetRef(o, x)
+		p.sessionRef(o)
+		p.loopVarRef(o, x, false)
+	}
+	return p
+}
+
+// checkVarName reports an error if v is blank or unnamed (eg. the receiver
+// of `func (T) M()`), which can't be referenced.
+func (p *CodeBuilder) checkVarName(v *types.Var, src ast.Node) {
+	name := v.Name()
+	if name != "" && name != "_" {
+		return
+	}
+	kind := "variable"
+	if fn := p.current.fn; fn != nil && fn.Ancestor().Recv() == v {
+		kind = "receiver"
+	}
+	pos, end := getSrcPos(src), getSrcEnd(src)
+	if name == "_" {
+		p.panicCodeErrorf(pos, end, "cannot use blank %s _ as value", kind)
+	}
+	p.panicCodeErrorf(pos, end, "cannot refer to unnamed %s", kind)
 }
 
 func (p *CodeBuilder) pushVal(v interface{}, src ast.Node) *CodeBuilder {
@@ -1426,12 +2337,37 @@ func (p *CodeBuilder) pushVal(v interface{}, src ast.Node) *CodeBuilder {
 	return p
 }
 
+// RawString renders the string literal on the top of stack as a raw string
+// literal (eg. `SELECT * FROM t`) if possible. A string containing backquote,
+// carriage return, NUL, BOM or invalid UTF-8 can't be a raw string literal,
+// so it remains an interpreted string literal.
+func (p *CodeBuilder) RawString() *CodeBuilder {
+	if debugInstr {
+		log.Println("RawString")
+	}
+	arg := p.stk.Get(-1)
+	if lit, ok := arg.Val.(*ast.BasicLit); ok && lit.Kind == token.STRING && arg.CVal != nil {
+		if s := constant.StringVal(arg.CVal); canRawString(s) {
+			arg.Val = &ast.BasicLit{ValuePos: lit.ValuePos, Kind: token.STRING, Value: "`" + s + "`"}
+		}
+	}
+	return p
+}
+
+func canRawString(s string) bool {
+	return utf8.ValidString(s) && !strings.ContainsAny(s, "`\r\x00\ufeff")
+}
+
 // Star func
 func (p *CodeBuilder) Star(src ...ast.Node) *CodeBuilder {
 	if debugInstr {
 		log.Println("Star")
 	}
 	arg := p.stk.Get(-1)
+	if isInvalidType(arg.Type) {
+		p.pushInvalid(1, getSrc(src), false)
+		return p
+	}
 	ret := &internal.Elem{Val: &ast.StarExpr{X: arg.Val}, Src: getSrc(src)}
 	argType := arg.Type
 retry:
@@ -1457,6 +2393,10 @@ func (p *CodeBuilder) Elem(src ...ast.Node) *CodeBuilder {
 		log.Println("Elem")
 	}
 	arg := p.stk.Get(-1)
+	if isInvalidType(arg.Type) {
+		p.pushInvalid(1, getSrc(src), false)
+		return p
+	}
 	t, ok := arg.Type.(*types.Pointer)
 	if !ok {
 		code, pos, end := p.loadExpr(arg.Src)
@@ -1472,6 +2412,10 @@ func (p *CodeBuilder) ElemRef(src ...ast.Node) *CodeBuilder {
 		log.Println("ElemRef")
 	}
 	arg := p.stk.Get(-1)
+	if isInvalidType(arg.Type) {
+		p.pushInvalid(1, getSrc(src), true)
+		return p
+	}
 	t, ok := arg.Type.(*types.Pointer)
 	if !ok {
 		code, pos, end := p.loadExpr(arg.Src)
@@ -1605,6 +2549,10 @@ func (p *CodeBuilder) Member(name string, flag MemberFlag, src ...ast.Node) (kin
 	if debugInstr {
 		log.Println("Member", name, flag, "//", arg.Type)
 	}
+	if isInvalidType(arg.Type) {
+		p.pushInvalid(1, srcExpr, flag == MemberFlagRef)
+		return MemberField, nil
+	}
 	at := typesalias.Unalias(arg.Type)
 	switch at {
 	case p.pkg.utBigInt, p.pkg.utBigRat, p.pkg.utBigFlt:
@@ -1626,6 +2574,11 @@ func (p *CodeBuilder) Member(name string, flag MemberFlag, src ...ast.Node) (kin
 			return MemberInvalid, p.newCodeError(
 				pos, end, fmt.Sprintf("%s undefined (type %v has no method %s)", code, at, name))
 		}
+		if kind == MemberMethod && !isType {
+			if err = p.checkPtrMethod(at, arg, srcExpr); err != nil {
+				return MemberInvalid, err
+			}
+		}
 	}
 	if kind > 0 {
 		return
@@ -1635,6 +2588,73 @@ func (p *CodeBuilder) Member(name string, flag MemberFlag, src ...ast.Node) (kin
 		pos, end, fmt.Sprintf("%s undefined (type %v has no field or method %s)", code, arg.Type, name))
 }
 
+// checkPtrMethod checks if the method just found (at top of the stack) has a
+// pointer receiver but arg is neither a pointer nor addressable. Go inserts
+// the address-of (&arg).M() automatically only for addressable values.
+func (p *CodeBuilder) checkPtrMethod(typ types.Type, arg *Element, src ast.Node) error {
+	if sel, ok := p.stk.Get(-1).Val.(*ast.SelectorExpr); ok && !p.isAddressable(arg) {
+		name := sel.Sel.Name
+		if obj, _, indirect := types.LookupFieldOrMethod(typ, false, p.pkg.Types, name); obj == nil && indirect {
+			_, pos, end := p.loadExpr(src)
+			if p.isMapIndex(arg) {
+				code, _, _ := p.loadExpr(arg.Src)
+				return p.newCodeErrorf(pos, end, "cannot call pointer method on map index %s (method %s has pointer receiver)", code, name)
+			}
+			return p.newCodeErrorf(pos, end, "cannot call pointer method %s on %v", name, typ)
+		}
+	}
+	return nil
+}
+
+func (p *CodeBuilder) isMapIndex(arg *Element) bool {
+	if x := arg.Idx; x != nil {
+		_, ok := getUnderlying(p.pkg, x.Type).(*types.Map)
+		return ok
+	}
+	return false
+}
+
+// isAddressable reports whether arg is addressable: a variable, a pointer
+// indirection, a field selector of an addressable struct, or an index of a
+// slice or an addressable array (but not of a map or a string).
+func (p *CodeBuilder) isAddressable(arg *Element) bool {
+	if arg.CVal != nil {
+		return false
+	}
+	if x := arg.Idx; x != nil {
+		if _, ok := arg.Val.(*ast.IndexExpr); ok {
+			switch getUnderlying(p.pkg, x.Type).(type) {
+			case *types.Slice, *types.Pointer: // *array
+				return true
+			case *types.Array:
+				return p.isAddressable(x)
+			}
+			return false
+		}
+	}
+	return p.isAddressableExpr(arg.Val)
+}
+
+func (p *CodeBuilder) isAddressableExpr(expr ast.Expr) bool {
+	switch v := expr.(type) {
+	case *ast.Ident, *ast.StarExpr:
+		return true
+	case *ast.IndexExpr: // operand unknown, see isAddressable
+		return true
+	case *ast.ParenExpr:
+		return p.isAddressableExpr(v.X)
+	case *ast.SelectorExpr:
+		if recv := denoteRecv(v); recv != nil {
+			if _, ok := recv.Type.Underlying().(*types.Pointer); ok {
+				return true
+			}
+			return p.isAddressable(recv)
+		}
+		return true // pkg.Var
+	}
+	return false
+}
+
 func (p *CodeBuilder) getUnderlying(t *types.Named) types.Type {
 	u := t.Underlying()
 	if u == nil {
@@ -1711,6 +2731,11 @@ retry:
 		if kind := p.method(o, name, aliasName, flag, arg, srcExpr); kind != MemberInvalid {
 			return kind
 		}
+	case *types.TypeParam: // methods of a type parameter are those of its constraint
+		if t, ok := o.Constraint().Underlying().(*types.Interface); ok {
+			t.Complete()
+			return p.method(t, name, aliasName, flag, arg, srcExpr)
+		}
 	case *types.Basic, *types.Slice, *types.Map, *types.Chan:
 		return p.btiMethod(p.getBuiltinTI(o), name, aliasName, flag, srcExpr)
 	}
@@ -1957,7 +2982,7 @@ func (p *CodeBuilder) methodSigOf(typ types.Type, flag MemberFlag, arg, ret *Ele
 	if t, ok := at.(*types.Pointer); ok {
 		if !isPtr {
 			if _, ok := recv.Underlying().(*types.Interface); !ok { // and recv isn't a interface
-				log.Panicf("recv of method %v.%s isn't a pointer\n", t.Elem(), sel.Sel.Name)
+				panicf("recv of method %v.%s isn't a pointer\n", t.Elem(), sel.Sel.Name)
 			}
 		}
 	} else if isPtr { // use *T
@@ -1999,6 +3024,10 @@ func (p *CodeBuilder) IncDec(op token.Token, src ...ast.Node) *CodeBuilder {
 	}
 	pkg := p.pkg
 	arg := p.stk.Pop()
+	if isInvalidType(arg.Type) {
+		p.emitStmt(&ast.IncDecStmt{X: arg.Val, Tok: op})
+		return p
+	}
 	if t, ok := arg.Type.(*refType).typ.(*types.Named); ok {
 		op := lookupMethod(t, name)
 		if op != nil {
@@ -2032,7 +3061,12 @@ var (
 // AssignOp func
 func (p *CodeBuilder) AssignOp(op token.Token, src ...ast.Node) *CodeBuilder {
 	args := p.stk.GetArgs(2)
-	stmt := callAssignOp(p.pkg, op, args, src)
+	var stmt ast.Stmt
+	if hasInvalidType(args) {
+		stmt = &ast.AssignStmt{Lhs: []ast.Expr{args[0].Val}, Tok: op, Rhs: []ast.Expr{args[1].Val}}
+	} else {
+		stmt = callAssignOp(p.pkg, op, args, src)
+	}
 	p.emitStmt(stmt)
 	p.stk.PopN(2)
 	return p
@@ -2084,7 +3118,7 @@ func callAssignOp(pkg *Package, tok token.Token, args []*internal.Elem, src []as
 		}
 	}
 	op := pkg.builtin.Ref(name)
-	if tok == token.QUO_ASSIGN {
+	if tok == token.QUO_ASSIGN || tok == token.REM_ASSIGN {
 		checkDivisionByZero(&pkg.cb, &internal.Elem{Val: args[0].Val, Type: args[0].Type.(*refType).typ}, args[1])
 	}
 	fn := &internal.Elem{
@@ -2147,6 +3181,7 @@ func (p *CodeBuilder) AssignWith(lhs, rhs int, src ...ast.Node) *CodeBuilder {
 }
 
 func (p *CodeBuilder) doAssignWith(lhs, rhs int, src ast.Node) *CodeBuilder {
+	p.dispatchByResults(rhs)
 	mkBlockStmt := false
 	args := p.stk.GetArgs(lhs + rhs)
 	stmt := &ast.AssignStmt{
@@ -2154,18 +3189,35 @@ func (p *CodeBuilder) doAssignWith(lhs, rhs int, src ast.Node) *CodeBuilder {
 		Lhs: make([]ast.Expr, lhs),
 		Rhs: make([]ast.Expr, rhs),
 	}
+	if rhs == 1 && lhs != 1 && isInvalidType(args[lhs].Type) { // v1, v2 = <invalid>
+		for i := 0; i < lhs; i++ {
+			stmt.Lhs[i] = args[i].Val
+		}
+		stmt.Rhs[0] = args[lhs].Val
+		goto done
+	}
 	if rhs == 1 {
 		if rhsVals, ok := args[lhs].Type.(*types.Tuple); ok {
+			_, isCall := args[lhs].Val.(*ast.CallExpr)
 			if lhs != rhsVals.Len() {
 				pos := getSrcPos(src)
 				end := getSrcEnd(src)
+				if !isCall {
+					p.panicCodeErrorf(pos, end, "assignment mismatch: %s but 1 value", plural(lhs, "variable"))
+				}
 				caller := getCaller(args[lhs])
 				p.panicCodeErrorf(
-					pos, end, "assignment mismatch: %d variables but %v returns %d values",
-					lhs, caller, rhsVals.Len())
+					pos, end, "assignment mismatch: %s but %v returns %s",
+					plural(lhs, "variable"), caller, plural(rhsVals.Len(), "value"))
 			}
 			for i := 0; i < lhs; i++ {
 				val := &internal.Elem{Type: rhsVals.At(i).Type(), Src: src}
+				if !isCall { // comma-ok: v, ok = m[k], x.(T) or <-ch
+					val.Val, val.Src = args[lhs].Val, args[lhs].Src
+					if i == 1 { // ok is an untyped boolean value
+						val.Type = types.Typ[types.UntypedBool]
+					}
+				}
 				checkAssignType(p.pkg, args[i].Type, val)
 				stmt.Lhs[i] = args[i].Val
 			}
@@ -2184,7 +3236,7 @@ func (p *CodeBuilder) doAssignWith(lhs, rhs int, src ast.Node) *CodeBuilder {
 		pos := getSrcPos(src)
 		end := getSrcEnd(src)
 		p.panicCodeErrorf(
-			pos, end, "assignment mismatch: %d variables but %d values", lhs, rhs)
+			pos, end, "assignment mismatch: %s but %s", plural(lhs, "variable"), plural(rhs, "value"))
 	}
 done:
 	p.emitStmt(stmt)
@@ -2248,6 +3300,10 @@ func (p *CodeBuilder) UnaryOp(op token.Token, params ...interface{}) *CodeBuilde
 	if debugInstr {
 		log.Println("UnaryOp", op, "flags:", flags)
 	}
+	if isInvalidType(p.stk.Get(-1).Type) {
+		p.pushInvalid(1, src, false)
+		return p
+	}
 	ret, err := doUnaryOp(p, op, p.stk.GetArgs(1), flags)
 	if err != nil {
 		panic(err)
@@ -2268,6 +3324,10 @@ func (p *CodeBuilder) BinaryOp(op token.Token, src ...ast.Node) *CodeBuilder {
 	pkg := p.pkg
 	name := goxPrefix + binaryOps[op]
 	args := p.stk.GetArgs(2)
+	if hasInvalidType(args) {
+		p.pushInvalid(2, getSrc(src), false)
+		return p
+	}
 
 	var ret *internal.Elem
 	var err error = errNotFound
@@ -2290,7 +3350,7 @@ func (p *CodeBuilder) BinaryOp(op token.Token, src ...ast.Node) *CodeBuilder {
 		}
 	}
 	if err != nil && !isUserDef {
-		if op == token.QUO {
+		if op == token.QUO || op == token.REM {
 			checkDivisionByZero(p, args[0], args[1])
 		}
 		if op == token.EQL || op == token.NEQ {
@@ -2329,6 +3389,9 @@ func (p *CodeBuilder) BinaryOp(op token.Token, src ...ast.Node) *CodeBuilder {
 				pos, end, "invalid operation: operator %s not defined on %s (%v)", opstr, arg0Src, arg0)
 		}
 	}
+	if op == token.ADD && pkg.conf.FoldStringConcat && !isUserDef && ret.CVal != nil && ret.CVal.Kind() == constant.String {
+		ret.Val = stringConstExpr(pkg, ret.Type, ret.CVal)
+	}
 	ret.Src = expr
 	p.stk.Ret(2, ret)
 	return p
@@ -2398,33 +3461,59 @@ func (p *CodeBuilder) Send() *CodeBuilder {
 }
 
 // Defer func
-func (p *CodeBuilder) Defer() *CodeBuilder {
+//
+// As Go does, the function value and arguments of the deferred call are
+// evaluated immediately. For a method call like `defer x.Close()`, it means
+// the receiver x (or &x for a pointer method) is captured when the defer
+// statement executes, not when the deferred call runs.
+//
+// If wrap is true, the call is wrapped in a closure (`defer func() { f(g()) }()`)
+// so that its function value and arguments are evaluated when the deferred
+// call runs. Variables referenced by the call are captured by the closure, so
+// loop variables follow the semantics of Config.GoVersion.
+func (p *CodeBuilder) Defer(wrap ...bool) *CodeBuilder {
 	if debugInstr {
-		log.Println("Defer")
+		log.Println("Defer", wrap)
 	}
 	arg := p.stk.Pop()
 	call, ok := arg.Val.(*ast.CallExpr)
 	if !ok {
 		panic("TODO: please use defer callExpr()")
 	}
+	if wrap != nil && wrap[0] {
+		call = wrapCall(call)
+	}
 	p.emitStmt(&ast.DeferStmt{Call: call})
 	return p
 }
 
 // Go func
-func (p *CodeBuilder) Go() *CodeBuilder {
+//
+// If wrap is true, the call is wrapped in a closure like Defer does.
+func (p *CodeBuilder) Go(wrap ...bool) *CodeBuilder {
 	if debugInstr {
-		log.Println("Go")
+		log.Println("Go", wrap)
 	}
 	arg := p.stk.Pop()
 	call, ok := arg.Val.(*ast.CallExpr)
 	if !ok {
 		panic("TODO: please use go callExpr()")
 	}
+	if wrap != nil && wrap[0] {
+		call = wrapCall(call)
+	}
 	p.emitStmt(&ast.GoStmt{Call: call})
 	return p
 }
 
+// wrapCall returns `func() { call }()`.
+func wrapCall(call *ast.CallExpr) *ast.CallExpr {
+	return &ast.CallExpr{Fun: &ast.FuncLit{
+		Type: &ast.FuncType{Params: &ast.FieldList{}},
+		Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ExprStmt{X: call}}},
+	}}
+}
+
 // Block starts a block statement.
 func (p *CodeBuilder) Block(src ...ast.Node) *CodeBuilder {
 	if debugInstr {
@@ -2501,11 +3590,14 @@ func (p *CodeBuilder) Else(src ...ast.Node) *CodeBuilder {
 //
 // end
 // </pre>
+//
+// If name is empty, no variable is bound and the guard is just expr.(type).
 func (p *CodeBuilder) TypeSwitch(name string, src ...ast.Node) *CodeBuilder {
 	if debugInstr {
 		log.Println("TypeSwitch")
 	}
 	stmt := &typeSwitchStmt{name: name}
+	p.startBranchTarget(false)
 	p.startBlockStmt(stmt, src, "type switch statement", &stmt.old)
 	return p
 }
@@ -2516,18 +3608,22 @@ func (p *CodeBuilder) TypeAssert(typ types.Type, twoValue bool, src ...ast.Node)
 		log.Println("TypeAssert", typ, twoValue)
 	}
 	arg := p.stk.Get(-1)
+	if isInvalidType(arg.Type) || isInvalidType(typ) {
+		p.pushInvalid(1, getSrc(src), false)
+		return p
+	}
 	xType, ok := p.checkInterface(arg.Type)
 	if !ok {
 		text, pos, end := p.loadExpr(getSrc(src))
 		p.panicCodeErrorf(
 			pos, end, "invalid type assertion: %s (non-interface type %v on left)", text, arg.Type)
 	}
-	if missing := p.missingMethod(typ, xType); missing != "" {
+	if reason := p.missingMethod(typ, xType); reason != "" {
 		pos := getSrcPos(getSrc(src))
 		end := getSrcEnd(getSrc(src))
 		p.panicCodeErrorf(
-			pos, end, "impossible type assertion:\n\t%v does not implement %v (missing %s method)",
-			typ, arg.Type, missing)
+			pos, end, "impossible type assertion:\n\t%v does not implement %v (%s)",
+			typ, arg.Type, reason)
 	}
 	pkg := p.pkg
 	ret := &ast.TypeAssertExpr{X: arg.Val, Type: toType(pkg, typ)}
@@ -2542,12 +3638,53 @@ func (p *CodeBuilder) TypeAssert(typ types.Type, twoValue bool, src ...ast.Node)
 	return p
 }
 
-func (p *CodeBuilder) missingMethod(T types.Type, V *types.Interface) (missing string) {
+// IfTypeAssert starts an `if name, ok := x.(T); ok {` statement, where x is
+// the value on top of the stack, and leaves the builder in the then-block, so
+// Else and End work as they do for If. The variable name (of type T) is
+// declared in the if scope. The ok variable isn't visible to the builder, and
+// it's renamed if needed so as not to shadow another ok in scope.
+func (p *CodeBuilder) IfTypeAssert(name string, typ types.Type, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("IfTypeAssert", name, typ)
+	}
+	x := p.TypeAssert(typ, true, src...).stk.Pop()
+	p.If(src...)
+	ok := "ok"
+	for i := 1; ; i++ {
+		if _, o := p.current.scope.LookupParent(ok, token.NoPos); o == nil {
+			break
+		}
+		ok = "ok" + strconv.Itoa(i)
+	}
+	if name == "" {
+		name = "_"
+	}
+	p.emitStmt(&ast.AssignStmt{
+		Lhs: []ast.Expr{ident(name), ident(ok)},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{x.Val},
+	})
+	if name != "_" {
+		p.useLocalName(name, getPos(src), getEnd(src))
+		p.current.scope.Insert(types.NewVar(token.NoPos, p.pkg.Types, name, typ))
+	}
+	p.stk.Push(&internal.Elem{Val: ident(ok), Type: types.Typ[types.Bool]})
+	return p.Then(src...)
+}
+
+// missingMethod returns why a value of type T can't have the dynamic type
+// of an interface V, eg. "missing Foo method", or "" if it can. If T is an
+// interface, only methods in both T and V are checked, which must have the
+// same signature.
+func (p *CodeBuilder) missingMethod(T types.Type, V *types.Interface) string {
 	p.ensureLoaded(T)
-	if m, _ := types.MissingMethod(T, V, false); m != nil {
-		missing = m.Name()
+	if m, wrongType := types.MissingMethod(T, V, false); m != nil {
+		if wrongType {
+			return "wrong type for method " + m.Name()
+		}
+		return "missing " + m.Name() + " method"
 	}
-	return
+	return ""
 }
 
 func (p *CodeBuilder) checkInterface(typ types.Type) (*types.Interface, bool) {
@@ -2594,12 +3731,14 @@ func (p *CodeBuilder) TypeDefaultThen(src ...ast.Node) *CodeBuilder {
 	return p.TypeCase(src...).Then(src...)
 }
 
-// Select starts a select statement.
+// Select starts a select statement. A select without any comm case (select {})
+// blocks forever, while a select with only a default clause never blocks.
 func (p *CodeBuilder) Select(src ...ast.Node) *CodeBuilder {
 	if debugInstr {
 		log.Println("Select")
 	}
 	stmt := &selectStmt{}
+	p.startBranchTarget(false)
 	p.startBlockStmt(stmt, src, "select statement", &stmt.old)
 	return p
 }
@@ -2621,12 +3760,191 @@ func (p *CodeBuilder) CommDefaultThen(src ...ast.Node) *CodeBuilder {
 	return p.CommCase(src...).Then(src...)
 }
 
+// SelectTimeoutFlags represents options of CodeBuilder.SelectTimeout.
+type SelectTimeoutFlags int
+
+const (
+	SelectTimeoutValue    SelectTimeoutFlags = 1 << iota // bind the received value and push it
+	SelectTimeoutTimedOut                                // bind whether it timed out and push it
+	SelectTimeoutCtx                                     // add a case of ctx.Done()
+)
+
+// SelectTimeout emits a select statement which receives a value from a channel
+// with a timeout. It pops the channel and the timeout duration (and a context
+// if flags has SelectTimeoutCtx) from the stack, then emits:
+//
+//	var v T           // only if SelectTimeoutValue
+//	var timedOut bool // only if SelectTimeoutTimedOut
+//	select {
+//	case v = <-ch:    // `case <-ch:` without SelectTimeoutValue
+//	case <-time.After(d):
+//		timedOut = true
+//	case <-ctx.Done(): // only if SelectTimeoutCtx
+//		timedOut = true
+//	}
+//
+// and pushes the variables bound (v, timedOut or both, in this order) onto
+// the stack. Only results which are used should be bound, since Go rejects
+// unused variables.
+func (p *CodeBuilder) SelectTimeout(flags SelectTimeoutFlags, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("SelectTimeout", flags)
+	}
+	n := 2
+	if flags&SelectTimeoutCtx != 0 {
+		n = 3
+	}
+	args := append([]*internal.Elem(nil), p.stk.GetArgs(n)...)
+	ch := args[0]
+	t, ok := getUnderlying(p.pkg, ch.Type).(*types.Chan)
+	if !ok || t.Dir() == types.SendOnly {
+		what := "non-channel"
+		if ok {
+			what = "send-only channel"
+		}
+		text, pos, end := p.loadExpr(ch.Src)
+		p.panicCodeErrorf(pos, end, "invalid operation: cannot receive from %s %s (type %v)", what, text, ch.Type)
+	}
+	p.stk.PopN(n)
+
+	pkg := p.pkg
+	time := pkg.Import("time")
+	var vRef, timedOutRef types.Object
+	if flags&SelectTimeoutValue != 0 {
+		v := p.autoName()
+		p.NewVar(t.Elem(), v)
+		vRef = p.current.scope.Lookup(v)
+	}
+	if flags&SelectTimeoutTimedOut != 0 {
+		timedOut := p.autoName()
+		p.NewVar(types.Typ[types.Bool], timedOut)
+		timedOutRef = p.current.scope.Lookup(timedOut)
+	}
+	setTimedOut := func() {
+		if timedOutRef != nil {
+			p.VarRef(timedOutRef).Val(true).Assign(1)
+		}
+	}
+	p.Select(src...).CommCase()
+	if vRef != nil {
+		p.VarRef(vRef)
+	}
+	p.stk.Push(ch)
+	p.UnaryOp(token.ARROW)
+	if vRef != nil {
+		p.Assign(1)
+	} else {
+		p.EndStmt()
+	}
+	p.Then().End()
+	p.CommCase().Val(time.Ref("After"))
+	p.stk.Push(args[1])
+	p.Call(1).UnaryOp(token.ARROW).EndStmt().Then()
+	setTimedOut()
+	p.End()
+	if flags&SelectTimeoutCtx != 0 {
+		p.CommCase()
+		p.stk.Push(args[2])
+		p.MemberVal("Done").Call(0).UnaryOp(token.ARROW).EndStmt().Then()
+		setTimedOut()
+		p.End()
+	}
+	p.End()
+	if vRef != nil {
+		p.Val(vRef)
+	}
+	if timedOutRef != nil {
+		p.Val(timedOutRef)
+	}
+	return p
+}
+
+// ErrorsNew pushes `errors.New(msg)` onto the stack.
+func (p *CodeBuilder) ErrorsNew(msg string, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("ErrorsNew", msg)
+	}
+	return p.Val(p.pkg.Import("errors").Ref("New")).Val(msg).CallWith(1, 0, src...)
+}
+
+// Errorf pushes `fmt.Errorf(format, args...)` onto the stack, where format and
+// args are the top arity elements of the stack. If format is a constant, each
+// %w verb must refer to an argument of error type, and more than one %w verb
+// requires go1.20.
+func (p *CodeBuilder) Errorf(arity int, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("Errorf", arity)
+	}
+	if arity < 1 {
+		p.panicCodeError(getPos(src), getEnd(src),
+			"not enough arguments in call to fmt.Errorf\n\thave ()\n\twant (string, ...any)")
+	}
+	args := append([]*internal.Elem(nil), p.stk.GetArgs(arity)...)
+	if cv := args[0].CVal; cv != nil && cv.Kind() == constant.String {
+		p.checkErrorf(constant.StringVal(cv), args)
+	}
+	p.stk.PopN(arity)
+	p.Val(p.pkg.Import("fmt").Ref("Errorf"))
+	for _, arg := range args {
+		p.stk.Push(arg)
+	}
+	return p.CallWith(arity, 0, src...)
+}
+
+func (p *CodeBuilder) checkErrorf(format string, args []*internal.Elem) {
+	nwrap, argNum := 0, 0
+	for i, n := 0, len(format); i < n; i++ {
+		if format[i] != '%' {
+			continue
+		}
+		for i++; i < n && strings.IndexByte("+-# 0", format[i]) >= 0; i++ { // flags
+		}
+		for i < n && (format[i] == '*' || format[i] == '.' || format[i] == '[' || '0' <= format[i] && format[i] <= '9') {
+			switch format[i] {
+			case '*':
+				argNum++
+			case '[': // explicit argument index
+				j := strings.IndexByte(format[i:], ']')
+				if j < 0 {
+					return
+				}
+				idx, err := strconv.Atoi(format[i+1 : i+j])
+				if err != nil {
+					return
+				}
+				argNum, i = idx-1, i+j
+			}
+			i++
+		}
+		if i == n || format[i] == '%' {
+			continue
+		}
+		if format[i] == 'w' {
+			nwrap++
+			if argNum+1 >= len(args) {
+				_, pos, end := p.loadExpr(args[0].Src)
+				p.panicCodeErrorf(pos, end, "fmt.Errorf format %%w reads arg #%d, but call has %s", argNum+1, plural(len(args)-1, "arg"))
+			}
+			if arg := args[argNum+1]; !AssignableTo(p.pkg, arg.Type, TyError) {
+				text, pos, end := p.loadExpr(arg.Src)
+				p.panicCodeErrorf(pos, end, "fmt.Errorf format %%w has arg %s of wrong type %v", text, arg.Type)
+			}
+		}
+		argNum++
+	}
+	if nwrap > 1 && !p.pkg.allowGoVersion(20) {
+		_, pos, end := p.loadExpr(args[0].Src)
+		p.panicCodeErrorf(pos, end, "fmt.Errorf call has more than one error-wrapping directive %%w: requires go1.20 or later (GoVersion is %s)", p.pkg.conf.GoVersion)
+	}
+}
+
 // Switch starts a switch statement.
 func (p *CodeBuilder) Switch(src ...ast.Node) *CodeBuilder {
 	if debugInstr {
 		log.Println("Switch")
 	}
 	stmt := &switchStmt{}
+	p.startBranchTarget(false)
 	p.startBlockStmt(stmt, src, "switch statement", &stmt.old)
 	return p
 }
@@ -2648,6 +3966,44 @@ func (p *CodeBuilder) DefaultThen(src ...ast.Node) *CodeBuilder {
 	return p.Case(src...).Then(src...)
 }
 
+// CondBranch represents a branch of CondChain.
+type CondBranch struct {
+	Cond func(cb *CodeBuilder) // pushes the condition (nil means the default branch)
+	Body func(cb *CodeBuilder) // emits statements of the branch
+}
+
+// CondChain emits conditional branches as a `switch { case cond: ... }`
+// statement. Conditions must be boolean, and each branch body has its own
+// scope. At most one branch (the default branch) can have a nil Cond.
+func (p *CodeBuilder) CondChain(branches []CondBranch, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("CondChain", len(branches))
+	}
+	hasDefault := false
+	for _, b := range branches {
+		if b.Cond == nil {
+			if hasDefault {
+				pos, end := getPos(src), getEnd(src)
+				p.panicCodeErrorf(pos, end, "multiple defaults in switch")
+			}
+			hasDefault = true
+		}
+	}
+	p.Switch(src...).None().Then()
+	for _, b := range branches {
+		p.Case()
+		if b.Cond != nil {
+			b.Cond(p)
+		}
+		p.Then()
+		if b.Body != nil {
+			b.Body(p)
+		}
+		p.End()
+	}
+	return p.End()
+}
+
 func (p *CodeBuilder) NewLabel(pos, end token.Pos, name string) *Label {
 	if p.current.fn == nil {
 		panic(p.newCodeError(pos, end, "syntax error: non-declaration statement outside function body"))
@@ -2697,9 +4053,10 @@ func (p *CodeBuilder) Goto(l *Label) *CodeBuilder {
 	return p
 }
 
-func (p *CodeBuilder) labelFlow(flow int, l *Label) (string, *ast.Ident) {
+func (p *CodeBuilder) labelFlow(flow int, l *Label, src []ast.Node) (string, *ast.Ident) {
 	if l != nil {
 		l.used = true
+		p.checkBranchLabel(flow, l.Name(), src)
 		p.current.flows |= (flow | flowFlagWithLabel)
 		return l.Name(), ident(l.Name())
 	}
@@ -2707,9 +4064,28 @@ func (p *CodeBuilder) labelFlow(flow int, l *Label) (string, *ast.Ident) {
 	return "", nil
 }
 
+// checkBranchLabel checks that a labeled break refers to an enclosing for,
+// switch or select statement, and a labeled continue to an enclosing loop.
+func (p *CodeBuilder) checkBranchLabel(flow int, name string, src []ast.Node) {
+	targets := p.current.targets
+	for i := len(targets) - 1; i >= 0; i-- {
+		if t := targets[i]; t.label == name {
+			if flow == flowFlagContinue && !t.loop {
+				break
+			}
+			return
+		}
+	}
+	tok := "break"
+	if flow == flowFlagContinue {
+		tok = "continue"
+	}
+	p.panicCodeErrorf(getPos(src), getEnd(src), "invalid %s label %s", tok, name)
+}
+
 // Break func
-func (p *CodeBuilder) Break(l *Label) *CodeBuilder {
-	name, label := p.labelFlow(flowFlagBreak, l)
+func (p *CodeBuilder) Break(l *Label, src ...ast.Node) *CodeBuilder {
+	name, label := p.labelFlow(flowFlagBreak, l, src)
 	if debugInstr {
 		log.Println("Break", name)
 	}
@@ -2718,8 +4094,8 @@ func (p *CodeBuilder) Break(l *Label) *CodeBuilder {
 }
 
 // Continue func
-func (p *CodeBuilder) Continue(l *Label) *CodeBuilder {
-	name, label := p.labelFlow(flowFlagContinue, l)
+func (p *CodeBuilder) Continue(l *Label, src ...ast.Node) *CodeBuilder {
+	name, label := p.labelFlow(flowFlagContinue, l, src)
 	if debugInstr {
 		log.Println("Continue", name)
 	}
@@ -2728,23 +4104,44 @@ func (p *CodeBuilder) Continue(l *Label) *CodeBuilder {
 }
 
 // Fallthrough func
-func (p *CodeBuilder) Fallthrough() *CodeBuilder {
+func (p *CodeBuilder) Fallthrough(src ...ast.Node) *CodeBuilder {
 	if debugInstr {
 		log.Println("Fallthrough")
 	}
-	if flow, ok := p.current.codeBlock.(*caseStmt); ok {
+	switch flow := p.current.codeBlock.(type) {
+	case *caseStmt:
 		flow.Fallthrough(p)
 		return p
+	case *typeCaseStmt:
+		p.panicCodeError(getPos(src), getEnd(src), "cannot fallthrough in type switch")
 	}
 	panic("please use fallthrough in case statement")
 }
 
+// Unreachable emits `panic("unreachable")`, eg. as the body of the default
+// case of an exhaustive switch:
+//
+//	cb.DefaultThen().Unreachable().End()
+//
+// If msg is provided, it is used as the panic message instead.
+func (p *CodeBuilder) Unreachable(msg ...string) *CodeBuilder {
+	if debugInstr {
+		log.Println("Unreachable", msg)
+	}
+	text := "unreachable"
+	if msg != nil {
+		text = msg[0]
+	}
+	return p.Val(p.pkg.builtin.Ref("panic")).Val(text).CallWith(1, 0).EndStmt()
+}
+
 // For func
 func (p *CodeBuilder) For(src ...ast.Node) *CodeBuilder {
 	if debugInstr {
 		log.Println("For")
 	}
 	stmt := &forStmt{}
+	p.startBranchTarget(true)
 	p.startBlockStmt(stmt, src, "for statement", &stmt.old)
 	return p
 }
@@ -2772,6 +4169,7 @@ func (p *CodeBuilder) ForRangeEx(names []string, src ...ast.Node) *CodeBuilder {
 		log.Println("ForRange", names)
 	}
 	stmt := &forRangeStmt{names: names}
+	p.startBranchTarget(true)
 	p.startBlockStmt(stmt, src, "for range statement", &stmt.old)
 	return p
 }
@@ -2823,7 +4221,11 @@ func (p *CodeBuilder) End(src ...ast.Node) *CodeBuilder {
 			panic("forget to call EndStmt()?")
 		}
 	}
+	target := isBranchTarget(p.current.codeBlock)
 	p.current.End(p, getSrc(src))
+	if target {
+		p.current.targets = p.current.targets[:len(p.current.targets)-1]
+	}
 	return p
 }
 
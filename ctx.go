@@ -0,0 +1,104 @@
+/*
+Copyright 2026 The XGo Authors (xgo.dev)
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gogen
+
+import (
+	"go/token"
+	"go/types"
+
+	"github.com/goplus/gogen/internal"
+	"github.com/goplus/gogen/internal/typesalias"
+)
+
+// ----------------------------------------------------------------------------
+
+// ContextType returns the type context.Context. The context package is
+// imported the first time it is called.
+func (p *Package) ContextType() types.Type {
+	if p.tyCtx == nil {
+		p.tyCtx = p.Import("context").Ref("Context").Type()
+	}
+	return p.tyCtx
+}
+
+func isContextType(typ types.Type) bool {
+	if t, ok := typesalias.Unalias(typ).(*types.Named); ok {
+		o := t.Obj()
+		return o.Name() == "Context" && o.Pkg() != nil && o.Pkg().Path() == "context"
+	}
+	return false
+}
+
+// WithCtxParam returns params with a parameter of type context.Context
+// prepended, eg. to create a function which takes a context first:
+//
+//	pkg.NewFunc(nil, "Get", pkg.WithCtxParam(params, results), results, false)
+//
+// The parameter is named Config.CtxParamName ("ctx" if it is empty), followed
+// by a number (eg. ctx1) if the name is used by params or results.
+func (p *Package) WithCtxParam(params, results *Tuple) *Tuple {
+	used := make(map[string]bool)
+	for _, t := range []*Tuple{params, results} {
+		for i, n := 0, t.Len(); i < n; i++ {
+			used[t.At(i).Name()] = true
+		}
+	}
+	name := p.conf.CtxParamName
+	if name == "" {
+		name = "ctx"
+	}
+	vars := make([]*Param, 1, params.Len()+1)
+	vars[0] = p.NewParam(token.NoPos, stubName(name, used), p.ContextType())
+	for i, n := 0, params.Len(); i < n; i++ {
+		vars = append(vars, params.At(i))
+	}
+	return NewTuple(vars...)
+}
+
+// ctxArg returns the context to be supplied as the first argument of a call
+// of fn with args (see Config.AutoCtxArg): fn takes a context.Context first,
+// args don't start with a context, and the innermost enclosing function
+// (including closures) which takes a context.Context first is found, and its
+// context parameter isn't shadowed in the current scope.
+func (p *CodeBuilder) ctxArg(fn *internal.Elem, args []*internal.Elem) *types.Var {
+	sig, ok := fn.Type.(*types.Signature)
+	if !ok {
+		return nil
+	}
+	params := sig.Params()
+	if params.Len() == 0 || !isContextType(params.At(0).Type()) {
+		return nil
+	}
+	if !sig.Variadic() && len(args) != params.Len()-1 {
+		return nil
+	}
+	if len(args) > 0 && AssignableTo(p.pkg, args[0].Type, params.At(0).Type()) {
+		return nil
+	}
+	for f := p.current.fn; f != nil; f = f.old.fn {
+		if t := f.Type().(*types.Signature).Params(); t.Len() > 0 {
+			if v := t.At(0); v.Name() != "" && v.Name() != "_" && isContextType(v.Type()) {
+				if _, o := p.Scope().LookupParent(v.Name(), token.NoPos); o != v { // shadowed
+					return nil
+				}
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
@@ -17,6 +17,7 @@ import (
 	"bytes"
 	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/token"
 	"go/types"
 	"path/filepath"
@@ -207,6 +208,23 @@ func TestErrTypeSwitch(t *testing.T) {
 				/**/ End().
 				End()
 		})
+	codeErrorTest(t, "./foo.gop:1:5: v (type int) is not an interface",
+		func(pkg *gogen.Package) {
+			v := pkg.NewParam(token.NoPos, "v", types.Typ[types.Int])
+			pkg.NewFunc(nil, "foo", types.NewTuple(v), nil, false).BodyStart(pkg).
+				/**/ TypeSwitch("").Val(v, source("v", 1, 5)).TypeAssertThen().
+				/**/ End().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:9: impossible type switch case: v (type interface{Error() string}) cannot have dynamic type int (missing Error method)",
+		func(pkg *gogen.Package) {
+			v := pkg.NewParam(token.NoPos, "v", gogen.TyError)
+			pkg.NewFunc(nil, "foo", types.NewTuple(v), nil, false).BodyStart(pkg).
+				/**/ TypeSwitch("").Val(v, source("v", 1, 5)).TypeAssertThen().
+				/**/ TypeCase().Typ(types.Typ[types.Int], source("int", 2, 9)).Then().
+				/**/ End().
+				End()
+		})
 	codeErrorTest(t, "./foo.gop:2:9: 1 (type untyped int) is not a type",
 		func(pkg *gogen.Package) {
 			v := pkg.NewParam(token.NoPos, "v", gogen.TyEmptyInterface)
@@ -216,6 +234,16 @@ func TestErrTypeSwitch(t *testing.T) {
 				/**/ End().
 				End()
 		})
+	codeErrorTest(t, "./foo.gop:3:3: cannot fallthrough in type switch",
+		func(pkg *gogen.Package) {
+			v := pkg.NewParam(token.NoPos, "v", gogen.TyEmptyInterface)
+			pkg.NewFunc(nil, "foo", types.NewTuple(v), nil, false).BodyStart(pkg).
+				/**/ TypeSwitch("t").Val(v).TypeAssertThen().
+				/**/ TypeCase().Typ(types.Typ[types.Int]).Then().
+				/****/ Fallthrough(source("fallthrough", 3, 3)).
+				/**/ End().
+				End()
+		})
 }
 
 func TestErrAssignOp(t *testing.T) {
@@ -279,6 +307,38 @@ func TestErrBinaryOp(t *testing.T) {
 		})
 }
 
+func TestErrBinaryOpNamed(t *testing.T) {
+	codeErrorTest(t, `./foo.gop:2:9: invalid operation: c + f (mismatched types Celsius and Fahrenheit)`,
+		func(pkg *gogen.Package) {
+			celsius := pkg.NewType("Celsius").InitType(pkg, types.Typ[types.Float64])
+			fahrenheit := pkg.NewType("Fahrenheit").InitType(pkg, types.Typ[types.Float64])
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(celsius, "c").
+				NewVar(fahrenheit, "f").
+				VarVal("c").VarVal("f").BinaryOp(token.ADD, source(`c + f`, 2, 9)).EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:2:9: invalid operation: c == f (mismatched types Celsius and Fahrenheit)`,
+		func(pkg *gogen.Package) {
+			celsius := pkg.NewType("Celsius").InitType(pkg, types.Typ[types.Float64])
+			fahrenheit := pkg.NewType("Fahrenheit").InitType(pkg, types.Typ[types.Float64])
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(celsius, "c").
+				NewVar(fahrenheit, "f").
+				VarVal("c").VarVal("f").BinaryOp(token.EQL, source(`c == f`, 2, 9)).EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:2:9: invalid operation: c < x (mismatched types Celsius and float64)`,
+		func(pkg *gogen.Package) {
+			celsius := pkg.NewType("Celsius").InitType(pkg, types.Typ[types.Float64])
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(celsius, "c").
+				NewVar(types.Typ[types.Float64], "x").
+				VarVal("c").VarVal("x").BinaryOp(token.LSS, source(`c < x`, 2, 9)).EndStmt().
+				End()
+		})
+}
+
 func TestErrBinaryOp2(t *testing.T) {
 	codeErrorTest(t, `-: invalid operation: operator <> not defined on a (int)`,
 		func(pkg *gogen.Package) {
@@ -312,6 +372,29 @@ func TestErrTypeAssert(t *testing.T) {
 				TypeAssert(types.Typ[types.String], false, source("v.(string)", 2, 9)).EndInit(1).
 				End()
 		})
+	codeErrorTest(t, "./foo.gop:2:9: impossible type assertion:\n\tbaz does not implement bar (wrong type for method Bar)",
+		func(pkg *gogen.Package) {
+			newIface := func(name string, results *types.Tuple) types.Type {
+				methods := []*types.Func{
+					types.NewFunc(token.NoPos, pkg.Types, "Bar", types.NewSignatureType(nil, nil, nil, nil, results, false)),
+				}
+				return pkg.NewType(name).InitType(pkg, types.NewInterfaceType(methods, nil).Complete())
+			}
+			bar := newIface("bar", nil)
+			baz := newIface("baz", types.NewTuple(pkg.NewParam(token.NoPos, "", types.Typ[types.Int])))
+			params := types.NewTuple(pkg.NewParam(token.NoPos, "v", bar))
+			pkg.NewFunc(nil, "foo", params, nil, false).BodyStart(pkg).
+				VarVal("v").TypeAssert(baz, false, source("v.(baz)", 2, 9)).MemberVal("Bar").Call(0).EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:9: invalid type assertion: v.(fmt.Stringer) (non-interface type int on left)",
+		func(pkg *gogen.Package) {
+			params := types.NewTuple(pkg.NewParam(token.NoPos, "v", types.Typ[types.Int]))
+			pkg.NewFunc(nil, "foo", params, nil, false).BodyStart(pkg).
+				VarVal("v").TypeAssert(pkg.Import("fmt").Ref("Stringer").Type(), false, source("v.(fmt.Stringer)", 2, 9)).
+				MemberVal("String").Call(0).EndStmt().
+				End()
+		})
 }
 
 func TestErrConst(t *testing.T) {
@@ -354,6 +437,13 @@ func TestErrConst(t *testing.T) {
 				}, 0, position(2, 7), nil, "_").
 				Next(1, position(2, 9), "a")
 		})
+	codeErrorTest(t, "./foo.gop:2:19: (n) (type int) is not an integer constant",
+		func(pkg *gogen.Package) {
+			pkg.NewVar(position(1, 5), types.Typ[types.Int], "n")
+			pkg.NewConstDefs(pkg.Types.Scope()).NewAssert(func(cb *gogen.CodeBuilder) {
+				cb.Val(ctxRef(pkg, "n"), source("(n)", 2, 19))
+			}, position(2, 7))
+		})
 	codeErrorTest(t, "./foo.gop:2:9: extra expression in const declaration",
 		func(pkg *gogen.Package) {
 			pkg.NewConstDefs(pkg.Types.Scope()).
@@ -389,17 +479,17 @@ func TestErrNewVar(t *testing.T) {
 		func(pkg *gogen.Package) {
 			pkg.NewVarStart(position(2, 7), types.Typ[types.String], "a").Val(1, source("1", 2, 9)).EndInit(1)
 		})
-	codeErrorTest(t, "./foo.gop:2:7: assignment mismatch: 1 variables but fmt.Println returns 2 values",
+	codeErrorTest(t, "./foo.gop:2:7: assignment mismatch: 1 variable but fmt.Println returns 2 values",
 		func(pkg *gogen.Package) {
 			fmt := pkg.Import("fmt")
 			pkg.NewVarStart(position(2, 7), nil, "a").
 				Val(fmt.Ref("Println")).Val(2).CallWith(1, 0, source("fmt.Println(2)", 2, 11)).EndInit(1)
 		})
-	codeErrorTest(t, "./foo.gop:2:7: assignment mismatch: 1 variables but 2 values",
+	codeErrorTest(t, "./foo.gop:2:7: assignment mismatch: 1 variable but 2 values",
 		func(pkg *gogen.Package) {
 			pkg.NewVarStart(position(2, 7), nil, "a").Val(1).Val(2).EndInit(2)
 		})
-	codeErrorTest(t, "./foo.gop:2:7: assignment mismatch: 2 variables but 1 values",
+	codeErrorTest(t, "./foo.gop:2:7: assignment mismatch: 2 variables but 1 value",
 		func(pkg *gogen.Package) {
 			pkg.NewVarStart(position(2, 7), nil, "a", "b").Val(2).EndInit(1)
 		})
@@ -519,10 +609,22 @@ func TestErrForRange(t *testing.T) {
 				End().
 				End()
 		})
+	codeErrorTest(t, `./foo.gop:1:8: cannot assign type int32 to c (type uint8) in range`,
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.Byte], "c").
+				ForRange().
+				VarRef(nil).
+				VarRef(ctxRef(pkg, "c"), source("c", 1, 8)).
+				Val("abc", source(`"abc"`, 1, 17)).
+				RangeAssignThen(token.NoPos).
+				End().
+				End()
+		})
 }
 
 func TestErrAssign(t *testing.T) {
-	codeErrorTest(t, "./foo.gop:1:3: assignment mismatch: 1 variables but bar returns 2 values",
+	codeErrorTest(t, "./foo.gop:1:3: assignment mismatch: 1 variable but bar returns 2 values",
 		func(pkg *gogen.Package) {
 			retInt := pkg.NewParam(position(1, 10), "", types.Typ[types.Int])
 			retErr := pkg.NewParam(position(1, 15), "", gogen.TyError)
@@ -535,7 +637,7 @@ func TestErrAssign(t *testing.T) {
 				AssignWith(1, 1, source("x = bar()", 1, 3)).
 				End()
 		})
-	codeErrorTest(t, "./foo.gop:1:3: assignment mismatch: 1 variables but 2 values",
+	codeErrorTest(t, "./foo.gop:1:3: assignment mismatch: 1 variable but 2 values",
 		func(pkg *gogen.Package) {
 			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
 				NewVar(types.Typ[types.Int], "x").
@@ -544,6 +646,37 @@ func TestErrAssign(t *testing.T) {
 				AssignWith(1, 2, source("x = 1, 2", 1, 3)).
 				End()
 		})
+	codeErrorTest(t, `./foo.gop:1:10: cannot use y["a"] (type int) as type string in assignment`,
+		func(pkg *gogen.Package) {
+			y := pkg.NewParam(token.NoPos, "y", types.NewMap(types.Typ[types.String], types.Typ[types.Int]))
+			pkg.NewFunc(nil, "foo", types.NewTuple(y), nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.String], "v").
+				NewVar(types.Typ[types.Bool], "ok").
+				VarRef(ctxRef(pkg, "v")).VarRef(ctxRef(pkg, "ok")).
+				Val(y).Val("a").Index(1, true, source(`y["a"]`, 1, 10)).
+				AssignWith(2, 1, source(`v, ok = y["a"]`, 1, 3)).
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:1:10: cannot use y["a"] (type untyped bool) as type int in assignment`,
+		func(pkg *gogen.Package) {
+			y := pkg.NewParam(token.NoPos, "y", types.NewMap(types.Typ[types.String], types.Typ[types.Int]))
+			pkg.NewFunc(nil, "foo", types.NewTuple(y), nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.Int], "v", "ok").
+				VarRef(ctxRef(pkg, "v")).VarRef(ctxRef(pkg, "ok")).
+				Val(y).Val("a").Index(1, true, source(`y["a"]`, 1, 10)).
+				AssignWith(2, 1, source(`v, ok = y["a"]`, 1, 3)).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:1:3: assignment mismatch: 3 variables but 1 value",
+		func(pkg *gogen.Package) {
+			y := pkg.NewParam(token.NoPos, "y", types.NewMap(types.Typ[types.String], types.Typ[types.Int]))
+			pkg.NewFunc(nil, "foo", types.NewTuple(y), nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.Int], "a", "b", "c").
+				VarRef(ctxRef(pkg, "a")).VarRef(ctxRef(pkg, "b")).VarRef(ctxRef(pkg, "c")).
+				Val(y).Val("a").Index(1, true, source(`y["a"]`, 1, 12)).
+				AssignWith(3, 1, source(`a, b, c = y["a"]`, 1, 3)).
+				End()
+		})
 }
 
 func TestErrFunc(t *testing.T) {
@@ -571,6 +704,28 @@ func TestErrFuncCall(t *testing.T) {
 				Val(ctxRef(pkg, "foo"), source("foo", 2, 2)).VarVal("a").CallWith(1, 1, source("foo(a...)", 2, 10)).
 				End()
 		})
+	codeErrorTest(t, `./foo.gop:2:22: cannot use args (type []string) as type []any in argument to fmt.Sprintf("%v", args...)`,
+		func(pkg *gogen.Package) {
+			fmt := pkg.Import("fmt")
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.NewSlice(types.Typ[types.String]), "args").
+				VarRef(nil).Val(fmt.Ref("Sprintf"), source("fmt.Sprintf", 2, 6)).
+				Val("%v").VarVal("args", source("args", 2, 22)).
+				CallWith(2, gogen.InstrFlagEllipsis, source(`fmt.Sprintf("%v", args...)`, 2, 6)).Assign(1).
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:2:6: too many arguments in call to fmt.Sprintf
+	have (untyped string, untyped int, []interface{})
+	want (format string, a []any)`,
+		func(pkg *gogen.Package) {
+			fmt := pkg.Import("fmt")
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.NewSlice(gogen.TyEmptyInterface), "args").
+				VarRef(nil).Val(fmt.Ref("Sprintf"), source("fmt.Sprintf", 2, 6)).
+				Val("%v").Val(1).VarVal("args").
+				CallWith(3, gogen.InstrFlagEllipsis, source(`fmt.Sprintf("%v", 1, args...)`, 2, 6)).Assign(1).
+				End()
+		})
 	codeErrorTest(t, `./foo.gop:3:5: cannot use a (type bool) as type int in argument to foo(a)`,
 		func(pkg *gogen.Package) {
 			retInt := pkg.NewParam(position(1, 10), "", types.Typ[types.Int])
@@ -584,6 +739,53 @@ func TestErrFuncCall(t *testing.T) {
 				CallWith(1, 0, source("foo(a)", 3, 10)).
 				End()
 		})
+	codeErrorTest(t, `./foo.gop:3:5: cannot use Vec{1, 2} (type Vec) as type Point in argument to foo(Vec{1, 2})`,
+		func(pkg *gogen.Package) {
+			tyInt := types.Typ[types.Int]
+			fields := []*types.Var{
+				types.NewField(token.NoPos, pkg.Types, "X", tyInt, false),
+				types.NewField(token.NoPos, pkg.Types, "Y", tyInt, false),
+			}
+			point := pkg.NewType("Point").InitType(pkg, types.NewStruct(fields, nil))
+			vec := pkg.NewType("Vec").InitType(pkg, types.NewStruct(fields, nil))
+			v := pkg.NewParam(position(1, 10), "v", point)
+			newFunc(pkg, 1, 5, 1, 7, nil, "foo", types.NewTuple(v), nil, false).BodyStart(pkg).
+				End()
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(ctxRef(pkg, "foo")).Val(1).Val(2).StructLit(vec, 2, false, source("Vec{1, 2}", 3, 5)).
+				CallWith(1, 0, source("foo(Vec{1, 2})", 3, 1)).EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:3:5: cannot use []int{1} (type []int) as type []float64 in argument to foo([]int{1})`,
+		func(pkg *gogen.Package) {
+			v := pkg.NewParam(position(1, 10), "v", types.NewSlice(types.Typ[types.Float64]))
+			newFunc(pkg, 1, 5, 1, 7, nil, "foo", types.NewTuple(v), nil, false).BodyStart(pkg).
+				End()
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(ctxRef(pkg, "foo")).Val(1).SliceLitEx(types.NewSlice(types.Typ[types.Int]), 1, false, source("[]int{1}", 3, 5)).
+				CallWith(1, 0, source("foo([]int{1})", 3, 1)).EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:3:5: cannot use 1000 (type untyped int) as type int8 in argument to foo(1000) (overflows)`,
+		func(pkg *gogen.Package) {
+			v := pkg.NewParam(position(1, 10), "v", types.Typ[types.Int8])
+			newFunc(pkg, 1, 5, 1, 7, nil, "foo", types.NewTuple(v), nil, false).BodyStart(pkg).
+				End()
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(ctxRef(pkg, "foo")).Val(1000, source("1000", 3, 5)).
+				CallWith(1, 0, source("foo(1000)", 3, 1)).EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:3:8: cannot use 1.5 (type untyped float) as type int in argument to foo(1, 1.5) (truncated)`,
+		func(pkg *gogen.Package) {
+			v := pkg.NewParam(position(1, 10), "v", types.NewSlice(types.Typ[types.Int]))
+			newFunc(pkg, 1, 5, 1, 7, nil, "foo", types.NewTuple(v), nil, true).BodyStart(pkg).
+				End()
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(ctxRef(pkg, "foo")).Val(1, source("1", 3, 5)).Val(1.5, source("1.5", 3, 8)).
+				CallWith(2, 0, source("foo(1, 1.5)", 3, 1)).EndStmt().
+				End()
+		})
 	codeErrorTest(t, `./foo.gop:2:10: not enough arguments in call to foo
 	have (int)
 	want (int, int)`,
@@ -623,7 +825,7 @@ func TestErrFuncCall(t *testing.T) {
 }
 
 func TestErrReturn(t *testing.T) {
-	codeErrorTest(t, `./foo.gop:2:9: cannot use "Hi" (type untyped string) as type error in return argument`,
+	codeErrorTest(t, `./foo.gop:2:9: cannot use "Hi" (type untyped string) as type error in return argument (missing method Error)`,
 		func(pkg *gogen.Package) {
 			retInt := pkg.NewParam(position(1, 10), "", types.Typ[types.Int])
 			retErr := pkg.NewParam(position(1, 15), "", gogen.TyError)
@@ -633,7 +835,7 @@ func TestErrReturn(t *testing.T) {
 				Return(2, source(`return 1, "Hi"`, 2, 5)).
 				End()
 		})
-	codeErrorTest(t, "./foo.gop:2:5: cannot use byte value as type error in return argument",
+	codeErrorTest(t, "./foo.gop:2:5: cannot use byte value as type error in return argument (missing method Error)",
 		func(pkg *gogen.Package) {
 			retInt := pkg.NewParam(position(1, 10), "", types.Typ[types.Int])
 			retErr := pkg.NewParam(position(1, 15), "", gogen.TyError)
@@ -690,6 +892,52 @@ func TestErrReturn(t *testing.T) {
 				Return(1, source("return bar()", 2, 5)).
 				End()
 		})
+	codeErrorTest(t, "./foo.gop:2:9: cannot use func(s string) int {...} (type func(s string) int) as type func(int) int in return argument",
+		func(pkg *gogen.Package) {
+			tyInt := types.Typ[types.Int]
+			sig := types.NewSignatureType(nil, nil, nil,
+				types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), false)
+			ret := pkg.NewParam(position(1, 10), "", sig)
+			s := pkg.NewParam(token.NoPos, "s", types.Typ[types.String])
+			newFunc(pkg, 1, 5, 1, 7, nil, "foo", nil, types.NewTuple(ret), false).BodyStart(pkg).
+				/**/ NewClosure(types.NewTuple(s), types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), false).BodyStart(pkg).
+				/****/ Val(0).Return(1).
+				/**/ End(source("func(s string) int {...}", 2, 9)).
+				Return(1, source("return func(s string) int {...}", 2, 5)).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:9: cannot use 1000 (type untyped int) as type int8 in return argument (overflows)",
+		func(pkg *gogen.Package) {
+			ret := pkg.NewParam(position(1, 10), "", types.Typ[types.Int8])
+			newFunc(pkg, 1, 5, 1, 7, nil, "foo", nil, types.NewTuple(ret), false).BodyStart(pkg).
+				Val(1000, source("1000", 2, 9)).
+				Return(1, source("return 1000", 2, 5)).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:9: cannot use 1.5 (type untyped float) as type int in return argument (truncated)",
+		func(pkg *gogen.Package) {
+			ret := pkg.NewParam(position(1, 10), "", types.Typ[types.Int])
+			newFunc(pkg, 1, 5, 1, 7, nil, "foo", nil, types.NewTuple(ret), false).BodyStart(pkg).
+				Val(1.5, source("1.5", 2, 9)).
+				Return(1, source("return 1.5", 2, 5)).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:9: cannot use nil (type untyped nil) as type int in return argument",
+		func(pkg *gogen.Package) {
+			ret := pkg.NewParam(position(1, 10), "", types.Typ[types.Int])
+			newFunc(pkg, 1, 5, 1, 7, nil, "foo", nil, types.NewTuple(ret), false).BodyStart(pkg).
+				Val(nil, source("nil", 2, 9)).
+				Return(1, source("return nil", 2, 5)).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:9: cannot use 1 (type untyped int) as type error in return argument (missing method Error)",
+		func(pkg *gogen.Package) {
+			ret := pkg.NewParam(position(1, 10), "", gogen.TyError)
+			newFunc(pkg, 1, 5, 1, 7, nil, "foo", nil, types.NewTuple(ret), false).BodyStart(pkg).
+				Val(1, source("1", 2, 9)).
+				Return(1, source("return 1", 2, 5)).
+				End()
+		})
 	codeErrorTest(t, "./foo.gop:2:5: not enough arguments to return\n\thave ()\n\twant (byte)",
 		func(pkg *gogen.Package) {
 			ret := pkg.NewParam(position(1, 10), "", gogen.TyByte)
@@ -699,6 +947,84 @@ func TestErrReturn(t *testing.T) {
 		})
 }
 
+func TestErrInvalidIdent(t *testing.T) {
+	codeErrorTest(t, `./foo.gop:1:5: invalid identifier "type" in NewVar`,
+		func(pkg *gogen.Package) {
+			pkg.NewVar(position(1, 5), types.Typ[types.Int], "a", "type")
+		})
+	codeErrorTest(t, `./foo.gop:1:5: invalid identifier "select" in NewConst`,
+		func(pkg *gogen.Package) {
+			pkg.NewConstStart(pkg.Types.Scope(), position(1, 5), nil, "select").Val(1).EndInit(1)
+		})
+	codeErrorTest(t, `./foo.gop:2:5: invalid identifier "my-var" in DefineVarStart`,
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				DefineVarStart(position(2, 5), "my-var").Val(1).EndInit(1).
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:1:5: invalid identifier "func" in NewFunc`,
+		func(pkg *gogen.Package) {
+			newFunc(pkg, 1, 5, 1, 7, nil, "func", nil, nil, false)
+		})
+	codeErrorTest(t, `./foo.gop:1:5: invalid identifier "1T" in NewType`,
+		func(pkg *gogen.Package) {
+			pkg.NewType("1T", source("1T", 1, 5))
+		})
+}
+
+func TestErrSession(t *testing.T) {
+	newSess := func(pkg *gogen.Package) *gogen.Session {
+		sess := gogen.NewSession()
+		pkg.NewFunc(nil, "line1", nil, nil, false).BodyStartWith(pkg, sess).
+			DefineVarStart(0, "x").Val(1).EndInit(1).
+			End()
+		sess.Commit()
+		return sess
+	}
+	codeErrorTest(t, `./foo.gop:2:5: no new variables on left side of :=`,
+		func(pkg *gogen.Package) {
+			sess := newSess(pkg)
+			pkg.NewFunc(nil, "line2", nil, nil, false).BodyStartWith(pkg, sess).
+				DefineVarStart(position(2, 5), "x").Val(2).EndInit(1).
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:2:10: cannot use "hi" (type untyped string) as type int in assignment`,
+		func(pkg *gogen.Package) {
+			sess := newSess(pkg)
+			pkg.NewFunc(nil, "line2", nil, nil, false).BodyStartWith(pkg, sess).
+				DefineVarStart(position(2, 5), "x", "y").Val("hi", source(`"hi"`, 2, 10)).Val(1).EndInit(2).
+				End()
+		})
+}
+
+func TestErrSelect(t *testing.T) {
+	codeErrorTest(t, `./foo.gop:3:2: multiple defaults in select`,
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Select().
+				/**/ CommDefaultThen(source("default", 2, 2)).End().
+				/**/ CommDefaultThen(source("default", 3, 2)).End().
+				End().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:2:7: invalid operation: cannot receive from non-channel a (type int)`,
+		func(pkg *gogen.Package) {
+			time := pkg.Import("time")
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.Int], "a").
+				VarVal("a", source("a", 2, 7)).Val(time.Ref("Second")).SelectTimeout(0).EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:2:7: invalid operation: cannot receive from send-only channel ch (type chan<- int)`,
+		func(pkg *gogen.Package) {
+			time := pkg.Import("time")
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.NewChan(types.SendOnly, types.Typ[types.Int]), "ch").
+				VarVal("ch", source("ch", 2, 7)).Val(time.Ref("Second")).SelectTimeout(0).EndStmt().
+				End()
+		})
+}
+
 func TestErrInitFunc(t *testing.T) {
 	codeErrorTest(t, "./foo.gop:1:5: func init must have no arguments and no return values", func(pkg *gogen.Package) {
 		v := pkg.NewParam(token.NoPos, "v", gogen.TyByte)
@@ -727,6 +1053,274 @@ func TestErrRecv(t *testing.T) {
 	})
 }
 
+func TestErrRecvRef(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:2:2: cannot use blank receiver _ as value", func(pkg *gogen.Package) {
+		foo := pkg.NewType("foo").InitType(pkg, types.NewStruct(nil, nil))
+		recv := pkg.NewParam(position(1, 7), "_", foo)
+		fn := newFunc(pkg, 1, 5, 1, 9, recv, "bar", nil, nil, false)
+		fn.BodyStart(pkg).Val(fn.Recv(), source("_", 2, 2)).EndStmt().End()
+	})
+	codeErrorTest(t, "./foo.gop:2:2: cannot refer to unnamed receiver", func(pkg *gogen.Package) {
+		foo := pkg.NewType("foo").InitType(pkg, types.NewStruct(nil, nil))
+		recv := pkg.NewParam(position(1, 7), "", foo)
+		fn := newFunc(pkg, 1, 5, 1, 9, recv, "bar", nil, nil, false)
+		fn.BodyStart(pkg).VarRef(fn.Recv(), source("recv", 2, 2)).Val(nil).Assign(1).End()
+	})
+	codeErrorTest(t, "./foo.gop:2:2: cannot use blank variable _ as value", func(pkg *gogen.Package) {
+		v := pkg.NewParam(position(1, 7), "_", types.Typ[types.Int])
+		pkg.NewFunc(nil, "bar", types.NewTuple(v), nil, false).BodyStart(pkg).
+			VarRef(nil).Val(v, source("_", 2, 2)).Assign(1).
+			End()
+	})
+}
+
+func TestErrLiteral(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:1:11: invalid INT literal 0xZZ", func(pkg *gogen.Package) {
+		pkg.CB().NewConstStart(nil, "a").
+			Val(&ast.BasicLit{Kind: token.INT, Value: "0xZZ"}, source("0xZZ", 1, 11)).EndInit(1)
+	})
+	codeErrorTest(t, "./foo.gop:1:11: literal 0xFF doesn't match its value 256", func(pkg *gogen.Package) {
+		pkg.CB().NewConstStart(nil, "a").
+			Val(&gogen.Literal{Kind: token.INT, Text: "0xFF", Value: constant.MakeInt64(256)}, source("0xFF", 1, 11)).
+			EndInit(1)
+	})
+	codeErrorTest(t, `./foo.gop:1:11: literal '\n' doesn't match its value "\n"`, func(pkg *gogen.Package) {
+		pkg.CB().NewConstStart(nil, "a").
+			Val(&gogen.Literal{Kind: token.CHAR, Text: `'\n'`, Value: constant.MakeString("\n")}, source(`'\n'`, 1, 11)).
+			EndInit(1)
+	})
+}
+
+func TestErrTypeConvSlice(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:2:10: cannot convert a (type []MyByte) to type []uint8", func(pkg *gogen.Package) {
+		myByte := pkg.NewType("MyByte").InitType(pkg, types.Typ[types.Byte])
+		tyBytes := types.NewSlice(types.Typ[types.Byte])
+		pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+			NewVar(types.NewSlice(myByte), "a").
+			VarRef(nil).Typ(tyBytes).VarVal("a", source("a", 2, 10)).Call(1).Assign(1).
+			End()
+	})
+	codeErrorTestEx(t, newGoVersionPackage("go1.19"),
+		"./foo.gop:2:10: cannot convert s (type []uint8) to type [4]uint8: requires go1.20 or later (GoVersion is go1.19)",
+		func(pkg *gogen.Package) {
+			tyArr := types.NewArray(types.Typ[types.Byte], 4)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.NewSlice(types.Typ[types.Byte]), "s").
+				VarRef(nil).Typ(tyArr).VarVal("s", source("s", 2, 10)).Call(1).Assign(1).
+				End()
+		})
+	codeErrorTestEx(t, newGoVersionPackage("go1.16"),
+		"./foo.gop:2:10: cannot convert s (type []uint8) to type *[4]uint8: requires go1.17 or later (GoVersion is go1.16)",
+		func(pkg *gogen.Package) {
+			tyPArr := types.NewPointer(types.NewArray(types.Typ[types.Byte], 4))
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.NewSlice(types.Typ[types.Byte]), "s").
+				VarRef(nil).Typ(tyPArr).VarVal("s", source("s", 2, 10)).Call(1).Assign(1).
+				End()
+		})
+}
+
+func TestErrErrorf(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:2:15: fmt.Errorf format %w has arg s of wrong type string", func(pkg *gogen.Package) {
+		pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+			NewVar(types.Typ[types.String], "s").
+			Val("bad: %w", source(`"bad: %w"`, 2, 3)).VarVal("s", source("s", 2, 15)).Errorf(2).EndStmt().
+			End()
+	})
+	codeErrorTest(t, "./foo.gop:2:3: fmt.Errorf format %w reads arg #2, but call has 1 arg", func(pkg *gogen.Package) {
+		pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+			Val("%d: %w", source(`"%d: %w"`, 2, 3)).Val(1).Errorf(2).EndStmt().
+			End()
+	})
+	codeErrorTest(t, "./foo.gop:2:3: not enough arguments in call to fmt.Errorf\n\thave ()\n\twant (string, ...any)",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Errorf(0, source("fmt.Errorf()", 2, 3)).EndStmt().
+				End()
+		})
+	codeErrorTestEx(t, newGoVersionPackage("go1.19"),
+		"./foo.gop:2:3: fmt.Errorf call has more than one error-wrapping directive %w: requires go1.20 or later (GoVersion is go1.19)",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(gogen.TyError, "a", "b").
+				Val("%w, %w", source(`"%w, %w"`, 2, 3)).VarVal("a").VarVal("b").Errorf(3).EndStmt().
+				End()
+		})
+}
+
+func TestErrClear(t *testing.T) {
+	codeErrorTestEx(t, newGoVersionPackage("go1.20"),
+		"./foo.gop:2:3: cannot use builtin clear: requires go1.21 or later (GoVersion is go1.20)",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.NewMap(types.Typ[types.String], types.Typ[types.Int]), "m").
+				Val(pkg.Builtin().Ref("clear"), source("clear", 2, 3)).VarVal("m").Call(1).EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:9: invalid argument: s (type string) does not satisfy clearable",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.String], "s").
+				Val(pkg.Builtin().Ref("clear")).VarVal("s", source("s", 2, 9)).
+				CallWith(1, 0, source("clear(s)", 2, 3)).EndStmt().
+				End()
+		})
+}
+
+func TestErrMinMax(t *testing.T) {
+	codeErrorTestEx(t, newGoVersionPackage("go1.20"),
+		"./foo.gop:2:3: cannot use builtin max: requires go1.21 or later (GoVersion is go1.20)",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(pkg.Builtin().Ref("max"), source("max", 2, 3)).Val(1).Val(2).Call(2).EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:7: invalid argument: b (type bool) cannot be ordered",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.Bool], "b").
+				Val(pkg.Builtin().Ref("min")).VarVal("b", source("b", 2, 7)).VarVal("b").
+				CallWith(2, 0, source("min(b, b)", 2, 3)).EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:10: invalid argument: mismatched types int (previous argument) and float64 (type of y)",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.Int], "x").
+				NewVar(types.Typ[types.Float64], "y").
+				Val(pkg.Builtin().Ref("min")).VarVal("x").VarVal("y", source("y", 2, 10)).
+				CallWith(2, 0, source("min(x, y)", 2, 3)).EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:10: cannot use 2.5 (type untyped float) as type int in argument to max",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.Int], "x").
+				Val(pkg.Builtin().Ref("max")).VarVal("x").Val(2.5, source("2.5", 2, 10)).
+				CallWith(2, 0, source("max(x, 2.5)", 2, 3)).EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:10: invalid argument: mismatched types untyped int (previous argument) and untyped string (type of \"a\")",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(pkg.Builtin().Ref("max")).Val(1).Val("a", source(`"a"`, 2, 10)).
+				CallWith(2, 0, source(`max(1, "a")`, 2, 3)).EndStmt().
+				End()
+		})
+}
+
+func TestErrShadowImport(t *testing.T) {
+	newPkg := func() *gogen.Package {
+		return gogen.NewPackage("", "main", &gogen.Config{
+			Fset:            gblFset,
+			Importer:        gblImp,
+			NodeInterpreter: nodeInterp{},
+			DbgPositioner:   nodeInterp{},
+			ErrShadowImport: true,
+		})
+	}
+	codeErrorTestEx(t, newPkg(), `./foo.gop:2:2: fmt shadows imported package "fmt"`,
+		func(pkg *gogen.Package) {
+			fmt := pkg.Import("fmt")
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(fmt.Ref("Println")).Call(0).EndStmt().
+				DefineVarStart(position(2, 2), "fmt").Val(1).EndInit(1).
+				End()
+		})
+	codeErrorTestEx(t, newPkg(), `./foo.gop:2:7: fmt shadows imported package "fmt"`,
+		func(pkg *gogen.Package) {
+			fmt := pkg.Import("fmt")
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(fmt.Ref("Println")).Call(0).EndStmt().
+				End()
+			param := pkg.NewParam(position(2, 7), "fmt", types.Typ[types.Int])
+			pkg.NewFunc(nil, "foo", types.NewTuple(param), nil, false).BodyStart(pkg).
+				End()
+		})
+}
+
+func TestErrChainCall(t *testing.T) {
+	newB := func(pkg *gogen.Package) *types.Named {
+		b := pkg.NewType("B").InitType(pkg, types.NewStruct(nil, nil))
+		recv := pkg.NewParam(token.NoPos, "b", b)
+		pkg.NewFunc(recv, "Copy", nil, types.NewTuple(pkg.NewParam(token.NoPos, "", b)), false).BodyStart(pkg).
+			Val(recv).Return(1).
+			End()
+		pkg.NewFunc(recv, "Pair", nil, types.NewTuple(
+			pkg.NewParam(token.NoPos, "", b), pkg.NewParam(token.NoPos, "", types.Typ[types.Int])), false).BodyStart(pkg).
+			Val(recv).Val(0).Return(2).
+			End()
+		precv := pkg.NewParam(token.NoPos, "b", types.NewPointer(b))
+		pkg.NewFunc(precv, "Reset", nil, nil, false).BodyStart(pkg).
+			End()
+		return b
+	}
+	codeErrorTest(t, "./foo.gop:2:3: v.Reset() (no value) used as value",
+		func(pkg *gogen.Package) {
+			b := newB(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(b, "v").
+				VarVal("v").ChainCall("Reset", 0, source("v.Reset()", 2, 3)).EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:3: multiple-value v.Pair() (value of type (B, int)) in single-value context",
+		func(pkg *gogen.Package) {
+			b := newB(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(b, "v").
+				VarVal("v").ChainCall("Pair", 0, source("v.Pair()", 2, 3)).EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:3: cannot call pointer method Reset on B",
+		func(pkg *gogen.Package) {
+			b := newB(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(b, "v").
+				VarVal("v").ChainCall("Copy", 0).ChainCall("Reset", 0, source("v.Copy().Reset()", 2, 3)).EndStmt().
+				End()
+		})
+}
+
+func TestErrChanDir(t *testing.T) {
+	tyChan := types.NewChan(types.SendRecv, types.Typ[types.Int])
+	codeErrorTest(t, "./foo.gop:2:5: cannot use r (type <-chan int) as type chan int in assignment (receive-only channel)",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(tyChan, "c").
+				NewVar(types.NewChan(types.RecvOnly, types.Typ[types.Int]), "r").
+				VarRef(ctxRef(pkg, "c")).VarVal("r", source("r", 2, 5)).Assign(1).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:18: cannot use s (type chan<- int) as type <-chan int in assignment (send-only channel)",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.NewChan(types.SendOnly, types.Typ[types.Int]), "s").
+				NewVarStart(types.NewChan(types.RecvOnly, types.Typ[types.Int]), "r").VarVal("s", source("s", 2, 18)).EndInit(1).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:14: cannot convert r (type <-chan int) to type chan int (receive-only channel)",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.NewChan(types.RecvOnly, types.Typ[types.Int]), "r").
+				Typ(tyChan).VarVal("r", source("r", 2, 14)).Call(1).EndStmt().
+				End()
+		})
+}
+
+func TestErrCondChain(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:2:7: cannot use x (type int) as type bool", func(pkg *gogen.Package) {
+		cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+			NewVar(types.Typ[types.Int], "x")
+		cb.CondChain([]gogen.CondBranch{
+			{Cond: func(cb *gogen.CodeBuilder) { cb.VarVal("x", source("x", 2, 7)) }},
+		}).End()
+	})
+	codeErrorTest(t, "./foo.gop:1:1: multiple defaults in switch", func(pkg *gogen.Package) {
+		cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
+		cb.CondChain([]gogen.CondBranch{{}, {}}, source("switch", 1, 1)).End()
+	})
+}
+
 func TestErrLabel(t *testing.T) {
 	codeErrorTest(t, "./foo.gop:2:1: label foo already defined at ./foo.gop:1:1", func(pkg *gogen.Package) {
 		cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
@@ -743,6 +1337,42 @@ func TestErrLabel(t *testing.T) {
 	codeErrorTest(t, "./foo.gop:1:1: syntax error: non-declaration statement outside function body", func(pkg *gogen.Package) {
 		pkg.CB().NewLabel(position(1, 1), position(1, 1), "foo")
 	})
+	codeErrorTest(t, "./foo.gop:3:3: invalid break label foo", func(pkg *gogen.Package) {
+		cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
+		l := cb.NewLabel(position(1, 1), position(1, 1), "foo")
+		cb.Label(l).For().None().Then().End().
+			For().None().Then().
+			Break(l, source("break foo", 3, 3)).
+			End().
+			End()
+	})
+	codeErrorTest(t, "./foo.gop:3:3: invalid continue label foo", func(pkg *gogen.Package) {
+		cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
+		l := cb.NewLabel(position(1, 1), position(1, 1), "foo")
+		cb.Label(l).Select().
+			CommDefaultThen().
+			Continue(l, source("continue foo", 3, 3)).
+			End().
+			End().
+			End()
+	})
+	codeErrorTest(t, "./foo.gop:4:4: invalid continue label sel", func(pkg *gogen.Package) {
+		tyCh := types.NewChan(types.SendRecv, types.Typ[types.Int])
+		ch := pkg.NewParam(token.NoPos, "ch", tyCh)
+		cb := pkg.NewFunc(nil, "main", types.NewTuple(ch), nil, false).BodyStart(pkg)
+		outer := cb.NewLabel(position(1, 1), position(1, 1), "outer")
+		sel := cb.NewLabel(position(2, 2), position(2, 2), "sel")
+		cb.Label(outer).ForRange().Val(ch).RangeAssignThen(token.NoPos).
+			Label(sel).Select().
+			CommCase().Val(ch).UnaryOp(token.ARROW).EndStmt().Then().
+			ForRange().Val(ch).RangeAssignThen(token.NoPos).
+			Continue(sel, source("continue sel", 4, 4)).
+			End().
+			End().
+			End().
+			End().
+			End()
+	})
 	/*	codeErrorTest(t, "./foo.gop:1:1: label foo is not defined", func(pkg *gogen.Package) {
 			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
 				Goto("foo", source("goto foo", 1, 1)).
@@ -794,6 +1424,45 @@ func TestErrStructLit(t *testing.T) {
 				EndStmt().
 				End()
 		})
+	codeErrorTest(t, `./foo.gop:1:9: duplicate field name x in struct literal`,
+		func(pkg *gogen.Package) {
+			fields := []*types.Var{
+				types.NewField(token.NoPos, pkg.Types, "x", types.Typ[types.Int], false),
+			}
+			tyStruc := types.NewStruct(fields, nil)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(0).Val(1, source(`1`, 1, 5)).
+				Val(0).Val(2, source(`2`, 1, 9)).
+				StructLit(tyStruc, 4, true).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:1:5: unknown field z in struct literal of type struct{x int; y string}`,
+		func(pkg *gogen.Package) {
+			fields := []*types.Var{
+				types.NewField(token.NoPos, pkg.Types, "x", types.Typ[types.Int], false),
+				types.NewField(token.NoPos, pkg.Types, "y", types.Typ[types.String], false),
+			}
+			tyStruc := types.NewStruct(fields, nil)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(1, source(`1`, 1, 5)).
+				StructLitByName(tyStruc, []string{"z"}).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:1:5: cannot use 1 (type untyped int) as type string in value of field y`,
+		func(pkg *gogen.Package) {
+			fields := []*types.Var{
+				types.NewField(token.NoPos, pkg.Types, "x", types.Typ[types.Int], false),
+				types.NewField(token.NoPos, pkg.Types, "y", types.Typ[types.String], false),
+			}
+			tyStruc := types.NewStruct(fields, nil)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(1, source(`1`, 1, 5)).
+				StructLitByName(tyStruc, []string{"y"}).
+				EndStmt().
+				End()
+		})
 	codeErrorTest(t, `./foo.gop:1:1: cannot use "1" (type untyped string) as type int in value of field x`,
 		func(pkg *gogen.Package) {
 			fields := []*types.Var{
@@ -808,6 +1477,22 @@ func TestErrStructLit(t *testing.T) {
 				EndStmt().
 				End()
 		})
+	codeErrorTest(t, `./foo.gop:1:8: cannot use func(x int) {} (type func(x int)) as type func(s string) in value of field fn`,
+		func(pkg *gogen.Package) {
+			s := pkg.NewParam(token.NoPos, "s", types.Typ[types.String])
+			x := pkg.NewParam(token.NoPos, "x", types.Typ[types.Int])
+			fields := []*types.Var{
+				types.NewField(token.NoPos, pkg.Types, "fn", types.NewSignatureType(nil, nil, nil, types.NewTuple(s), nil, false), false),
+			}
+			tyStruc := types.NewStruct(fields, nil)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(0).
+				NewClosure(types.NewTuple(x), nil, false).BodyStart(pkg).
+				End(source(`func(x int) {}`, 1, 8)).
+				StructLit(tyStruc, 2, true).
+				EndStmt().
+				End()
+		})
 }
 
 func TestErrMapLit(t *testing.T) {
@@ -824,44 +1509,109 @@ func TestErrMapLit(t *testing.T) {
 				MapLit(tyMap, 2).
 				End()
 		})
-	codeErrorTest(t, `./foo.gop:1:5: cannot use "Hi" + "!" (type untyped string) as type int in map value`,
+	codeErrorTest(t, `./foo.gop:1:5: cannot use "Hi" + "!" (type untyped string) as type int in map value`,
+		func(pkg *gogen.Package) {
+			tyMap := types.NewMap(types.Typ[types.String], types.Typ[types.Int])
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val("1").
+				Val("Hi", source(`"Hi"`)).
+				Val("!", source(`"!"`)).
+				BinaryOp(token.ADD, source(`"Hi" + "!"`, 1, 5)).
+				MapLit(tyMap, 2).
+				EndStmt().
+				End()
+		})
+}
+
+func TestErrMapLit2(t *testing.T) {
+	codeErrorTest(t, "-: MapLit: invalid arity, can't be odd - 1",
+		func(pkg *gogen.Package) {
+			tyMap := types.NewMap(types.Typ[types.String], types.Typ[types.Int])
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val("1").
+				MapLit(tyMap, 1).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, "-: type string isn't a map",
+		func(pkg *gogen.Package) {
+			tyMap := types.Typ[types.String]
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val("1").
+				Val("Hi", source(`"Hi"`)).
+				Val("!", source(`"!"`)).
+				BinaryOp(token.ADD, source(`"Hi" + "!"`, 1, 5)).
+				MapLit(tyMap, 2).
+				EndStmt().
+				End()
+		})
+}
+
+func TestErrMapLitDupKey(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:1:12: duplicate key 0x01 (value 1 of type uint8) in map literal\n\tprevious key at ./foo.gop:1:5",
+		func(pkg *gogen.Package) {
+			tyMap := types.NewMap(types.Typ[types.Uint8], types.Typ[types.Int])
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(1, source("1", 1, 5)).Val(1).
+				Val(0x01, source("0x01", 1, 12)).Val(2).
+				MapLit(tyMap, 4).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:1:12: cannot use 257 (type untyped int) as type uint8 in map key (overflows)",
 		func(pkg *gogen.Package) {
-			tyMap := types.NewMap(types.Typ[types.String], types.Typ[types.Int])
+			tyMap := types.NewMap(types.Typ[types.Uint8], types.Typ[types.Int])
 			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
-				Val("1").
-				Val("Hi", source(`"Hi"`)).
-				Val("!", source(`"!"`)).
-				BinaryOp(token.ADD, source(`"Hi" + "!"`, 1, 5)).
-				MapLit(tyMap, 2).
+				Val(1, source("1", 1, 5)).Val(1).
+				Val(257, source("257", 1, 12)).Val(2).
+				MapLit(tyMap, 4).
 				EndStmt().
 				End()
 		})
-}
-
-func TestErrMapLit2(t *testing.T) {
-	codeErrorTest(t, "-: MapLit: invalid arity, can't be odd - 1",
+	codeErrorTest(t, `./foo.gop:2:5: duplicate key "a" + "b" (value "ab" of type Key) in map literal`+"\n\tprevious key at ./foo.gop:1:5",
 		func(pkg *gogen.Package) {
-			tyMap := types.NewMap(types.Typ[types.String], types.Typ[types.Int])
+			key := pkg.NewType("Key").InitType(pkg, types.Typ[types.String])
+			tyMap := types.NewMap(key, types.Typ[types.Int])
 			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
-				Val("1").
-				MapLit(tyMap, 1).
+				Val("ab", source(`"ab"`, 1, 5)).Val(1).
+				Val("a").Val("b").BinaryOp(token.ADD, source(`"a" + "b"`, 2, 5)).Val(2).
+				MapLit(tyMap, 4).
 				EndStmt().
 				End()
 		})
-	codeErrorTest(t, "-: type string isn't a map",
+	codeErrorTest(t, `./foo.gop:1:10: duplicate key "a" (value "a" of type string) in map literal`+"\n\tprevious key at ./foo.gop:1:5",
 		func(pkg *gogen.Package) {
-			tyMap := types.Typ[types.String]
 			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
-				Val("1").
-				Val("Hi", source(`"Hi"`)).
-				Val("!", source(`"!"`)).
-				BinaryOp(token.ADD, source(`"Hi" + "!"`, 1, 5)).
-				MapLit(tyMap, 2).
+				Val("a", source(`"a"`, 1, 5)).Val(1).
+				Val("a", source(`"a"`, 1, 10)).Val(2).
+				MapLit(nil, 4).
 				EndStmt().
 				End()
 		})
 }
 
+func TestErrArrayType(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:1:5: array length n (value of type int) must be constant",
+		func(pkg *gogen.Package) {
+			n := pkg.NewParam(token.NoPos, "n", types.Typ[types.Int])
+			cb := pkg.NewFunc(nil, "foo", types.NewTuple(n), nil, false).BodyStart(pkg)
+			cb.Val(n, source("n", 1, 5)).ArrayType(types.Typ[types.Int])
+			cb.End()
+		})
+	codeErrorTest(t, "./foo.gop:1:5: array length 1.5 (type untyped float) must be integer",
+		func(pkg *gogen.Package) {
+			pkg.CB().Val(1.5, source("1.5", 1, 5)).ArrayType(types.Typ[types.Int])
+		})
+	codeErrorTest(t, `./foo.gop:1:5: array length "a" (type untyped string) must be integer`,
+		func(pkg *gogen.Package) {
+			pkg.CB().Val("a", source(`"a"`, 1, 5)).ArrayType(types.Typ[types.Int])
+		})
+	codeErrorTest(t, "./foo.gop:1:5: invalid array length -1",
+		func(pkg *gogen.Package) {
+			pkg.CB().Val(1).UnaryOp(token.SUB, false, source("-1", 1, 5)).ArrayType(types.Typ[types.Int])
+		})
+}
+
 func TestErrArrayLit(t *testing.T) {
 	codeErrorTest(t, "./foo.gop:1:5: cannot use 32 (type untyped int) as type string in array literal",
 		func(pkg *gogen.Package) {
@@ -969,6 +1719,68 @@ func TestErrSliceLit(t *testing.T) {
 		})
 }
 
+func TestErrLitFromConsts(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:1:5: cannot use 65536 (type untyped int) as type uint16 in slice literal (overflows)",
+		func(pkg *gogen.Package) {
+			tySlice := types.NewSlice(types.Typ[types.Uint16])
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				SliceLitFromConsts(tySlice, []constant.Value{
+					constant.MakeInt64(1), constant.MakeInt64(65536),
+				}, source("tab", 1, 5)).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:1:5: cannot use "x" (type untyped string) as type int in map value`,
+		func(pkg *gogen.Package) {
+			tyMap := types.NewMap(types.Typ[types.String], types.Typ[types.Int])
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				MapLitFromConsts(tyMap, []constant.Value{constant.MakeString("a")},
+					[]constant.Value{constant.MakeString("x")}, source("tab", 1, 5)).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:1:5: duplicate key "a" in map literal`,
+		func(pkg *gogen.Package) {
+			tyMap := types.NewMap(types.Typ[types.String], types.Typ[types.Int])
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				MapLitFromConsts(tyMap, []constant.Value{constant.MakeString("a"), constant.MakeString("a")},
+					[]constant.Value{constant.MakeInt64(1), constant.MakeInt64(2)}, source("tab", 1, 5)).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:1:5: type int isn't a slice",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				SliceLitFromConsts(types.Typ[types.Int], nil, source("tab", 1, 5)).
+				EndStmt().
+				End()
+		})
+}
+
+func TestErrSliceConcat(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:1:5: cannot concat a (type int): not a slice",
+		func(pkg *gogen.Package) {
+			a := pkg.NewParam(token.NoPos, "a", types.Typ[types.Int])
+			pkg.NewFunc(nil, "main", types.NewTuple(a), nil, false).BodyStart(pkg).
+				Val(a, source("a", 1, 5)).
+				Val(a, source("a", 1, 9)).
+				SliceConcat().
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:1:9: cannot concat b (type []string) to type []int",
+		func(pkg *gogen.Package) {
+			a := pkg.NewParam(token.NoPos, "a", types.NewSlice(types.Typ[types.Int]))
+			b := pkg.NewParam(token.NoPos, "b", types.NewSlice(types.Typ[types.String]))
+			pkg.NewFunc(nil, "main", types.NewTuple(a, b), nil, false).BodyStart(pkg).
+				Val(a, source("a", 1, 5)).
+				Val(b, source("b", 1, 9)).
+				SliceConcat().
+				EndStmt().
+				End()
+		})
+}
+
 func TestErrSlice(t *testing.T) {
 	codeErrorTest(t,
 		`./foo.gop:1:5: cannot slice true (type untyped bool)`,
@@ -1022,7 +1834,7 @@ func TestErrIndex(t *testing.T) {
 				End()
 		})
 	codeErrorTest(t,
-		`./foo.gop:1:5: assignment mismatch: 2 variables but 1 values`,
+		`./foo.gop:1:5: assignment mismatch: 2 variables but 1 value`,
 		func(pkg *gogen.Package) {
 			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
 				NewVar(types.Typ[types.String], "x").
@@ -1133,6 +1945,89 @@ func TestErrMember(t *testing.T) {
 		})
 }
 
+func TestErrPtrMethod(t *testing.T) {
+	newM := func(pkg *gogen.Package) *types.Named {
+		tyM := pkg.NewType("M").InitType(pkg, types.NewStruct(nil, nil))
+		recv := pkg.NewParam(token.NoPos, "p", types.NewPointer(tyM))
+		pkg.NewFunc(recv, "Set", nil, nil, false).BodyStart(pkg).End()
+		return tyM
+	}
+	codeErrorTest(t,
+		`./foo.gop:1:5: cannot call pointer method Set on M`,
+		func(pkg *gogen.Package) {
+			tyM := newM(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				StructLit(tyM, 0, false).
+				MemberVal("Set", source("M{}.Set", 1, 5)).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t,
+		`./foo.gop:2:5: cannot call pointer method Set on M`,
+		func(pkg *gogen.Package) {
+			tyM := newM(pkg)
+			ret := types.NewTuple(pkg.NewParam(token.NoPos, "", tyM))
+			pkg.NewFunc(nil, "get", nil, ret, false).BodyStart(pkg).
+				StructLit(tyM, 0, false).Return(1).
+				End()
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(ctxRef(pkg, "get")).Call(0).
+				MemberVal("Set", source("get().Set", 2, 5)).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t,
+		`./foo.gop:2:5: cannot call pointer method on map index m["a"] (method Set has pointer receiver)`,
+		func(pkg *gogen.Package) {
+			tyM := newM(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.NewMap(types.Typ[types.String], tyM), "m").
+				VarVal("m").Val("a").Index(1, false, source(`m["a"]`, 2, 5)).
+				MemberVal("Set", source(`m["a"].Set`, 2, 5)).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t,
+		`./foo.gop:2:5: cannot call pointer method Set on M`,
+		func(pkg *gogen.Package) {
+			tyM := newM(pkg)
+			fields := []*types.Var{
+				types.NewField(token.NoPos, pkg.Types, "m", tyM, false),
+			}
+			tyT := types.NewStruct(fields, nil)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.NewMap(types.Typ[types.String], tyT), "m").
+				VarVal("m").Val("a").Index(1, false).MemberVal("m").
+				MemberVal("Set", source(`m["a"].m.Set`, 2, 5)).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t,
+		`./foo.gop:2:5: cannot call pointer method Set on M`,
+		func(pkg *gogen.Package) {
+			tyM := newM(pkg)
+			ret := types.NewTuple(pkg.NewParam(token.NoPos, "", types.NewArray(tyM, 3)))
+			pkg.NewFunc(nil, "get", nil, ret, false).BodyStart(pkg).
+				ArrayLit(types.NewArray(tyM, 3), 0).Return(1).
+				End()
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(ctxRef(pkg, "get")).Call(0).Val(0).Index(1, false).
+				MemberVal("Set", source("get()[0].Set", 2, 5)).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t,
+		`./foo.gop:2:5: cannot call pointer method Set on M`,
+		func(pkg *gogen.Package) {
+			tyM := newM(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				ArrayLit(types.NewArray(tyM, 3), 0).Val(0).Index(1, false).
+				MemberVal("Set", source("[3]M{}[0].Set", 2, 5)).
+				EndStmt().
+				End()
+		})
+}
+
 func TestErrMemberRef(t *testing.T) {
 	codeErrorTest(t,
 		`./foo.gop:1:7: x.y undefined (type string has no field or method y)`,
@@ -1390,6 +2285,31 @@ func TestDivisionByZero(t *testing.T) {
 				VarRef(ctxRef(pkg, "a")).Val(&ast.BasicLit{Kind: token.IMAG, Value: "0i"}, source("0i", 1, 3)).AssignOp(token.QUO_ASSIGN).
 				End()
 		})
+	codeErrorTest(t,
+		`./foo.gop:1:3: invalid operation: division by zero`,
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(1).Val(0, source("0", 1, 3)).BinaryOp(token.REM).
+				End()
+		})
+	codeErrorTest(t,
+		`./foo.gop:1:3: invalid operation: division by zero`,
+		func(pkg *gogen.Package) {
+			typ := types.Typ[types.Int]
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(typ, "a").
+				VarVal("a").Val(0, source("0", 1, 3)).BinaryOp(token.REM).
+				End()
+		})
+	codeErrorTest(t,
+		`./foo.gop:1:3: invalid operation: division by zero`,
+		func(pkg *gogen.Package) {
+			typ := types.Typ[types.Int]
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(typ, "a").
+				VarRef(ctxRef(pkg, "a")).Val(0, source("0", 1, 3)).AssignOp(token.REM_ASSIGN).
+				End()
+		})
 }
 
 func TestErrUsedNoValue(t *testing.T) {
@@ -1442,6 +2362,20 @@ type M struct {
 		})
 }
 
+func TestErrImportInternal(t *testing.T) {
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		Fset:            gblFset,
+		Importer:        gblImp,
+		NodeInterpreter: nodeInterp{},
+		DbgPositioner:   nodeInterp{},
+		DestPath:        "example.com/m",
+	})
+	codeErrorTestEx(t, pkg, "./foo.gop:1:8: use of internal package github.com/goplus/gogen/internal/builtin not allowed in example.com/m",
+		func(pkg *gogen.Package) {
+			pkg.Import("github.com/goplus/gogen/internal/builtin", source(`"github.com/goplus/gogen/internal/builtin"`, 1, 8))
+		})
+}
+
 func TestErrorLit(t *testing.T) {
 	codeErrorTest(t, "./foo.gop:1:5: type int isn't a map",
 		func(pkg *gogen.Package) {
@@ -1467,6 +2401,24 @@ func TestErrorLit(t *testing.T) {
 				EndStmt().
 				End()
 		})
+	codeErrorTest(t, "./foo.gop:1:5: type foo isn't a slice",
+		func(pkg *gogen.Package) {
+			foo := pkg.NewType("foo").InitType(pkg, types.NewMap(types.Typ[types.Int], types.Typ[types.Int]))
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(1).Val(2).
+				SliceLitEx(foo, 2, false, source("foo{1,2}", 1, 5)).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:1:5: type foo isn't a array",
+		func(pkg *gogen.Package) {
+			foo := pkg.NewType("foo").InitType(pkg, types.NewSlice(types.Typ[types.Int]))
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(1).Val(2).
+				ArrayLitEx(foo, 2, false, source("foo{1,2}", 1, 5)).
+				EndStmt().
+				End()
+		})
 	codeErrorTest(t, "./foo.gop:1:5: type int isn't a struct",
 		func(pkg *gogen.Package) {
 			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
@@ -1476,3 +2428,275 @@ func TestErrorLit(t *testing.T) {
 				End()
 		})
 }
+
+func TestErrAssertImplements(t *testing.T) {
+	newFoo := func(pkg *gogen.Package) *types.Named {
+		foo := pkg.NewType("Foo").InitType(pkg, types.NewStruct(nil, nil))
+		recv := pkg.NewParam(token.NoPos, "f", types.NewPointer(foo))
+		err := pkg.NewParam(token.NoPos, "err", gogen.TyError)
+		pkg.NewFunc(recv, "Close", nil, types.NewTuple(err), false).BodyStart(pkg).
+			Val(nil).Return(1).
+			End()
+		return foo
+	}
+	codeErrorTest(t, "./foo.gop:1:5: *Foo does not implement io.Writer (missing method Write)",
+		func(pkg *gogen.Package) {
+			foo := newFoo(pkg)
+			io := pkg.Import("io")
+			pkg.AssertImplements(io.Ref("Writer").Type(), types.NewPointer(foo), source("(*Foo)(nil)", 1, 5))
+		})
+	codeErrorTest(t, "./foo.gop:1:5: Foo does not implement io.Closer (method Close has pointer receiver)",
+		func(pkg *gogen.Package) {
+			foo := newFoo(pkg)
+			io := pkg.Import("io")
+			pkg.AssertImplements(io.Ref("Closer").Type(), foo, source("Foo{}", 1, 5))
+		})
+	codeErrorTest(t, "./foo.gop:1:5: int is not an interface",
+		func(pkg *gogen.Package) {
+			foo := newFoo(pkg)
+			pkg.AssertImplements(types.Typ[types.Int], foo, source("Foo{}", 1, 5))
+		})
+}
+
+func TestErrAssertSize(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:1:5: size of int32 is 4, not 8",
+		func(pkg *gogen.Package) {
+			pkg.AssertSize(types.Typ[types.Int32], 8, source("int32", 1, 5))
+		})
+}
+
+func TestErrExportOverloadGroup(t *testing.T) {
+	newFuncs := func(pkg *gogen.Package) (*types.Named, []types.Object) {
+		tyT := pkg.NewType("T").InitType(pkg, types.NewStruct(nil, nil))
+		recv := pkg.NewParam(token.NoPos, "t", tyT)
+		fns := []*gogen.Func{
+			pkg.NewFunc(nil, "Foo", nil, nil, false),
+			pkg.NewFunc(nil, "bar", nil, nil, false),
+			pkg.NewFunc(recv, "Baz", nil, nil, false),
+		}
+		objs := make([]types.Object, len(fns))
+		for i, fn := range fns {
+			fn.BodyStart(pkg).End()
+			objs[i] = fn.Obj()
+		}
+		return tyT, objs
+	}
+	codeErrorTest(t, "./foo.gop:1:5: overload member bar is not exported",
+		func(pkg *gogen.Package) {
+			_, fns := newFuncs(pkg)
+			pkg.ExportOverloadGroup("Foo", fns[:2], source("Foo", 1, 5))
+		})
+	codeErrorTest(t, "./foo.gop:1:5: overload member Println is not declared in this package",
+		func(pkg *gogen.Package) {
+			_, fns := newFuncs(pkg)
+			fmt := pkg.Import("fmt")
+			pkg.ExportOverloadGroup("Foo", []types.Object{fns[0], fmt.Ref("Println")}, source("Foo", 1, 5))
+		})
+	codeErrorTest(t, "./foo.gop:1:5: overload member T is not a function",
+		func(pkg *gogen.Package) {
+			tyT, fns := newFuncs(pkg)
+			pkg.ExportOverloadGroup("Foo", []types.Object{fns[0], tyT.Obj()}, source("Foo", 1, 5))
+		})
+	codeErrorTest(t, "./foo.gop:1:5: overload member Baz is not a method of Foo",
+		func(pkg *gogen.Package) {
+			_, fns := newFuncs(pkg)
+			pkg.ExportOverloadGroup("Foo", []types.Object{fns[0], fns[2]}, source("Foo", 1, 5))
+		})
+	codeErrorTest(t, "./foo.gop:1:5: U is not a type declared in this package",
+		func(pkg *gogen.Package) {
+			_, fns := newFuncs(pkg)
+			pkg.ExportOverloadGroup("U.Baz", fns[2:], source("Foo", 1, 5))
+		})
+	codeErrorTest(t, "./foo.gop:1:5: overload foo is not exported",
+		func(pkg *gogen.Package) {
+			_, fns := newFuncs(pkg)
+			pkg.ExportOverloadGroup("foo", fns[:1], source("foo", 1, 5))
+		})
+	codeErrorTest(t, "./foo.gop:1:5: overload T.Baz has no members",
+		func(pkg *gogen.Package) {
+			newFuncs(pkg)
+			pkg.ExportOverloadGroup("T.Baz", nil, source("T.Baz", 1, 5))
+		})
+}
+
+func TestErrStructLitPositional(t *testing.T) {
+	codeErrorTest(t, `./foo.gop:1:11: cannot use "2" (type untyped string) as type int in value of field Y`,
+		func(pkg *gogen.Package) {
+			point := pkg.Import("image").Ref("Point").Type()
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(1, source(`1`, 1, 7)).
+				Val("2", source(`"2"`, 1, 11)).
+				StructLit(point, 2, false).
+				EndStmt().
+				End()
+		})
+}
+
+func TestErrStructLitSparse(t *testing.T) {
+	newOpts := func(pkg *gogen.Package) types.Type {
+		fields := []*types.Var{
+			types.NewField(token.NoPos, pkg.Types, "Name", types.Typ[types.String], false),
+			types.NewField(token.NoPos, pkg.Types, "Retry", types.Typ[types.Int], false),
+		}
+		return pkg.NewType("Options").InitType(pkg, types.NewStruct(fields, nil))
+	}
+	codeErrorTest(t, `./foo.gop:1:9: unknown field Size in struct literal of type Options`,
+		func(pkg *gogen.Package) {
+			opts := newOpts(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val("Size", source(`"Size"`, 1, 9)).Val(0).
+				StructLitSparse(opts, 1, true).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:1:16: cannot use "" (type untyped string) as type int in value of field Retry`,
+		func(pkg *gogen.Package) {
+			opts := newOpts(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val("Retry").Val("", source(`""`, 1, 16)).
+				StructLitSparse(opts, 1, true).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:1:16: cannot use "x" (type untyped string) as type int in value of field Retry`,
+		func(pkg *gogen.Package) {
+			opts := newOpts(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val("Retry").Val("x", source(`"x"`, 1, 16)).
+				StructLitSparse(opts, 1, true).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:1:20: duplicate field name Name in struct literal`,
+		func(pkg *gogen.Package) {
+			opts := newOpts(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val("Name").Val("").
+				Val("Name", source(`"Name"`, 1, 20)).Val("x").
+				StructLitSparse(opts, 2, true).
+				EndStmt().
+				End()
+		})
+	codeErrorTest(t, `./foo.gop:1:9: cannot use n as field name which must be a string constant`,
+		func(pkg *gogen.Package) {
+			opts := newOpts(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				DefineVarStart(token.NoPos, "n").Val("Name").EndInit(1).
+				Val(ctxRef(pkg, "n"), source(`n`, 1, 9)).Val("x").
+				StructLitSparse(opts, 1, true).
+				EndStmt().
+				End()
+		})
+}
+
+func TestErrAssignNamedResults(t *testing.T) {
+	newDiv := func(pkg *gogen.Package) {
+		q := pkg.NewParam(token.NoPos, "q", types.Typ[types.Int])
+		msg := pkg.NewParam(token.NoPos, "msg", types.Typ[types.String])
+		pkg.NewFunc(nil, "div", nil, gogen.NewTuple(q, msg), false).BodyStart(pkg).
+			Return(0).
+			End()
+	}
+	codeErrorTest(t, "./foo.gop:1:1: cannot use int value as type string in assignment",
+		func(pkg *gogen.Package) {
+			newDiv(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.String], "q").
+				NewVar(types.Typ[types.Int], "msg").
+				VarRef(ctxRef(pkg, "q")).VarRef(ctxRef(pkg, "msg")).
+				Val(ctxRef(pkg, "div")).CallWith(0, 0, source("div()", 1, 9)).
+				AssignWith(2, 1, source("q, msg = div()", 1, 1)).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:1:1: assignment mismatch: 3 variables but div returns 2 values",
+		func(pkg *gogen.Package) {
+			newDiv(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVar(types.Typ[types.Int], "a", "b", "c").
+				VarRef(ctxRef(pkg, "a")).VarRef(ctxRef(pkg, "b")).VarRef(ctxRef(pkg, "c")).
+				Val(ctxRef(pkg, "div")).CallWith(0, 0, source("div()", 1, 12)).
+				AssignWith(3, 1, source("a, b, c = div()", 1, 1)).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:1:1: assignment mismatch: 1 variable but div returns 2 values",
+		func(pkg *gogen.Package) {
+			newDiv(pkg)
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				DefineVarStart(position(1, 1), "q").
+				Val(ctxRef(pkg, "div")).CallWith(0, 0, source("div()", 1, 6)).
+				EndInit(1).
+				End()
+		})
+}
+
+func TestErrRecvNonLocal(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:1:10: cannot define new methods on non-local type bytes.Buffer", func(pkg *gogen.Package) {
+		bytes := pkg.Import("bytes")
+		recv := pkg.NewParam(position(1, 7), "p", types.NewPointer(bytes.Ref("Buffer").Type()))
+		newFunc(pkg, 1, 5, 1, 10, recv, "foo", nil, nil, false).BodyStart(pkg).End()
+	})
+	codeErrorTest(t, "./foo.gop:2:9: cannot define new methods on non-local type time.Duration", func(pkg *gogen.Package) {
+		time := pkg.Import("time")
+		recv := pkg.NewParam(position(2, 7), "d", time.Ref("Duration").Type())
+		newFunc(pkg, 2, 5, 2, 9, recv, "foo", nil, nil, false).BodyStart(pkg).End()
+	})
+	codeErrorTestEx(t, newPackage("main", true), "./foo.gop:3:9: cannot define new methods on non-local type time.Duration", func(pkg *gogen.Package) {
+		time := pkg.Import("time")
+		dur := pkg.AliasType("Dur", time.Ref("Duration").Type())
+		recv := pkg.NewParam(position(3, 7), "d", dur)
+		newFunc(pkg, 3, 5, 3, 9, recv, "foo", nil, nil, false).BodyStart(pkg).End()
+	})
+	codeErrorTest(t, "./foo.gop:4:10: invalid receiver type *foo (*foo is not a defined type)", func(pkg *gogen.Package) {
+		foo := pkg.NewType("foo").InitType(pkg, types.NewStruct(nil, nil))
+		recv := pkg.NewParam(position(4, 7), "p", types.NewPointer(types.NewPointer(foo)))
+		newFunc(pkg, 4, 5, 4, 10, recv, "bar", nil, nil, false).BodyStart(pkg).End()
+	})
+}
+
+func TestErrOverloadByResults(t *testing.T) {
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		Fset:              gblFset,
+		Importer:          gblImp,
+		NodeInterpreter:   nodeInterp{},
+		DbgPositioner:     nodeInterp{},
+		OverloadByResults: true,
+	})
+	codeErrorTestEx(t, pkg, "./foo.gop:1:10: ambiguous call of Bar(): both Bar__1 and Bar__2 return 2 results",
+		func(pkg *gogen.Package) {
+			n := pkg.NewParam(token.NoPos, "", types.Typ[types.Int])
+			s := pkg.NewParam(token.NoPos, "", types.Typ[types.String])
+			err := pkg.NewParam(token.NoPos, "", gogen.TyError)
+			bar0 := pkg.NewFunc(nil, "Bar__0", nil, types.NewTuple(n), false)
+			bar0.BodyStart(pkg).Val(0).Return(1).End()
+			bar1 := pkg.NewFunc(nil, "Bar__1", nil, types.NewTuple(n, err), false)
+			bar1.BodyStart(pkg).Val(0).Val(nil).Return(2).End()
+			bar2 := pkg.NewFunc(nil, "Bar__2", nil, types.NewTuple(s, err), false)
+			bar2.BodyStart(pkg).Val("").Val(nil).Return(2).End()
+			pkg.Types.Scope().Insert(gogen.NewOverloadFunc(
+				token.NoPos, pkg.Types, "Bar", bar0.Obj(), bar1.Obj(), bar2.Obj()))
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				DefineVarStart(position(1, 1), "x", "err").
+				Val(ctxRef(pkg, "Bar"), source("Bar", 1, 10)).CallWith(0, 0, source("Bar()", 1, 10)).
+				EndInit(1).
+				End()
+		})
+}
+
+func TestErrRefLaterValue(t *testing.T) {
+	codeErrorTest(t, "./foo.gop:2:7: foo() can only be called as a statement: foo isn't declared yet",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				DefineVarStart(position(2, 2), "x").
+				Val(pkg.RefLater("foo"), source("foo", 2, 7)).CallWith(0, 0, source("foo()", 2, 7)).
+				EndInit(1).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:3:14: foo() can only be called as a statement: foo isn't declared yet",
+		func(pkg *gogen.Package) {
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				Val(pkg.Import("fmt").Ref("Println")).
+				Val(pkg.RefLater("foo"), source("foo", 3, 14)).CallWith(0, 0, source("foo()", 3, 14)).
+				Call(1).EndStmt().
+				End()
+		})
+}
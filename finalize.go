@@ -0,0 +1,134 @@
+/*
+Copyright 2026 The XGo Authors (xgo.dev)
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gogen
+
+import (
+	"go/token"
+	"sort"
+
+	"github.com/goplus/gogen/internal/go/format"
+	"github.com/goplus/gogen/internal/go/printer"
+)
+
+// ----------------------------------------------------------------------------
+
+// BuildResult summarizes a build of a package, see Package.Finalize.
+//
+// It doesn't report unused objects (other than imports) nor check cycles of
+// package-level initialization: gogen doesn't resolve identifiers of the
+// generated code, so these are left to go/types or the Go compiler.
+type BuildResult struct {
+	Files         []BuildFile         // files to be written, sorted by name
+	Errors        []error             // errors found by the deferred checks
+	Warnings      []error             // warnings (see Config.HandleWarn) and TODOs if not Config.StrictTodo
+	UnusedImports []string            // packages referenced only by discarded code, pruned from the output
+	ImportedRefs  map[string][]string // see Package.ImportedRefs
+}
+
+// BuildFile is a file to be written, see BuildResult.
+type BuildFile struct {
+	Name string // file name
+	Size int    // size in bytes, not including GeneratedHeader
+}
+
+func (p *Package) warn(err error) {
+	p.warns = append(p.warns, err)
+	if warn := p.conf.HandleWarn; warn != nil {
+		warn(err)
+	}
+}
+
+// Finalize runs the work deferred to the end of a build: it resolves forward
+// references (see ResolveLaterRefs), prunes unused imports, and checks that
+// neither invalid expressions (see CodeBuilder.Invalid) nor TODOs in strict
+// mode (see Config.StrictTodo) remain in any file. It returns a summary of the
+// build, and the first error found (also listed in BuildResult.Errors). Files
+// are only formatted, to compute their sizes, if no error is found.
+//
+// Finalize doesn't change the generated code, so it can be called more than
+// once. Calls to forward references are type-checked again each time (their
+// arguments are restored afterwards), so warnings found by these checks, if
+// any, are reported again (see Config.HandleWarn).
+// WriteTo and WriteFile run the same checks implicitly (limited to the file
+// being written for invalid expressions), so calling Finalize before them is
+// optional, but lets the caller inspect the result before anything is
+// written.
+func (p *Package) Finalize() (ret *BuildResult, err error) {
+	ret = &BuildResult{ImportedRefs: p.ImportedRefs()}
+	addErr := func(e error) {
+		if e != nil {
+			ret.Errors = append(ret.Errors, e)
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	addErr(p.ResolveLaterRefs())
+
+	fnames := make([]string, 0, len(p.files))
+	for fname := range p.files {
+		fnames = append(fnames, fname)
+	}
+	sort.Strings(fnames)
+	files := make([]*printer.CommentedNodes, len(fnames))
+	unused := make(map[string]null)
+	for i, fname := range fnames {
+		files[i] = p.CommentedASTFile(fname)
+		addErr(p.checkInvalidExprs(files[i]))
+		p.files[fname].unusedImports(unused)
+	}
+	for pkgPath := range unused {
+		ret.UnusedImports = append(ret.UnusedImports, pkgPath)
+	}
+	sort.Strings(ret.UnusedImports)
+
+	ret.Warnings = append(ret.Warnings, p.warns...)
+	if p.conf.StrictTodo {
+		addErr(p.checkTodos())
+	} else {
+		ret.Warnings = append(ret.Warnings, p.todoErrors()...)
+	}
+
+	if err == nil {
+		for i, file := range files {
+			var n byteCounter
+			if e := format.Node(&n, token.NewFileSet(), file); e != nil {
+				addErr(e)
+				continue
+			}
+			ret.Files = append(ret.Files, BuildFile{Name: fnames[i], Size: int(n)})
+		}
+	}
+	return
+}
+
+// checkPackage runs the package-wide checks of Finalize: forward references
+// and TODOs in strict mode.
+func (p *Package) checkPackage() error {
+	if err := p.ResolveLaterRefs(); err != nil {
+		return err
+	}
+	return p.checkTodos()
+}
+
+type byteCounter int
+
+func (p *byteCounter) Write(b []byte) (int, error) {
+	*p += byteCounter(len(b))
+	return len(b), nil
+}
+
+// ----------------------------------------------------------------------------
@@ -0,0 +1,199 @@
+/*
+Copyright 2026 The XGo Authors (xgo.dev)
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gogen
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/goplus/gogen/internal"
+)
+
+// ----------------------------------------------------------------------------
+
+// TyLaterRef represents type of a forward reference to an object which will
+// be declared later in the same package (see Package.RefLater).
+type TyLaterRef struct {
+	obj   *types.Var
+	uses  []laterUse
+	calls []*laterCall
+}
+
+type laterUse struct {
+	id  *ast.Ident // the emitted reference
+	src ast.Node
+}
+
+type laterCall struct {
+	expr  *ast.CallExpr // the emitted call
+	fn    *internal.Elem
+	args  []*internal.Elem
+	flags InstrFlags
+}
+
+func (p *TyLaterRef) typeEx()                {}
+func (p *TyLaterRef) Underlying() types.Type { return p }
+func (p *TyLaterRef) String() string         { return "TyLaterRef{" + p.obj.Name() + "}" }
+
+// Obj returns the placeholder object of this forward reference.
+func (p *TyLaterRef) Obj() types.Object { return p.obj }
+
+// Resolve returns the object this forward reference bound to (nil means
+// the object isn't declared yet).
+func (p *TyLaterRef) Resolve() types.Object {
+	if o := p.obj.Pkg().Scope().Lookup(p.obj.Name()); o != p.obj {
+		return o
+	}
+	return nil
+}
+
+func (p *TyLaterRef) use(src ast.Node) *internal.Elem {
+	id := ident(p.obj.Name())
+	p.uses = append(p.uses, laterUse{id: id, src: src})
+	return &internal.Elem{Val: id, Type: p, Src: src}
+}
+
+// call calls this forward reference with args. Its results are unknown until
+// the object is declared, so it can only be called in a statement context,
+// ie. fn and args are all of the current statement on the stack.
+func (p *TyLaterRef) call(
+	pkg *Package, fn *internal.Elem, args []*internal.Elem, flags InstrFlags) (*internal.Elem, error) {
+	cb := &pkg.cb
+	if _, ok := cb.current.codeBlock.(*ValueDecl); ok || cb.stk.Len() != cb.current.base+len(args)+1 {
+		src, pos, end := cb.loadExpr(fn.Src)
+		return nil, cb.newCodeErrorf(
+			pos, end, "%s can only be called as a statement: %s isn't declared yet", src, p.obj.Name())
+	}
+	valArgs := make([]ast.Expr, len(args))
+	for i, arg := range args {
+		valArgs[i] = arg.Val
+	}
+	expr := &ast.CallExpr{Fun: fn.Val, Args: valArgs, Ellipsis: token.Pos(flags & InstrFlagEllipsis)}
+	p.calls = append(p.calls, &laterCall{
+		expr: expr, fn: fn, args: append([]*internal.Elem(nil), args...), flags: flags})
+	return &internal.Elem{Type: types.NewTuple(), Val: expr}, nil
+}
+
+var (
+	_ TyTypeEx = (*TyLaterRef)(nil)
+)
+
+// RefLater returns the object with the given name in this package. If the
+// object isn't declared yet, it returns a placeholder which is bound to the
+// real object once it is declared. Until then, the placeholder can only be
+// called in a statement context (calling it as a value, eg. `x := foo()`, is
+// an error since its results are unknown), and its call arguments are checked
+// by ResolveLaterRefs.
+func (p *Package) RefLater(name string) Ref {
+	if o := p.Types.Scope().Lookup(name); o != nil {
+		return o
+	}
+	if t, ok := p.laterRefs[name]; ok {
+		return t.obj
+	}
+	if p.laterRefs == nil {
+		p.laterRefs = make(map[string]*TyLaterRef)
+	}
+	t := new(TyLaterRef)
+	t.obj = types.NewVar(token.NoPos, p.Types, name, t)
+	p.laterRefs[name] = t
+	return t.obj
+}
+
+// ResolveLaterRefs checks if all forward references created by RefLater are
+// declared, and if calls to them are valid. Uses in code which is discarded
+// (not in any file) are ignored. It is called by WriteTo/WriteFile.
+func (p *Package) ResolveLaterRefs() error {
+	if len(p.laterRefs) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(p.laterRefs))
+	for name := range p.laterRefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	emitted := p.laterRefsEmitted()
+	var errs LaterRefError
+	for _, name := range names {
+		t := p.laterRefs[name]
+		o := t.Resolve()
+		if o == nil {
+			for _, use := range t.uses {
+				if emitted[use.id] {
+					src := use.src
+					errs = append(errs, p.cb.newCodeErrorf(getSrcPos(src), getSrcEnd(src), "undefined: %s", name))
+				}
+			}
+			continue
+		}
+		for _, c := range t.calls {
+			if !emitted[c.expr] {
+				continue
+			}
+			fn := toObject(p, o, c.fn.Src)
+			backup := backupArgs(c.args)
+			if _, err := matchFuncCall(p, fn, c.args, c.flags); err != nil {
+				errs = append(errs, err)
+			}
+			restoreArgs(c.args, backup)
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// laterRefsEmitted returns the uses and calls of forward references which
+// are in the code of some file.
+func (p *Package) laterRefsEmitted() map[ast.Node]bool {
+	ret := make(map[ast.Node]bool)
+	for _, t := range p.laterRefs {
+		for _, use := range t.uses {
+			ret[use.id] = false
+		}
+		for _, c := range t.calls {
+			ret[c.expr] = false
+		}
+	}
+	for _, f := range p.files {
+		for _, decl := range f.decls {
+			ast.Inspect(decl, func(n ast.Node) bool {
+				if _, ok := ret[n]; ok {
+					ret[n] = true
+				}
+				return true
+			})
+		}
+	}
+	return ret
+}
+
+// LaterRefError represents errors of unresolved forward references.
+type LaterRefError []error
+
+func (p LaterRefError) Error() string {
+	msgs := make([]string, len(p))
+	for i, err := range p {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ----------------------------------------------------------------------------
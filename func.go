@@ -14,13 +14,24 @@
 package gogen
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
+	"go/parser"
 	"go/token"
 	"go/types"
+	"io"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/goplus/gogen/internal"
+	"github.com/goplus/gogen/internal/go/format"
+	"github.com/goplus/gogen/internal/go/printer"
+	"github.com/goplus/gogen/internal/typesalias"
 )
 
 // ----------------------------------------------------------------------------
@@ -60,9 +71,45 @@ func NewTuple(x ...*Param) *Tuple {
 // Func type
 type Func struct {
 	*types.Func
-	decl   *ast.FuncDecl
-	old    funcBodyCtx
-	arity1 int // 0 for normal, (arity+1) for inlineClosure
+	decl    *ast.FuncDecl
+	old     funcBodyCtx
+	stream  *funcStream // not nil for an append-only func
+	snippet *Snippet    // not nil for the body of a snippet
+	sess    *Session    // not nil if the body is attached to a session
+	dirs    []*ast.Comment
+	stats   FuncStats
+	stats0  FuncStats // counters of CodeBuilder when the body starts
+	depth0  int       // block nesting depth where the body starts
+	arity1  int       // 0 for normal, (arity+1) for inlineClosure
+	compact bool      // print a trivial body on one line, see SetCompact
+}
+
+// FuncStats represents statistics of a function body collected while building
+// it, which can be used to limit size of generated functions. They are
+// approximate: Stmts counts statements emitted by CodeBuilder, Exprs counts
+// expressions it builds (including ones which are discarded or folded later,
+// eg. constant operands), and MaxDepth is the max nesting depth of blocks,
+// including implicit blocks of if, for, switch and select statements and their
+// clauses (the function body itself is at depth 1). Statements and expressions of
+// closures are included in their enclosing function.
+type FuncStats struct {
+	Stmts    int
+	Exprs    int
+	MaxDepth int
+}
+
+func (p *FuncStats) add(s FuncStats) {
+	p.Stmts += s.Stmts
+	p.Exprs += s.Exprs
+	if p.MaxDepth < s.MaxDepth {
+		p.MaxDepth = s.MaxDepth
+	}
+}
+
+// Stats returns statistics of the function body, which are available after
+// the body ends.
+func (p *Func) Stats() FuncStats {
+	return p.stats
 }
 
 // Obj returns this function object.
@@ -77,11 +124,49 @@ func (p *Func) Comments() *ast.CommentGroup {
 
 // SetComments sets associated documentation.
 func (p *Func) SetComments(pkg *Package, doc *ast.CommentGroup) *Func {
-	p.decl.Doc = doc
+	p.decl.Doc = p.withDirectives(doc)
 	pkg.setDoc(p.Func, doc)
 	return p
 }
 
+// AddDirective adds a compiler directive (eg. `go:noinline` or `//go:nosplit`)
+// to this function. Directives are emitted in order after the documentation,
+// on the lines directly preceding the func keyword.
+func (p *Func) AddDirective(directive string) *Func {
+	if p.decl == nil {
+		panic("AddDirective: can't be used for a closure")
+	}
+	if !strings.HasPrefix(directive, "//") {
+		directive = "//" + directive
+	}
+	var doc *ast.CommentGroup
+	if p.decl.Doc != nil {
+		doc = &ast.CommentGroup{List: p.decl.Doc.List[:len(p.decl.Doc.List)-len(p.dirs)]}
+	}
+	p.dirs = append(p.dirs, &ast.Comment{Text: directive})
+	p.decl.Doc = p.withDirectives(doc)
+	return p
+}
+
+func (p *Func) withDirectives(doc *ast.CommentGroup) *ast.CommentGroup {
+	if len(p.dirs) == 0 {
+		return doc
+	}
+	if doc == nil || len(doc.List) == 0 { // separate directives from the previous decl
+		first := &ast.Comment{Text: "\n" + p.dirs[0].Text}
+		return &ast.CommentGroup{List: append([]*ast.Comment{first}, p.dirs[1:]...)}
+	}
+	list := append(make([]*ast.Comment, 0, len(doc.List)+len(p.dirs)), doc.List...)
+	return &ast.CommentGroup{List: append(list, p.dirs...)}
+}
+
+// Recv returns the receiver of this method (nil if it is a function). A named
+// receiver is visible in the method body, so it can be referenced by VarRef
+// or Val.
+func (p *Func) Recv() *types.Var {
+	return p.Type().(*types.Signature).Recv()
+}
+
 // Ancestor returns ancestor of a closure function.
 // It returns itself if the specified func is a normal function.
 func (p *Func) Ancestor() *Func {
@@ -109,26 +194,251 @@ func (p *Func) BodyStart(pkg *Package, src ...ast.Node) *CodeBuilder {
 	return pkg.cb.startFuncBody(p, src, &p.old)
 }
 
+// BodyStartWith starts the function body like BodyStart, and attaches the
+// session sess to it: variables of the session are visible at top level of
+// the function body as if they were defined in the same block. Each variable
+// of the session referenced by the body is declared at its beginning (eg.
+// `var x int`), so the generated function is self-contained. Values of
+// session variables aren't carried from one function to another.
+func (p *Func) BodyStartWith(pkg *Package, sess *Session, src ...ast.Node) *CodeBuilder {
+	cb := p.BodyStart(pkg, src...)
+	sess.attach(cb.current.scope)
+	p.sess = sess
+	return cb
+}
+
 // End is for internal use.
 func (p *Func) End(cb *CodeBuilder, src ast.Node) {
 	if p.isInline() {
 		p.inlineClosureEnd(cb)
 		return
 	}
+	if p.snippet != nil {
+		p.snippet.stmts = cb.endFuncBody(p.old)
+		return
+	}
+	if p.stream != nil {
+		for _, stmt := range cb.endFuncBody(p.old) {
+			p.stream.emit(stmt)
+		}
+		p.stream.write("}\n")
+		return
+	}
 	pkg := cb.pkg
 	body := &ast.BlockStmt{List: cb.endFuncBody(p.old)}
+	if p.sess != nil {
+		body.List = append(p.sess.decls(pkg), body.List...)
+	}
 	t, _ := toNormalizeSignature(nil, p.Type().(*types.Signature))
+	ft := toFuncType(pkg, t)
+	if p.compact && isCompactBody(body) {
+		if pkg.compactBodies == nil {
+			pkg.compactBodies = make(map[*ast.BlockStmt]bool)
+		}
+		pkg.compactBodies[body] = true
+	}
 	if fn := p.decl; fn == nil { // is closure
-		expr := &ast.FuncLit{Type: toFuncType(pkg, t), Body: body}
+		expr := &ast.FuncLit{Type: ft, Body: body}
 		cb.stk.Push(&internal.Elem{Val: expr, Type: t, Src: src})
 	} else {
-		fn.Name, fn.Type, fn.Body = ident(p.Name()), toFuncType(pkg, t), body
+		fn.Name, fn.Type, fn.Body = ident(p.Name()), ft, body
 		if recv := t.Recv(); IsMethodRecv(recv) {
 			fn.Recv = toRecv(pkg, recv)
 		}
 	}
 }
 
+// SetCompact sets whether a trivial body of this function, ie. a single
+// return or assignment statement, is printed on the same line as the function
+// header, eg. `func (x *T) Name() string { return x.name }`. Like gofmt, the
+// printer still breaks the body into lines if the function is too long to fit
+// on one line. It must be called before End.
+func (p *Func) SetCompact(compact bool) *Func {
+	p.compact = compact
+	return p
+}
+
+func isCompactBody(body *ast.BlockStmt) bool {
+	if len(body.List) != 1 {
+		return false
+	}
+	switch body.List[0].(type) {
+	case *ast.ReturnStmt, *ast.AssignStmt:
+		return true
+	}
+	return false
+}
+
+// SetBody installs stmts, eg. statements produced outside gogen, as the body
+// of this function directly instead of building it between BodyStart and
+// End. Like End, it pushes a closure onto the stack. Identifiers in stmts
+// aren't resolved, except that a selector `x.Sel` whose x is the name of a
+// package imported by Package.Import (and not shared by other imported
+// packages) is bound to that package, so the import is added to the file.
+// Other packages referred by stmts should be marked used explicitly (see
+// PkgRef.MarkForceUsed). If validate is true, SetBody checks that stmts are
+// well-formed (ie. they can be printed and parsed back as Go source) first,
+// and returns an error if not.
+func (p *Func) SetBody(pkg *Package, stmts []ast.Stmt, validate bool) error {
+	if p.isInline() {
+		panic("SetBody: can't be used for an inline closure")
+	}
+	if validate {
+		if err := checkStmts(stmts); err != nil {
+			return err
+		}
+	}
+	pkg.bindImports(stmts)
+	cb := p.BodyStart(pkg)
+	cb.current.stmts = append(cb.current.stmts, stmts...)
+	cb.nstmts += len(stmts)
+	cb.End()
+	return nil
+}
+
+// bindImports binds selectors `x.Sel` in stmts whose x names an imported
+// package to the import of current file.
+func (p *Package) bindImports(stmts []ast.Stmt) {
+	paths := make(map[string]string, len(p.imported)) // name => pkgPath ("" means ambiguous)
+	for pkgPath, pkg := range p.imported {
+		name := pkg.Name()
+		if _, ok := paths[name]; ok {
+			pkgPath = ""
+		}
+		paths[name] = pkgPath
+	}
+	for _, stmt := range stmts {
+		ast.Inspect(stmt, func(node ast.Node) bool {
+			if sel, ok := node.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok && x.Obj == nil {
+					if pkgPath := paths[x.Name]; pkgPath != "" {
+						sel.X = p.file.newImport(x.Name, pkgPath)
+					}
+					return false
+				}
+			}
+			return true
+		})
+	}
+}
+
+func checkStmts(stmts []ast.Stmt) (err error) {
+	for i, stmt := range stmts {
+		if stmt == nil {
+			return fmt.Errorf("SetBody: statement #%d is nil", i)
+		}
+	}
+	defer func() {
+		if e := recover(); e != nil { // the printer panics on some malformed nodes
+			err = fmt.Errorf("SetBody: malformed statements: %v", e)
+		}
+	}()
+	var b bytes.Buffer
+	b.WriteString("package p\n\nfunc _() {\n")
+	if err = format.Node(&b, token.NewFileSet(), stmts); err != nil {
+		return fmt.Errorf("SetBody: malformed statements: %v", err)
+	}
+	b.WriteString("\n}\n")
+	if _, err = parser.ParseFile(token.NewFileSet(), "", b.Bytes(), 0); err != nil {
+		return fmt.Errorf("SetBody: malformed statements: %v", err)
+	}
+	return nil
+}
+
+// AppendOnly makes this function append-only, to reduce memory usage when
+// generating a huge function body: the function is written to w instead of
+// the package file, and each top-level statement of its body is written to w
+// and released once the next statement is emitted. So a statement can't be
+// changed after its next statement is emitted. Imports used by the function are added to the current file, and
+// the caller should append content of w to that file after it is written.
+// AppendOnly must be called before BodyStart. It panics if writing fails.
+func (p *Func) AppendOnly(pkg *Package, w io.Writer) *Func {
+	if p.decl == nil {
+		panic("AppendOnly: can't be used for a closure")
+	}
+	f := pkg.file
+	for i, decl := range f.decls {
+		if decl == p.decl {
+			f.decls = append(f.decls[:i], f.decls[i+1:]...)
+			break
+		}
+	}
+	sig := p.Type().(*types.Signature)
+	decl := &ast.FuncDecl{Doc: p.decl.Doc, Name: ident(p.Name()), Type: toFuncType(pkg, sig)}
+	if recv := sig.Recv(); IsMethodRecv(recv) {
+		decl.Recv = toRecv(pkg, recv)
+	}
+	p.stream = &funcStream{pkg: pkg, file: f, w: w}
+	p.stream.print(decl)
+	p.stream.write(" {\n")
+	return p
+}
+
+// IsAppendOnly reports whether this function is append-only (see AppendOnly).
+func (p *Func) IsAppendOnly() bool {
+	return p.stream != nil
+}
+
+type funcStream struct {
+	pkg   *Package
+	file  *File
+	w     io.Writer
+	scope *types.Scope // scope of the function body
+	open  int          // number of statements not committed (see startStmtAt)
+}
+
+var streamConf = printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+
+func (p *funcStream) print(node ast.Node) {
+	ast.Walk(astVisitor{p.pkg, p.file}, node) // mark imports used
+	conf := streamConf
+	if _, ok := node.(ast.Stmt); ok {
+		conf.Indent = 1
+	}
+	cnode := &printer.CommentedNodes{
+		Node: node, CommentedStmts: p.pkg.commentedStmts, CompactBodies: p.pkg.compactBodies,
+	}
+	if err := conf.Fprint(p.w, token.NewFileSet(), cnode); err != nil {
+		panic(err)
+	}
+}
+
+func (p *funcStream) write(s string) {
+	if _, err := io.WriteString(p.w, s); err != nil {
+		panic(err)
+	}
+}
+
+// flush writes all statements except the last one, and returns the rest.
+func (p *funcStream) flush(stmts []ast.Stmt) []ast.Stmt {
+	n := len(stmts) - 1
+	if n <= 0 {
+		return stmts
+	}
+	for i, stmt := range stmts[:n] {
+		p.emit(stmt)
+		stmts[i] = nil
+	}
+	stmts[0], stmts[n] = stmts[n], nil
+	return stmts[:1]
+}
+
+func (p *funcStream) emit(stmt ast.Stmt) {
+	if doc, ok := p.pkg.commentedStmts[stmt]; ok {
+		for _, c := range doc.List {
+			text := c.Text
+			for strings.HasPrefix(text, "\n") { // blank lines before the comment
+				p.write("\n")
+				text = text[1:]
+			}
+			p.write("\t" + text + "\n")
+		}
+		delete(p.pkg.commentedStmts, stmt) // release it
+	}
+	p.print(stmt)
+	p.write("\n")
+}
+
 // NewFuncDecl creates a new function without function body (declaration only).
 func (p *Package) NewFuncDecl(pos token.Pos, name string, sig *types.Signature) *Func {
 	f, err := p.NewFuncWith(pos, name, sig, nil)
@@ -150,6 +460,145 @@ func (p *Package) NewFunc(recv *Param, name string, params, results *Tuple, vari
 	return f
 }
 
+// NewEqualMethod creates the method `func (a T) Equal(b T) bool` of the struct
+// type T, which compares fields of a and b one by one. Fields that are not
+// comparable (eg. slices and maps) are compared by reflect.DeepEqual.
+func (p *Package) NewEqualMethod(typ *types.Named) *Func {
+	t, ok := typ.Underlying().(*types.Struct)
+	if !ok {
+		panicf("NewEqualMethod: %v is not a struct type\n", typ)
+	}
+	a := p.NewParam(token.NoPos, "a", typ)
+	b := p.NewParam(token.NoPos, "b", typ)
+	ret := p.NewParam(token.NoPos, "", types.Typ[types.Bool])
+	fn := p.NewFunc(a, "Equal", NewTuple(b), NewTuple(ret), false)
+	cb := fn.BodyStart(p)
+	var deepEqual Ref
+	for i, n := 0, t.NumFields(); i < n; i++ {
+		name := t.Field(i).Name()
+		if name == "_" {
+			continue
+		}
+		cb.If()
+		if types.Comparable(t.Field(i).Type()) {
+			cb.Val(a).MemberVal(name).Val(b).MemberVal(name).BinaryOp(token.NEQ)
+		} else {
+			if deepEqual == nil {
+				deepEqual = p.Import("reflect").Ref("DeepEqual")
+			}
+			cb.Val(deepEqual).Val(a).MemberVal(name).Val(b).MemberVal(name).Call(2).UnaryOp(token.NOT)
+		}
+		cb.Then().Val(false).Return(1).End()
+	}
+	cb.Val(true).Return(1).End()
+	return fn
+}
+
+// ImplementStub creates a skeleton implementation of the interface iface on
+// the named type recv: a method, with a TODO comment and a body which panics
+// "not implemented", for each method of iface (including methods of embedded
+// interfaces) which isn't declared on recv yet. The receiver is a pointer if
+// ptrRecv is true. Unnamed (or blank) parameters are named arg0, arg1, etc.
+// It returns the created methods in the order of iface methods.
+func (p *Package) ImplementStub(recv *types.Named, iface *types.Interface, ptrRecv bool) []*Func {
+	declared := make(map[string]bool)
+	for i, n := 0, recv.NumMethods(); i < n; i++ {
+		declared[recv.Method(i).Name()] = true
+	}
+	var recvType types.Type = recv
+	if ptrRecv {
+		recvType = types.NewPointer(recv)
+	}
+	var fns []*Func
+	iface = iface.Complete()
+	for i, n := 0, iface.NumMethods(); i < n; i++ {
+		m := iface.Method(i)
+		if declared[m.Name()] {
+			continue
+		}
+		sig := m.Type().(*types.Signature)
+		used := make(map[string]bool)
+		for _, vars := range []*types.Tuple{sig.Params(), sig.Results()} {
+			for j, nv := 0, vars.Len(); j < nv; j++ {
+				used[vars.At(j).Name()] = true
+			}
+		}
+		c, _ := utf8.DecodeRuneInString(recv.Obj().Name())
+		recvName := stubName(string(unicode.ToLower(c)), used)
+		params := make([]*Param, sig.Params().Len())
+		for j := range params {
+			v := sig.Params().At(j)
+			name := v.Name()
+			if name == "" || name == "_" {
+				k := j
+				for used["arg"+strconv.Itoa(k)] {
+					k++
+				}
+				name = "arg" + strconv.Itoa(k)
+				used[name] = true
+			}
+			params[j] = p.NewParam(v.Pos(), name, v.Type())
+		}
+		results := make([]*Param, sig.Results().Len())
+		for j := range results {
+			v := sig.Results().At(j)
+			results[j] = p.NewParam(v.Pos(), v.Name(), v.Type())
+		}
+		fn := p.NewFunc(
+			p.NewParam(token.NoPos, recvName, recvType), m.Name(), NewTuple(params...), NewTuple(results...), sig.Variadic())
+		fn.SetComments(p, &ast.CommentGroup{List: []*ast.Comment{{Text: "\n// TODO: implement " + m.Name() + "."}}})
+		fn.BodyStart(p).Unreachable("not implemented").End()
+		fns = append(fns, fn)
+	}
+	return fns
+}
+
+// stubName returns name (or name followed by the first number that makes it
+// unused, eg. b1), and marks it used.
+func stubName(name string, used map[string]bool) string {
+	ret := name
+	for i := 1; used[ret]; i++ {
+		ret = name + strconv.Itoa(i)
+	}
+	used[ret] = true
+	return ret
+}
+
+// NewLazyVar declares the package variable name of type typ, which is
+// initialized on first use in a thread-safe way, and its accessor getter:
+//
+//	var (
+//		name     typ
+//		nameOnce sync.Once
+//	)
+//
+//	func getter() typ {
+//		nameOnce.Do(func() {
+//			name = <init>
+//		})
+//		return name
+//	}
+//
+// init is called to push the initial value of name onto the code builder.
+// It returns the accessor function.
+func (p *Package) NewLazyVar(pos token.Pos, typ types.Type, name, getter string, init func(cb *CodeBuilder)) *Func {
+	scope := p.Types.Scope()
+	defs := p.NewVarDefs(scope)
+	defs.New(pos, typ, name)
+	defs.New(pos, p.Import("sync").Ref("Once").Type(), name+"Once")
+	v, once := scope.Lookup(name), scope.Lookup(name+"Once")
+
+	ret := p.NewParam(token.NoPos, "", typ)
+	fn := p.NewFunc(nil, getter, nil, NewTuple(ret), false)
+	cb := fn.BodyStart(p).Val(once).MemberVal("Do")
+	cb.NewClosure(nil, nil, false).BodyStart(p).VarRef(v)
+	init(cb)
+	cb.Assign(1).End().Call(1).EndStmt().
+		Val(v).Return(1).
+		End()
+	return fn
+}
+
 func getRecv(recvTypePos func() token.Pos) token.Pos {
 	if recvTypePos != nil {
 		return recvTypePos()
@@ -167,17 +616,21 @@ func (p *Package) NewFuncWith(
 	if name == "" {
 		panic("no func name")
 	}
+	name, err := p.checkIdent(pos, pos, name, "NewFunc")
+	if err != nil {
+		return nil, err
+	}
 	cb := p.cb
 	fn := &Func{Func: types.NewFunc(pos, p.Types, name, sig)}
 	if recv := sig.Recv(); IsMethodRecv(recv) { // add method to this type
 		var t *types.Named
 		var ok bool
-		var typ = recv.Type()
+		var typ = typesalias.Unalias(recv.Type())
 		switch tt := typ.(type) {
 		case *types.Named:
 			t, ok = tt, true
 		case *types.Pointer:
-			typ = tt.Elem()
+			typ = typesalias.Unalias(tt.Elem())
 			t, ok = typ.(*types.Named)
 		}
 		if !ok {
@@ -192,6 +645,10 @@ func (p *Package) NewFuncWith(
 			return nil, cb.newCodeErrorf(
 				getRecv(recvTypePos), getRecv(recvTypePos), "invalid receiver type %v (%v is a pointer type)", typ, typ)
 		}
+		if t.Obj().Pkg() != p.Types {
+			return nil, cb.newCodeErrorf(
+				getRecv(recvTypePos), getRecv(recvTypePos), "cannot define new methods on non-local type %v", typ)
+		}
 		if name != "_" { // skip underscore
 			t.AddMethod(fn.Func)
 		}
@@ -234,6 +691,107 @@ func (p *Package) newInlineClosure(sig *types.Signature, arity int) *Func {
 	return &Func{Func: fn, arity1: arity + 1}
 }
 
+// ----------------------------------------------------------------------------
+
+// A Snippet is a detached statement list built once against its free
+// variables, which can be instantiated into many function bodies (see
+// CodeBuilder.Snippet).
+type Snippet struct {
+	fn    *Func
+	file  *File
+	scope *types.Scope // scope of the snippet body
+	vars  []*types.Var
+	refs  map[*ast.Ident]int // references to vars (index of vars)
+	stmts []ast.Stmt
+}
+
+// NewSnippet creates a snippet whose free variables are vars. Its statements
+// are built between BodyStart and CodeBuilder.End, where vars are in scope.
+func (p *Package) NewSnippet(vars ...*types.Var) *Snippet {
+	sig := types.NewSignatureType(nil, nil, nil, types.NewTuple(vars...), nil, false)
+	ret := &Snippet{vars: vars, refs: make(map[*ast.Ident]int)}
+	ret.fn = p.newClosure(sig)
+	ret.fn.snippet = ret
+	return ret
+}
+
+// BodyStart starts building statements of this snippet.
+func (p *Snippet) BodyStart(pkg *Package, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("NewSnippet", p.fn.Type())
+	}
+	p.file = pkg.file
+	cb := pkg.cb.startFuncBody(p.fn, src, &p.fn.old)
+	p.scope = cb.current.scope
+	return cb
+}
+
+// Vars returns free variables of this snippet.
+func (p *Snippet) Vars() []*types.Var {
+	return p.vars
+}
+
+// locals returns objects declared by this snippet: those of its body block
+// (top), and those of nested blocks (inner).
+func (p *Snippet) locals() (top, inner []types.Object) {
+	isVar := func(o types.Object) bool {
+		for _, v := range p.vars {
+			if v == o {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range p.scope.Names() {
+		if o := p.scope.Lookup(name); !isVar(o) {
+			top = append(top, o)
+		}
+	}
+	var walk func(scope *types.Scope)
+	walk = func(scope *types.Scope) {
+		for i, n := 0, scope.NumChildren(); i < n; i++ {
+			child := scope.Child(i)
+			for _, name := range child.Names() {
+				inner = append(inner, child.Lookup(name))
+			}
+			walk(child)
+		}
+	}
+	walk(p.scope)
+	return
+}
+
+// SnippetError represents errors of instantiating a snippet (see
+// CodeBuilder.Snippet).
+type SnippetError []error
+
+func (p SnippetError) Error() string {
+	msgs := make([]string, len(p))
+	for i, err := range p {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// snippetRef records x if it is a reference to a free variable of the
+// snippet being built.
+func (p *CodeBuilder) snippetRef(v types.Object, x ast.Expr) {
+	id, ok := x.(*ast.Ident)
+	if !ok {
+		return
+	}
+	for fn := p.current.fn; fn != nil; fn = fn.old.fn {
+		if s := fn.snippet; s != nil {
+			for i, sv := range s.vars {
+				if sv == v {
+					s.refs[id] = i
+					return
+				}
+			}
+		}
+	}
+}
+
 func (p *Func) isInline() bool {
 	return p.arity1 != 0
 }
@@ -263,3 +821,99 @@ type Instruction interface {
 }
 
 // ----------------------------------------------------------------------------
+
+// Session represents a persistent set of variables shared by a sequence of
+// function bodies, eg. statements of a REPL.
+type Session struct {
+	vars  map[string]*types.Var
+	scope *types.Scope // scope of the function body attached
+	olds  map[types.Object]none
+	refs  map[*types.Var]none // session variables referenced by the body
+}
+
+// NewSession creates a new session.
+func NewSession() *Session {
+	return &Session{vars: make(map[string]*types.Var)}
+}
+
+// Lookup returns the variable with the given name in this session.
+func (p *Session) Lookup(name string) *types.Var {
+	return p.vars[name]
+}
+
+// Names returns the variable names in this session in sorted order.
+func (p *Session) Names() []string {
+	names := make([]string, 0, len(p.vars))
+	for name := range p.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Session) attach(scope *types.Scope) {
+	olds := make(map[types.Object]none)
+	for _, name := range scope.Names() { // params & results
+		olds[scope.Lookup(name)] = none{}
+	}
+	for _, v := range p.vars {
+		if scope.Insert(v) == nil {
+			olds[v] = none{}
+		}
+	}
+	p.scope, p.olds, p.refs = scope, olds, make(map[*types.Var]none)
+}
+
+// sessionRef records v if it is a variable of the session attached to the
+// function being built.
+func (p *CodeBuilder) sessionRef(v *types.Var) {
+	for fn := p.current.fn; fn != nil; fn = fn.old.fn {
+		if s := fn.sess; s != nil {
+			if s.vars[v.Name()] == v {
+				s.refs[v] = none{}
+			}
+			return
+		}
+	}
+}
+
+// decls returns declarations of session variables referenced by the body
+// attached, in sorted order.
+func (p *Session) decls(pkg *Package) []ast.Stmt {
+	vars := make([]*types.Var, 0, len(p.refs))
+	for v := range p.refs {
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name() < vars[j].Name() })
+	stmts := make([]ast.Stmt, len(vars))
+	for i, v := range vars {
+		stmts[i] = &ast.DeclStmt{Decl: &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{
+			&ast.ValueSpec{Names: []*ast.Ident{ident(v.Name())}, Type: toType(pkg, v.Type())},
+		}}}
+	}
+	return stmts
+}
+
+// Commit returns variables newly defined at top level of the function body
+// attached (in sorted order), and carries them forward to this session.
+// It should be called after the function body ends.
+func (p *Session) Commit() (vars []*types.Var) {
+	scope := p.scope
+	if scope == nil {
+		return nil
+	}
+	for _, name := range scope.Names() {
+		o := scope.Lookup(name)
+		if _, ok := p.olds[o]; ok {
+			continue
+		}
+		if v, ok := o.(*types.Var); ok {
+			p.vars[name] = v
+			vars = append(vars, v)
+		}
+	}
+	p.scope, p.olds, p.refs = nil, nil, nil
+	return
+}
+
+// ----------------------------------------------------------------------------
@@ -134,18 +134,27 @@ func newFuncEx(pos token.Pos, pkg *types.Package, recv *types.Var, name string,
 }
 
 func newMethodEx(typ *types.Named, pos token.Pos, pkg *types.Package, name string, t TyFuncEx) *types.Func {
+	fns := methodsEx(typ, pos, pkg, name, t)
+	for _, fn := range fns {
+		typ.AddMethod(fn)
+	}
+	return fns[0]
+}
+
+// methodsEx creates the method name of typ (and its alias if name is Gopx_xxx)
+// without adding them to typ.
+func methodsEx(typ *types.Named, pos token.Pos, pkg *types.Package, name string, t TyFuncEx) []*types.Func {
 	recv := types.NewVar(token.NoPos, pkg, "recv", typ)
 	ofn := newFuncEx(pos, pkg, recv, name, t)
-	typ.AddMethod(ofn)
 	if strings.HasPrefix(name, xgoxPrefix) {
 		aname := name[len(xgoxPrefix):]
 		ofnAlias := newFuncEx(pos, pkg, recv, aname, &TyTypeAsParams{ofn})
-		typ.AddMethod(ofnAlias)
 		if debugImport {
 			log.Println("==> AliasMethod", typ, name, "=>", aname)
 		}
+		return []*types.Func{ofn, ofnAlias}
 	}
-	return ofn
+	return []*types.Func{ofn}
 }
 
 // ----------------------------------------------------------------------------
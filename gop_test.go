@@ -14,11 +14,13 @@
 package gogen_test
 
 import (
+	"bytes"
 	"go/ast"
 	"go/constant"
 	"go/token"
 	"go/types"
 	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/goplus/gogen"
@@ -94,6 +96,129 @@ const Gopo_x = "Hello"
 `)
 }
 
+func TestMarkGopPackage(t *testing.T) {
+	pkg := newPackage("foo", false)
+	pkg.MarkGopPackage()
+	domTest(t, pkg, `package foo
+
+const GopPackage = true
+`)
+}
+
+func TestExportOverloadGroup(t *testing.T) {
+	pkg := newPackage("foo", false)
+	tyT := pkg.NewType("T").InitType(pkg, types.NewStruct(nil, nil))
+	recv := pkg.NewParam(token.NoPos, "t", types.NewPointer(tyT))
+	x := pkg.NewParam(token.NoPos, "x", types.Typ[types.Int])
+	s := pkg.NewParam(token.NoPos, "s", types.Typ[types.String])
+	addInt := pkg.NewFunc(nil, "AddInt", types.NewTuple(x), nil, false)
+	addStr := pkg.NewFunc(nil, "AddString", types.NewTuple(s), nil, false)
+	setInt := pkg.NewFunc(recv, "SetInt", types.NewTuple(x), nil, false)
+	setStr := pkg.NewFunc(recv, "Set_string", types.NewTuple(s), nil, false)
+	for _, fn := range []*gogen.Func{addInt, addStr, setInt, setStr} {
+		fn.BodyStart(pkg).End()
+	}
+	pkg.ExportOverloadGroup("Add", []types.Object{addInt.Obj(), addStr.Obj()})
+	pkg.ExportOverloadGroup("T.Set", []types.Object{setInt.Obj(), setStr.Obj()})
+	pkg.ExportOverloadGroup("T.Set_", []types.Object{setInt.Obj()})
+	domTest(t, pkg, `package foo
+
+const GopPackage = true
+
+type T struct {
+}
+
+func AddInt(x int) {
+}
+func AddString(s string) {
+}
+func (t *T) SetInt(x int) {
+}
+func (t *T) Set_string(s string) {
+}
+
+const Gopo_Add = "AddInt,AddString"
+const Gopo_T_Set = ".SetInt,.Set_string"
+const Gopo__T__Set_ = ".SetInt"
+`)
+	gogen.InitThisGopPkg(pkg.Types)
+	add := pkg.Types.Scope().Lookup("Add")
+	if add == nil {
+		t.Fatal("TestExportOverloadGroup: Add not found")
+	}
+	if fns, ok := gogen.CheckOverloadFunc(add.Type().(*types.Signature)); !ok || len(fns) != 2 {
+		t.Fatal("TestExportOverloadGroup: Add isn't an overload func -", add.Type())
+	}
+	for i, n := 0, tyT.NumMethods(); i < n; i++ {
+		if m := tyT.Method(i); m.Name() == "Set" {
+			if fns, ok := gogen.CheckOverloadMethod(m.Type().(*types.Signature)); ok && len(fns) == 2 {
+				return
+			}
+		}
+	}
+	t.Fatal("TestExportOverloadGroup: T.Set isn't an overload method")
+}
+
+func TestOverloadOrderStable(t *testing.T) {
+	gen := func(withMul bool) (string, []string) {
+		pkg := newPackage("foo", false)
+		tyT := pkg.NewType("T").InitType(pkg, types.NewStruct(nil, nil))
+		recv := pkg.NewParam(token.NoPos, "t", types.NewPointer(tyT))
+		x := pkg.NewParam(token.NoPos, "x", types.Typ[types.Int])
+		s := pkg.NewParam(token.NoPos, "s", types.Typ[types.String])
+		names := []string{"Add", "Sub"}
+		if withMul {
+			names = []string{"Add", "Mul", "Sub"}
+		}
+		for _, name := range names {
+			pkg.NewFunc(nil, name+"__0", types.NewTuple(x), nil, false).BodyStart(pkg).End()
+			pkg.NewFunc(nil, name+"__1", types.NewTuple(s), nil, false).BodyStart(pkg).End()
+		}
+		for _, name := range names {
+			mInt := pkg.NewFunc(recv, name+"Int", types.NewTuple(x), nil, false)
+			mStr := pkg.NewFunc(recv, name+"Str", types.NewTuple(s), nil, false)
+			mInt.BodyStart(pkg).End()
+			mStr.BodyStart(pkg).End()
+			pkg.ExportOverloadGroup("T."+name, []types.Object{mInt.Obj(), mStr.Obj()})
+		}
+		var b bytes.Buffer
+		if err := gogen.WriteTo(&b, pkg, ""); err != nil {
+			t.Fatal("gogen.WriteTo failed:", err)
+		}
+		gogen.InitThisGopPkg(pkg.Types)
+		var mthds []string
+		for i, n := 0, tyT.NumMethods(); i < n; i++ {
+			mthds = append(mthds, tyT.Method(i).Name())
+		}
+		return b.String(), mthds
+	}
+	old, oldMthds := gen(false)
+	ret, mthds := gen(true)
+	for _, mul := range []string{
+		"func Mul__0(x int) {\n}\n",
+		"func Mul__1(s string) {\n}\n",
+		"func (t *T) MulInt(x int) {\n}\nfunc (t *T) MulStr(s string) {\n}\n\n" +
+			"const Gopo_T_Mul = \".MulInt,.MulStr\"\n\n",
+	} {
+		if !strings.Contains(ret, mul) {
+			t.Fatalf("TestOverloadOrderStable: %q not found\n%s", mul, ret)
+		}
+		ret = strings.Replace(ret, mul, "", 1)
+	}
+	if ret != old {
+		t.Fatalf("TestOverloadOrderStable:\nResult:\n%s\nExpected:\n%s\n", ret, old)
+	}
+	var v []string
+	for _, m := range mthds {
+		if !strings.Contains(m, "Mul") {
+			v = append(v, m)
+		}
+	}
+	if strings.Join(v, " ") != strings.Join(oldMthds, " ") {
+		t.Fatalf("TestOverloadOrderStable: methods %v, expected %v\n", v, oldMthds)
+	}
+}
+
 func TestFmtPrintln(t *testing.T) {
 	pkg := newGopMainPackage()
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
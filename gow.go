@@ -14,6 +14,7 @@
 package gogen
 
 import (
+	"bytes"
 	"go/ast"
 	"go/token"
 	"go/types"
@@ -45,6 +46,9 @@ func (p *Package) ASTFile(fname ...string) *ast.File {
 	}
 	decls := f.getDecls(p)
 	file := &ast.File{Name: ident(p.Types.Name()), Decls: decls, Imports: getImports(decls)}
+	if doc, docFile := p.Doc(); doc != nil && f.fname == docFile {
+		file.Doc = doc
+	}
 	return file
 }
 
@@ -69,19 +73,31 @@ func (p *Package) CommentedASTFile(fname ...string) *printer.CommentedNodes {
 	if f == nil {
 		return nil
 	}
+	file, _ := p.File(fname...)
 	return &printer.CommentedNodes{
-		Node:           f,
-		CommentedStmts: p.commentedStmts,
+		Node:              f,
+		CommentedStmts:    p.commentedStmts,
+		CompactBodies:     p.compactBodies,
+		FloatingComments:  file.floatingComments(f.Decls),
+		MaxStringLitWidth: p.conf.MaxStringLitWidth,
 	}
 }
 
 // WriteTo writes a file named fname to dst.
 // If fname is not provided, it writes the default (NOT current) file.
+// It fails without writing anything if the package doesn't pass the checks
+// of Finalize, except that only this file is checked for invalid expressions.
 func (p *Package) WriteTo(dst io.Writer, fname ...string) (err error) {
 	file := p.CommentedASTFile(fname...)
 	if file == nil {
 		return syscall.ENOENT
 	}
+	if err = p.checkPackage(); err != nil {
+		return
+	}
+	if err = p.checkInvalidExprs(file); err != nil {
+		return
+	}
 	fset := token.NewFileSet()
 	return format.Node(dst, fset, file)
 }
@@ -94,10 +110,12 @@ var (
 
 // WriteFile writes a file named fname.
 // If fname is not provided, it writes the default (NOT current) file.
+// The file is generated in memory before it is created, so it is never left
+// truncated if the generation fails.
 func (p *Package) WriteFile(file string, fname ...string) (err error) {
-	ast := p.CommentedASTFile(fname...)
-	if ast == nil {
-		return syscall.ENOENT
+	var buf bytes.Buffer
+	if err = p.WriteTo(&buf, fname...); err != nil {
+		return
 	}
 	if debugWriteFile {
 		log.Println("WriteFile", file)
@@ -106,18 +124,19 @@ func (p *Package) WriteFile(file string, fname ...string) (err error) {
 	if err != nil {
 		return
 	}
-	err = syscall.EFAULT
 	defer func() {
-		f.Close()
+		if e := f.Close(); err == nil {
+			err = e
+		}
 		if err != nil {
 			os.Remove(file)
 		}
 	}()
-	if GeneratedHeader != "" {
-		f.WriteString(GeneratedHeader)
+	if _, err = f.WriteString(GeneratedHeader); err != nil {
+		return
 	}
-	fset := token.NewFileSet()
-	return format.Node(f, fset, ast)
+	_, err = buf.WriteTo(f)
+	return
 }
 
 // ----------------------------------------------------------------------------
@@ -14,6 +14,7 @@
 package gogen
 
 import (
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/constant"
@@ -21,8 +22,10 @@ import (
 	"go/types"
 	"log"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/goplus/gogen/internal/typesalias"
 )
@@ -104,12 +107,57 @@ func InitThisGopPkg(pkg *types.Package) {
 
 // InitThisGopPkg initializes a Go+ package. pos map overload name to postion.
 func InitThisGopPkgEx(pkg *types.Package, pos map[string]token.Pos) {
+	var sym string
+	initThisGopPkg(pkg, pos, &sym)
+}
+
+// gopPkgObjs holds the objects synthesized for a Go+ package, which are
+// inserted into the package (or added to their named types) all at once by
+// commit, so that a failed initialization doesn't change the package.
+type gopPkgObjs struct {
+	scope   *types.Scope
+	objs    []types.Object
+	methods []namedMethod
+}
+
+type namedMethod struct {
+	typ *types.Named
+	fn  *types.Func
+}
+
+func (p *gopPkgObjs) insert(o types.Object) {
+	p.objs = append(p.objs, o)
+}
+
+func (p *gopPkgObjs) addMethods(typ *types.Named, fns []*types.Func) {
+	for _, fn := range fns {
+		p.methods = append(p.methods, namedMethod{typ, fn})
+	}
+}
+
+func (p *gopPkgObjs) commit() {
+	for _, o := range p.objs {
+		p.scope.Insert(o)
+	}
+	for _, m := range p.methods {
+		m.typ.AddMethod(m.fn)
+	}
+}
+
+// initThisGopPkg initializes a Go+ package, keeping the name of the symbol
+// being processed in *sym so that a panic can be attributed to it. The
+// package is changed only if it doesn't panic.
+func initThisGopPkg(pkg *types.Package, pos map[string]token.Pos, sym *string) {
 	scope := pkg.Scope()
+	objs := &gopPkgObjs{scope: scope}
 	gopos := make([]string, 0, 4)
 	overloads := make(map[omthd][]types.Object)
+	okeys := make([]omthd, 0, 4) // in name order, see scope.Names
 	onameds := make(map[string][]*types.Named)
+	nkeys := make([]string, 0, 4)
 	names := scope.Names()
 	for _, name := range names {
+		*sym = name
 		if isGopoConst(name) {
 			gopos = append(gopos, name)
 			continue
@@ -132,21 +180,31 @@ func InitThisGopPkgEx(pkg *types.Package, pos map[string]token.Pos) {
 				if isOverload(mName) { // overload method
 					mthd := mName[:len(mName)-3]
 					key := omthd{named, mthd}
+					if _, ok := overloads[key]; !ok {
+						okeys = append(okeys, key)
+					}
 					overloads[key] = append(overloads[key], m)
 				}
 			}
 			if isOverload(name) { // overload named
 				key := name[:len(name)-3]
+				if _, ok := onameds[key]; !ok {
+					nkeys = append(nkeys, key)
+				}
 				onameds[key] = append(onameds[key], named)
 			}
 		} else if isOverload(name) { // overload function
 			key := omthd{nil, name[:len(name)-3]}
+			if _, ok := overloads[key]; !ok {
+				okeys = append(okeys, key)
+			}
 			overloads[key] = append(overloads[key], o)
 		} else {
-			checkGoptsx(pkg, scope, name, o)
+			checkGoptsx(pkg, objs, name, o)
 		}
 	}
 	for _, gopoName := range gopos {
+		*sym = gopoName
 		if names, ok := checkOverloads(scope, gopoName); ok {
 			key := gopoName[len(xgooPrefix):]
 			m, tname := checkTypeMethod(scope, key)
@@ -163,25 +221,36 @@ func InitThisGopPkgEx(pkg *types.Package, pos map[string]token.Pos) {
 				}
 			}
 			if len(fns) > 0 {
-				newOverload(pkg, scope, m, fns, pos)
+				newOverload(pkg, objs, m, fns, pos)
 			}
 			delete(overloads, m)
 		}
 	}
-	for key, items := range overloads {
+	for _, key := range okeys {
+		items, ok := overloads[key]
+		if !ok { // deleted by Gopo_xxx
+			continue
+		}
+		*sym = key.name
+		if key.typ != nil {
+			*sym = key.typ.Obj().Name() + "." + key.name
+		}
 		off := len(key.name) + 2
 		fns := overloadFuncs(off, items)
-		newOverload(pkg, scope, key, fns, pos)
+		newOverload(pkg, objs, key, fns, pos)
 	}
-	for name, items := range onameds {
+	for _, name := range nkeys {
+		*sym = name
+		items := onameds[name]
 		off := len(name) + 2
 		nameds := overloadNameds(off, items)
 		if debugImport {
 			log.Println("==> NewOverloadNamed", name)
 		}
 		on := NewOverloadNamed(token.NoPos, pkg, name, nameds...)
-		scope.Insert(on)
+		objs.insert(on)
 	}
+	objs.commit()
 }
 
 // name
@@ -192,9 +261,13 @@ func lookupFunc(scope *types.Scope, name, tname string) types.Object {
 		tobj := scope.Lookup(tname)
 		if tobj != nil {
 			if tn, ok := tobj.(*types.TypeName); ok {
-				if o, ok := tn.Type().(*types.Named); ok { // TODO(xsw): interface support
-					for i, n := 0, o.NumMethods(); i < n; i++ {
-						method := o.Method(i)
+				if o, ok := tn.Type().(*types.Named); ok {
+					var list methodList = o
+					if t, ok := o.Underlying().(*types.Interface); ok {
+						list = t // interface methods are declared in underlying
+					}
+					for i, n := 0, list.NumMethods(); i < n; i++ {
+						method := list.Method(i)
 						if method.Name() == name {
 							return method
 						}
@@ -239,7 +312,7 @@ func checkTypeMethod(scope *types.Scope, name string) (omthd, string) {
 			}
 		}
 		if tobj != nil || nsep == 2 {
-			log.Panicf("checkTypeMethod: %v not found or not a named type\n", tname)
+			panicf("checkTypeMethod: %v not found or not a named type\n", tname)
 		}
 	}
 	return omthd{nil, name}, ""
@@ -250,7 +323,7 @@ func checkTypeMethod(scope *types.Scope, name string) (omthd, string) {
 // Gopt__TypeName__Method
 // Gops_TypeName_Method
 // Gops__TypeName__Method
-func checkGoptsx(pkg *types.Package, scope *types.Scope, name string, o types.Object) {
+func checkGoptsx(pkg *types.Package, objs *gopPkgObjs, name string, o types.Object) {
 	const n = len(commonPrefix)
 	const n2 = n + 2
 	if isGopCommon(name) {
@@ -262,18 +335,18 @@ func checkGoptsx(pkg *types.Package, scope *types.Scope, name string, o types.Ob
 					if debugImport {
 						log.Println("==> NewTemplateRecvMethod", tname, m.name)
 					}
-					NewTemplateRecvMethod(m.typ, token.NoPos, pkg, m.name, o)
+					objs.addMethods(m.typ, methodsEx(m.typ, token.NoPos, pkg, m.name, &TyTemplateRecvMethod{o}))
 				} else {
 					if debugImport {
 						log.Println("==> NewStaticMethod", tname, m.name)
 					}
-					NewStaticMethod(m.typ, token.NoPos, pkg, m.name, o)
+					objs.addMethods(m.typ, methodsEx(m.typ, token.NoPos, pkg, m.name, &TyStaticMethod{o}))
 				}
 			}
 		case xgoxCh: // Gopx_xxx
 			aname := name[n2:]
 			o := newFuncEx(token.NoPos, pkg, nil, aname, &TyTypeAsParams{o})
-			scope.Insert(o)
+			objs.insert(o)
 			if debugImport {
 				log.Println("==> AliasFunc", name, "=>", aname)
 			}
@@ -295,6 +368,7 @@ const (
 
 	xgoPackage = "GopPackage"
 	xgoPkgInit = "__xgo_inited"
+	xgoDepInit = "__xgo_deps_inited" // all Go+ dependencies are initialized too
 )
 
 /*
@@ -317,19 +391,20 @@ func checkOverloads(scope *types.Scope, gopoName string) (ret []string, exists b
 	return
 }
 
-func newOverload(pkg *types.Package, scope *types.Scope, m omthd, fns []types.Object, pos map[string]token.Pos) {
+func newOverload(pkg *types.Package, objs *gopPkgObjs, m omthd, fns []types.Object, pos map[string]token.Pos) {
 	if m.typ == nil {
 		if debugImport {
 			log.Println("==> NewOverloadFunc", m.name)
 		}
 		o := NewOverloadFunc(pos[m.name], pkg, m.name, fns...)
-		scope.Insert(o)
-		checkGoptsx(pkg, scope, m.name, o)
+		objs.insert(o)
+		checkGoptsx(pkg, objs, m.name, o)
 	} else {
 		if debugImport {
 			log.Println("==> NewOverloadMethod", m.typ.Obj().Name(), m.name)
 		}
-		NewOverloadMethod(m.typ, pos[m.typ.Obj().Name()+"."+m.name], pkg, m.name, fns...)
+		pos := pos[m.typ.Obj().Name()+"."+m.name]
+		objs.addMethods(m.typ, methodsEx(m.typ, pos, pkg, m.name, &TyOverloadMethod{fns}))
 	}
 }
 
@@ -338,10 +413,10 @@ func overloadFuncs(off int, items []types.Object) []types.Object {
 	for _, item := range items {
 		idx := toIndex(item.Name()[off])
 		if idx >= len(items) {
-			log.Panicf("overload func %v out of range 0..%v\n", item.Name(), len(fns)-1)
+			panicf("overload func %v out of range 0..%v\n", item.Name(), len(fns)-1)
 		}
 		if fns[idx] != nil {
-			log.Panicf("overload func %v exists?\n", item.Name())
+			panicf("overload func %v exists?\n", item.Name())
 		}
 		fns[idx] = item
 	}
@@ -354,10 +429,10 @@ func overloadNameds(off int, items []*types.Named) []*types.Named {
 		name := item.Obj().Name()
 		idx := toIndex(name[off])
 		if idx >= len(items) {
-			log.Panicf("overload type %v out of range 0..%v\n", name, len(nameds)-1)
+			panicf("overload type %v out of range 0..%v\n", name, len(nameds)-1)
 		}
 		if nameds[idx] != nil {
-			log.Panicf("overload type %v exists?\n", name)
+			panicf("overload type %v exists?\n", name)
 		}
 		nameds[idx] = item
 	}
@@ -403,6 +478,7 @@ func checkGopPkg(pkg *Package) (val ast.Expr, ok bool) {
 		}
 	}
 	if len(deps) > 0 {
+		sort.Strings(deps)
 		return stringLit(strings.Join(deps, ",")), true
 	}
 	if ok = pkg.isGopPkg; ok {
@@ -411,6 +487,72 @@ func checkGopPkg(pkg *Package) (val ast.Expr, ok bool) {
 	return
 }
 
+// MarkGopPackage marks the package being generated as a Go+ package, so that
+// `const GopPackage = true` is written to its output (if it is not a main
+// package) and downstream importers reconstruct its overloads. Usually it is
+// not needed, because declaring a Go+ func (eg. Foo__0) or a Gopo_ constant
+// marks the package implicitly.
+func (p *Package) MarkGopPackage() {
+	p.isGopPkg = true
+}
+
+// ExportOverloadGroup declares a Gopo_ constant (see initThisGopPkg) in the
+// package being generated, to group members as the overload function name,
+// or as the overload method name of a named type if name is in the form
+// TypeName.Method. Each member must be an exported function (or method of the
+// named type) declared in this package. It also marks the package as a Go+
+// package, see MarkGopPackage.
+func (p *Package) ExportOverloadGroup(name string, members []types.Object, src ...ast.Node) {
+	cb := &p.cb
+	srcExpr := getSrc(src)
+	pos, end := getSrcPos(srcExpr), getSrcEnd(srcExpr)
+	tname, fname := "", name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		tname, fname = name[:i], name[i+1:]
+		if _, ok := p.Types.Scope().Lookup(tname).(*types.TypeName); !ok {
+			cb.panicCodeErrorf(pos, end, "%s is not a type declared in this package", tname)
+		}
+	}
+	if !token.IsExported(fname) {
+		cb.panicCodeErrorf(pos, end, "overload %s is not exported", name)
+	}
+	if len(members) == 0 {
+		cb.panicCodeErrorf(pos, end, "overload %s has no members", name)
+	}
+	names := make([]string, len(members))
+	for i, m := range members {
+		fn, ok := m.(*types.Func)
+		if !ok {
+			cb.panicCodeErrorf(pos, end, "overload member %s is not a function", m.Name())
+		}
+		if fn.Pkg() != p.Types {
+			cb.panicCodeErrorf(pos, end, "overload member %s is not declared in this package", m.Name())
+		}
+		if !fn.Exported() {
+			cb.panicCodeErrorf(pos, end, "overload member %s is not exported", m.Name())
+		}
+		names[i] = fn.Name()
+		if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+			t, _ := indirect(recv.Type()).(*types.Named)
+			if t == nil || t.Obj().Name() != tname {
+				cb.panicCodeErrorf(pos, end, "overload member %s is not a method of %s", m.Name(), name)
+			}
+			names[i] = "." + names[i]
+		}
+	}
+	gopoName := xgooPrefix + fname
+	if tname != "" {
+		if strings.IndexByte(tname, '_') >= 0 || strings.IndexByte(fname, '_') >= 0 {
+			gopoName = xgooPrefix + "_" + tname + "__" + fname
+		} else {
+			gopoName = xgooPrefix + tname + "_" + fname
+		}
+	} else if strings.IndexByte(fname, '_') >= 0 {
+		gopoName = xgooPrefix + "_" + fname
+	}
+	p.NewConstStart(p.Types.Scope(), pos, nil, gopoName).Val(strings.Join(names, ",")).EndInit(1)
+}
+
 func (p expDeps) typ(typ types.Type) {
 retry:
 	switch t := typ.(type) {
@@ -441,7 +583,7 @@ retry:
 		goto retry
 	case *types.TypeParam, *types.Union, *typesalias.Alias:
 	default:
-		log.Panicf("expDeps: unknown type - %T\n", typ)
+		panicf("expDeps: unknown type - %T\n", typ)
 	}
 }
 
@@ -499,48 +641,156 @@ func (p expDeps) struc(t *types.Struct) {
 	}
 }
 
-// initGopPkg initializes a Go+ packages.
-func (p *Package) initGopPkg(importer types.Importer, pkgImp *types.Package) {
+// initGopPkg initializes a Go+ packages. chain is the list of packages which
+// dragged pkgImp in, it is used to annotate the error if initialization fails.
+func (p *Package) initGopPkg(importer types.Importer, pkgImp *types.Package, chain ...string) (err error) {
+	chain = append(chain[:len(chain):len(chain)], pkgImp.Path())
+	gopDeps, err := initGopPkgOnce(pkgImp, chain)
+	if err != nil {
+		return
+	}
+	for _, depPath := range gopDeps {
+		imp, e := importer.Import(depPath)
+		if e != nil {
+			return &InitGopPkgError{Chain: append(chain, depPath), Path: depPath, Err: e}
+		}
+		if err = p.initGopPkg(importer, imp, chain...); err != nil {
+			return
+		}
+	}
+	if gopDeps != nil {
+		markGopDepsInit(pkgImp)
+	}
+	return
+}
+
+// markGopDepsInit marks pkgImp, whose Go+ dependencies are all initialized, so
+// the walk of its dependencies is skipped next time.
+func markGopDepsInit(pkgImp *types.Package) {
+	gopPkgMutex.Lock()
+	defer gopPkgMutex.Unlock()
+
+	if scope := pkgImp.Scope(); scope.Lookup(xgoDepInit) == nil {
+		scope.Insert(types.NewConst(
+			token.NoPos, pkgImp, xgoDepInit, types.Typ[types.UntypedBool], constant.MakeBool(true),
+		))
+	}
+}
+
+// gopPkgMutex guards initialization of Go+ packages: an imported package may
+// be shared by Packages being built concurrently (eg. by one Importer).
+var gopPkgMutex sync.Mutex
+
+// initGopPkgOnce initializes pkgImp if it is a Go+ package which isn't
+// initialized yet, and returns its Go+ dependencies (even if it is initialized,
+// since initialization of a dependency may have failed), unless they are all
+// initialized (see markGopDepsInit). The objects synthesized
+// are computed first, and then inserted into the scope of pkgImp all at once
+// under gopPkgMutex, followed by the mark of initialized, so another build
+// never observes a half-initialized package, and skips the package
+// initialized. If the initialization fails, pkgImp isn't changed, so it fails
+// again (with the same error) when it is imported next time.
+func initGopPkgOnce(pkgImp *types.Package, chain []string) (gopDeps []string, err error) {
+	gopPkgMutex.Lock()
+	defer gopPkgMutex.Unlock()
+
 	scope := pkgImp.Scope()
 	objGopPkg := scope.Lookup(xgoPackage)
-	if objGopPkg == nil { // not is a Go+ package
+	if objGopPkg == nil || scope.Lookup(xgoDepInit) != nil { // not is a Go+ package, or done
 		return
 	}
-
-	if scope.Lookup(xgoPkgInit) != nil { // initialized
+	pkgDeps, ok := objGopPkg.(*types.Const)
+	if ok {
+		if v := pkgDeps.Val(); v.Kind() == constant.String && constant.StringVal(v) != "" {
+			gopDeps = strings.Split(constant.StringVal(v), ",")
+		}
+	}
+	if scope.Lookup(xgoPkgInit) != nil { // initialized, but its deps may not be
 		return
 	}
+	if ok {
+		if debugImport {
+			log.Println("==> Import", pkgImp.Path())
+		}
+		if err = initGopPkgSafe(pkgImp, chain); err != nil {
+			return nil, err
+		}
+	}
 	scope.Insert(types.NewConst(
 		token.NoPos, pkgImp, xgoPkgInit, types.Typ[types.UntypedBool], constant.MakeBool(true),
 	))
+	return
+}
 
-	pkgDeps, ok := objGopPkg.(*types.Const)
-	if !ok {
-		return
-	}
+func initGopPkgSafe(pkgImp *types.Package, chain []string) (err error) {
+	var sym string
+	defer func() {
+		if e := recover(); e != nil {
+			ret := &InitGopPkgError{Chain: chain, Path: pkgImp.Path(), Name: sym}
+			if v, ok := e.(error); ok {
+				ret.Err = v
+			} else {
+				ret.Err = errors.New(strings.TrimSuffix(fmt.Sprint(e), "\n"))
+			}
+			err = ret
+		}
+	}()
+	initThisGopPkg(pkgImp, nil, &sym)
+	return
+}
 
-	var gopDeps []string
-	if v := pkgDeps.Val(); v.Kind() == constant.String {
-		gopDeps = strings.Split(constant.StringVal(v), ",")
-	}
+// InitGopPkgError represents a failure to initialize the Go+ package Path.
+// Chain lists the import path of each package from the one imported by user
+// down to Path, and Name is the offending symbol (if known).
+type InitGopPkgError struct {
+	Chain []string
+	Path  string
+	Name  string
+	Err   error
+}
 
-	if debugImport {
-		log.Println("==> Import", pkgImp.Path())
+func (p *InitGopPkgError) Unwrap() error {
+	return p.Err
+}
+
+func (p *InitGopPkgError) Error() string {
+	var b strings.Builder
+	b.WriteString("init Go+ package ")
+	b.WriteString(p.Path)
+	if p.Name != "" {
+		b.WriteString(": symbol ")
+		b.WriteString(p.Name)
 	}
-	InitThisGopPkg(pkgImp)
-	for _, depPath := range gopDeps {
-		imp, _ := importer.Import(depPath)
-		p.initGopPkg(importer, imp)
+	b.WriteString(": ")
+	b.WriteString(p.Err.Error())
+	if len(p.Chain) > 1 {
+		b.WriteString(" (imported via ")
+		b.WriteString(strings.Join(p.Chain, " -> "))
+		b.WriteByte(')')
 	}
+	return b.String()
 }
 
 // ----------------------------------------------------------------------------
 
 func importPkg(this *Package, pkgPath string, src ast.Node) (PkgRef, error) {
+	var pkgImp *types.Package
+	var err error
 	if strings.HasPrefix(pkgPath, ".") { // canonical pkgPath
 		pkgPath = path.Join(this.Path(), pkgPath)
 	}
-	pkgImp, err := this.imp.Import(pkgPath)
+	if pkgImp = this.imported[pkgPath]; pkgImp != nil {
+		return PkgRef{Types: pkgImp}, nil
+	}
+	dest := this.conf.DestPath
+	if dest != "" && !internalVisible(canonicalImportPath(pkgPath), dest) {
+		err = fmt.Errorf("use of internal package %s not allowed in %s", pkgPath, dest)
+	} else {
+		pkgImp, err = this.imp.Import(pkgPath)
+	}
+	if err == nil {
+		err = this.initGopPkg(this.imp, pkgImp)
+	}
 	if err != nil {
 		e := &ImportError{Path: pkgPath, Err: err}
 		if src != nil {
@@ -549,12 +799,42 @@ func importPkg(this *Package, pkgPath string, src ast.Node) (PkgRef, error) {
 			e.End = src.End()
 		}
 		return PkgRef{}, e
-	} else {
-		this.initGopPkg(this.imp, pkgImp)
 	}
+	if this.imported == nil {
+		this.imported = make(map[string]*types.Package)
+	}
+	this.imported[pkgPath] = pkgImp
 	return PkgRef{Types: pkgImp}, nil
 }
 
+// canonicalImportPath strips the vendor prefix of pkgPath, eg.
+// "example.com/m/vendor/github.com/x/y" => "github.com/x/y".
+func canonicalImportPath(pkgPath string) string {
+	if i := strings.LastIndex(pkgPath, "/vendor/"); i >= 0 {
+		return pkgPath[i+8:]
+	}
+	return strings.TrimPrefix(pkgPath, "vendor/")
+}
+
+// internalVisible reports whether pkgPath can be imported by the package
+// destPath, according to the visibility rules of internal packages.
+func internalVisible(pkgPath, destPath string) bool {
+	var parent string
+	switch {
+	case strings.HasSuffix(pkgPath, "/internal"):
+		parent = pkgPath[:len(pkgPath)-9]
+	case strings.Contains(pkgPath, "/internal/"):
+		parent = pkgPath[:strings.LastIndex(pkgPath, "/internal/")]
+	case pkgPath == "internal", strings.HasPrefix(pkgPath, "internal/"):
+		// internal packages of the standard library
+		elem, _, _ := strings.Cut(destPath, "/")
+		return !strings.Contains(elem, ".")
+	default:
+		return true
+	}
+	return destPath == parent || strings.HasPrefix(destPath, parent+"/")
+}
+
 // Import imports a package by pkgPath. It will panic if pkgPath not found.
 func (p *Package) Import(pkgPath string, src ...ast.Node) PkgRef {
 	ret, err := importPkg(p, pkgPath, getSrc(src))
@@ -570,6 +850,16 @@ func (p *Package) ForceImport(pkgPath string, src ...ast.Node) {
 	p.file.forceImport(pkgPath)
 }
 
+// SetImportComment sets the trailing comment of the import spec of pkgPath
+// in current file, eg. `import _ "image/png" // registers the PNG decoder`.
+func (p *Package) SetImportComment(pkgPath string, comment *ast.CommentGroup) {
+	f := p.file
+	if f.cmts == nil {
+		f.cmts = make(map[string]*ast.CommentGroup)
+	}
+	f.cmts[pkgPath] = comment
+}
+
 // TryImport imports a package by pkgPath. It returns nil if pkgPath not found.
 func (p *Package) TryImport(pkgPath string) PkgRef {
 	ret, _ := importPkg(p, pkgPath, nil)
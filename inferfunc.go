@@ -15,6 +15,8 @@ func inferFunc(pkg *Package, fn *internal.Elem, sig *types.Signature, targs []ty
 	if err != nil {
 		return nil, nil, err
 	}
+	osig := sig
+	sig = renameTParams(sig, args)
 	xlist := make([]*operand, len(args))
 	tp := sig.TypeParams()
 	n := tp.Len()
@@ -49,6 +51,9 @@ func inferFunc(pkg *Package, fn *internal.Elem, sig *types.Signature, targs []ty
 	if err != nil {
 		return nil, nil, err
 	}
+	if err = pkg.cb.checkInstCycle(osig, targs[:n]); err != nil {
+		return nil, nil, err
+	}
 	typ, err := types.Instantiate(pkg.cb.ctxt, sig, targs[:n], true)
 	return targs, typ, err
 }
@@ -451,7 +451,7 @@ func (a *Gop_bigrat) Gop_Rcast__1() int {
 	return 0
 }
 
-func (a *Gop_bigrat) Gop_Rcast__2() float64 {
+func (a Gop_bigrat) Gop_Rcast__2() float64 {
 	return 0
 }
 
@@ -96,6 +96,15 @@ func (p *printer) setComment(g *ast.CommentGroup) {
 	}
 }
 
+// setTrailingComment sets g as the next comment like setComment, and g will
+// be printed on the same line as the last item even if it has no position.
+func (p *printer) setTrailingComment(g *ast.CommentGroup) { // by Go+
+	if g != nil {
+		p.lineComment = g
+	}
+	p.setComment(g)
+}
+
 type exprListMode uint
 
 const (
@@ -558,7 +567,7 @@ func (p *printer) fieldList(fields *ast.FieldList, isStruct, isIncomplete bool)
 					p.print(sep)
 				}
 				p.print(sep)
-				p.expr(f.Tag)
+				p.literal(f.Tag) // by Go+
 				extraTabs = 0
 			}
 			if f.Comment != nil {
@@ -775,6 +784,69 @@ func (p *printer) binaryExpr(x *ast.BinaryExpr, prec1, cutoff, depth int) {
 	}
 }
 
+// literal prints a literal which isn't an expression, ie. a struct tag or an
+// import path. Unlike a string literal in an expression, it is never split
+// (see CommentedNodes.MaxStringLitWidth) (by Go+).
+func (p *printer) literal(x *ast.BasicLit) {
+	p.print(x.Pos(), x)
+}
+
+// splitStringLit splits the string literal lit into quoted parts at rune
+// boundaries, so that each part is at most maxWidth columns wide (but holds
+// one rune at least). It returns nil if lit isn't wider than maxWidth.
+func splitStringLit(lit string, maxWidth int) (parts []string) {
+	if utf8.RuneCountInString(lit) <= maxWidth {
+		return nil
+	}
+	val, err := strconv.Unquote(lit)
+	if err != nil {
+		return nil
+	}
+	var part []byte
+	width := 2 // quotes
+	for val != "" {
+		_, size := utf8.DecodeRuneInString(val)
+		q := strconv.Quote(val[:size])
+		w := utf8.RuneCountInString(q) - 2
+		if part != nil && width+w > maxWidth {
+			parts = append(parts, strconv.Quote(string(part)))
+			part, width = nil, 2
+		}
+		part = append(part, val[:size]...)
+		width += w
+		val = val[size:]
+	}
+	return append(parts, strconv.Quote(string(part)))
+}
+
+// stringLitParts prints parts of a split string literal, one per line, as
+// binaryExpr prints a chain of `+` operations.
+func (p *printer) stringLitParts(parts []string, prec1, depth int) {
+	paren := token.ADD.Precedence() < prec1
+	if paren {
+		p.print(token.LPAREN)
+		depth = reduceDepth(depth)
+	}
+	printBlank := depth <= 1 // see cutoff
+	for i, part := range parts {
+		if i > 0 {
+			if printBlank {
+				p.print(blank)
+			}
+			p.print(token.ADD)
+			if i == 1 {
+				p.print(indent)
+			}
+			p.print(newline)
+		}
+		p.print(&ast.BasicLit{Kind: token.STRING, Value: part})
+	}
+	p.print(unindent)
+	if paren {
+		p.print(token.RPAREN)
+	}
+}
+
 func isBinary(expr ast.Expr) bool {
 	_, ok := expr.(*ast.BinaryExpr)
 	return ok
@@ -837,6 +909,12 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		if p.Config.Mode&normalizeNumbers != 0 {
 			x = normalizedNumber(x)
 		}
+		if x.Kind == token.STRING && p.maxLitWidth > 0 { // by Go+
+			if parts := splitStringLit(x.Value, p.maxLitWidth); len(parts) > 1 {
+				p.stringLitParts(parts, prec1, depth)
+				break
+			}
+		}
 		p.print(x)
 
 	case *ast.FuncLit:
@@ -844,7 +922,7 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		// See the comment in funcDecl about how the header size is computed.
 		startCol := p.out.Column - len("func")
 		p.signature(x.Type)
-		p.funcBody(p.distanceFrom(x.Type.Pos(), startCol), blank, x.Body)
+		p.funcBody(p.headerSize(x.Type.Pos(), x.Body, startCol), blank, x.Body) // by Go+
 
 	case *ast.ParenExpr:
 		if _, hasParens := x.X.(*ast.ParenExpr); hasParens {
@@ -1615,8 +1693,8 @@ func (p *printer) spec(spec ast.Spec, n int, doIndent bool) {
 			p.expr(s.Name)
 			p.print(blank)
 		}
-		p.expr(sanitizeImportPath(s.Path))
-		p.setComment(s.Comment)
+		p.literal(sanitizeImportPath(s.Path)) // by Go+
+		p.setTrailingComment(s.Comment)
 		p.print(s.EndPos)
 
 	case *ast.ValueSpec:
@@ -1841,6 +1919,24 @@ func (p *printer) distanceFrom(startPos token.Pos, startOutCol int) int {
 	return infinity
 }
 
+// headerSize returns the size of a function header starting at funcPos (by
+// Go+). If body is compact (see CommentedNodes.CompactBodies), the header is
+// treated as on the same line as the body.
+func (p *printer) headerSize(funcPos token.Pos, body *ast.BlockStmt, startCol int) int {
+	if body != nil && p.compactBodies[body] {
+		return p.out.Column - startCol
+	}
+	return p.distanceFrom(funcPos, startCol)
+}
+
+// isCompact reports whether d is a function declaration with a compact body
+// (see CommentedNodes.CompactBodies), which is taken as a one-line function
+// (by Go+).
+func (p *printer) isCompact(d ast.Decl) bool {
+	fn, ok := d.(*ast.FuncDecl)
+	return ok && fn.Body != nil && p.compactBodies[fn.Body]
+}
+
 func (p *printer) funcDecl(d *ast.FuncDecl) {
 	p.setComment(d.Doc)
 	p.print(d.Pos(), token.FUNC, blank)
@@ -1854,7 +1950,7 @@ func (p *printer) funcDecl(d *ast.FuncDecl) {
 	}
 	p.expr(d.Name)
 	p.signature(d.Type)
-	p.funcBody(p.distanceFrom(d.Pos(), startCol), vtab, d.Body)
+	p.funcBody(p.headerSize(d.Pos(), d.Body, startCol), vtab, d.Body) // by Go+
 }
 
 func (p *printer) decl(decl ast.Decl) {
@@ -1886,9 +1982,11 @@ func declToken(decl ast.Decl) (tok token.Token) {
 
 func (p *printer) declList(list []ast.Decl) {
 	tok := token.ILLEGAL
+	tok = p.floatingComments(nil, tok) // by Go+
 	for _, d := range list {
 		if gd, ok := d.(*ast.GenDecl); ok && len(gd.Specs) == 0 {
-			continue // skip empty genDecl
+			tok = p.floatingComments(d, tok) // by Go+
+			continue                         // skip empty genDecl
 		}
 		prev := tok
 		tok = declToken(d)
@@ -1908,14 +2006,40 @@ func (p *printer) declList(list []ast.Decl) {
 			}
 			// start a new section if the next declaration is a function
 			// that spans multiple lines (see also issue #19544)
-			p.linebreak(p.lineFor(d.Pos()), min, ignore, tok == token.FUNC && p.numLines(d) > 1)
+			p.linebreak(p.lineFor(d.Pos()), min, ignore, tok == token.FUNC && p.numLines(d) > 1 && !p.isCompact(d)) // by Go+
 		}
 		p.decl(d)
+		tok = p.floatingComments(d, tok) // by Go+
+	}
+}
+
+// floatingComments prints floating comments after the declaration d (see
+// CommentedNodes.FloatingComments), each of which is separated from its
+// neighbors by empty lines. It returns token.COMMENT if any is printed, and
+// tok otherwise (by Go+).
+func (p *printer) floatingComments(d ast.Decl, tok token.Token) token.Token {
+	for _, g := range p.floating[d] {
+		if len(p.output) > 0 {
+			p.linebreak(0, 2, ignore, false)
+		}
+		p.flush(p.pos, token.ILLEGAL) // write pending linebreaks
+		for i, c := range g.List {
+			if i > 0 {
+				p.writeByte('\f', 1)
+			}
+			p.writeComment(c)
+		}
+		tok = token.COMMENT
 	}
+	return tok
 }
 
 func (p *printer) file(src *ast.File) {
 	p.setComment(src.Doc)
+	if src.Doc != nil && !src.Package.IsValid() { // by Go+
+		// a position-less package doc must be flushed before the package clause
+		p.pos.Offset++
+	}
 	p.print(src.Pos(), token.PACKAGE, blank)
 	p.expr(src.Name)
 	p.declList(src.Decls)
@@ -92,6 +92,10 @@ type printer struct {
 
 	// by Go+
 	commentedStmts map[ast.Stmt]*ast.CommentGroup
+	floating       map[ast.Decl][]*ast.CommentGroup // see CommentedNodes.FloatingComments
+	compactBodies  map[*ast.BlockStmt]bool          // see CommentedNodes.CompactBodies
+	lineComment    *ast.CommentGroup                // trailing comment without position
+	maxLitWidth    int                              // see CommentedNodes.MaxStringLitWidth
 }
 
 func (p *printer) init(cfg *Config, fset *token.FileSet, nodeSizes map[ast.Node]int) {
@@ -359,7 +363,11 @@ func (p *printer) writeCommentPrefix(pos, next token.Position, prev *ast.Comment
 		return
 	}
 
-	if pos.Line == p.last.Line && (prev == nil || len(prev.Text) > 1 && prev.Text[1] != '/') {
+	sameLine := pos.Line == p.last.Line
+	if !pos.IsValid() && p.comment == p.lineComment { // by Go+
+		sameLine = true
+	}
+	if sameLine && (prev == nil || len(prev.Text) > 1 && prev.Text[1] != '/') {
 		// comment on the same line as last item:
 		// separate with at least one separator
 		hasSep := false
@@ -739,7 +747,9 @@ func (p *printer) containsLinebreak() bool {
 // newline was written or if a formfeed was dropped from the whitespace buffer.
 func (p *printer) intersperseComments(next token.Position, tok token.Token) (wroteNewline, droppedFF bool) {
 	var last *ast.Comment
+	var trailing bool
 	for p.commentBefore(next) {
+		trailing = p.comment == p.lineComment
 		for _, c := range p.comment.List {
 			p.writeCommentPrefix(p.posFor(c.Pos()), next, last, tok)
 			p.writeComment(c)
@@ -748,6 +758,20 @@ func (p *printer) intersperseComments(next token.Position, tok token.Token) (wro
 		p.nextComment()
 	}
 
+	if trailing && !last.Pos().IsValid() { // by Go+
+		// keep all pending linebreaks after a trailing comment without
+		// position, since they can't be recomputed from positions
+		for i, ch := range p.wsbuf {
+			if ch == blank || ch == vtab {
+				p.wsbuf[i] = ignore
+			}
+		}
+		if p.containsLinebreak() {
+			p.writeWhitespace(len(p.wsbuf))
+			return true, false
+		}
+	}
+
 	if last != nil {
 		// If the last comment is a /*-style comment and the next item
 		// follows on the same line but is not a comma, and not a "closing"
@@ -1079,6 +1103,9 @@ func (p *printer) printNode(node interface{}) error {
 	if cnodes, ok := node.(*CommentedNodes); ok {
 		node = cnodes.Node
 		p.commentedStmts = cnodes.CommentedStmts
+		p.floating = cnodes.FloatingComments
+		p.compactBodies = cnodes.CompactBodies
+		p.maxLitWidth = cnodes.MaxStringLitWidth
 	} else if cnode, ok := node.(*CommentedNode); ok {
 		node = cnode.Node
 		comments = cnode.Comments
@@ -1366,6 +1393,20 @@ type CommentedNode struct {
 type CommentedNodes struct {
 	Node           interface{}
 	CommentedStmts map[ast.Stmt]*ast.CommentGroup
+
+	// FloatingComments are standalone comments printed after a top-level
+	// declaration (nil means before all declarations), which are separated
+	// from their neighboring declarations by empty lines.
+	FloatingComments map[ast.Decl][]*ast.CommentGroup
+
+	// CompactBodies are function bodies which are printed on the same line as
+	// their function headers if they are short enough, like gofmt keeps
+	// one-line functions of the source.
+	CompactBodies map[*ast.BlockStmt]bool
+
+	// MaxStringLitWidth is the maximum width of a string literal. A longer
+	// one is split into a `+`-joined multi-line form. Zero means no limit.
+	MaxStringLitWidth int
 }
 
 // Fprint "pretty-prints" an AST node to output for a given configuration cfg.
@@ -17,7 +17,6 @@ import (
 	"bytes"
 	"go/ast"
 	"go/token"
-	"log"
 
 	"github.com/goplus/gogen/internal/go/format"
 )
@@ -27,7 +26,7 @@ func Format(fset *token.FileSet, v ast.Node) string {
 	var b bytes.Buffer
 	err := format.Node(&b, fset, v)
 	if err != nil {
-		log.Fatalln("goxdbg.Format failed:", err)
+		panic("goxdbg.Format failed: " + err.Error())
 	}
 	return b.String()
 }
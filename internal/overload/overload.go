@@ -41,3 +41,12 @@ const Gopo_Game_Run2 = ",.RunString"
 const Gopo_Game_Run3 = ".RunInt,RunGame"
 
 // -----------------------------------------------------------------------------
+
+type Writer interface {
+	WriteString(s string)
+	WriteBytes(b []byte)
+}
+
+const Gopo_Writer_Write = ".WriteString,.WriteBytes"
+
+// -----------------------------------------------------------------------------
@@ -28,11 +28,13 @@ type Elem struct {
 	Type types.Type
 	CVal constant.Value
 	Src  ast.Node
+	Idx  *Elem // the indexed operand x if Val is an index expression x[i]
 }
 
 // A Stack represents a FILO container.
 type Stack struct {
-	data []*Elem
+	data   []*Elem
+	pushed int
 }
 
 // NewStack creates a Stack instance.
@@ -63,11 +65,18 @@ func (p *Stack) GetArgs(arity int) []*Elem {
 // Ret pops n values from this stack, and then pushes results.
 func (p *Stack) Ret(arity int, results ...*Elem) {
 	p.data = append(p.data[:len(p.data)-arity], results...)
+	p.pushed += len(results)
 }
 
 // Push pushes a value into this stack.
 func (p *Stack) Push(v *Elem) {
 	p.data = append(p.data, v)
+	p.pushed++
+}
+
+// Pushed returns the number of values pushed into this stack so far.
+func (p *Stack) Pushed() int {
+	return p.pushed
 }
 
 // PopN pops n elements.
@@ -0,0 +1,106 @@
+/*
+Copyright 2026 The XGo Authors (xgo.dev)
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gogen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"log"
+	"strings"
+
+	"github.com/goplus/gogen/internal"
+	"github.com/goplus/gogen/internal/go/printer"
+)
+
+// ----------------------------------------------------------------------------
+
+func isInvalidType(typ types.Type) bool {
+	switch t := typ.(type) {
+	case *types.Basic:
+		return t.Kind() == types.Invalid
+	case *refType:
+		return isInvalidType(t.typ)
+	case *TypeType:
+		return isInvalidType(t.typ)
+	}
+	return false
+}
+
+func hasInvalidType(args []*internal.Elem) bool {
+	for _, arg := range args {
+		if isInvalidType(arg.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// pushInvalid replaces the top n elements of the stack with an invalid
+// operand (or a reference to it if ref is true).
+func (p *CodeBuilder) pushInvalid(n int, src ast.Node, ref bool) {
+	var typ = TyInvalid
+	if ref {
+		typ = &refType{typ: TyInvalid}
+	}
+	p.stk.PopN(n)
+	p.stk.Push(&internal.Elem{
+		Val: &ast.BadExpr{From: getSrcPos(src), To: getSrcEnd(src)}, Type: typ, Src: src,
+	})
+}
+
+// Invalid pushes an invalid operand (of type TyInvalid) onto the stack. It is
+// used in error-tolerant mode (see Config.HandleErr) to stand for an
+// expression that failed to build. Operations on an invalid operand yield
+// invalid operands without reporting follow-on errors, and assignments from
+// or to them aren't checked. An invalid operand never makes it into the
+// generated code: WriteTo/WriteFile fail if any remains.
+func (p *CodeBuilder) Invalid(src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("Invalid")
+	}
+	p.pushInvalid(0, getSrc(src), false)
+	return p
+}
+
+// InvalidExprError represents invalid operands (see CodeBuilder.Invalid)
+// which remain in the generated code.
+type InvalidExprError []error
+
+func (p InvalidExprError) Error() string {
+	msgs := make([]string, len(p)+1)
+	msgs[0] = fmt.Sprintf("%d invalid expression(s) in generated code:", len(p))
+	for i, err := range p {
+		msgs[i+1] = "\t" + err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (p *Package) checkInvalidExprs(file *printer.CommentedNodes) error {
+	var errs InvalidExprError
+	ast.Inspect(file.Node.(ast.Node), func(node ast.Node) bool {
+		if e, ok := node.(*ast.BadExpr); ok {
+			errs = append(errs, p.cb.newCodeError(e.From, e.To, "invalid expression"))
+		}
+		return true
+	})
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
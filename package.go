@@ -14,11 +14,13 @@
 package gogen
 
 import (
+	"fmt"
 	"go/ast"
 	"go/token"
 	"go/types"
 	"log"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -65,6 +67,17 @@ func fatal(msg string) {
 	panic(fatalMsg(msg))
 }
 
+// panicf is like log.Panicf, but doesn't write to the standard logger: gogen
+// only logs if debug tracing is enabled (see SetDebug).
+func panicf(format string, v ...interface{}) {
+	panic(fmt.Sprintf(format, v...))
+}
+
+// panicln is like log.Panicln, but doesn't write to the standard logger.
+func panicln(v ...interface{}) {
+	panic(fmt.Sprintln(v...))
+}
+
 // ----------------------------------------------------------------------------
 
 // Recorder represents a gogen event recorder.
@@ -82,6 +95,47 @@ type dbgPositioner interface {
 	Position(p token.Pos) token.Position
 }
 
+// fsetPositioner formats positions by DbgPositioner, Fset and ImportFset in
+// order, so positions of imported objects which don't belong to Fset (eg.
+// loaded by go/packages with its own FileSet) are resolved correctly. It
+// never panics on an unknown position, which is formatted as its raw value,
+// eg. `Pos(1234)`.
+type fsetPositioner struct {
+	dbg  dbgPositioner
+	fset *token.FileSet
+	imp  *token.FileSet
+}
+
+func newPositioner(conf *Config, fset *token.FileSet) *fsetPositioner {
+	return &fsetPositioner{dbg: conf.DbgPositioner, fset: fset, imp: conf.ImportFset}
+}
+
+func (p *fsetPositioner) Position(pos token.Pos) (ret token.Position) {
+	if !pos.IsValid() {
+		return
+	}
+	if p.dbg != nil {
+		if ret = p.dbgPosition(pos); ret.IsValid() {
+			return
+		}
+	}
+	for _, fset := range [...]*token.FileSet{p.fset, p.imp} {
+		if fset != nil && fset.File(pos) != nil {
+			return fset.Position(pos)
+		}
+	}
+	return token.Position{Filename: "Pos(" + strconv.Itoa(int(pos)) + ")"}
+}
+
+func (p *fsetPositioner) dbgPosition(pos token.Pos) (ret token.Position) {
+	defer func() {
+		if recover() != nil {
+			ret = token.Position{}
+		}
+	}()
+	return p.dbg.Position(pos)
+}
+
 type NodeInterpreter interface {
 	// LoadExpr is called to load an expr code.
 	LoadExpr(expr ast.Node) string
@@ -99,6 +153,11 @@ type Config struct {
 	// HandleErr is called to handle errors (optional).
 	HandleErr func(err error)
 
+	// HandleWarn is called to handle warnings, eg. a conversion from an
+	// integer to a string (optional). Warnings are also collected in the
+	// result of Package.Finalize.
+	HandleWarn func(err error)
+
 	// NodeInterpreter is to interpret an ast.Node (optional).
 	NodeInterpreter NodeInterpreter
 
@@ -132,8 +191,100 @@ type Config struct {
 	// NoSkipConstant is to disable optimization of skipping constant (optional).
 	NoSkipConstant bool
 
+	// ElideConversions is to elide redundant conversions (optional): T(x) is
+	// generated as x if x is already of type T, and converting a constant to
+	// bool, int, float64 or string is folded into a literal of that type.
+	ElideConversions bool
+
+	// FoldStringConcat is to fold constant string concatenation (optional):
+	// `"a" + c + "b"` is generated as a single literal "axb" if c is a string
+	// constant "x", or T("axb") if the result is of type T.
+	FoldStringConcat bool
+
+	// MaxStringLitWidth is the maximum width (in columns) of a string literal
+	// in generated code (optional). A longer literal is split at rune
+	// boundaries into a `+`-joined multi-line form when the code is written.
+	// Zero means no limit.
+	MaxStringLitWidth int
+
 	// EnableTypesalias is enable use goypesalias (optional).
 	EnableTypesalias bool
+
+	// MangleName is called to repair an invalid identifier (eg. a Go keyword)
+	// passed to a declaration (optional). If it is nil, an invalid identifier
+	// is reported as an error.
+	MangleName func(name string) string
+
+	// GoVersion is the Go language version of generated code, eg. "go1.19"
+	// (optional). Using a language feature which requires a later version
+	// is reported as an error. Empty means the latest version.
+	//
+	// Before go1.22, a loop variable is shared by all iterations. If such a
+	// variable is captured by a closure which may escape the iteration (eg.
+	// `go func() { ... }()`), a per-iteration copy `v := v` is inserted at the
+	// front of the loop body.
+	GoVersion string
+
+	// MapKeyLess reports whether key x sorts before key y in map literals
+	// created by MapLitFromMap (optional). If it is nil, keys are sorted in
+	// ascending order, see CodeBuilder.MapLitFromMap.
+	MapKeyLess func(x, y interface{}) bool
+
+	// ImportFset is the FileSet of objects loaded by Importer (optional), eg.
+	// the FileSet of go/packages. Positions which don't belong to Fset are
+	// resolved by it when an error message is formatted.
+	ImportFset *token.FileSet
+
+	// CtxParamName is the name of the context parameter prepended by
+	// WithCtxParam (optional). If it is empty, "ctx" is used.
+	CtxParamName string
+
+	// AutoCtxArg enables supplying a context automatically (optional): when
+	// calling a function whose first parameter is a context.Context without
+	// passing it, the context parameter of the enclosing function is passed
+	// as the first argument.
+	AutoCtxArg bool
+
+	// OverloadByResults makes overload resolution aware of the number of
+	// results expected by the call site (optional): when a call of an
+	// overload function is the value of a declaration of n names (eg. by
+	// DefineVarStart) or the right-hand side of an assignment to n VarRefs,
+	// a candidate returning n results is preferred to the first candidate
+	// matching the arguments. It is an error if more than one such candidate
+	// matches.
+	OverloadByResults bool
+
+	// StrictTodo makes WriteTo/WriteFile fail if any placeholder emitted by
+	// CodeBuilder.Todo remains (optional).
+	StrictTodo bool
+
+	// Sizes provides sizes and alignments of types of the target platform
+	// (optional). They are used by unsafe.Sizeof, Alignof and Offsetof, by
+	// Sizeof and Offsetsof of Package, and to check whether a constant
+	// overflows int, uint or uintptr. If it is nil, sizes of the gc compiler
+	// for GOARCH are used, or sizes of the host platform if GOARCH is empty.
+	//
+	// Sizes are kept by each Package, as are its builtin package and its
+	// context of generic instantiations, so packages of different targets can
+	// be built in one process.
+	Sizes types.Sizes
+
+	// GOARCH specifies the target architecture (optional), see Sizes. There is
+	// no GOOS, since sizes of the gc compiler don't depend on it. Files of
+	// imported packages are selected by build constraints of the target in
+	// Importer, which should be created for the target (eg. by the GOOS and
+	// GOARCH environment variables of go list).
+	GOARCH string
+
+	// ErrShadowImport is to report an error if a local name (a variable,
+	// parameter, etc.) is the name of a package imported by the current file
+	// (optional). By default, such an import is renamed, eg. `fmt1 "fmt"`.
+	ErrShadowImport bool
+
+	// DestPath is the import path of the generated package in its destination
+	// module (optional). If it is set, importing an internal package which
+	// isn't visible to DestPath is reported as an error.
+	DestPath string
 }
 
 // ----------------------------------------------------------------------------
@@ -143,7 +294,10 @@ type importUsed bool
 type File struct {
 	decls []ast.Decl
 	fname string
-	imps  map[string]*ast.Ident // importPath => impRef (nil means force-import)
+	imps  map[string]*ast.Ident            // importPath => impRef (nil means force-import)
+	cmts  map[string]*ast.CommentGroup     // importPath => trailing comment
+	force map[string]null                  // force-imported paths which have impRefs
+	float map[ast.Decl][]*ast.CommentGroup // decl => floating comments after it (nil means before all decls)
 	dirty bool
 }
 
@@ -154,6 +308,12 @@ func newFile(fname string) *File {
 func (p *File) newImport(name, pkgPath string) *ast.Ident {
 	id := p.imps[pkgPath]
 	if id == nil {
+		if _, ok := p.imps[pkgPath]; ok { // force-imported
+			if p.force == nil {
+				p.force = make(map[string]null)
+			}
+			p.force[pkgPath] = null{}
+		}
 		id = &ast.Ident{Name: name, Obj: &ast.Object{Data: importUsed(false)}}
 		p.imps[pkgPath] = id
 		p.dirty = true
@@ -162,12 +322,23 @@ func (p *File) newImport(name, pkgPath string) *ast.Ident {
 }
 
 func (p *File) forceImport(pkgPath string) {
-	if _, ok := p.imps[pkgPath]; !ok {
+	if id, ok := p.imps[pkgPath]; !ok {
 		p.imps[pkgPath] = nil
 		p.dirty = true
+	} else if id != nil {
+		if p.force == nil {
+			p.force = make(map[string]null)
+		}
+		p.force[pkgPath] = null{}
 	}
 }
 
+// isForced reports whether pkgPath is force-imported, though it has an impRef.
+func (p *File) isForced(pkgPath string) bool {
+	_, ok := p.force[pkgPath]
+	return ok
+}
+
 func (p *File) markUsed(this *Package) {
 	if p.dirty {
 		astVisitor{this, p}.markUsed(p.decls)
@@ -175,6 +346,68 @@ func (p *File) markUsed(this *Package) {
 	}
 }
 
+// AddFloatingComment adds a standalone comment right after the declaration
+// afterDecl of this file, or at the end of this file if afterDecl is nil (so
+// it precedes the declarations added later). It is separated from the
+// neighboring declarations by empty lines, so it is never taken as their
+// documentation. Each line of text which doesn't start with `//` (eg.
+// `//go:generate stringer -type=Kind`) is written as a `// ` line comment.
+// Comments added after the same declaration keep their order.
+func (p *File) AddFloatingComment(afterDecl ast.Decl, text string) {
+	if afterDecl == nil {
+		if n := len(p.decls); n > 0 {
+			afterDecl = p.decls[n-1]
+		}
+	} else if !p.hasDecl(afterDecl) {
+		panicln("AddFloatingComment: declaration not found in file", p.fname)
+	}
+	if p.float == nil {
+		p.float = make(map[ast.Decl][]*ast.CommentGroup)
+	}
+	p.float[afterDecl] = append(p.float[afterDecl], floatingComment(text))
+}
+
+func (p *File) hasDecl(decl ast.Decl) bool {
+	for _, d := range p.decls {
+		if d == decl {
+			return true
+		}
+	}
+	return false
+}
+
+// floatingComments returns floating comments of this file to print with
+// decls, which are declarations of this file preceded by the ones generated
+// at write time (eg. imports).
+func (p *File) floatingComments(decls []ast.Decl) map[ast.Decl][]*ast.CommentGroup {
+	if n := len(decls) - len(p.decls); n > 0 {
+		if cmts, ok := p.float[nil]; ok { // before all decls of this file
+			ret := make(map[ast.Decl][]*ast.CommentGroup, len(p.float))
+			for decl, v := range p.float {
+				ret[decl] = v
+			}
+			delete(ret, nil)
+			ret[decls[n-1]] = cmts
+			return ret
+		}
+	}
+	return p.float
+}
+
+func floatingComment(text string) *ast.CommentGroup {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	list := make([]*ast.Comment, len(lines))
+	for i, line := range lines {
+		if line = strings.TrimRight(line, " \t"); line == "" {
+			line = "//"
+		} else if !strings.HasPrefix(line, "//") {
+			line = "// " + line
+		}
+		list[i] = &ast.Comment{Text: line}
+	}
+	return &ast.CommentGroup{List: list}
+}
+
 // Name returns the name of this file.
 func (p *File) Name() string {
 	return p.fname
@@ -251,7 +484,7 @@ const (
 func (p *File) CheckGopDeps(this *Package) (flags int) {
 	p.markUsed(this)
 	for pkgPath, id := range p.imps {
-		if id == nil || id.Obj.Data.(importUsed) {
+		if id == nil || bool(id.Obj.Data.(importUsed)) || p.isForced(pkgPath) {
 			if isPkgInMod(pkgPath, "github.com/goplus/gop") {
 				flags |= FlagDepModGop
 			} else if isPkgInMod(pkgPath, "github.com/qiniu/x") {
@@ -262,14 +495,57 @@ func (p *File) CheckGopDeps(this *Package) (flags int) {
 	return
 }
 
+// collectImportedRefs adds the objects of imported packages referenced by
+// decls of this file to refs (importPath => object names).
+func (p *File) collectImportedRefs(refs map[string]map[string]null) {
+	paths := make(map[*ast.Ident]string, len(p.imps))
+	for pkgPath, id := range p.imps {
+		if id != nil {
+			paths[id] = canonicalImportPath(pkgPath)
+		}
+	}
+	for _, decl := range p.decls {
+		ast.Inspect(decl, func(node ast.Node) bool {
+			if sel, ok := node.(*ast.SelectorExpr); ok {
+				if id, ok := sel.X.(*ast.Ident); ok {
+					if pkgPath, ok := paths[id]; ok {
+						names := refs[pkgPath]
+						if names == nil {
+							names = make(map[string]null)
+							refs[pkgPath] = names
+						}
+						names[sel.Sel.Name] = null{}
+						return false
+					}
+				}
+			}
+			return true
+		})
+	}
+}
+
+// unusedImports adds the packages imported by this file but not used (and
+// not forced, see ForceImport) to unused. It must be called after markUsed.
+func (p *File) unusedImports(unused map[string]null) {
+	for pkgPath, id := range p.imps {
+		if id != nil && !bool(id.Obj.Data.(importUsed)) && !p.isForced(pkgPath) {
+			unused[canonicalImportPath(pkgPath)] = null{}
+		}
+	}
+}
+
 func (p *File) getDecls(this *Package) (decls []ast.Decl) {
 	p.markUsed(this)
 	specs := make([]ast.Spec, 0, len(p.imps))
 	for pkgPath, id := range p.imps {
+		if id != nil && !bool(id.Obj.Data.(importUsed)) && p.isForced(pkgPath) {
+			id = nil
+		}
 		if id == nil { // force-used
 			specs = append(specs, &ast.ImportSpec{
-				Name: underscore, // _
-				Path: stringLit(pkgPath),
+				Name:    underscore, // _
+				Path:    stringLit(canonicalImportPath(pkgPath)),
+				Comment: p.cmts[pkgPath],
 			})
 		} else if id.Obj.Data.(importUsed) {
 			var name *ast.Ident
@@ -277,8 +553,9 @@ func (p *File) getDecls(this *Package) (decls []ast.Decl) {
 				name = ident(id.Obj.Name)
 			}
 			specs = append(specs, &ast.ImportSpec{
-				Name: name,
-				Path: stringLit(pkgPath),
+				Name:    name,
+				Path:    stringLit(canonicalImportPath(pkgPath)),
+				Comment: p.cmts[pkgPath],
 			})
 		}
 	}
@@ -321,6 +598,11 @@ type Package struct {
 	Docs ObjectDocs
 	Fset *token.FileSet
 
+	// Implicits maps each case clause (*ast.CaseClause) of a type switch with
+	// a binding variable to the variable implicitly declared in that clause,
+	// like types.Info.Implicits.
+	Implicits map[ast.Node]types.Object
+
 	unitMgr
 	autoNames
 	cb             CodeBuilder
@@ -335,9 +617,22 @@ type Package struct {
 	utBigRat       *types.Named
 	utBigFlt       *types.Named
 	commentedStmts map[ast.Stmt]*ast.CommentGroup
+	compactBodies  map[*ast.BlockStmt]bool   // bodies of funcs set compact, see Func.SetCompact
+	arrayLens      map[*types.Array]ast.Expr // length expressions of arrays made by ArrayType
 	implicitCast   func(pkg *Package, V, T types.Type, pv *Element) bool
 
 	expObjTypes []types.Type // types of export objects
+	laterRefs   map[string]*TyLaterRef
+	goMinor     int // minor version of conf.GoVersion (0 means the latest)
+	pkgDoc      *ast.CommentGroup
+	pkgDocFile  string                    // file carrying pkgDoc ("" means the default file)
+	stats       FuncStats                 // aggregated over top-level functions
+	imported    map[string]*types.Package // pkgPath => imported package, see importPkg
+	todos       []Todo                    // placeholders emitted by CodeBuilder.Todo
+	warns       []error                   // warnings reported, see Config.HandleWarn
+	tyCtx       types.Type                // context.Context, see ContextType
+	sizes       types.Sizes
+	intRanges   *intRanges // value ranges of integer kinds on the target platform
 	isGopPkg    bool
 	allowRedecl bool // for c2go
 }
@@ -346,6 +641,38 @@ const (
 	goxPrefix = "Gop_"
 )
 
+// checkIdent checks if name is a valid identifier (keywords aren't allowed)
+// to be declared by `at`. It returns the mangled name if conf.MangleName is
+// specified.
+func (p *Package) checkIdent(pos, end token.Pos, name, at string) (string, error) {
+	if token.IsIdentifier(name) {
+		return name, nil
+	}
+	if mangle := p.conf.MangleName; mangle != nil {
+		if ret := mangle(name); token.IsIdentifier(ret) {
+			return ret, nil
+		}
+	}
+	return "", p.cb.newCodeErrorf(pos, end, "invalid identifier %q in %s", name, at)
+}
+
+func (p *Package) checkIdents(pos token.Pos, names []string, at string) []string {
+	ret := names
+	for i, name := range names {
+		v, err := p.checkIdent(pos, pos, name, at)
+		if err != nil {
+			panic(err)
+		}
+		if v != name {
+			if &ret[0] == &names[0] { // copy on write
+				ret = append([]string(nil), names...)
+			}
+			ret[i] = v
+		}
+	}
+	return ret
+}
+
 // NewPackage creates a new package.
 func NewPackage(pkgPath, name string, conf *Config) *Package {
 	if conf == nil {
@@ -372,6 +699,8 @@ func NewPackage(pkgPath, name string, conf *Config) *Package {
 		files: files,
 		conf:  conf,
 	}
+	pkg.sizes = targetSizes(conf)
+	pkg.intRanges = newIntRanges(pkg.sizes)
 	pkg.unitMgr.init()
 	pkg.autoNames.init()
 	pkg.imp = imp
@@ -384,10 +713,32 @@ func NewPackage(pkgPath, name string, conf *Config) *Package {
 	pkg.utBigInt = conf.UntypedBigInt
 	pkg.utBigRat = conf.UntypedBigRat
 	pkg.utBigFlt = conf.UntypedBigFloat
+	pkg.goMinor = goMinorVersion(conf.GoVersion)
 	pkg.cb.init(pkg)
 	return pkg
 }
 
+// goMinorVersion returns minor version of a Go version like "go1.20" (0 means
+// the version is empty or unknown).
+func goMinorVersion(ver string) int {
+	if !strings.HasPrefix(ver, "go1.") {
+		return 0
+	}
+	ver = ver[4:]
+	n := 0
+	for n < len(ver) && ver[n] >= '0' && ver[n] <= '9' {
+		n++
+	}
+	minor, _ := strconv.Atoi(ver[:n])
+	return minor
+}
+
+// allowGoVersion reports whether go1.minor language features are allowed by
+// Config.GoVersion.
+func (p *Package) allowGoVersion(minor int) bool {
+	return p.goMinor == 0 || p.goMinor >= minor
+}
+
 func (p *Package) setDoc(o types.Object, doc *ast.CommentGroup) {
 	if p.Docs == nil {
 		p.Docs = make(ObjectDocs)
@@ -395,6 +746,55 @@ func (p *Package) setDoc(o types.Object, doc *ast.CommentGroup) {
 	p.Docs[o] = doc
 }
 
+// SetDoc sets the package documentation, which is emitted directly above the
+// package clause. Each line of text is written as a `//` line comment.
+// If fname is provided, the documentation is attached to the file named fname
+// instead of the default file. Only one file carries the package documentation,
+// so calling SetDoc again moves it; an empty text removes it.
+func (p *Package) SetDoc(text string, fname ...string) {
+	p.pkgDocFile = ""
+	if len(fname) == 1 {
+		p.pkgDocFile = fname[0]
+	}
+	p.pkgDoc = nil
+	if text = strings.TrimRight(text, "\n"); text != "" {
+		lines := strings.Split(text, "\n")
+		list := make([]*ast.Comment, len(lines))
+		for i, line := range lines {
+			if line = strings.TrimRight(line, " \t"); line != "" {
+				line = "// " + line
+			} else {
+				line = "//"
+			}
+			list[i] = &ast.Comment{Text: line}
+		}
+		p.pkgDoc = &ast.CommentGroup{List: list}
+	}
+}
+
+// Doc returns the package documentation and the name of the file carrying it.
+func (p *Package) Doc() (doc *ast.CommentGroup, fname string) {
+	fname = p.pkgDocFile
+	if fname == "" {
+		fname = p.conf.DefaultGoFile
+	}
+	return p.pkgDoc, fname
+}
+
+func (p *Package) setImplicit(node ast.Node, o types.Object) {
+	if p.Implicits == nil {
+		p.Implicits = make(map[ast.Node]types.Object)
+	}
+	p.Implicits[node] = o
+}
+
+func (p *Package) setArrayLen(t *types.Array, len ast.Expr) {
+	if p.arrayLens == nil {
+		p.arrayLens = make(map[*types.Array]ast.Expr)
+	}
+	p.arrayLens[t] = len
+}
+
 func (p *Package) setStmtComments(stmt ast.Stmt, comments *ast.CommentGroup) {
 	if p.commentedStmts == nil {
 		p.commentedStmts = make(map[ast.Stmt]*ast.CommentGroup)
@@ -402,6 +802,13 @@ func (p *Package) setStmtComments(stmt ast.Stmt, comments *ast.CommentGroup) {
 	p.commentedStmts[stmt] = comments
 }
 
+// Stats returns statistics aggregated over top-level functions (including
+// methods) whose bodies have ended: the sums of their Stmts and Exprs, and the
+// max of their MaxDepth. See FuncStats.
+func (p *Package) Stats() FuncStats {
+	return p.stats
+}
+
 // SetRedeclarable sets to allow redeclaration of variables/functions or not.
 func (p *Package) SetRedeclarable(allowRedecl bool) {
 	p.allowRedecl = allowRedecl
@@ -409,7 +816,7 @@ func (p *Package) SetRedeclarable(allowRedecl bool) {
 
 // Sizeof returns sizeof typ in bytes.
 func (p *Package) Sizeof(typ types.Type) int64 {
-	return align(std.Sizeof(typ), std.Alignof(typ))
+	return align(p.sizes.Sizeof(typ), p.sizes.Alignof(typ))
 }
 
 // align returns the smallest y >= x such that y % a == 0.
@@ -419,7 +826,91 @@ func align(x, a int64) int64 {
 }
 
 func (p *Package) Offsetsof(fields []*types.Var) []int64 {
-	return std.Offsetsof(fields)
+	return p.sizes.Offsetsof(fields)
+}
+
+// Sizes returns sizes of the target platform, see Config.Sizes.
+func (p *Package) Sizes() types.Sizes {
+	return p.sizes
+}
+
+// Implements reports whether typ implements the interface type iface (eg.
+// io.Writer). It returns false if iface isn't an interface.
+//
+// A method is in the method set of its receiver type as soon as NewFunc
+// declares it, even if its body isn't built yet. Method sets aren't cached
+// (neither by gogen nor by go/types), so Implements and MemberVal can be used
+// at any time during generation, and see the methods declared so far.
+func (p *Package) Implements(typ, iface types.Type) bool {
+	it, ok := getUnderlying(p, iface).(*types.Interface)
+	if !ok {
+		return false
+	}
+	p.cb.ensureLoaded(typ)
+	return types.Implements(typ, it)
+}
+
+// AssertImplements declares `var _ iface = typ(nil)` in the package (eg.
+// `var _ io.Writer = (*T)(nil)`) to assert statically that typ implements
+// the interface iface, which also documents the contract in the generated
+// code. It panics with a *CodeError if typ doesn't implement iface. If the
+// zero value of typ isn't nil, it's used instead (eg. `var _ I = T{}`).
+func (p *Package) AssertImplements(iface, typ types.Type, src ...ast.Node) {
+	cb := &p.cb
+	srcExpr := getSrc(src)
+	pos, end := getSrcPos(srcExpr), getSrcEnd(srcExpr)
+	it, ok := getUnderlying(p, iface).(*types.Interface)
+	if !ok {
+		cb.panicCodeErrorf(pos, end, "%v is not an interface", iface)
+	}
+	cb.ensureLoaded(typ)
+	if m, _ := types.MissingMethod(typ, it, true); m != nil {
+		if _, isPtr := typ.(*types.Pointer); !isPtr && types.Implements(types.NewPointer(typ), it) {
+			cb.panicCodeErrorf(
+				pos, end, "%v does not implement %v (method %s has pointer receiver)", typ, iface, m.Name())
+		}
+		cb.panicCodeErrorf(pos, end, "%v does not implement %v (missing method %s)", typ, iface, m.Name())
+	}
+	p.valOfZero(p.NewVarStart(pos, iface, "_"), typ).EndInit(1)
+}
+
+// valOfZero pushes a zero value of typ, which is a composite literal (eg.
+// T{}) or a conversion (eg. (*T)(nil)).
+func (p *Package) valOfZero(cb *CodeBuilder, typ types.Type) *CodeBuilder {
+	switch getUnderlying(p, typ).(type) {
+	case *types.Struct:
+		return cb.StructLit(typ, 0, false)
+	case *types.Array:
+		return cb.ArrayLit(typ, 0)
+	}
+	return cb.Typ(typ).ZeroLit(typ).Call(1)
+}
+
+// AssertSize declares
+//
+//	const (
+//		_ = uint(unsafe.Sizeof(x) - size)
+//		_ = uint(size - unsafe.Sizeof(x))
+//	)
+//
+// in the package by ConstDefs.NewAssert, where x is the zero value of typ, to
+// assert statically that typ is size bytes in size (eg. on a target platform
+// with other sizes, the generated code doesn't compile). It panics with a
+// *CodeError if the size of typ isn't size already, see Config.Sizes.
+func (p *Package) AssertSize(typ types.Type, size int, src ...ast.Node) {
+	srcExpr := getSrc(src)
+	pos, end := getSrcPos(srcExpr), getSrcEnd(srcExpr)
+	if n := p.sizes.Sizeof(typ); n != int64(size) {
+		p.cb.panicCodeErrorf(pos, end, "size of %v is %d, not %d", typ, n, size)
+	}
+	sizeof := func(cb *CodeBuilder) *CodeBuilder {
+		return p.valOfZero(cb.Val(p.unsafe_.Ref("Sizeof")), typ).Call(1)
+	}
+	p.NewConstDefs(p.Types.Scope()).NewAssert(func(cb *CodeBuilder) {
+		sizeof(cb).Val(size).BinaryOp(token.SUB)
+	}, pos).NewAssert(func(cb *CodeBuilder) {
+		sizeof(cb.Val(size)).BinaryOp(token.SUB)
+	}, pos)
 }
 
 // Builtin returns the buitlin package.
@@ -487,4 +978,24 @@ func (p *Package) ForEachFile(doSth func(fname string, file *File)) {
 	}
 }
 
+// ImportedRefs returns the objects of imported packages referenced by the
+// generated code, as importPath => sorted object names. It inspects the code
+// as it is emitted, so references only in discarded code aren't included.
+func (p *Package) ImportedRefs() map[string][]string {
+	refs := make(map[string]map[string]null)
+	for _, file := range p.files {
+		file.collectImportedRefs(refs)
+	}
+	ret := make(map[string][]string, len(refs))
+	for pkgPath, names := range refs {
+		list := make([]string, 0, len(names))
+		for name := range names {
+			list = append(list, name)
+		}
+		sort.Strings(list)
+		ret[pkgPath] = list
+	}
+	return ret
+}
+
 // ----------------------------------------------------------------------------
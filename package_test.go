@@ -23,9 +23,13 @@ import (
 	"go/token"
 	"go/types"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
 	"syscall"
 	"testing"
 	"unsafe"
@@ -81,6 +85,17 @@ func newPackage(
 	return gogen.NewPackage("", name, conf)
 }
 
+func newGoVersionPackage(goVersion string) *gogen.Package {
+	conf := &gogen.Config{
+		Fset:            gblFset,
+		Importer:        gblImp,
+		NodeInterpreter: nodeInterp{},
+		DbgPositioner:   nodeInterp{},
+		GoVersion:       goVersion,
+	}
+	return gogen.NewPackage("", "main", conf)
+}
+
 func domTest(t *testing.T, pkg *gogen.Package, expected string) {
 	domTestEx(t, pkg, expected, "")
 }
@@ -519,6 +534,122 @@ func main() {
 `)
 }
 
+func TestTypeConvElide(t *testing.T) { // TypeCast
+	conf := &gogen.Config{
+		Fset:             gblFset,
+		Importer:         gblImp,
+		NodeInterpreter:  nodeInterp{},
+		DbgPositioner:    nodeInterp{},
+		ElideConversions: true,
+	}
+	pkg := gogen.NewPackage("", "main", conf)
+	tyInt := types.Typ[types.Int]
+	foo := pkg.NewType("T").InitType(pkg, tyInt)
+	x := pkg.NewParam(token.NoPos, "x", tyInt)
+	s := pkg.NewParam(token.NoPos, "s", types.Typ[types.String])
+	pkg.NewFunc(nil, "main", types.NewTuple(x, s), nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "a").Typ(tyInt).Val(x).Val(1).BinaryOp(token.ADD).Call(1).EndInit(1).
+		DefineVarStart(token.NoPos, "b").Typ(types.Typ[types.String]).Val(s).Call(1).EndInit(1).
+		DefineVarStart(token.NoPos, "c").Typ(types.Typ[types.Float64]).Val(1).Call(1).EndInit(1).
+		DefineVarStart(token.NoPos, "d").Typ(tyInt).Val(2.0).Call(1).EndInit(1).
+		DefineVarStart(token.NoPos, "e").Typ(foo).Val(x).Call(1).EndInit(1).
+		DefineVarStart(token.NoPos, "f").Typ(types.Typ[types.Uint8]).Val(1).Call(1).EndInit(1).
+		DefineVarStart(token.NoPos, "g").Typ(types.Typ[types.Float32]).Val(x).Call(1).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type T int
+
+func main(x int, s string) {
+	a := x + 1
+	b := s
+	c := 1.0
+	d := 2
+	e := T(x)
+	f := uint8(1)
+	g := float32(x)
+}
+`)
+}
+
+func TestTypeConvIntToString(t *testing.T) {
+	var warns []string
+	newPkg := func(elide bool) *gogen.Package {
+		return gogen.NewPackage("", "main", &gogen.Config{
+			Fset:             gblFset,
+			Importer:         gblImp,
+			NodeInterpreter:  nodeInterp{},
+			DbgPositioner:    nodeInterp{},
+			ElideConversions: elide,
+			HandleWarn:       func(err error) { warns = append(warns, err.Error()) },
+		})
+	}
+	tyString := types.Typ[types.String]
+	build := func(pkg *gogen.Package) {
+		x := pkg.NewParam(token.NoPos, "x", types.Typ[types.Int])
+		r := pkg.NewParam(token.NoPos, "r", types.Typ[types.Rune])
+		b := pkg.NewParam(token.NoPos, "b", types.NewSlice(types.Typ[types.Byte]))
+		cb := pkg.NewFunc(nil, "main", types.NewTuple(x, r, b), nil, false).BodyStart(pkg).
+			DefineVarStart(token.NoPos, "a").Typ(tyString).Val(65, source("65", 1, 12)).Call(1)
+		if cval := cb.Get(-1).CVal; cval == nil || constant.StringVal(cval) != "A" {
+			t.Fatal("TestTypeConvIntToString: unexpected constant", cval)
+		}
+		cb.EndInit(1).
+			DefineVarStart(token.NoPos, "c").Typ(tyString).Val(-1, source("-1", 2, 12)).Call(1)
+		if cval := cb.Get(-1).CVal; cval == nil || constant.StringVal(cval) != "\uFFFD" {
+			t.Fatal("TestTypeConvIntToString: unexpected constant", cval)
+		}
+		cb.EndInit(1).
+			DefineVarStart(token.NoPos, "d").Typ(tyString).Val('A').Call(1).EndInit(1).
+			DefineVarStart(token.NoPos, "e").Typ(tyString).Val(r).Call(1).EndInit(1).
+			DefineVarStart(token.NoPos, "f").Typ(tyString).Val(x, source("x", 3, 12)).Call(1)
+		if cval := cb.Get(-1).CVal; cval != nil {
+			t.Fatal("TestTypeConvIntToString: unexpected constant", cval)
+		}
+		cb.EndInit(1).
+			DefineVarStart(token.NoPos, "g").Typ(tyString).Val(b).Call(1).EndInit(1).
+			End()
+	}
+	pkg := newPkg(false)
+	build(pkg)
+	domTest(t, pkg, `package main
+
+func main(x int, r int32, b []uint8) {
+	a := string(65)
+	c := string(-1)
+	d := string('A')
+	e := string(r)
+	f := string(x)
+	g := string(b)
+}
+`)
+	expected := []string{
+		"./foo.gop:1:12: conversion from 65 (type untyped int) to string yields a string of one rune, not a string of digits (did you mean strconv.Itoa(65)?)",
+		"./foo.gop:2:12: conversion from -1 (type untyped int) to string yields a string of one rune, not a string of digits (did you mean strconv.Itoa(-1)?)",
+		"./foo.gop:3:12: conversion from x (type int) to string yields a string of one rune, not a string of digits (did you mean strconv.Itoa(x)?)",
+	}
+	if !reflect.DeepEqual(warns, expected) {
+		t.Fatal("TestTypeConvIntToString:", warns)
+	}
+	warns = nil
+	pkg = newPkg(true)
+	build(pkg)
+	domTest(t, pkg, `package main
+
+func main(x int, r int32, b []uint8) {
+	a := "A"
+	c := "�"
+	d := "A"
+	e := string(r)
+	f := string(x)
+	g := string(b)
+}
+`)
+	if len(warns) != 3 {
+		t.Fatal("TestTypeConvIntToString:", warns)
+	}
+}
+
 func TestTypeConvBool(t *testing.T) { // TypeCast
 	pkg := newMainPackage()
 	tyBool := types.Typ[types.Bool]
@@ -542,6 +673,79 @@ func main() {
 `)
 }
 
+func TestTypeConvSlice(t *testing.T) { // TypeCast
+	pkg := newMainPackage()
+	tyBytes := types.NewSlice(types.Typ[types.Byte])
+	bytes := pkg.NewType("Bytes").InitType(pkg, tyBytes)
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(bytes, "a").
+		NewVarStart(tyBytes, "b").Typ(tyBytes).VarVal("a").Call(1).EndInit(1).
+		NewVarStart(bytes, "c").Typ(bytes).VarVal("b").Call(1).EndInit(1).
+		NewVarStart(tyBytes, "d").Typ(tyBytes).Val("Hi").Call(1).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type Bytes []uint8
+
+func main() {
+	var a Bytes
+	var b []uint8 = []uint8(a)
+	var c Bytes = Bytes(b)
+	var d []uint8 = []uint8("Hi")
+}
+`)
+}
+
+func TestRawString(t *testing.T) {
+	const blob = "nul\x00 `tick` \xff\xfe\r\n\u00e9"
+	pkg := newMainPackage()
+	pkg.CB().NewVarStart(nil, "a", "b", "c").
+		Val("SELECT *\nFROM t\n").RawString().
+		Val("a`b").RawString().
+		Val(blob).RawString().EndInit(3)
+	domTest(t, pkg, `package main
+
+var a, b, c = `+"`SELECT *\nFROM t\n`"+`, "a`+"`"+`b", "nul\x00 `+"`tick`"+` \xff\xfe\r\né"
+`)
+	var buf bytes.Buffer
+	if err := pkg.WriteTo(&buf); err != nil {
+		t.Fatal("WriteTo:", err)
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "foo.go", buf.Bytes(), 0)
+	if err != nil {
+		t.Fatal("parser.ParseFile:", err)
+	}
+	lits := f.Decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Values
+	for i, want := range []string{"SELECT *\nFROM t\n", "a`b", blob} {
+		lit := lits[i].(*ast.BasicLit)
+		if v := constant.StringVal(constant.MakeFromLiteral(lit.Value, lit.Kind, 0)); v != want {
+			t.Fatalf("lit %d: %q, want %q", i, v, want)
+		}
+	}
+}
+
+func TestTypeConvSliceToArray(t *testing.T) { // TypeCast
+	pkg := newGoVersionPackage("go1.20")
+	tyBytes := types.NewSlice(types.Typ[types.Byte])
+	tyArr := types.NewArray(types.Typ[types.Byte], 4)
+	tyPArr := types.NewPointer(tyArr)
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(tyBytes, "s").
+		NewVarStart(tyArr, "a").Typ(tyArr).VarVal("s").Call(1).EndInit(1).
+		NewVarStart(tyPArr, "p").Typ(tyPArr).VarVal("s").Call(1).EndInit(1).
+		NewVarStart(tyBytes, "b").VarVal("a").None().None().Slice(false).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+func main() {
+	var s []uint8
+	var a [4]uint8 = [4]uint8(s)
+	var p *[4]uint8 = (*[4]uint8)(s)
+	var b []uint8 = a[:]
+}
+`)
+}
+
 func TestIncDec(t *testing.T) {
 	pkg := newMainPackage()
 	tyInt := types.Typ[types.Uint]
@@ -623,6 +827,32 @@ func main() {
 `)
 }
 
+func TestRecvBlankValue(t *testing.T) {
+	pkg := newMainPackage()
+	ch := pkg.NewParam(token.NoPos, "ch", types.NewChan(types.RecvOnly, types.Typ[types.Int]))
+	cb := pkg.NewFunc(nil, "drain", types.NewTuple(ch), nil, false).BodyStart(pkg).
+		DefineVarStart(0, "_", "ok").Val(ch).UnaryOp(token.ARROW, true).EndInit(1)
+	if o := cb.Scope().Lookup("ok"); o == nil || o.Type() != types.Typ[types.Bool] {
+		t.Fatal("TestRecvBlankValue: unexpected ok", o)
+	}
+	if o := cb.Scope().Lookup("_"); o != nil {
+		t.Fatal("TestRecvBlankValue: blank identifier declared", o)
+	}
+	cb.For().Val(ctxRef(pkg, "ok")).Then().
+		/**/ VarRef(nil).VarRef(ctxRef(pkg, "ok")).Val(ch).UnaryOp(token.ARROW, true).Assign(2, 1).
+		End().
+		End()
+	domTest(t, pkg, `package main
+
+func drain(ch <-chan int) {
+	_, ok := <-ch
+	for ok {
+		_, ok = <-ch
+	}
+}
+`)
+}
+
 func TestRecv3(t *testing.T) {
 	pkg := newMainPackage()
 	tyUint := pkg.NewType("Uint").InitType(pkg, types.Typ[types.Uint])
@@ -869,6 +1099,73 @@ func (p *foo) Print() {
 `)
 }
 
+func TestReturnEmpty(t *testing.T) {
+	pkg := newMainPackage()
+	x := pkg.NewParam(token.NoPos, "x", types.Typ[types.Bool])
+	pkg.NewFunc(nil, "foo", types.NewTuple(x), nil, false).BodyStart(pkg).
+		If().Val(x).Then().
+		/**/ Return(0).
+		End().
+		End()
+	n := pkg.NewParam(token.NoPos, "n", types.Typ[types.Int])
+	err := pkg.NewParam(token.NoPos, "err", gogen.TyError)
+	pkg.NewFunc(nil, "bar", nil, types.NewTuple(n, err), false).BodyStart(pkg).
+		VarRef(n).Val(1).Assign(1).
+		Return(0).
+		End()
+	domTest(t, pkg, `package main
+
+func foo(x bool) {
+	if x {
+		return
+	}
+}
+func bar() (n int, err error) {
+	n = 1
+	return
+}
+`)
+}
+
+func TestImplementsMidBuild(t *testing.T) {
+	pkg := newMainPackage()
+	writer := pkg.Import("io").Ref("Writer").Type()
+	foo := pkg.NewType("T").InitType(pkg, types.NewStruct(nil, nil))
+	pfoo := types.NewPointer(foo)
+	if pkg.Implements(pfoo, writer) {
+		t.Fatal("TestImplementsMidBuild: *T implements io.Writer without methods")
+	}
+	recv := pkg.NewParam(token.NoPos, "p", pfoo)
+	params := types.NewTuple(pkg.NewParam(token.NoPos, "b", types.NewSlice(types.Typ[types.Byte])))
+	results := types.NewTuple(
+		pkg.NewParam(token.NoPos, "n", types.Typ[types.Int]),
+		pkg.NewParam(token.NoPos, "err", gogen.TyError))
+	write := pkg.NewFunc(recv, "Write", params, results, false)
+	if !pkg.Implements(pfoo, writer) || pkg.Implements(foo, writer) {
+		t.Fatal("TestImplementsMidBuild: method set of *T isn't updated")
+	}
+	if n := types.NewMethodSet(pfoo).Len(); n != 1 {
+		t.Fatal("TestImplementsMidBuild: NewMethodSet(*T).Len() =", n)
+	}
+	if pkg.Implements(foo, types.Typ[types.Int]) {
+		t.Fatal("TestImplementsMidBuild: int isn't an interface")
+	}
+	write.BodyStart(pkg).
+		DefineVarStart(token.NoPos, "w").Val(recv).MemberVal("Write").EndInit(1).
+		Return(0).
+		End()
+	domTest(t, pkg, `package main
+
+type T struct {
+}
+
+func (p *T) Write(b []uint8) (n int, err error) {
+	w := p.Write
+	return
+}
+`)
+}
+
 func TestAssignInterface(t *testing.T) {
 	pkg := newMainPackage()
 	foo := pkg.NewType("foo").InitType(pkg, types.Typ[types.Int])
@@ -946,6 +1243,32 @@ func foo(v interface{}) {
 `)
 }
 
+func TestTypeAssertIfaceMethod(t *testing.T) {
+	pkg := newMainPackage()
+	io := pkg.Import("io")
+	fmt := pkg.Import("fmt")
+	params := types.NewTuple(pkg.NewParam(token.NoPos, "r", io.Ref("Reader").Type()))
+	results := types.NewTuple(pkg.NewParam(token.NoPos, "", gogen.TyError))
+	pkg.NewFunc(nil, "foo", params, results, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).
+		/**/ VarVal("r").TypeAssert(fmt.Ref("Stringer").Type(), false).MemberVal("String").Call(0).
+		/**/ Call(1).EndStmt().
+		VarVal("r").TypeAssert(io.Ref("Closer").Type(), false).MemberVal("Close").Call(0).Return(1).
+		End()
+	domTest(t, pkg, `package main
+
+import (
+	"fmt"
+	"io"
+)
+
+func foo(r io.Reader) error {
+	fmt.Println(r.(fmt.Stringer).String())
+	return r.(io.Closer).Close()
+}
+`)
+}
+
 func newFuncDecl(pkg *gogen.Package, name string, params, results *types.Tuple) *gogen.Func {
 	sig := types.NewSignatureType(nil, nil, nil, params, results, false)
 	return pkg.NewFuncDecl(token.NoPos, name, sig)
@@ -1010,6 +1333,137 @@ func foo(v interface{}) {
 `)
 }
 
+func TestTypeSwitchNoBinding(t *testing.T) {
+	pkg := newMainPackage()
+	v := pkg.NewParam(token.NoPos, "v", gogen.TyEmptyInterface)
+	cb := pkg.NewFunc(nil, "foo", types.NewTuple(v), nil, false).BodyStart(pkg).
+		/**/ TypeSwitch("").Val(v).TypeAssertThen().
+		/****/ TypeCase().Typ(types.Typ[types.Int]).Typ(types.Typ[types.String]).Then()
+	if typ := ctxRef(pkg, "v").Type(); typ != gogen.TyEmptyInterface {
+		t.Fatal("TestTypeSwitchNoBinding: v rebound to", typ)
+	}
+	cb.NewVarStart(nil, "x").Val(ctxRef(pkg, "v")).EndInit(1).
+		/****/ End().
+		/****/ TypeCase().Val(nil).Then().
+		/****/ End().
+		/****/ TypeCase().Then().
+		/****/ End().
+		/**/ End().
+		End()
+	domTest(t, pkg, `package main
+
+func foo(v interface{}) {
+	switch v.(type) {
+	case int, string:
+		var x = v
+	case nil:
+	default:
+	}
+}
+`)
+}
+
+func TestTypeSwitchScope(t *testing.T) {
+	pkg := newMainPackage()
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "x", types.Typ[types.Int], false),
+	}
+	foo := pkg.NewType("T").InitType(pkg, types.NewStruct(fields, nil))
+	v := pkg.NewParam(token.NoPos, "v", gogen.TyEmptyInterface)
+	cb := pkg.NewFunc(nil, "bar", types.NewTuple(v), nil, false).BodyStart(pkg).
+		/**/ TypeSwitch("v").Val(v).TypeAssertThen().
+		/****/ TypeCase().Typ(types.NewPointer(foo)).Then().
+		/******/ DefineVarStart(0, "y").Val(ctxRef(pkg, "v")).MemberVal("x").EndInit(1)
+	if typ := ctxRef(pkg, "v").Type(); !types.Identical(typ, types.NewPointer(foo)) {
+		t.Fatal("TestTypeSwitchScope: case *T =>", typ)
+	}
+	cb.End().
+		/****/ TypeCase().Val(nil).Then()
+	if ctxRef(pkg, "y") != nil {
+		t.Fatal("TestTypeSwitchScope: y leaks to the next case")
+	}
+	if typ := ctxRef(pkg, "v").Type(); typ != gogen.TyEmptyInterface {
+		t.Fatal("TestTypeSwitchScope: case nil =>", typ)
+	}
+	cb.End().
+		/****/ TypeCase().Typ(types.Typ[types.Int]).Typ(types.Typ[types.String]).Then()
+	if typ := ctxRef(pkg, "v").Type(); typ != gogen.TyEmptyInterface {
+		t.Fatal("TestTypeSwitchScope: case int, string =>", typ)
+	}
+	cb.End().
+		/****/ TypeDefaultThen().
+		/******/ NewVarStart(gogen.TyEmptyInterface, "_").Val(ctxRef(pkg, "v")).EndInit(1).
+		/****/ End().
+		/**/ End()
+	if o := ctxRef(pkg, "v"); o != v {
+		t.Fatal("TestTypeSwitchScope: after switch =>", o)
+	}
+	cb.End()
+	if n := len(pkg.Implicits); n != 4 {
+		t.Fatal("TestTypeSwitchScope: len(Implicits) =", n)
+	}
+	for clause, o := range pkg.Implicits {
+		want := gogen.TyEmptyInterface
+		if list := clause.(*ast.CaseClause).List; len(list) == 1 {
+			if _, ok := list[0].(*ast.StarExpr); ok { // case *T
+				want = types.NewPointer(foo)
+			}
+		}
+		if o.Name() != "v" || !types.Identical(o.Type(), want) {
+			t.Fatal("TestTypeSwitchScope: Implicits =>", o)
+		}
+	}
+	domTest(t, pkg, `package main
+
+type T struct {
+	x int
+}
+
+func bar(v interface{}) {
+	switch v := v.(type) {
+	case *T:
+		y := v.x
+	case nil:
+	case int, string:
+	default:
+		var _ interface{} = v
+	}
+}
+`)
+}
+
+func TestSwitchCaseScope(t *testing.T) {
+	pkg := newMainPackage()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(0, "x").Val(1).EndInit(1).
+		/**/ Switch().Val(ctxRef(pkg, "x")).Then().
+		/****/ Case().Val(1).Then().
+		/******/ DefineVarStart(0, "y").Val("a").EndInit(1).
+		/******/ Fallthrough().
+		/****/ End().
+		/****/ Case().Val(2).Then()
+	if ctxRef(pkg, "y") != nil {
+		t.Fatal("TestSwitchCaseScope: y leaks to the next case")
+	}
+	cb.DefineVarStart(0, "y").Val(2).EndInit(1).
+		/****/ End().
+		/**/ End().
+		End()
+	domTest(t, pkg, `package main
+
+func main() {
+	x := 1
+	switch x {
+	case 1:
+		y := "a"
+		fallthrough
+	case 2:
+		y := 2
+	}
+}
+`)
+}
+
 func TestSelect(t *testing.T) {
 	pkg := newMainPackage()
 	tyXchg := types.NewChan(types.SendRecv, types.Typ[types.Int])
@@ -1043,60 +1497,299 @@ func main() {
 `)
 }
 
-func TestStructLit(t *testing.T) {
+func TestSelectEmpty(t *testing.T) {
 	pkg := newMainPackage()
-	fields := []*types.Var{
-		types.NewField(token.NoPos, pkg.Types, "x", types.Typ[types.Int], false),
-		types.NewField(token.NoPos, pkg.Types, "y", types.Typ[types.String], false),
-	}
-	typ := types.NewStruct(fields, nil)
-	pkg.CB().NewVarStart(nil, "a").
-		StructLit(typ, 0, false).EndInit(1)
-	pkg.CB().NewVarStart(nil, "b").
-		Val(123).Val("Hi").
-		StructLit(typ, 2, false).EndInit(1)
-	pkg.CB().NewVarStart(nil, "c").
-		Val(1).Val("abc").
-		StructLit(typ, 2, true).EndInit(1)
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		/**/ Select().
+		/**/ End().
+		End()
 	domTest(t, pkg, `package main
 
-var a = struct {
-	x int
-	y string
-}{}
-var b = struct {
-	x int
-	y string
-}{123, "Hi"}
-var c = struct {
-	x int
-	y string
-}{y: "abc"}
+func main() {
+	select {}
+}
 `)
 }
 
-func TestNamedStructLit(t *testing.T) {
+func TestSelectDefaultOnly(t *testing.T) {
 	pkg := newMainPackage()
-	fields := []*types.Var{
-		types.NewField(token.NoPos, pkg.Types, "x", types.Typ[types.Int], false),
-		types.NewField(token.NoPos, pkg.Types, "y", types.Typ[types.String], false),
-	}
-	typU := types.NewStruct(fields, nil)
-	typ := pkg.NewType("foo").InitType(pkg, typU)
-	bar := pkg.AliasType("bar", typ)
-	pkg.CB().NewVarStart(typ, "a").
-		StructLit(typ, 0, false).EndInit(1)
-	pkg.CB().NewVarStart(types.NewPointer(bar), "b").
-		Val(123).Val("Hi").
-		StructLit(bar, 2, false).
-		UnaryOp(token.AND).
-		EndInit(1)
-	pkg.CB().NewVarStart(bar, "c").
-		Val(1).Val("abc").
-		StructLit(typ, 2, true).EndInit(1)
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		/**/ Select().
+		/****/ CommDefaultThen().
+		/****/ End().
+		/**/ End().
+		End()
 	domTest(t, pkg, `package main
 
-type foo struct {
+func main() {
+	select {
+	default:
+	}
+}
+`)
+}
+
+func TestSelectTimeout(t *testing.T) {
+	pkg := newMainPackage()
+	time := pkg.Import("time")
+	tyCh := types.NewChan(types.SendRecv, types.Typ[types.Int])
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(tyCh, "ch").
+		/**/ Select().
+		/****/ CommCase().DefineVarStart(0, "v").Val(ctxRef(pkg, "ch")).UnaryOp(token.ARROW).EndInit(1).Then().
+		/******/ VarRef(nil).Val(ctxRef(pkg, "v")).Assign(1).
+		/****/ End().
+		/****/ CommCase().Val(time.Ref("After")).Val(time.Ref("Second")).Call(1).UnaryOp(token.ARROW).EndStmt().Then().
+		/******/ Return(0).
+		/****/ End().
+		/****/ CommDefaultThen().
+		/****/ End().
+		/**/ End().
+		End()
+	domTest(t, pkg, `package main
+
+import "time"
+
+func main() {
+	var ch chan int
+	select {
+	case v := <-ch:
+		_ = v
+	case <-time.After(time.Second):
+		return
+	default:
+	}
+}
+`)
+}
+
+func TestSelectTimeoutHelper(t *testing.T) {
+	pkg := newMainPackage()
+	time := pkg.Import("time")
+	context := pkg.Import("context")
+	tyCh := types.NewChan(types.RecvOnly, types.Typ[types.String])
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(tyCh, "ch").
+		NewVar(context.Ref("Context").Type(), "ctx").
+		DefineVarStart(0, "v", "timeout").
+		Val(ctxRef(pkg, "ch")).Val(time.Ref("Second")).
+		SelectTimeout(gogen.SelectTimeoutValue | gogen.SelectTimeoutTimedOut).
+		EndInit(2).
+		VarRef(ctxRef(pkg, "v")).VarRef(ctxRef(pkg, "timeout")).
+		Val(ctxRef(pkg, "ch")).Val(time.Ref("Second")).Val(ctxRef(pkg, "ctx")).
+		SelectTimeout(gogen.SelectTimeoutValue | gogen.SelectTimeoutTimedOut | gogen.SelectTimeoutCtx).
+		Assign(2).
+		VarRef(ctxRef(pkg, "timeout")).
+		Val(ctxRef(pkg, "ch")).Val(time.Ref("Second")).SelectTimeout(gogen.SelectTimeoutTimedOut).
+		Assign(1).
+		Val(ctxRef(pkg, "ch")).Val(time.Ref("Second")).Val(ctxRef(pkg, "ctx")).
+		SelectTimeout(gogen.SelectTimeoutCtx).
+		End()
+	domTest(t, pkg, `package main
+
+import (
+	"context"
+	"time"
+)
+
+func main() {
+	var ch <-chan string
+	var ctx context.Context
+	var _autoGo_1 string
+	var _autoGo_2 bool
+	select {
+	case _autoGo_1 = <-ch:
+	case <-time.After(time.Second):
+		_autoGo_2 = true
+	}
+	v, timeout := _autoGo_1, _autoGo_2
+	var _autoGo_3 string
+	var _autoGo_4 bool
+	select {
+	case _autoGo_3 = <-ch:
+	case <-time.After(time.Second):
+		_autoGo_4 = true
+	case <-ctx.Done():
+		_autoGo_4 = true
+	}
+	v, timeout = _autoGo_3, _autoGo_4
+	var _autoGo_5 bool
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		_autoGo_5 = true
+	}
+	timeout = _autoGo_5
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+	case <-ctx.Done():
+	}
+}
+`)
+}
+
+func TestErrorsNewAndErrorf(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(0, "err").ErrorsNew("not found").EndInit(1).
+		NewVar(types.Typ[types.String], "format").
+		VarRef(ctxRef(pkg, "err")).
+		Val("open %s: %w").Val("a.txt").Val(ctxRef(pkg, "err")).Errorf(3).
+		Assign(1).
+		VarRef(ctxRef(pkg, "err")).
+		Val("%[2]w: %[1]*d %%w").Val(8).Val(ctxRef(pkg, "err")).Val(1).Errorf(4).
+		Assign(1).
+		VarRef(ctxRef(pkg, "err")).
+		Val(ctxRef(pkg, "format")).Val(1).Errorf(2).
+		Assign(1).
+		End()
+	domTest(t, pkg, `package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func main() {
+	err := errors.New("not found")
+	var format string
+	err = fmt.Errorf("open %s: %w", "a.txt", err)
+	err = fmt.Errorf("%[2]w: %[1]*d %%w", 8, err, 1)
+	err = fmt.Errorf(format, 1)
+}
+`)
+}
+
+func TestErrorfMultiWrap(t *testing.T) {
+	pkg := newGoVersionPackage("go1.20")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(gogen.TyError, "a", "b").
+		Val("%w, %w").Val(ctxRef(pkg, "a")).Val(ctxRef(pkg, "b")).Errorf(3).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+func main() {
+	var a, b error
+	fmt.Errorf("%w, %w", a, b)
+}
+`)
+}
+
+func TestValLiteral(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.CB().NewConstStart(nil, "a").
+		Val(&gogen.Literal{Kind: token.INT, Text: "0xFF", Value: constant.MakeInt64(255)}).
+		Val(&gogen.Literal{Kind: token.INT, Text: "1_000_000"}).BinaryOp(token.ADD).EndInit(1)
+	pkg.CB().NewConstStart(nil, "b", "c", "d", "e").
+		Val(&gogen.Literal{Kind: token.FLOAT, Text: "1e9", Value: constant.MakeInt64(1e9)}).
+		Val(&gogen.Literal{Kind: token.INT, Text: "0o755"}).
+		Val(&gogen.Literal{Kind: token.CHAR, Text: `'\n'`, Value: constant.MakeInt64('\n')}).
+		Val(&ast.BasicLit{Kind: token.CHAR, Value: `'\u00e9'`}).EndInit(4)
+	domTest(t, pkg, `package main
+
+const a = 0xFF + 1_000_000
+const b, c, d, e = 1e9, 0o755, '\n', '\u00e9'
+`)
+	scope := pkg.Types.Scope()
+	if v := scope.Lookup("a").(*types.Const).Val(); v.String() != "1000255" {
+		t.Fatal("a =", v)
+	}
+	if v := scope.Lookup("e").(*types.Const).Val(); v.String() != "233" {
+		t.Fatal("e =", v)
+	}
+}
+
+func TestStructLit(t *testing.T) {
+	pkg := newMainPackage()
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "x", types.Typ[types.Int], false),
+		types.NewField(token.NoPos, pkg.Types, "y", types.Typ[types.String], false),
+	}
+	typ := types.NewStruct(fields, nil)
+	pkg.CB().NewVarStart(nil, "a").
+		StructLit(typ, 0, false).EndInit(1)
+	pkg.CB().NewVarStart(nil, "b").
+		Val(123).Val("Hi").
+		StructLit(typ, 2, false).EndInit(1)
+	pkg.CB().NewVarStart(nil, "c").
+		Val(1).Val("abc").
+		StructLit(typ, 2, true).EndInit(1)
+	domTest(t, pkg, `package main
+
+var a = struct {
+	x int
+	y string
+}{}
+var b = struct {
+	x int
+	y string
+}{123, "Hi"}
+var c = struct {
+	x int
+	y string
+}{y: "abc"}
+`)
+}
+
+func TestStructLitFuncField(t *testing.T) {
+	pkg := newMainPackage()
+	tyFn := types.NewSignatureType(nil, nil, nil, types.NewTuple(pkg.NewParam(token.NoPos, "s", types.Typ[types.String])), nil, false)
+	typ := pkg.NewType("Handler").InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "name", types.Typ[types.String], false),
+		types.NewField(token.NoPos, pkg.Types, "fn", tyFn, false),
+	}, nil))
+	s := pkg.NewParam(token.NoPos, "s", types.Typ[types.String])
+	pkg.CB().NewVarStart(nil, "a").
+		Val(1).
+		NewClosure(types.NewTuple(s), nil, false).BodyStart(pkg).
+		Val(pkg.Builtin().Ref("println")).Val(s).Call(1).EndStmt().
+		End().
+		StructLit(typ, 2, true).EndInit(1)
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(ctxRef(pkg, "a")).MemberVal("fn").Val("Hi").Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+type Handler struct {
+	name string
+	fn   func(s string)
+}
+
+var a = Handler{fn: func(s string) {
+	println(s)
+}}
+
+func main() {
+	a.fn("Hi")
+}
+`)
+}
+
+func TestNamedStructLit(t *testing.T) {
+	pkg := newMainPackage()
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "x", types.Typ[types.Int], false),
+		types.NewField(token.NoPos, pkg.Types, "y", types.Typ[types.String], false),
+	}
+	typU := types.NewStruct(fields, nil)
+	typ := pkg.NewType("foo").InitType(pkg, typU)
+	bar := pkg.AliasType("bar", typ)
+	pkg.CB().NewVarStart(typ, "a").
+		StructLit(typ, 0, false).EndInit(1)
+	pkg.CB().NewVarStart(types.NewPointer(bar), "b").
+		Val(123).Val("Hi").
+		StructLit(bar, 2, false).
+		UnaryOp(token.AND).
+		EndInit(1)
+	pkg.CB().NewVarStart(bar, "c").
+		Val(1).Val("abc").
+		StructLit(typ, 2, true).EndInit(1)
+	domTest(t, pkg, `package main
+
+type foo struct {
 	x int
 	y string
 }
@@ -1128,6 +1821,29 @@ var d = map[int]bool{1: true}
 `)
 }
 
+func TestMapLitConstKeys(t *testing.T) {
+	pkg := newMainPackage()
+	key := pkg.NewType("Key").InitType(pkg, types.Typ[types.String])
+	x := pkg.NewParam(token.NoPos, "x", types.Typ[types.Uint8])
+	pkg.NewFunc(nil, "main", types.NewTuple(x), nil, false).BodyStart(pkg).
+		NewVarStart(nil, "a").
+		Val(1).Val(1).Val(255).Val(2).Val(x).Val(3).Val(x).Val(4).
+		MapLit(types.NewMap(types.Typ[types.Uint8], types.Typ[types.Int]), 8).EndInit(1).
+		NewVarStart(nil, "b").
+		Val("a").Val(true).Val("b").Val(false).
+		MapLit(types.NewMap(key, types.Typ[types.Bool]), 4).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type Key string
+
+func main(x uint8) {
+	var a = map[uint8]int{1: 1, 255: 2, x: 3, x: 4}
+	var b = map[Key]bool{"a": true, "b": false}
+}
+`)
+}
+
 func TestNamedMapLit(t *testing.T) {
 	pkg := newMainPackage()
 	foo := pkg.NewType("foo").InitType(pkg, types.NewMap(types.Typ[types.Int], types.Typ[types.Bool]))
@@ -1148,6 +1864,29 @@ var b bar = bar{1: true}
 `)
 }
 
+func TestForeignNamedLit(t *testing.T) {
+	pkg := newMainPackage()
+	url := pkg.Import("net/url")
+	sort := pkg.Import("sort")
+	values := url.Ref("Values").Type()
+	strs := sort.Ref("StringSlice").Type()
+	pkg.CB().NewVarStart(nil, "a").
+		Val("k").Val("v").SliceLit(types.NewSlice(types.Typ[types.String]), 1).
+		MapLit(values, 2).EndInit(1)
+	pkg.CB().NewVarStart(nil, "b").
+		Val("x").Val("y").SliceLit(strs, 2).EndInit(1)
+	domTest(t, pkg, `package main
+
+import (
+	"net/url"
+	"sort"
+)
+
+var a = url.Values{"k": []string{"v"}}
+var b = sort.StringSlice{"x", "y"}
+`)
+}
+
 func TestSliceLit(t *testing.T) {
 	pkg := newMainPackage()
 	pkg.CB().NewVarStart(nil, "a").
@@ -1188,6 +1927,203 @@ var b bar = bar{1}
 `)
 }
 
+func TestFloatRoundTrip(t *testing.T) {
+	pkg := newMainPackage()
+	cb := pkg.CB()
+	for _, v := range []float64{
+		0.1, 1e-323, 5e-324, 2.2250738585072014e-308, math.MaxFloat64, 1.0 / 3, 1e21, 123456789.123, -2.5e-8,
+	} {
+		e := cb.Val(v).Get(-1)
+		lit, ok := e.Val.(*ast.BasicLit)
+		if !ok {
+			t.Fatal("TestFloatRoundTrip:", v, e.Val)
+		}
+		f, err := strconv.ParseFloat(lit.Value, 64)
+		if err != nil || math.Float64bits(f) != math.Float64bits(v) {
+			t.Fatal("TestFloatRoundTrip:", v, lit.Value, err)
+		}
+		cb.ResetStmt()
+	}
+	for _, c := range []struct {
+		v    float64
+		want string
+	}{
+		{math.NaN(), "math.NaN()"},
+		{math.Inf(1), "math.Inf(1)"},
+		{math.Inf(-1), "math.Inf(-1)"},
+		{math.Copysign(0, -1), "math.Copysign(0, -1)"},
+	} {
+		e := cb.Val(c.v).Get(-1)
+		if ret := types.ExprString(e.Val); ret != c.want || e.CVal != nil {
+			t.Fatal("TestFloatRoundTrip:", c.v, ret)
+		}
+		cb.ResetStmt()
+	}
+	for _, c := range []struct {
+		v    interface{}
+		want string
+	}{
+		{complex(math.NaN(), 1), "complex(math.NaN(), 1.0)"},
+		{complex(2, math.Inf(-1)), "complex(2.0, math.Inf(-1))"},
+		{complex64(complex(math.Inf(1), 0)), "complex64(complex(math.Inf(1), 0.0))"},
+	} {
+		e := cb.Val(c.v).Get(-1)
+		if ret := types.ExprString(e.Val); ret != c.want || e.CVal != nil {
+			t.Fatal("TestFloatRoundTrip:", c.v, ret)
+		}
+		cb.ResetStmt()
+	}
+	pkg.CB().NewVarStart(nil, "a").Val(math.NaN()).EndInit(1)
+	pkg.CB().NewVarStart(nil, "b").
+		Val(constant.MakeFromLiteral("0.1000000000000000000000000000001", token.FLOAT, 0)).EndInit(1)
+	pkg.CB().NewVarStart(nil, "c").
+		Val(constant.BinaryOp(constant.MakeFloat64(1.5), token.ADD, constant.MakeImag(constant.MakeInt64(2)))).EndInit(1)
+	pkg.CB().NewVarStart(nil, "d").
+		SliceLitFromConsts(types.NewSlice(types.Typ[types.Float32]), []constant.Value{
+			constant.BinaryOp(constant.MakeInt64(1), token.QUO, constant.MakeInt64(3)),
+		}).EndInit(1)
+	domTest(t, pkg, `package main
+
+import "math"
+
+var a = math.NaN()
+var b = 0.1000000000000000000000000000001
+var c = 1.5 + 2i
+var d = []float32{0.33333334}
+`)
+}
+
+func TestLitFromConsts(t *testing.T) {
+	pkg := newMainPackage()
+	kind := pkg.NewType("Kind").InitType(pkg, types.Typ[types.Uint16])
+	pkg.CB().NewVarStart(nil, "a").
+		SliceLitFromConsts(types.NewSlice(kind), []constant.Value{
+			constant.MakeInt64(1), constant.MakeInt64(0xffff), constant.MakeFloat64(3),
+		}).EndInit(1)
+	pkg.CB().NewVarStart(nil, "b").
+		SliceLitFromConsts(types.NewSlice(types.Typ[types.Float64]), []constant.Value{
+			constant.MakeInt64(1), constant.MakeFloat64(1.5),
+		}).EndInit(1)
+	pkg.CB().NewVarStart(nil, "c").
+		MapLitFromConsts(types.NewMap(types.Typ[types.String], types.Typ[types.Bool]), []constant.Value{
+			constant.MakeString("x"), constant.MakeString("y"),
+		}, []constant.Value{
+			constant.MakeBool(true), constant.MakeBool(false),
+		}).EndInit(1)
+	domTest(t, pkg, `package main
+
+type Kind uint16
+
+var a = []Kind{1, 65535, 3}
+var b = []float64{1.0, 1.5}
+var c = map[string]bool{"x": true, "y": false}
+`)
+}
+
+func TestSliceConcat(t *testing.T) {
+	pkg := newMainPackage()
+	tyInts := types.NewSlice(types.Typ[types.Int])
+	ids := pkg.NewType("IDs").InitType(pkg, tyInts)
+	a := pkg.NewParam(token.NoPos, "a", tyInts)
+	b := pkg.NewParam(token.NoPos, "b", tyInts)
+	x := pkg.NewParam(token.NoPos, "x", ids)
+	pkg.NewFunc(nil, "main", types.NewTuple(a, b, x), nil, false).BodyStart(pkg).
+		NewVarStart(nil, "c").Val(a).Val(b).SliceConcat().EndInit(1).
+		NewVarStart(nil, "d").Val(x).Val(x).SliceConcat().EndInit(1).
+		NewVarStart(nil, "e").Val(a).Val(b).SliceConcat().SliceLit(tyInts, 0).SliceConcat().EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type IDs []int
+
+func main(a []int, b []int, x IDs) {
+	var c = append(append([]int{}, a...), b...)
+	var d = append(append(IDs{}, x...), x...)
+	var e = append(append([]int{}, append(append([]int{}, a...), b...)...), []int{}...)
+}
+`)
+}
+
+func TestMapLitFromMap(t *testing.T) {
+	pkg := newMainPackage()
+	m := map[int8]string{3: "c", -1: "a", 2: "b", 0: "z"}
+	for i := 0; i < 10; i++ { // the order must not depend on map iteration
+		pkg.CB().NewVarStart(nil, "a"+strconv.Itoa(i)).
+			MapLitFromMap(types.NewMap(types.Typ[types.Int8], types.Typ[types.String]), m).
+			EndInit(1)
+	}
+	pkg.CB().NewVarStart(nil, "b").
+		MapLitFromMap(types.NewMap(gogen.TyEmptyInterface, types.Typ[types.Int]), map[interface{}]int{
+			"y": 1, 2.5: 2, true: 3, uint(7): 4, "x": 5, false: 6,
+		}).EndInit(1)
+	var buf bytes.Buffer
+	if err := gogen.WriteTo(&buf, pkg, ""); err != nil {
+		t.Fatal("gogen.WriteTo failed:", err)
+	}
+	for i, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "var a") &&
+			!strings.HasSuffix(line, `= map[int8]string{-1: "a", 0: "z", 2: "b", 3: "c"}`) {
+			t.Fatal("line", i, ":", line)
+		}
+	}
+	if !strings.Contains(buf.String(), `var b = map[interface{}]int{false: 6, true: 3, uint(7): 4, 2.5: 2, "x": 5, "y": 1}`) {
+		t.Fatal("TestMapLitFromMap:", buf.String())
+	}
+
+	pkg = gogen.NewPackage("", "main", &gogen.Config{
+		MapKeyLess: func(x, y interface{}) bool {
+			return x.(string) > y.(string)
+		},
+	})
+	pkg.CB().NewVarStart(nil, "c").
+		MapLitFromMap(types.NewMap(types.Typ[types.String], types.Typ[types.Int]), map[string]int{
+			"a": 1, "b": 2, "c": 3,
+		}).EndInit(1)
+	domTest(t, pkg, `package main
+
+var c = map[string]int{"c": 3, "b": 2, "a": 1}
+`)
+}
+
+const benchTableSize = 100000
+
+func benchTable() []constant.Value {
+	values := make([]constant.Value, benchTableSize)
+	for i := range values {
+		values[i] = constant.MakeInt64(int64(i & 0xffff))
+	}
+	return values
+}
+
+func BenchmarkSliceLitFromConsts(b *testing.B) {
+	values := benchTable()
+	tySlice := types.NewSlice(types.Typ[types.Uint16])
+	gogen.SetDebug(0)
+	defer gogen.SetDebug(gogen.DbgFlagAll)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pkg := newMainPackage()
+		pkg.CB().NewVarStart(nil, "a").
+			SliceLitFromConsts(tySlice, values).EndInit(1)
+	}
+}
+
+func BenchmarkSliceLitByElem(b *testing.B) {
+	values := benchTable()
+	tySlice := types.NewSlice(types.Typ[types.Uint16])
+	gogen.SetDebug(0)
+	defer gogen.SetDebug(gogen.DbgFlagAll)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pkg := newMainPackage()
+		cb := pkg.CB().NewVarStart(nil, "a")
+		for _, v := range values {
+			cb.Val(&gogen.Literal{Kind: token.INT, Text: v.ExactString(), Value: v})
+		}
+		cb.SliceLit(tySlice, len(values)).EndInit(1)
+	}
+}
+
 func TestKeyValModeLit(t *testing.T) {
 	pkg := newMainPackage()
 	pkg.CB().NewVarStart(nil, "a").
@@ -1237,6 +2173,27 @@ var c = [10]interface{}{}
 `)
 }
 
+func TestArrayTypeConstLen(t *testing.T) {
+	pkg := newMainPackage()
+	cb := pkg.CB()
+	cb.NewConstStart(nil, "N").Val(4).EndInit(1)
+	n := pkg.Types.Scope().Lookup("N")
+	a := cb.Val(n).ArrayType(types.Typ[types.Int])
+	cb.NewVar(a, "a")
+	b := cb.Val(n).Val(2).BinaryOp(token.MUL).ArrayType(types.Typ[types.Byte])
+	cb.NewVarStart(nil, "b").Typ(b).Call(0).EndInit(1)
+	if a.Len() != 4 || b.Len() != 8 {
+		t.Fatal("TestArrayTypeConstLen:", a.Len(), b.Len())
+	}
+	domTest(t, pkg, `package main
+
+const N = 4
+
+var a [N]int
+var b = [N * 2]uint8{}
+`)
+}
+
 func TestBlockStmt(t *testing.T) {
 	pkg := newMainPackage()
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
@@ -1557,11 +2514,86 @@ func main() {
 `)
 }
 
-func TestFuncDoc2(t *testing.T) {
+func TestFuncDirectives(t *testing.T) {
 	pkg := newMainPackage()
-	fn := pkg.NewFunc(nil, "main", nil, nil, false).SetComments(pkg, comment("\n/*\n doc\n*/"))
+	pkg.NewFunc(nil, "foo", nil, nil, false).BodyStart(pkg).End()
+	pkg.NewFunc(nil, "bar", nil, nil, false).AddDirective("go:noinline").BodyStart(pkg).End()
+	fn := pkg.NewFunc(nil, "main", nil, nil, false).
+		AddDirective("go:noinline").
+		AddDirective("//go:nosplit").
+		SetComments(pkg, comment("\n// main is the entry."))
 	fn.BodyStart(pkg).End()
-	if fn.Comments() == nil {
+	if doc := fn.Comments(); doc == nil || len(doc.List) != 3 {
+		t.Fatal("TestFuncDirectives:", doc)
+	}
+	domTest(t, pkg, `package main
+
+func foo() {
+}
+
+//go:noinline
+func bar() {
+}
+
+// main is the entry.
+//go:noinline
+//go:nosplit
+func main() {
+}
+`)
+}
+
+func TestFuncStats(t *testing.T) {
+	pkg := newMainPackage()
+	foo := pkg.NewFunc(nil, "foo", nil, nil, false)
+	foo.BodyStart(pkg).End()
+	main := pkg.NewFunc(nil, "main", nil, nil, false)
+	cb := main.BodyStart(pkg).
+		If().Val(true).Then().
+		/**/ If().Val(false).Then().
+		/**/ /**/ Val(pkg.Builtin().Ref("println")).Val(1).Call(1).EndStmt().
+		/**/ End().
+		End()
+	closure := cb.NewClosure(nil, nil, false)
+	closure.BodyStart(pkg).
+		Val(pkg.Builtin().Ref("println")).Val(2).Val(3).BinaryOp(token.ADD).Call(1).EndStmt().
+		End().
+		Call(0).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+func foo() {
+}
+func main() {
+	if true {
+		if false {
+			println(1)
+		}
+	}
+	func() {
+		println(2 + 3)
+	}()
+}
+`)
+	if s := foo.Stats(); s != (gogen.FuncStats{MaxDepth: 1}) {
+		t.Fatal("foo.Stats:", s)
+	}
+	if s := closure.Stats(); s != (gogen.FuncStats{Stmts: 1, Exprs: 5, MaxDepth: 1}) {
+		t.Fatal("closure.Stats:", s)
+	}
+	if s := main.Stats(); s != (gogen.FuncStats{Stmts: 5, Exprs: 12, MaxDepth: 5}) {
+		t.Fatal("main.Stats:", s)
+	}
+	if s := pkg.Stats(); s != main.Stats() {
+		t.Fatal("pkg.Stats:", s)
+	}
+}
+
+func TestFuncDoc2(t *testing.T) {
+	pkg := newMainPackage()
+	fn := pkg.NewFunc(nil, "main", nil, nil, false).SetComments(pkg, comment("\n/*\n doc\n*/"))
+	fn.BodyStart(pkg).End()
+	if fn.Comments() == nil {
 		t.Fatal("TestFuncDoc2 failed: no doc?")
 	}
 	domTest(t, pkg, `package main
@@ -1946,6 +2978,67 @@ const c6 = unsafe.Offsetof(t.n)
 	}
 }
 
+func TestTargetSizes(t *testing.T) {
+	newPkg := func(goarch string) *gogen.Package {
+		return gogen.NewPackage("", "main", &gogen.Config{Importer: gblImp, GOARCH: goarch})
+	}
+	pkg64, pkg32 := newPkg("amd64"), newPkg("386")
+	for _, c := range []struct {
+		pkg                 *gogen.Package
+		size, align, offset int64
+	}{
+		{pkg64, 16, 8, 8},
+		{pkg32, 12, 4, 4},
+	} {
+		pkg := c.pkg
+		fields := []*types.Var{
+			types.NewField(token.NoPos, pkg.Types, "a", types.Typ[types.Int8], false),
+			types.NewField(token.NoPos, pkg.Types, "b", types.Typ[types.Int64], false),
+		}
+		typ := pkg.NewType("T").InitType(pkg, types.NewStruct(fields, nil))
+		if n := pkg.Sizeof(typ); n != c.size {
+			t.Fatal("pkg.Sizeof:", n, c.size)
+		}
+		if off := pkg.Offsetsof(fields); off[1] != c.offset {
+			t.Fatal("pkg.Offsetsof:", off, c.offset)
+		}
+		unsafe := pkg.Unsafe()
+		cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+			NewVar(typ, "v")
+		for i, want := range []int64{c.size, c.align, c.offset} {
+			switch i {
+			case 0:
+				cb.Val(unsafe.Ref("Sizeof")).VarVal("v").Call(1)
+			case 1:
+				cb.Val(unsafe.Ref("Alignof")).VarVal("v").MemberVal("b").Call(1)
+			default:
+				cb.Val(unsafe.Ref("Offsetof")).VarVal("v").MemberVal("b").Call(1)
+			}
+			if v, _ := constant.Int64Val(cb.Get(-1).CVal); v != want {
+				t.Fatal("TestTargetSizes:", v, want)
+			}
+			cb.EndStmt()
+		}
+		cb.End()
+	}
+
+	err := func() (err error) {
+		defer func() {
+			err, _ = recover().(error)
+		}()
+		pkg32.NewFunc(nil, "f", nil, nil, false).BodyStart(pkg32).
+			NewVarStart(types.Typ[types.Uint], "u").Val(1).Val(40).BinaryOp(token.SHL).EndInit(1).
+			End()
+		return
+	}()
+	if err == nil || !strings.Contains(err.Error(), "overflows") {
+		t.Fatal("TestTargetSizes: 1<<40 overflows uint on 386 -", err)
+	}
+	pkg64.NewFunc(nil, "f", nil, nil, false).BodyStart(pkg64).
+		NewVarStart(types.Typ[types.Uint], "u").Val(1).Val(40).BinaryOp(token.SHL).EndInit(1).
+		End()
+}
+
 func TestEmptyInterface(t *testing.T) {
 	pkg := newMainPackage()
 	v := pkg.NewParam(token.NoPos, "v", types.NewSlice(gogen.TyEmptyInterface))
@@ -2027,6 +3120,83 @@ func main() {
 `)
 }
 
+func TestFuncCallVariadicIface(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	tyArgs := types.NewSlice(gogen.TyEmptyInterface)
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(tyArgs, "args").
+		NewVar(types.NewPointer(types.Typ[types.Int]), "p").
+		VarRef(nil).Val(fmt.Ref("Sprintf")).Val("%d %v").VarVal("args").CallWith(2, gogen.InstrFlagEllipsis).Assign(1).
+		VarRef(nil).Val(fmt.Ref("Sprintf")).Val("%d %s %v %v %v").
+		Val(1).Val("x").VarVal("args").VarVal("p").Val(nil).CallWith(6, 0).Assign(1).
+		End()
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+func main() {
+	var args []interface{}
+	var p *int
+	_ = fmt.Sprintf("%d %v", args...)
+	_ = fmt.Sprintf("%d %s %v %v %v", 1, "x", args, p, nil)
+}
+`)
+}
+
+func newOverloadByResults(byResults bool) *gogen.Package {
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		Fset: gblFset, Importer: gblImp, OverloadByResults: byResults,
+	})
+	tyInt := types.Typ[types.Int]
+	n := pkg.NewParam(token.NoPos, "", tyInt)
+	err := pkg.NewParam(token.NoPos, "", gogen.TyError)
+	foo0 := pkg.NewFunc(nil, "Foo__0", nil, types.NewTuple(n), false)
+	foo0.BodyStart(pkg).Val(0).Return(1).End()
+	foo1 := pkg.NewFunc(nil, "Foo__1", nil, types.NewTuple(n, err), false)
+	foo1.BodyStart(pkg).Val(0).Val(nil).Return(2).End()
+	pkg.Types.Scope().Insert(gogen.NewOverloadFunc(token.NoPos, pkg.Types, "Foo", foo0.Obj(), foo1.Obj()))
+	return pkg
+}
+
+func TestOverloadByResults(t *testing.T) {
+	pkg := newOverloadByResults(true)
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "a", "err").Val(ctxRef(pkg, "Foo")).Call(0).EndInit(1).
+		DefineVarStart(token.NoPos, "b").Val(ctxRef(pkg, "Foo")).Call(0).EndInit(1).
+		DefineVarStart(token.NoPos, "c", "d").Val(ctxRef(pkg, "Foo")).Call(0).Val(1).EndInit(2)
+	cb.VarRef(ctxRef(pkg, "b")).VarRef(ctxRef(pkg, "err")).Val(ctxRef(pkg, "Foo")).Call(0).Assign(2, 1).
+		VarRef(ctxRef(pkg, "a")).Val(ctxRef(pkg, "Foo")).Call(0).Val(1).BinaryOp(token.ADD).Assign(1).
+		End()
+	domTest(t, pkg, `package main
+
+func Foo__0() int {
+	return 0
+}
+func Foo__1() (int, error) {
+	return 0, nil
+}
+func main() {
+	a, err := Foo__1()
+	b := Foo__0()
+	c, d := Foo__0(), 1
+	b, err = Foo__1()
+	a = Foo__0() + 1
+}
+`)
+}
+
+func TestOverloadByResultsOff(t *testing.T) {
+	pkg := newOverloadByResults(false)
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatal("TestOverloadByResultsOff: no error")
+		}
+	}()
+	cb.DefineVarStart(token.NoPos, "a", "err").Val(ctxRef(pkg, "Foo")).Call(0).EndInit(1)
+}
+
 func TestDelayedLoadUnused(t *testing.T) {
 	pkg := newMainPackage()
 	println := gogen.NewOverloadFunc(token.NoPos, pkg.Types, "println", pkg.Import("fmt").Ref("Println"))
@@ -2115,6 +3285,51 @@ func main() {
 `)
 }
 
+func TestIfTypeAssert(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	x := pkg.NewParam(token.NoPos, "x", gogen.TyEmptyInterface)
+	ok := pkg.NewParam(token.NoPos, "ok", types.Typ[types.Bool])
+	cb := pkg.NewFunc(nil, "foo", types.NewTuple(x, ok), nil, false).BodyStart(pkg).
+		/**/ Val(x).IfTypeAssert("v", types.Typ[types.Int]).
+		/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "v")).Val(ctxRef(pkg, "ok")).Call(2).EndStmt()
+	if typ := ctxRef(pkg, "v").Type(); typ != types.Typ[types.Int] {
+		t.Fatal("TestIfTypeAssert: v =>", typ)
+	}
+	if o := ctxRef(pkg, "ok"); o != ok {
+		t.Fatal("TestIfTypeAssert: ok =>", o)
+	}
+	cb.Else().Val(x).IfTypeAssert("s", types.Typ[types.String])
+	if typ := ctxRef(pkg, "s").Type(); typ != types.Typ[types.String] {
+		t.Fatal("TestIfTypeAssert: s =>", typ)
+	}
+	cb.
+		/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "s")).Call(1).EndStmt().
+		/****/ Else().
+		/******/ Val(fmt.Ref("Println")).Val(x).Call(1).EndStmt().
+		/****/ End().
+		/**/ End().
+		/**/ Val(x).IfTypeAssert("", types.Typ[types.Bool]).
+		/**/ End().
+		End()
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+func foo(x interface{}, ok bool) {
+	if v, ok1 := x.(int); ok1 {
+		fmt.Println(v, ok)
+	} else if s, ok1 := x.(string); ok1 {
+		fmt.Println(s)
+	} else {
+		fmt.Println(x)
+	}
+	if _, ok1 := x.(bool); ok1 {
+	}
+}
+`)
+}
+
 func TestGoto(t *testing.T) {
 	pkg := newMainPackage()
 	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
@@ -2149,21 +3364,132 @@ retry2:
 `)
 }
 
-func TestBreakContinue(t *testing.T) { // TODO: check invalid syntax
+func TestBreakContinue(t *testing.T) {
 	pkg := newMainPackage()
 	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
 	l := cb.NewLabel(token.NoPos, token.NoPos, "retry")
-	cb.Label(l).Break(nil).Continue(nil).
+	cb.Label(l).For().None().Then().
+		Break(nil).Continue(nil).
 		Break(l).Continue(l).
+		End().
 		End()
 	domTest(t, pkg, `package main
 
 func main() {
 retry:
-	break
-	continue
-	break retry
-	continue retry
+	for {
+		break
+		continue
+		break retry
+		continue retry
+	}
+}
+`)
+}
+
+func TestBreakFromSwitchSelect(t *testing.T) {
+	pkg := newMainPackage()
+	tyCh := types.NewChan(types.SendRecv, types.Typ[types.Int])
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(tyCh, "ch")
+	loop := cb.NewLabel(token.NoPos, token.NoPos, "loop")
+	sw := cb.NewLabel(token.NoPos, token.NoPos, "sw")
+	cb.Label(loop).For().None().Then().
+		/**/ Label(sw).Switch().Val(1).Then().
+		/****/ Case().Val(1).Then().
+		/******/ Select().
+		/********/ CommCase().Val(ctxRef(pkg, "ch")).UnaryOp(token.ARROW).EndStmt().Then().
+		/**********/ Break(loop).
+		/********/ End().
+		/********/ CommDefaultThen().
+		/**********/ Break(sw).
+		/********/ End().
+		/******/ End().
+		/******/ Continue(loop).
+		/****/ End().
+		/**/ End().
+		End().
+		End()
+	domTest(t, pkg, `package main
+
+func main() {
+	var ch chan int
+loop:
+	for {
+	sw:
+		switch 1 {
+		case 1:
+			select {
+			case <-ch:
+				break loop
+			default:
+				break sw
+			}
+			continue loop
+		}
+	}
+}
+`)
+}
+
+func TestLabeledRangeSelect(t *testing.T) {
+	pkg := newMainPackage()
+	tyCh := types.NewChan(types.SendRecv, types.Typ[types.Int])
+	ch := pkg.NewParam(token.NoPos, "ch", tyCh)
+	xs := pkg.NewParam(token.NoPos, "xs", types.NewSlice(types.Typ[types.Int]))
+	cb := pkg.NewFunc(nil, "run", types.NewTuple(ch, xs), nil, false).BodyStart(pkg)
+	outer := cb.NewLabel(token.NoPos, token.NoPos, "outer")
+	sel := cb.NewLabel(token.NoPos, token.NoPos, "sel")
+	inner := cb.NewLabel(token.NoPos, token.NoPos, "inner")
+	cb.Label(outer).ForRange("v").Val(ch).RangeAssignThen(token.NoPos).
+		/**/ Label(sel).Select().
+		/****/ CommCase().Val(ch).UnaryOp(token.ARROW).EndStmt().Then().
+		/******/ Label(inner).ForRange("_", "x").Val(xs).RangeAssignThen(token.NoPos).
+		/********/ If().Val(ctxRef(pkg, "x")).Val(ctxRef(pkg, "v")).BinaryOp(token.EQL).Then().
+		/**********/ Continue(outer).
+		/********/ End().
+		/********/ If().Val(ctxRef(pkg, "x")).Val(ctxRef(pkg, "v")).BinaryOp(token.GTR).Then().
+		/**********/ Break(sel).
+		/********/ End().
+		/********/ If().Val(ctxRef(pkg, "x")).Val(0).BinaryOp(token.LSS).Then().
+		/**********/ Break(inner).
+		/********/ End().
+		/********/ Continue(inner).
+		/******/ End().
+		/******/ Break(outer).
+		/****/ End().
+		/****/ CommDefaultThen().
+		/******/ Continue(outer).
+		/****/ End().
+		/**/ End().
+		End().
+		End()
+	domTest(t, pkg, `package main
+
+func run(ch chan int, xs []int) {
+outer:
+	for v := range ch {
+	sel:
+		select {
+		case <-ch:
+		inner:
+			for _, x := range xs {
+				if x == v {
+					continue outer
+				}
+				if x > v {
+					break sel
+				}
+				if x < 0 {
+					break inner
+				}
+				continue inner
+			}
+			break outer
+		default:
+			continue outer
+		}
+	}
 }
 `)
 }
@@ -2186,35 +3512,1603 @@ func main() {
 `)
 }
 
-func TestSwitch(t *testing.T) {
+func TestDeferMethod(t *testing.T) {
 	pkg := newMainPackage()
-	fmt := pkg.Import("fmt")
+	foo := pkg.NewType("T").InitType(pkg, types.NewStruct(nil, nil))
+	recv := pkg.NewParam(token.NoPos, "p", types.NewPointer(foo))
+	ret := pkg.NewParam(token.NoPos, "", gogen.TyError)
+	pkg.NewFunc(recv, "Close", nil, types.NewTuple(ret), false).BodyStart(pkg).
+		Val(nil).Return(1).
+		End()
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
-		/**/ Switch().DefineVarStart(0, "x").Val(3).EndInit(1).Val(ctxRef(pkg, "x")).Then(). // switch x := 3; x {
-		/**/ Case().Val(1).Val(2).Then(). // case 1, 2:
-		/******/ Val(fmt.Ref("Println")).Val("1 or 2").Call(1).EndStmt().
-		/******/ End().
-		/**/ Case().Val(3).Then(). // case 3:
-		/******/ Val(fmt.Ref("Println")).Val("3").Call(1).EndStmt().
-		/******/ End().
-		/**/ DefaultThen(). // default:
-		/******/ Val(fmt.Ref("Println")).Val("other").Call(1).EndStmt().
-		/******/ End().
-		/**/ End(). // end switch
+		NewVar(foo, "x").
+		Val(ctxRef(pkg, "x")).MemberVal("Close").Call(0).Defer().
+		DefineVarStart(token.NoPos, "y").Val(ctxRef(pkg, "x")).UnaryOp(token.AND).EndInit(1).
+		Val(ctxRef(pkg, "y")).MemberVal("Close").Call(0).Defer().
 		End()
 	domTest(t, pkg, `package main
 
-import "fmt"
+type T struct {
+}
 
+func (p *T) Close() error {
+	return nil
+}
 func main() {
-	switch x := 3; x {
-	case 1, 2:
-		fmt.Println("1 or 2")
-	case 3:
-		fmt.Println("3")
-	default:
-		fmt.Println("other")
+	var x T
+	defer x.Close()
+	y := &x
+	defer y.Close()
+}
+`)
+}
+
+func TestRawStmtExpr(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "", `package p
+func _() {
+	fmt.Println(s, T{s: len(s)})
+}`, 0)
+	if err != nil {
+		t.Fatal("parser.ParseFile:", err)
+	}
+	stmt := f.Decls[0].(*ast.FuncDecl).Body.List[0]
+	expr, err := parser.ParseExpr(`strings.ToUpper(s) + "!"`)
+	if err != nil {
+		t.Fatal("parser.ParseExpr:", err)
+	}
+	env := &gogen.RawEnv{
+		Imports: map[string]string{"fmt": "fmt", "strings": "strings"},
+		Rename:  map[string]string{"s": "name"},
+	}
+	pkg := newMainPackage()
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "s", types.Typ[types.Int], false),
+	}
+	pkg.NewType("T").InitType(pkg, types.NewStruct(fields, nil))
+	name := pkg.NewParam(token.NoPos, "name", types.Typ[types.String])
+	pkg.NewFunc(nil, "foo", types.NewTuple(name), nil, false).BodyStart(pkg).
+		RawStmt(stmt, env).
+		DefineVarStart(token.NoPos, "x").RawExpr(expr, types.Typ[types.String], env).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type T struct {
+	s int
+}
+
+func foo(name string) {
+	fmt.Println(name, T{s: len(name)})
+	x := strings.ToUpper(name) + "!"
+}
+`)
+}
+
+func TestClear(t *testing.T) {
+	pkg := newGoVersionPackage("go1.21")
+	builtin := pkg.Builtin()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(types.NewMap(types.Typ[types.String], types.Typ[types.Int]), "m").
+		NewVar(types.NewSlice(types.Typ[types.Int]), "s").
+		Val(builtin.Ref("clear")).VarVal("m").Call(1).EndStmt().
+		Val(builtin.Ref("clear")).VarVal("s").Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+func main() {
+	var m map[string]int
+	var s []int
+	clear(m)
+	clear(s)
+}
+`)
+}
+
+func TestMinMax(t *testing.T) {
+	pkg := newGoVersionPackage("go1.21")
+	builtin := pkg.Builtin()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "x").
+		NewVar(types.Typ[types.String], "s").
+		DefineVarStart(0, "a").Val(builtin.Ref("min")).Val(1).Val(2.5).Call(2).EndInit(1).
+		DefineVarStart(0, "b").Val(builtin.Ref("max")).VarVal("x").Val(2).VarVal("x").Call(3).EndInit(1).
+		DefineVarStart(0, "c").Val(builtin.Ref("max")).VarVal("s").Val("abc").Call(2).EndInit(1)
+	if typ := ctxRef(pkg, "a").Type(); typ != types.Typ[types.Float64] {
+		t.Fatal("TestMinMax: a is", typ)
+	}
+	cb.DefineVarStart(0, "d").Val(builtin.Ref("min")).Val(3).Val('a').Val(2).Call(3)
+	if v := cb.Get(-1); v.Type != types.Typ[types.UntypedRune] || v.CVal.String() != "2" {
+		t.Fatal("TestMinMax: min(3, 'a', 2) =", v.Type, v.CVal)
+	}
+	cb.EndInit(1).
+		DefineVarStart(0, "e").Val(builtin.Ref("max")).Val(1).Val(2.5).Call(2)
+	if v := cb.Get(-1); v.Type != types.Typ[types.UntypedFloat] || v.CVal.String() != "2.5" {
+		t.Fatal("TestMinMax: max(1, 2.5) =", v.Type, v.CVal)
+	}
+	cb.EndInit(1).End()
+	domTest(t, pkg, `package main
+
+func main() {
+	var x int
+	var s string
+	a := min(1, 2.5)
+	b := max(x, 2, x)
+	c := max(s, "abc")
+	d := min(3, 'a', 2)
+	e := max(1, 2.5)
+}
+`)
+}
+
+func TestNewEqualMethod(t *testing.T) {
+	pkg := newMainPackage()
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "Name", types.Typ[types.String], false),
+		types.NewField(token.NoPos, pkg.Types, "_", types.Typ[types.Int], false),
+		types.NewField(token.NoPos, pkg.Types, "Tags", types.NewSlice(types.Typ[types.String]), false),
+		types.NewField(token.NoPos, pkg.Types, "attrs", types.NewMap(types.Typ[types.String], types.Typ[types.Int]), false),
+	}
+	foo := pkg.NewType("Foo").InitType(pkg, types.NewStruct(fields, nil))
+	pkg.NewEqualMethod(foo)
+	domTest(t, pkg, `package main
+
+import "reflect"
+
+type Foo struct {
+	Name  string
+	_     int
+	Tags  []string
+	attrs map[string]int
+}
+
+func (a Foo) Equal(b Foo) bool {
+	if a.Name != b.Name {
+		return false
+	}
+	if !reflect.DeepEqual(a.Tags, b.Tags) {
+		return false
 	}
+	if !reflect.DeepEqual(a.attrs, b.attrs) {
+		return false
+	}
+	return true
+}
+`)
+}
+
+func TestNewLazyVar(t *testing.T) {
+	pkg := newMainPackage()
+	tyConfig := types.NewMap(types.Typ[types.String], types.Typ[types.String])
+	getConfig := pkg.NewLazyVar(token.NoPos, tyConfig, "config", "Config", func(cb *gogen.CodeBuilder) {
+		cb.Val("home").Val(pkg.Import("os").Ref("Getenv")).Val("HOME").Call(1).MapLit(tyConfig, 2)
+	})
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(pkg.Import("fmt").Ref("Println")).Val(getConfig.Func).Call(0).Val("home").Index(1, false).Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+var (
+	config     map[string]string
+	configOnce sync.Once
+)
+
+func Config() map[string]string {
+	configOnce.Do(func() {
+		config = map[string]string{"home": os.Getenv("HOME")}
+	})
+	return config
+}
+func main() {
+	fmt.Println(Config()["home"])
+}
+`)
+}
+
+func TestImplicitRecv(t *testing.T) {
+	pkg := newMainPackage()
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "Name", types.Typ[types.String], false),
+		types.NewField(token.NoPos, pkg.Types, "n", types.Typ[types.Int], false),
+	}
+	game := pkg.NewType("Game").InitType(pkg, types.NewStruct(fields, nil))
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.Int])
+	recv := pkg.NewParam(token.NoPos, "this", types.NewPointer(game))
+	pkg.NewFunc(recv, "Score", nil, types.NewTuple(ret), false).BodyStart(pkg).
+		Val(1).Return(1).
+		End()
+	pkg.NewVar(token.NoPos, types.Typ[types.String], "Name")
+	recv = pkg.NewParam(token.NoPos, "this", types.NewPointer(game))
+	cb := pkg.NewFunc(recv, "Main", nil, nil, false).BodyStart(pkg).
+		SetImplicitRecv(recv).
+		VarRef("n").VarVal("n").Val(1).BinaryOp(token.ADD).Assign(1).
+		DefineVarStart(token.NoPos, "s").VarVal("score").EndInit(1).
+		DefineVarStart(token.NoPos, "name").VarVal("Name").EndInit(1).
+		NewClosure(nil, nil, false).BodyStart(pkg).
+		VarRef("n").Val(0).Assign(1).
+		End().Call(0).EndStmt()
+	if cb.ImplicitRecv() != recv {
+		t.Fatal("ImplicitRecv:", cb.ImplicitRecv())
+	}
+	cb.End()
+	domTest(t, pkg, `package main
+
+type Game struct {
+	Name string
+	n    int
+}
+
+func (this *Game) Score() int {
+	return 1
+}
+
+var Name string
+
+func (this *Game) Main() {
+	this.n = this.n + 1
+	s := this.Score()
+	name := Name
+	func() {
+		this.n = 0
+	}()
+}
+`)
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatal("VarVal: no error without implicit receiver")
+		}
+	}()
+	pkg = newMainPackage()
+	pkg.NewFunc(nil, "foo", nil, nil, false).BodyStart(pkg).
+		NewVar(types.NewPointer(game), "this").
+		VarVal("n")
+}
+
+func TestMemberEmbeddedPointer(t *testing.T) {
+	pkg := newMainPackage()
+	deep := pkg.NewType("Deep").InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "V", types.Typ[types.Int], false)}, nil))
+	inner := pkg.NewType("Inner").InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "Deep", types.NewPointer(deep), true)}, nil))
+	outer := pkg.NewType("Outer").InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "Inner", types.NewPointer(inner), true)}, nil))
+	list := pkg.NewType("List")
+	list.InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "List", types.NewPointer(list.Type()), true)}, nil))
+	recv := pkg.NewParam(token.NoPos, "d", types.NewPointer(deep))
+	pkg.NewFunc(recv, "Inc", nil, nil, false).BodyStart(pkg).
+		Val(recv).MemberRef("V").IncDec(token.INC).
+		End()
+	ret := pkg.NewParam(token.NoPos, "o", outer)
+	pkg.NewFunc(nil, "get", nil, types.NewTuple(ret), false).BodyStart(pkg).
+		Return(0).
+		End()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(outer, "o").NewVar(types.NewPointer(outer), "p").NewVar(list.Type(), "l").
+		VarVal("o").MemberRef("V").VarVal("p").MemberVal("V").Assign(1).
+		DefineVarStart(token.NoPos, "d").VarVal("o").MemberVal("Deep")
+	if typ := cb.Get(-1).Type; !types.Identical(typ, types.NewPointer(deep)) {
+		t.Fatal("o.Deep:", typ)
+	}
+	cb.EndInit(1).
+		Val(ctxRef(pkg, "get")).Call(0).MemberVal("Inc").Call(0).EndStmt().
+		VarVal("l")
+	if _, err := cb.Member("X", gogen.MemberFlagVal); err == nil {
+		t.Fatal("l.X: no error")
+	}
+	cb.ResetStmt()
+	cb.End()
+	domTest(t, pkg, `package main
+
+type Deep struct {
+	V int
+}
+type Inner struct {
+	*Deep
+}
+type Outer struct {
+	*Inner
+}
+type List struct {
+	*List
+}
+
+func (d *Deep) Inc() {
+	d.V++
+}
+func get() (o Outer) {
+	return
+}
+func main() {
+	var o Outer
+	var p *Outer
+	var l List
+	o.V = p.V
+	d := o.Deep
+	get().Inc()
+}
+`)
+}
+
+func TestSnippet(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	n := types.NewVar(token.NoPos, pkg.Types, "n", types.Typ[types.Int])
+	name := types.NewVar(token.NoPos, pkg.Types, "name", types.Typ[types.String])
+	snip := pkg.NewSnippet(n, name)
+	snip.BodyStart(pkg).
+		VarRef(n).IncDec(token.INC).
+		If().Val(n).Val(1).BinaryOp(token.GTR).Then().
+		Val(fmt.Ref("Println")).Val(name).Val(n).Call(2).EndStmt().
+		End().
+		End()
+	if vars := snip.Vars(); len(vars) != 2 || vars[0] != n {
+		t.Fatal("Snippet.Vars:", vars)
+	}
+	pkg.NewVar(token.NoPos, types.Typ[types.String], "title")
+	title := ctxRef(pkg, "title")
+	pkg.NewFunc(nil, "foo", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "count").
+		Snippet(snip, []types.Object{ctxRef(pkg, "count"), title}).
+		End()
+	cb := pkg.NewFunc(nil, "bar", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "x").NewVar(types.Typ[types.String], "s").
+		Snippet(snip, []types.Object{ctxRef(pkg, "x"), ctxRef(pkg, "s")})
+	func() {
+		defer func() {
+			if e := recover(); e == nil || e.(error).Error() != "-: cannot use s (type string) as type int for snippet variable n" {
+				t.Fatal("Snippet:", e)
+			}
+		}()
+		cb.Snippet(snip, []types.Object{ctxRef(pkg, "s"), ctxRef(pkg, "s")})
+	}()
+	func() {
+		defer func() {
+			if e := recover(); e == nil || e.(error).Error() != `-: cannot use s (type string) as type int for snippet variable n
+-: cannot use x (type int) as type string for snippet variable name` {
+				t.Fatal("Snippet:", e)
+			}
+		}()
+		cb.Snippet(snip, []types.Object{ctxRef(pkg, "s"), ctxRef(pkg, "x")})
+	}()
+	func() {
+		defer func() {
+			if e := recover(); e == nil || e.(error).Error() != "-: snippet has 2 variables but 1 object given" {
+				t.Fatal("Snippet:", e)
+			}
+		}()
+		cb.Snippet(snip, []types.Object{ctxRef(pkg, "x")})
+	}()
+	cb.End()
+	pkg.SetCurFile("b.go", true)
+	pkg.NewFunc(nil, "baz", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "y").
+		Snippet(snip, []types.Object{ctxRef(pkg, "y"), title}).
+		End()
+	domTestEx(t, pkg, `package main
+
+import "fmt"
+
+func baz() {
+	var y int
+	y++
+	if y > 1 {
+		fmt.Println(title, y)
+	}
+}
+`, "b.go")
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+var title string
+
+func foo() {
+	var count int
+	count++
+	if count > 1 {
+		fmt.Println(title, count)
+	}
+}
+func bar() {
+	var x int
+	var s string
+	x++
+	if x > 1 {
+		fmt.Println(s, x)
+	}
+}
+`)
+}
+
+func TestConstAssert(t *testing.T) {
+	pkg := newMainPackage()
+	builtin := pkg.Builtin()
+	pkg.NewConstDefs(pkg.Types.Scope()).
+		New(func(cb *gogen.CodeBuilder) int {
+			cb.Val("abcd")
+			return 1
+		}, 0, token.NoPos, nil, "s")
+	s := ctxRef(pkg, "s")
+	pkg.NewConstDefs(pkg.Types.Scope()).NewAssert(func(cb *gogen.CodeBuilder) {
+		cb.Val(builtin.Ref("len")).Val(s).Call(1)
+		if v := cb.Get(-1); v.CVal == nil || v.Type != types.Typ[types.Int] {
+			t.Fatal("len(s):", v.CVal, v.Type)
+		}
+		cb.Val(4).BinaryOp(token.SUB)
+	}, token.NoPos)
+	domTest(t, pkg, `package main
+
+const s = "abcd"
+const _ = uint(len(s) - 4)
+`)
+}
+
+func TestStructLitByName(t *testing.T) {
+	pkg := newMainPackage()
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "Name", types.Typ[types.String], false),
+		types.NewField(token.NoPos, pkg.Types, "Age", types.Typ[types.Int], false),
+		types.NewField(token.NoPos, pkg.Types, "Tags", types.NewSlice(types.Typ[types.String]), false),
+	}
+	foo := pkg.NewType("Foo").InitType(pkg, types.NewStruct(fields, nil))
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "a").
+		Val(nil).Val(18).Val("bob").StructLitByName(foo, []string{"Tags", "Age", "Name"}).EndInit(1).
+		DefineVarStart(token.NoPos, "b").
+		Val(20).StructLitByName(foo, []string{"Age"}).EndInit(1).
+		DefineVarStart(token.NoPos, "c").
+		StructLitByName(foo, nil).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type Foo struct {
+	Name string
+	Age  int
+	Tags []string
+}
+
+func main() {
+	a := Foo{Name: "bob", Age: 18, Tags: nil}
+	b := Foo{Age: 20}
+	c := Foo{}
+}
+`)
+}
+
+func TestChainCall(t *testing.T) {
+	pkg := newMainPackage()
+	b := pkg.NewType("B").InitType(pkg, types.NewStruct(nil, nil))
+	recv := pkg.NewParam(token.NoPos, "b", types.NewPointer(b))
+	x := pkg.NewParam(token.NoPos, "x", types.Typ[types.String])
+	ret := pkg.NewParam(token.NoPos, "", types.NewPointer(b))
+	pkg.NewFunc(recv, "Add", types.NewTuple(x), types.NewTuple(ret), false).BodyStart(pkg).
+		Val(recv).Return(1).
+		End()
+	ret = pkg.NewParam(token.NoPos, "", types.Typ[types.String])
+	pkg.NewFunc(recv, "String", nil, types.NewTuple(ret), false).BodyStart(pkg).
+		Val("").Return(1).
+		End()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(b, "v").
+		DefineVarStart(token.NoPos, "s").
+		VarVal("v").Val("a").ChainCall("Add", 1).Val("b").ChainCall("Add", 1).ChainCall("String", 0).
+		EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type B struct {
+}
+
+func (b *B) Add(x string) *B {
+	return b
+}
+func (b *B) String() string {
+	return ""
+}
+func main() {
+	var v B
+	s := v.Add("a").Add("b").String()
+}
+`)
+}
+
+func TestBlankParams(t *testing.T) {
+	pkg := newMainPackage()
+	params := types.NewTuple(
+		pkg.NewParam(token.NoPos, "_", types.Typ[types.Int]),
+		pkg.NewParam(token.NoPos, "_", types.Typ[types.String]),
+		pkg.NewParam(token.NoPos, "x", types.Typ[types.Int]))
+	results := types.NewTuple(pkg.NewParam(token.NoPos, "_", types.Typ[types.Int]))
+	pkg.NewFunc(nil, "f", params, results, false).BodyStart(pkg).
+		Val(ctxRef(pkg, "x")).Return(1).
+		End()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
+	if cb.Scope().Lookup("_") != nil {
+		t.Fatal("TestBlankParams: _ is in scope")
+	}
+	cb.NewVarStart(nil, "g").
+		NewClosure(types.NewTuple(pkg.NewParam(token.NoPos, "_", types.Typ[types.Bool])), nil, false).BodyStart(pkg).
+		End().
+		EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+func f(_ int, _ string, x int) (_ int) {
+	return x
+}
+func main() {
+	var g = func(_ bool) {
+	}
+}
+`)
+}
+
+func TestShadowImport(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	strings := pkg.Import("strings")
+	param := pkg.NewParam(token.NoPos, "fmt", types.Typ[types.String])
+	pkg.NewFunc(nil, "hello", types.NewTuple(param), nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "strings").Val(strings.Ref("ToUpper")).Val(param).Call(1).EndInit(1).
+		Val(fmt.Ref("Println")).VarVal("strings").Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import (
+	fmt1 "fmt"
+	strings1 "strings"
+)
+
+func hello(fmt string) {
+	strings := strings1.ToUpper(fmt)
+	fmt1.Println(strings)
+}
+`)
+}
+
+func TestInvalidOperand(t *testing.T) {
+	var errs []error
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		Fset: gblFset, Importer: gblImp, HandleErr: func(err error) { errs = append(errs, err) },
+	})
+	fmt := pkg.Import("fmt")
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "x").Invalid().EndInit(1).
+		DefineVarStart(token.NoPos, "v", "ok").Invalid().EndInit(1).
+		Val(ctxRef(pkg, "x")).MemberVal("Foo").Val(1).Call(1).Val(2).BinaryOp(token.ADD).
+		UnaryOp(token.SUB).Val(1).Index(1, false).Star().EndStmt().
+		VarRef(ctxRef(pkg, "x")).MemberRef("Bar").Val("hi").Assign(1).
+		VarRef(ctxRef(pkg, "x")).IncDec(token.INC).
+		VarRef(ctxRef(pkg, "v")).Val(1).AssignOp(token.ADD_ASSIGN).
+		VarRef(nil).VarRef(ctxRef(pkg, "ok")).Invalid().Assign(2, 1).
+		Val(fmt.Ref("Println")).Val(ctxRef(pkg, "x")).TypeAssert(types.Typ[types.Int], false).Call(1).EndStmt()
+	if n := cb.InternalStack().Len(); n != 0 {
+		t.Fatal("TestInvalidOperand: stack isn't empty:", n)
+	}
+	cb.End()
+	if errs != nil {
+		t.Fatal("TestInvalidOperand:", errs)
+	}
+	var b bytes.Buffer
+	err := pkg.WriteTo(&b)
+	if e, ok := err.(gogen.InvalidExprError); !ok || len(e) != 6 {
+		t.Fatal("TestInvalidOperand:", err)
+	}
+	if !strings.HasPrefix(err.Error(), "6 invalid expression(s) in generated code:") {
+		t.Fatal("TestInvalidOperand:", err)
+	}
+	if b.Len() != 0 {
+		t.Fatal("TestInvalidOperand: unexpected output", b.String())
+	}
+}
+
+func TestTodo(t *testing.T) {
+	pkg := newMainPackage()
+	fn := pkg.NewFunc(nil, "foo", nil, nil, false)
+	fn.BodyStart(pkg).
+		NewClosure(nil, nil, false).BodyStart(pkg).
+		/**/ Todo("closure").
+		/**/ End().Call(0).EndStmt().
+		Todo("foo").
+		End()
+	domTest(t, pkg, `package main
+
+func foo() {
+	func() {
+		panic("TODO: closure")
+	}()
+	panic("TODO: foo")
+}
+`)
+	todos := pkg.Todos()
+	if len(todos) != 2 || todos[0].Msg != "closure" || todos[1].Msg != "foo" {
+		t.Fatal("TestTodo:", todos)
+	}
+	for _, todo := range todos {
+		if todo.Func != fn {
+			t.Fatal("TestTodo: unexpected func", todo.Func)
+		}
+	}
+}
+
+func TestTodoStrict(t *testing.T) {
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		Fset: gblFset, Importer: gblImp, StrictTodo: true,
+	})
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Todo("main").
+		End()
+	var b bytes.Buffer
+	err := pkg.WriteTo(&b)
+	if e, ok := err.(gogen.TodoError); !ok || len(e) != 1 {
+		t.Fatal("TestTodoStrict:", err)
+	}
+	if err.Error() != "1 TODO placeholder(s) in generated code:\n\t-: TODO: main (in func main)" {
+		t.Fatal("TestTodoStrict:", err)
+	}
+	if b.Len() != 0 {
+		t.Fatal("TestTodoStrict: unexpected output", b.String())
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	strings := pkg.Import("strings")
+	pkg.CB().Val(strings.Ref("ToUpper")) // discarded
+	pkg.CB().ResetStmt()
+	x := pkg.NewParam(token.NoPos, "x", types.Typ[types.Int64])
+	pkg.NewFunc(nil, "main", types.NewTuple(x), nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).
+		/**/ Typ(types.Typ[types.String]).Val(x, source("x")).Call(1).
+		/**/ Call(1).EndStmt().
+		Todo("more").
+		End()
+	if _, err := pkg.SetCurFile("a.go", true); err != nil {
+		t.Fatal("SetCurFile:", err)
+	}
+	pkg.NewVar(token.NoPos, types.Typ[types.Int], "a")
+
+	ret, err := pkg.Finalize()
+	if err != nil || len(ret.Errors) != 0 {
+		t.Fatal("Finalize:", err, ret.Errors)
+	}
+	ret2, err := pkg.Finalize()
+	if err != nil || !reflect.DeepEqual(ret, ret2) {
+		t.Fatal("Finalize: not idempotent -", ret, ret2)
+	}
+	if len(ret.Files) != 2 || ret.Files[0].Name != "" || ret.Files[1].Name != "a.go" {
+		t.Fatal("Finalize: unexpected files -", ret.Files)
+	}
+	for _, f := range ret.Files {
+		var b bytes.Buffer
+		if err := pkg.WriteTo(&b, f.Name); err != nil || b.Len() != f.Size {
+			t.Fatal("Finalize: unexpected size of", f.Name, "-", f.Size, b.Len(), err)
+		}
+	}
+	if len(ret.Warnings) != 2 ||
+		ret.Warnings[0].Error() != "-: conversion from x (type int64) to string yields a string of one rune, not a string of digits (did you mean strconv.Itoa(x)?)" ||
+		ret.Warnings[1].Error() != "-: TODO: more (in func main)" {
+		t.Fatal("Finalize: unexpected warnings -", ret.Warnings)
+	}
+	if !reflect.DeepEqual(ret.UnusedImports, []string{"strings"}) {
+		t.Fatal("Finalize: unexpected unused imports -", ret.UnusedImports)
+	}
+	if !reflect.DeepEqual(ret.ImportedRefs, map[string][]string{"fmt": {"Println"}}) {
+		t.Fatal("Finalize: unexpected imported refs -", ret.ImportedRefs)
+	}
+}
+
+func TestFinalizeError(t *testing.T) {
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		Fset: gblFset, Importer: gblImp, StrictTodo: true,
+	})
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Todo("main").
+		Val(pkg.RefLater("undefinedFn")).Call(0).EndStmt().
+		End()
+	ret, err := pkg.Finalize()
+	if _, ok := err.(gogen.LaterRefError); !ok || len(ret.Errors) != 2 || ret.Files != nil {
+		t.Fatal("Finalize:", err, ret.Errors, ret.Files)
+	}
+	if _, ok := ret.Errors[1].(gogen.TodoError); !ok {
+		t.Fatal("Finalize: unexpected error -", ret.Errors[1])
+	}
+	file := filepath.Join(t.TempDir(), "main.go")
+	if err := pkg.WriteFile(file); err == nil {
+		t.Fatal("WriteFile: no error")
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatal("WriteFile: file created -", err)
+	}
+}
+
+func TestWriteToChecks(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(pkg.RefLater("foo")).Call(0).EndStmt().
+		End()
+	var b bytes.Buffer
+	if _, ok := pkg.WriteTo(&b).(gogen.LaterRefError); !ok {
+		t.Fatal("WriteTo: no LaterRefError")
+	}
+	foo := types.NewFunc(token.NoPos, pkg.Types, "foo", types.NewSignatureType(nil, nil, nil, nil, nil, false))
+	pkg.Types.Scope().Insert(foo) // declared without adding code
+	if err := pkg.WriteTo(&b); err != nil {
+		t.Fatal("WriteTo:", err)
+	}
+	pkg.SetCurFile("b.go", true)
+	pkg.NewVarStart(token.NoPos, nil, "a").Invalid().EndInit(1)
+	if _, ok := pkg.WriteTo(&b, "b.go").(gogen.InvalidExprError); !ok {
+		t.Fatal("WriteTo b.go: no InvalidExprError")
+	}
+	b.Reset()
+	if err := pkg.WriteTo(&b); err != nil {
+		t.Fatal("WriteTo:", err)
+	}
+	if ret := b.String(); ret != `package main
+
+func main() {
+	foo()
+}
+` {
+		t.Fatal("WriteTo:", ret)
+	}
+}
+
+func TestAssertImplements(t *testing.T) {
+	pkg := newMainPackage()
+	io := pkg.Import("io")
+	fields := types.NewStruct(nil, nil)
+	foo := pkg.NewType("Foo").InitType(pkg, fields)
+	bar := pkg.NewType("Bar").InitType(pkg, types.NewSlice(gogen.TyByte))
+	p := pkg.NewParam(token.NoPos, "p", types.NewSlice(gogen.TyByte))
+	n := pkg.NewParam(token.NoPos, "n", types.Typ[types.Int])
+	err := pkg.NewParam(token.NoPos, "err", gogen.TyError)
+	recv := pkg.NewParam(token.NoPos, "f", types.NewPointer(foo))
+	pkg.NewFunc(recv, "Write", types.NewTuple(p), types.NewTuple(n, err), false).BodyStart(pkg).
+		Return(0).
+		End()
+	recv = pkg.NewParam(token.NoPos, "b", bar)
+	pkg.NewFunc(recv, "Close", nil, types.NewTuple(err), false).BodyStart(pkg).
+		Val(nil).Return(1).
+		End()
+	pkg.AssertImplements(io.Ref("Writer").Type(), types.NewPointer(foo))
+	pkg.AssertImplements(io.Ref("Closer").Type(), bar)
+	pkg.AssertImplements(gogen.TyEmptyInterface, foo)
+	domTest(t, pkg, `package main
+
+import "io"
+
+type Foo struct {
+}
+type Bar []byte
+
+func (f *Foo) Write(p []byte) (n int, err error) {
+	return
+}
+func (b Bar) Close() (err error) {
+	return nil
+}
+
+var _ io.Writer = (*Foo)(nil)
+var _ io.Closer = Bar(nil)
+var _ interface{} = Foo{}
+`)
+}
+
+func TestAssertSize(t *testing.T) {
+	pkg := newMainPackage()
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "a", types.Typ[types.Int32], false),
+		types.NewField(token.NoPos, pkg.Types, "b", types.Typ[types.Int64], false),
+	}
+	foo := pkg.NewType("Foo").InitType(pkg, types.NewStruct(fields, nil))
+	pkg.AssertSize(foo, 16)
+	pkg.AssertSize(types.Typ[types.Uint16], 2)
+	pkg.AssertSize(types.NewPointer(foo), 8)
+	domTest(t, pkg, `package main
+
+import "unsafe"
+
+type Foo struct {
+	a int32
+	b int64
+}
+
+const (
+	_ = uint(unsafe.Sizeof(Foo{}) - 16)
+	_ = uint(16 - unsafe.Sizeof(Foo{}))
+)
+const (
+	_ = uint(unsafe.Sizeof(uint16(0)) - 2)
+	_ = uint(2 - unsafe.Sizeof(uint16(0)))
+)
+const (
+	_ = uint(unsafe.Sizeof((*Foo)(nil)) - 8)
+	_ = uint(8 - unsafe.Sizeof((*Foo)(nil)))
+)
+`)
+}
+
+func TestAssertSizeKinds(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	for _, c := range []struct {
+		typ  types.Type
+		size int
+	}{
+		{types.Typ[types.Bool], 1},
+		{types.Typ[types.String], 16},
+		{types.Typ[types.Complex128], 16},
+		{types.Typ[types.UnsafePointer], 8},
+		{types.NewArray(tyInt, 2), 16},
+		{types.NewSlice(tyInt), 24},
+		{types.NewMap(tyInt, tyInt), 8},
+		{types.NewChan(types.SendRecv, tyInt), 8},
+		{types.NewSignatureType(nil, nil, nil, nil, nil, false), 8},
+		{types.NewInterfaceType(nil, nil), 16},
+	} {
+		pkg.AssertSize(c.typ, c.size)
+	}
+	domTest(t, pkg, `package main
+
+import "unsafe"
+
+const (
+	_ = uint(unsafe.Sizeof(bool(false)) - 1)
+	_ = uint(1 - unsafe.Sizeof(bool(false)))
+)
+const (
+	_ = uint(unsafe.Sizeof(string("")) - 16)
+	_ = uint(16 - unsafe.Sizeof(string("")))
+)
+const (
+	_ = uint(unsafe.Sizeof(complex128(0)) - 16)
+	_ = uint(16 - unsafe.Sizeof(complex128(0)))
+)
+const (
+	_ = uint(unsafe.Sizeof(unsafe.Pointer(nil)) - 8)
+	_ = uint(8 - unsafe.Sizeof(unsafe.Pointer(nil)))
+)
+const (
+	_ = uint(unsafe.Sizeof([2]int{}) - 16)
+	_ = uint(16 - unsafe.Sizeof([2]int{}))
+)
+const (
+	_ = uint(unsafe.Sizeof([]int(nil)) - 24)
+	_ = uint(24 - unsafe.Sizeof([]int(nil)))
+)
+const (
+	_ = uint(unsafe.Sizeof(map[int]int(nil)) - 8)
+	_ = uint(8 - unsafe.Sizeof(map[int]int(nil)))
+)
+const (
+	_ = uint(unsafe.Sizeof((chan int)(nil)) - 8)
+	_ = uint(8 - unsafe.Sizeof((chan int)(nil)))
+)
+const (
+	_ = uint(unsafe.Sizeof((func())(nil)) - 8)
+	_ = uint(8 - unsafe.Sizeof((func())(nil)))
+)
+const (
+	_ = uint(unsafe.Sizeof(interface{}(nil)) - 16)
+	_ = uint(16 - unsafe.Sizeof(interface{}(nil)))
+)
+`)
+}
+
+func TestPkgVarInDeepScope(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.NewVar(token.NoPos, types.Typ[types.Int], "count")
+	typ := pkg.NewType("T").InitType(pkg, types.NewStruct(nil, nil))
+	recv := pkg.NewParam(token.NoPos, "t", types.NewPointer(typ))
+	cb := pkg.NewFunc(recv, "Inc", nil, nil, false).BodyStart(pkg).
+		If().Val(true).Then().
+		/**/ For().DefineVarStart(0, "i").Val(0).EndInit(1).
+		/******/ Val(ctxRef(pkg, "i")).Val(3).BinaryOp(token.LSS).Then().
+		/******/ NewClosure(nil, nil, false).BodyStart(pkg)
+	count := ctxRef(pkg, "count")
+	if count == nil || count.Parent() != pkg.Types.Scope() {
+		t.Fatal("TestPkgVarInDeepScope: count not found")
+	}
+	cb.VarRef("count").Val(count).Val(ctxRef(pkg, "i")).BinaryOp(token.ADD).Assign(1).
+		/******/ End().Call(0).EndStmt().
+		/**/ End().
+		End().
+		End()
+	domTest(t, pkg, `package main
+
+var count int
+
+type T struct {
+}
+
+func (t *T) Inc() {
+	if true {
+		for i := 0; i < 3; {
+			func() {
+				count = count + i
+			}()
+		}
+	}
+}
+`)
+}
+
+func TestImplementStub(t *testing.T) {
+	pkg := newMainPackage()
+	io := pkg.Import("io")
+	foo := pkg.NewType("Foo").InitType(pkg, types.NewStruct(nil, nil))
+	recv := pkg.NewParam(token.NoPos, "f", types.NewPointer(foo))
+	err := pkg.NewParam(token.NoPos, "err", gogen.TyError)
+	pkg.NewFunc(recv, "Close", nil, types.NewTuple(err), false).BodyStart(pkg).
+		Val(nil).Return(1).
+		End()
+	rwc := io.Ref("ReadWriteCloser").Type().Underlying().(*types.Interface)
+	fns := pkg.ImplementStub(foo, rwc, true)
+	if len(fns) != 2 || fns[0].Name() != "Read" || fns[1].Name() != "Write" {
+		t.Fatal("TestImplementStub:", fns)
+	}
+	a := pkg.NewParam(token.NoPos, "", types.Typ[types.Int])
+	arg0 := pkg.NewParam(token.NoPos, "arg0", types.Typ[types.String])
+	b := pkg.NewParam(token.NoPos, "_", types.NewSlice(types.Typ[types.Bool]))
+	sig := types.NewSignatureType(nil, nil, nil, types.NewTuple(a, arg0, b), types.NewTuple(a), true)
+	m := types.NewFunc(token.NoPos, pkg.Types, "Do", sig)
+	iface := types.NewInterfaceType([]*types.Func{m}, []types.Type{pkg.Import("fmt").Ref("Stringer").Type()})
+	bar := pkg.NewType("Bar").InitType(pkg, types.NewStruct(nil, nil))
+	pkg.ImplementStub(bar, iface, false)
+	x := pkg.NewParam(token.NoPos, "ä", types.Typ[types.Int])
+	m2 := types.NewFunc(token.NoPos, pkg.Types, "Set", types.NewSignatureType(nil, nil, nil, types.NewTuple(x), nil, false))
+	aerger := pkg.NewType("Ärger").InitType(pkg, types.NewStruct(nil, nil))
+	pkg.ImplementStub(aerger, types.NewInterfaceType([]*types.Func{m2}, nil), true)
+	domTest(t, pkg, `package main
+
+type Foo struct {
+}
+
+func (f *Foo) Close() (err error) {
+	return nil
+}
+
+// TODO: implement Read.
+func (f *Foo) Read(p []byte) (n int, err error) {
+	panic("not implemented")
+}
+
+// TODO: implement Write.
+func (f *Foo) Write(p []byte) (n int, err error) {
+	panic("not implemented")
+}
+
+type Bar struct {
+}
+
+// TODO: implement Do.
+func (b Bar) Do(arg1 int, arg0 string, arg2 ...bool) int {
+	panic("not implemented")
+}
+
+// TODO: implement String.
+func (b Bar) String() string {
+	panic("not implemented")
+}
+
+type Ärger struct {
+}
+
+// TODO: implement Set.
+func (ä1 *Ärger) Set(ä int) {
+	panic("not implemented")
+}
+`)
+}
+
+func TestStructLitPositional(t *testing.T) {
+	pkg := newMainPackage()
+	point := pkg.Import("image").Ref("Point").Type()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "p").Val(1).Val(2).StructLit(point, 2, false).EndInit(1).
+		DefineVarStart(token.NoPos, "q").Val(0).Val(ctxRef(pkg, "p")).MemberVal("Y").StructLit(point, 2, false).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+import "image"
+
+func main() {
+	p := image.Point{1, 2}
+	q := image.Point{0, p.Y}
+}
+`)
+}
+
+func TestStructLitSparse(t *testing.T) {
+	pkg := newMainPackage()
+	duration := pkg.Import("time").Ref("Duration").Type()
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "Name", types.Typ[types.String], false),
+		types.NewField(token.NoPos, pkg.Types, "Timeout", duration, false),
+		types.NewField(token.NoPos, pkg.Types, "Retry", types.Typ[types.Int], false),
+		types.NewField(token.NoPos, pkg.Types, "Verbose", types.Typ[types.Bool], false),
+		types.NewField(token.NoPos, pkg.Types, "Tags", types.NewSlice(types.Typ[types.String]), false),
+		types.NewField(token.NoPos, pkg.Types, "Extra", gogen.TyEmptyInterface, false),
+	}
+	opts := pkg.NewType("Options").InitType(pkg, types.NewStruct(fields, nil))
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "n").Val(3).EndInit(1).
+		DefineVarStart(token.NoPos, "a").
+		Val("Verbose").Val(false).
+		Val("Retry").Val(ctxRef(pkg, "n")).
+		Val("Tags").Val(nil).
+		Val("Extra").Val(0).
+		Val("Name").Val("").
+		Val("Timeout").Val(5).
+		StructLitSparse(opts, 6, true).EndInit(1).
+		DefineVarStart(token.NoPos, "b").
+		Val("Retry").Val(0).
+		Val("Name").Val("").
+		StructLitSparse(opts, 2, false).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+import "time"
+
+type Options struct {
+	Name    string
+	Timeout time.Duration
+	Retry   int
+	Verbose bool
+	Tags    []string
+	Extra   interface{}
+}
+
+func main() {
+	n := 3
+	a := Options{Timeout: 5, Retry: n, Extra: 0}
+	b := Options{Name: "", Retry: 0}
+}
+`)
+}
+
+func TestAssignNamedResults(t *testing.T) {
+	pkg := newMainPackage()
+	q := pkg.NewParam(token.NoPos, "q", types.Typ[types.Int])
+	r := pkg.NewParam(token.NoPos, "r", types.Typ[types.Int])
+	msg := pkg.NewParam(token.NoPos, "msg", types.Typ[types.String])
+	pkg.NewFunc(nil, "div", nil, gogen.NewTuple(q, r, msg), false).BodyStart(pkg).
+		Return(0).
+		End()
+	div := ctxRef(pkg, "div")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "r", "q", "s").Val(div).Call(0).EndInit(1).
+		NewVar(types.Typ[types.String], "msg").
+		VarRef(ctxRef(pkg, "q")).VarRef(ctxRef(pkg, "r")).VarRef(ctxRef(pkg, "msg")).
+		Val(div).Call(0).Assign(3, 1).
+		End()
+	domTest(t, pkg, `package main
+
+func div() (q int, r int, msg string) {
+	return
+}
+func main() {
+	r, q, s := div()
+	var msg string
+	q, r, msg = div()
+}
+`)
+}
+
+func TestValTypedGoValues(t *testing.T) {
+	pkg := newMainPackage()
+	vals := []struct {
+		name  string
+		kind  types.BasicKind
+		v     interface{}
+		typed bool
+	}{
+		{"a", types.Int, 1, false},
+		{"b", types.Int8, int8(-5), true},
+		{"c", types.Int16, int16(300), true},
+		{"d", types.Int32, 'x', false},
+		{"e", types.Int64, int64(1) << 40, true},
+		{"f", types.Uint, uint(7), true},
+		{"g", types.Uint8, uint8(255), true},
+		{"h", types.Uint16, uint16(65535), true},
+		{"i", types.Uint32, uint32(1) << 31, true},
+		{"j", types.Uint64, uint64(1) << 63, true},
+		{"k", types.Uintptr, uintptr(4096), true},
+		{"l", types.Float32, float32(0.1), true},
+		{"m", types.Float64, 0.1, false},
+		{"n", types.Complex64, complex64(1 + 2i), true},
+		{"o", types.Complex128, 2i, false},
+		{"p", types.Bool, true, false},
+		{"q", types.String, "hi", false},
+		{"r", types.Float32, float32(math.Inf(-1)), true},
+	}
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
+	for _, v := range vals {
+		cb.NewVar(types.Typ[v.kind], v.name).VarRef(ctxRef(pkg, v.name)).Val(v.v).Assign(1)
+		if typ := cb.Val(v.v).Get(-1).Type; v.typed && typ != types.Typ[v.kind] {
+			t.Fatal("TestValTypedGoValues:", v.name, typ)
+		}
+		cb.InternalStack().Pop()
+	}
+	cb.End()
+	domTest(t, pkg, `package main
+
+import "math"
+
+func main() {
+	var a int
+	a = 1
+	var b int8
+	b = int8(-5)
+	var c int16
+	c = int16(300)
+	var d int32
+	d = 'x'
+	var e int64
+	e = int64(1099511627776)
+	var f uint
+	f = uint(7)
+	var g uint8
+	g = uint8(255)
+	var h uint16
+	h = uint16(65535)
+	var i uint32
+	i = uint32(2147483648)
+	var j uint64
+	j = uint64(9223372036854775808)
+	var k uintptr
+	k = uintptr(4096)
+	var l float32
+	l = float32(0.1)
+	var m float64
+	m = 0.1
+	var n complex64
+	n = complex64(1.0 + 2i)
+	var o complex128
+	o = 2i
+	var p bool
+	p = true
+	var q string
+	q = "hi"
+	var r float32
+	r = float32(math.Inf(-1))
+}
+`)
+}
+
+func TestFuncSetBody(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.Import("fmt")
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.Int])
+	foo := pkg.NewFunc(nil, "foo", nil, types.NewTuple(ret), false)
+	stmts := []ast.Stmt{
+		&ast.ExprStmt{X: &ast.CallExpr{
+			Fun:  &ast.SelectorExpr{X: ast.NewIdent("fmt"), Sel: ast.NewIdent("Println")},
+			Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: `"foo"`}},
+		}},
+		&ast.ReturnStmt{Results: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "1"}}},
+	}
+	if err := foo.SetBody(pkg, stmts, true); err != nil {
+		t.Fatal("SetBody:", err)
+	}
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "f")
+	closure := cb.NewClosure(nil, types.NewTuple(ret), false)
+	if err := closure.SetBody(pkg, stmts[1:], false); err != nil {
+		t.Fatal("SetBody:", err)
+	}
+	cb.EndInit(1).End()
+	if n := foo.Stats().Stmts; n != 2 {
+		t.Fatal("TestFuncSetBody: unexpected stmts", n)
+	}
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+func foo() int {
+	fmt.Println("foo")
+	return 1
+}
+func main() {
+	f := func() int {
+		return 1
+	}
+}
+`)
+}
+
+func TestErrFuncSetBody(t *testing.T) {
+	pkg := newMainPackage()
+	foo := pkg.NewFunc(nil, "foo", nil, nil, false)
+	err := foo.SetBody(pkg, []ast.Stmt{&ast.EmptyStmt{}, nil}, true)
+	if err == nil || err.Error() != "SetBody: statement #1 is nil" {
+		t.Fatal("TestErrFuncSetBody:", err)
+	}
+	err = foo.SetBody(pkg, []ast.Stmt{&ast.AssignStmt{Tok: token.ASSIGN, Rhs: []ast.Expr{ast.NewIdent("x")}}}, true)
+	if err == nil || !strings.HasPrefix(err.Error(), "SetBody: malformed statements:") {
+		t.Fatal("TestErrFuncSetBody:", err)
+	}
+}
+
+func TestFuncSetCompact(t *testing.T) {
+	pkg := newMainPackage()
+	fields := []*types.Var{types.NewField(token.NoPos, pkg.Types, "name", types.Typ[types.String], false)}
+	tyT := pkg.NewType("T").InitType(pkg, types.NewStruct(fields, nil))
+	recv := pkg.NewParam(token.NoPos, "x", types.NewPointer(tyT))
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.String])
+	v := pkg.NewParam(token.NoPos, "v", types.Typ[types.String])
+	pkg.NewFunc(recv, "Name", nil, gogen.NewTuple(ret), false).SetCompact(true).BodyStart(pkg).
+		Val(recv).MemberVal("name").Return(1).End()
+	pkg.NewFunc(recv, "SetName", gogen.NewTuple(v), nil, false).SetCompact(true).BodyStart(pkg).
+		Val(recv).MemberRef("name").Val(v).Assign(1).End()
+	pkg.NewFunc(recv, "Reset", nil, nil, false).SetCompact(true).BodyStart(pkg).
+		Val(recv).MemberRef("name").Val("").Assign(1).
+		Return(0).End()
+	pkg.NewFunc(recv, "Long", nil, gogen.NewTuple(ret), false).SetCompact(true).BodyStart(pkg).
+		Val(strings.Repeat("x", 100)).Return(1).End()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "f")
+	cb.NewClosure(nil, gogen.NewTuple(ret), false).SetCompact(true).BodyStart(pkg).
+		Val("hi").Return(1).End()
+	cb.EndInit(1).End()
+	for _, decl := range pkg.ASTFile().Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && (fn.Type.Func != token.NoPos || fn.Body.Lbrace != token.NoPos) {
+			t.Fatal("TestFuncSetCompact: positions of", fn.Name.Name, "are changed")
+		}
+	}
+	domTest(t, pkg, `package main
+
+type T struct {
+	name string
+}
+
+func (x *T) Name() string     { return x.name }
+func (x *T) SetName(v string) { x.name = v }
+func (x *T) Reset() {
+	x.name = ""
+	return
+}
+func (x *T) Long() string {
+	return "`+strings.Repeat("x", 100)+`"
+}
+func main() {
+	f := func() string { return "hi" }
+}
+`)
+}
+
+func TestCtxPlumbing(t *testing.T) {
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		Fset: gblFset, Importer: gblImp, AutoCtxArg: true,
+	})
+	id := pkg.NewParam(token.NoPos, "ctx", types.Typ[types.Int])
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.String])
+	get := pkg.NewFunc(nil, "get", pkg.WithCtxParam(gogen.NewTuple(id), nil), gogen.NewTuple(ret), false)
+	get.BodyStart(pkg).Val("").Return(1).End()
+	if typ := get.Type().(*types.Signature).Params().At(0).Type(); typ != pkg.ContextType() {
+		t.Fatal("TestCtxPlumbing: unexpected type", typ)
+	}
+	cb := pkg.NewFunc(nil, "handle", pkg.WithCtxParam(nil, nil), nil, false).BodyStart(pkg).
+		Val(get).Val(1).Call(1).EndStmt().
+		Val(get).Val(pkg.Import("context").Ref("TODO")).Call(0).Val(2).Call(2).EndStmt()
+	cb.NewClosure(nil, nil, false).BodyStart(pkg).
+		/**/ Val(get).Val(3).Call(1).EndStmt().
+		/**/ End().Call(0).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import "context"
+
+func get(ctx1 context.Context, ctx int) string {
+	return ""
+}
+func handle(ctx context.Context) {
+	get(ctx, 1)
+	get(context.TODO(), 2)
+	func() {
+		get(ctx, 3)
+	}()
+}
+`)
+}
+
+func TestCtxArgShadowed(t *testing.T) {
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		Fset: gblFset, Importer: gblImp, AutoCtxArg: true,
+	})
+	get := pkg.NewFunc(nil, "get", pkg.WithCtxParam(nil, nil), nil, false)
+	get.BodyStart(pkg).End()
+	cb := pkg.NewFunc(nil, "handle", pkg.WithCtxParam(nil, nil), nil, false).BodyStart(pkg).
+		If().DefineVarStart(token.NoPos, "ctx").Val(1).EndInit(1).Val(true).Then()
+	if err := cb.Val(get).CallWithEx(0, 0); err == nil {
+		t.Fatal("TestCtxArgShadowed: shadowed context supplied")
+	}
+	cb.ResetStmt()
+	cb.Val(pkg.Builtin().Ref("println")).Val(ctxRef(pkg, "ctx")).Call(1).EndStmt().
+		End().
+		Val(get).Call(0).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import "context"
+
+func get(ctx context.Context) {
+}
+func handle(ctx context.Context) {
+	if ctx := 1; true {
+		println(ctx)
+	}
+	get(ctx)
+}
+`)
+}
+
+func TestCtxParamName(t *testing.T) {
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		Fset: gblFset, Importer: gblImp, CtxParamName: "c",
+	})
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.String])
+	get := pkg.NewFunc(nil, "get", pkg.WithCtxParam(nil, nil), gogen.NewTuple(ret), false)
+	get.BodyStart(pkg).Val("").Return(1).End()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg)
+	if err := cb.Val(get).CallWithEx(0, 0); err == nil {
+		t.Fatal("TestCtxParamName: context supplied without AutoCtxArg")
+	}
+	cb.ResetStmt()
+	cb.End()
+	domTest(t, pkg, `package main
+
+import "context"
+
+func get(c context.Context) string {
+	return ""
+}
+func main() {
+}
+`)
+}
+
+func TestFoldStringConcat(t *testing.T) {
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		Fset: gblFset, Importer: gblImp, FoldStringConcat: true,
+	})
+	tyS := pkg.NewType("S").InitType(pkg, types.Typ[types.String])
+	pkg.NewConstStart(pkg.Types.Scope(), token.NoPos, tyS, "c").Val("x").EndInit(1)
+	pkg.NewConstStart(pkg.Types.Scope(), token.NoPos, types.Typ[types.String], "d").Val("y").EndInit(1)
+	s := pkg.NewParam(token.NoPos, "s", types.Typ[types.String])
+	cb := pkg.NewFunc(nil, "main", types.NewTuple(s), nil, false).BodyStart(pkg).
+		DefineVarStart(0, "a").Val("a").Val("b").BinaryOp(token.ADD).Val("c").BinaryOp(token.ADD).EndInit(1).
+		DefineVarStart(0, "b").Val("a").Val(ctxRef(pkg, "c")).BinaryOp(token.ADD).Val("c").BinaryOp(token.ADD)
+	if typ := cb.Get(-1).Type; typ != tyS {
+		t.Fatal("TestFoldStringConcat: unexpected type", typ)
+	}
+	cb.EndInit(1).
+		DefineVarStart(0, "e").Val(ctxRef(pkg, "d")).Val("z").BinaryOp(token.ADD).EndInit(1).
+		DefineVarStart(0, "f").Val("a").Val(s).BinaryOp(token.ADD).Val("b").BinaryOp(token.ADD).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type S string
+
+const c S = "x"
+const d string = "y"
+
+func main(s string) {
+	a := "abc"
+	b := S("axc")
+	e := string("yz")
+	f := "a" + s + "b"
+}
+`)
+}
+
+func TestMaxStringLitWidth(t *testing.T) {
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		Fset: gblFset, Importer: gblImp, MaxStringLitWidth: 20,
+	})
+	const long = "Hello, 世界! this is a long string literal\n"
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(0, "a").Val(long).EndInit(1).
+		DefineVarStart(0, "b").Val(long).Val(0).Index(1, false).EndInit(1).
+		Val(pkg.Builtin().Ref("println")).Val(long).Val("short").Call(2).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+func main() {
+	a := "Hello, 世界! this is" +
+		" a long string lit" +
+		"eral\n"
+	b := ("Hello, 世界! this is" +
+		" a long string lit" +
+		"eral\n")[0]
+	println("Hello, 世界! this is"+
+		" a long string lit"+
+		"eral\n", "short")
+}
+`)
+}
+
+func TestMaxStringLitWidthNoExpr(t *testing.T) {
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		Fset: gblFset, Importer: gblImp, MaxStringLitWidth: 20,
+	})
+	foo := pkg.Import("github.com/goplus/gogen/internal/foo")
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "Name", types.Typ[types.String], false),
+		types.NewField(token.NoPos, pkg.Types, "Nodes", foo.Ref("NodeSet").Type(), false),
+	}
+	tags := []string{`json:"name,omitempty" yaml:"name"`, `json:"nodes,omitempty"`}
+	pkg.NewType("T").InitType(pkg, types.NewStruct(fields, tags))
+	domTest(t, pkg, `package main
+
+import "github.com/goplus/gogen/internal/foo"
+
+type T struct {
+	Name  string      `+"`json:\"name,omitempty\" yaml:\"name\"`"+`
+	Nodes foo.NodeSet `+"`json:\"nodes,omitempty\"`"+`
+}
+`)
+}
+
+func TestSnippetLocals(t *testing.T) {
+	pkg := newMainPackage()
+	n := types.NewVar(token.NoPos, pkg.Types, "n", types.Typ[types.Int])
+	snip := pkg.NewSnippet(n)
+	snip.BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "tmp").
+		VarRef(ctxRef(pkg, "tmp")).Val(n).Assign(1).
+		Block().
+		NewVar(types.Typ[types.Int], "y").
+		VarRef(n).Val(ctxRef(pkg, "y")).Assign(1).
+		End().
+		End()
+	cb := pkg.NewFunc(nil, "foo", nil, nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "x").NewVar(types.Typ[types.Int], "y").
+		Snippet(snip, []types.Object{ctxRef(pkg, "x")})
+	func() {
+		defer func() {
+			if e := recover(); e == nil || e.(error).Error() != `-: snippet declares tmp, which is already in scope
+-: snippet declares y, which shadows the object for snippet variable n` {
+				t.Fatal("Snippet:", e)
+			}
+		}()
+		cb.Snippet(snip, []types.Object{ctxRef(pkg, "y")})
+	}()
+	cb.End()
+	domTest(t, pkg, `package main
+
+func foo() {
+	var x int
+	var y int
+	var tmp int
+	tmp = x
+	{
+		var y int
+		x = y
+	}
+}
+`)
+}
+
+func TestGoDeferWrap(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.Int])
+	pkg.NewVar(token.NoPos, types.Typ[types.Int], "n")
+	pkg.NewFunc(nil, "next", nil, types.NewTuple(ret), false).BodyStart(pkg).
+		VarRef(ctxRef(pkg, "n")).IncDec(token.INC).
+		Val(ctxRef(pkg, "n")).Return(1).
+		End()
+	next := ctxRef(pkg, "next")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).Val(next).Call(0).Call(1).Defer().
+		Val(fmt.Ref("Println")).Val(next).Call(0).Call(1).Defer(true).
+		Val(fmt.Ref("Println")).Val(next).Call(0).Call(1).Go(false).
+		Val(fmt.Ref("Println")).Val(next).Call(0).Call(1).Go(true).
+		End()
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+var n int
+
+func next() int {
+	n++
+	return n
+}
+func main() {
+	defer fmt.Println(next())
+	defer func() {
+		fmt.Println(next())
+	}()
+	go fmt.Println(next())
+	go func() {
+		fmt.Println(next())
+	}()
+}
+`)
+}
+
+func TestSwitch(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		/**/ Switch().DefineVarStart(0, "x").Val(3).EndInit(1).Val(ctxRef(pkg, "x")).Then(). // switch x := 3; x {
+		/**/ Case().Val(1).Val(2).Then(). // case 1, 2:
+		/******/ Val(fmt.Ref("Println")).Val("1 or 2").Call(1).EndStmt().
+		/******/ End().
+		/**/ Case().Val(3).Then(). // case 3:
+		/******/ Val(fmt.Ref("Println")).Val("3").Call(1).EndStmt().
+		/******/ End().
+		/**/ DefaultThen(). // default:
+		/******/ Val(fmt.Ref("Println")).Val("other").Call(1).EndStmt().
+		/******/ End().
+		/**/ End(). // end switch
+		End()
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+func main() {
+	switch x := 3; x {
+	case 1, 2:
+		fmt.Println("1 or 2")
+	case 3:
+		fmt.Println("3")
+	default:
+		fmt.Println("other")
+	}
+}
+`)
+}
+
+func TestUnreachable(t *testing.T) {
+	pkg := newMainPackage()
+	x := pkg.NewParam(token.NoPos, "x", types.Typ[types.Int])
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.String])
+	pkg.NewFunc(nil, "name", types.NewTuple(x), types.NewTuple(ret), false).BodyStart(pkg).
+		/**/ Switch().Val(x).Then().
+		/**/ Case().Val(0).Then().
+		/******/ Val("zero").Return(1).
+		/******/ End().
+		/**/ Case().Val(1).Then().
+		/******/ Val("one").Return(1).
+		/******/ End().
+		/**/ DefaultThen().
+		/******/ Unreachable().
+		/******/ End().
+		/**/ End().
+		End()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Unreachable("invalid state").
+		End()
+	domTest(t, pkg, `package main
+
+func name(x int) string {
+	switch x {
+	case 0:
+		return "zero"
+	case 1:
+		return "one"
+	default:
+		panic("unreachable")
+	}
+}
+func main() {
+	panic("invalid state")
 }
 `)
 }
@@ -2256,6 +5150,90 @@ func main() {
 `)
 }
 
+func TestCondChain(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(0, "x").Val(3).EndInit(1)
+	x := ctxRef(pkg, "x")
+	body := func(msg string) func(cb *gogen.CodeBuilder) {
+		return func(cb *gogen.CodeBuilder) {
+			cb.DefineVarStart(0, "y").Val(msg).EndInit(1).
+				Val(fmt.Ref("Println")).Val(ctxRef(pkg, "y")).Call(1).EndStmt()
+		}
+	}
+	cb.CondChain([]gogen.CondBranch{
+		{Cond: func(cb *gogen.CodeBuilder) { cb.Val(x).Val(2).BinaryOp(token.EQL) }, Body: body("x = 2")},
+		{Cond: func(cb *gogen.CodeBuilder) { cb.Val(x).Val(3).BinaryOp(token.LSS) }, Body: body("x < 3")},
+		{Body: body("other")},
+	}).End()
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+func main() {
+	x := 3
+	switch {
+	case x == 2:
+		y := "x = 2"
+		fmt.Println(y)
+	case x < 3:
+		y := "x < 3"
+		fmt.Println(y)
+	default:
+		y := "other"
+		fmt.Println(y)
+	}
+}
+`)
+}
+
+func TestAppendOnlyFunc(t *testing.T) {
+	var buf bytes.Buffer
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	strings := pkg.Import("strings")
+	b := pkg.NewParam(token.NoPos, "b", types.NewPointer(strings.Ref("Builder").Type()))
+	fn := pkg.NewFunc(nil, "gen", types.NewTuple(b), nil, false).AppendOnly(pkg, &buf)
+	if !fn.IsAppendOnly() {
+		t.Fatal("fn.IsAppendOnly: false")
+	}
+	cb := fn.BodyStart(pkg).
+		DefineVarStart(0, "x").Val(1).EndInit(1).
+		SetComments(comment("\n// inc x"), true).
+		VarRef(ctxRef(pkg, "x")).IncDec(token.INC).EndStmt()
+	if buf.String() != "func gen(b *strings.Builder) {\n\tx := 1\n" {
+		t.Fatalf("flush: %q", buf.String())
+	}
+	cb.If().Val(ctxRef(pkg, "x")).Val(0).BinaryOp(token.GTR).Then().
+		Val(fmt.Ref("Fprintln")).Val(b).Val(ctxRef(pkg, "x")).Call(2).EndStmt().
+		End().
+		End()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
+	domTest(t, pkg, `package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func main() {
+}
+`)
+	if buf.String() != `func gen(b *strings.Builder) {
+	x := 1
+
+	// inc x
+	x++
+	if x > 0 {
+		fmt.Fprintln(b, x)
+	}
+}
+` {
+		t.Fatal("AppendOnly:", buf.String())
+	}
+}
+
 func TestFor(t *testing.T) {
 	pkg := newMainPackage()
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
@@ -2278,6 +5256,113 @@ func main() {
 `)
 }
 
+func TestLoopVarCopy(t *testing.T) {
+	build := func(pkg *gogen.Package) {
+		fmt := pkg.Import("fmt")
+		tyFns := types.NewSlice(types.NewSignatureType(nil, nil, nil, nil, nil, false))
+		cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+			DefineVarStart(0, "a").Val(1).Val(2).SliceLit(nil, 2).EndInit(1).
+			NewVar(tyFns, "fns")
+		fns := ctxRef(pkg, "fns")
+		cb.ForRange("k", "v").Val(ctxRef(pkg, "a")).RangeAssignThen(token.NoPos). // go closure
+			/**/ NewClosure(nil, nil, false).BodyStart(pkg).
+			/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "v")).Call(1).EndStmt().
+			/**/ End().Call(0).Go().
+			/**/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "k")).Call(1).EndStmt().
+			End().
+			ForRange("i").Val(ctxRef(pkg, "a")).RangeAssignThen(token.NoPos). // closure called immediately
+			/**/ NewClosure(nil, nil, false).BodyStart(pkg).
+			/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "i")).Call(1).EndStmt().
+			/**/ End().Call(0).EndStmt().
+			End().
+			For().DefineVarStart(0, "i").Val(0).EndInit(1). // closure appended
+			/**/ Val(ctxRef(pkg, "i")).Val(3).BinaryOp(token.LSS).Then().
+			/**/ VarRef(fns).Val(ctxRef(pkg, "append")).Val(fns).
+			/******/ NewClosure(nil, nil, false).BodyStart(pkg).
+			/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "i")).Call(1).EndStmt().
+			/******/ End().Call(2).Assign(1).
+			/**/ Post().
+			/**/ VarRef(ctxRef(pkg, "i")).IncDec(token.INC).
+			End().
+			For().DefineVarStart(0, "j").Val(0).EndInit(1). // loop variable assigned in body
+			/**/ Val(ctxRef(pkg, "j")).Val(3).BinaryOp(token.LSS).Then().
+			/**/ NewClosure(nil, nil, false).BodyStart(pkg).
+			/******/ Val(fmt.Ref("Println")).Val(ctxRef(pkg, "j")).Call(1).EndStmt().
+			/**/ End().Call(0).Defer().
+			/**/ VarRef(ctxRef(pkg, "j")).IncDec(token.INC).
+			End().
+			End()
+	}
+	pkg := newGoVersionPackage("go1.21")
+	build(pkg)
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+func main() {
+	a := []int{1, 2}
+	var fns []func()
+	for k, v := range a {
+		v := v
+		go func() {
+			fmt.Println(v)
+		}()
+		fmt.Println(k)
+	}
+	for i := range a {
+		func() {
+			fmt.Println(i)
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		i := i
+		fns = append(fns, func() {
+			fmt.Println(i)
+		})
+	}
+	for j := 0; j < 3; {
+		defer func() {
+			fmt.Println(j)
+		}()
+		j++
+	}
+}
+`)
+	pkg = newGoVersionPackage("go1.22")
+	build(pkg)
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+func main() {
+	a := []int{1, 2}
+	var fns []func()
+	for k, v := range a {
+		go func() {
+			fmt.Println(v)
+		}()
+		fmt.Println(k)
+	}
+	for i := range a {
+		func() {
+			fmt.Println(i)
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		fns = append(fns, func() {
+			fmt.Println(i)
+		})
+	}
+	for j := 0; j < 3; {
+		defer func() {
+			fmt.Println(j)
+		}()
+		j++
+	}
+}
+`)
+}
+
 func TestLoopFor(t *testing.T) {
 	pkg := newMainPackage()
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
@@ -2425,6 +5510,76 @@ func main() {
 `)
 }
 
+func TestForRangeStringRunes(t *testing.T) {
+	pkg := newPackage("main", true)
+	myStr := pkg.NewType("MyStr").InitType(pkg, types.Typ[types.String])
+	strAlias := pkg.AliasType("StrAlias", types.Typ[types.String])
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(myStr, "s").
+		NewVar(strAlias, "t").
+		NewVar(types.NewSlice(types.Typ[types.Byte]), "b").
+		/**/ ForRange("i", "r").VarVal("s").RangeAssignThen(token.NoPos).
+		/******/ NewVarStart(types.Typ[types.Int], "_").Val(ctxRef(pkg, "i")).EndInit(1).
+		/******/ NewVarStart(types.Typ[types.Rune], "_").Val(ctxRef(pkg, "r")).EndInit(1).
+		/**/ End().
+		/**/ ForRange("i", "r").VarVal("t").RangeAssignThen(token.NoPos).
+		/******/ NewVarStart(types.Typ[types.Rune], "_").Val(ctxRef(pkg, "r")).EndInit(1).
+		/**/ End().
+		/**/ ForRange("i", "r").Val("héllo").RangeAssignThen(token.NoPos).
+		/******/ NewVarStart(types.Typ[types.Rune], "_").Val(ctxRef(pkg, "r")).EndInit(1).
+		/**/ End().
+		/**/ ForRange("i", "c").VarVal("b").RangeAssignThen(token.NoPos).
+		/******/ NewVarStart(types.Typ[types.Byte], "_").Val(ctxRef(pkg, "c")).EndInit(1).
+		/**/ End().
+		End()
+	domTest(t, pkg, `package main
+
+type MyStr string
+type StrAlias = string
+
+func main() {
+	var s MyStr
+	var t StrAlias
+	var b []uint8
+	for i, r := range s {
+		var _ int = i
+		var _ int32 = r
+	}
+	for i, r := range t {
+		var _ int32 = r
+	}
+	for i, r := range "héllo" {
+		var _ int32 = r
+	}
+	for i, c := range b {
+		var _ uint8 = c
+	}
+}
+`)
+}
+
+func TestChanDirAssign(t *testing.T) {
+	pkg := newMainPackage()
+	tyChan := types.NewChan(types.SendRecv, types.Typ[types.Int])
+	tyRecv := types.NewChan(types.RecvOnly, types.Typ[types.Int])
+	tySend := types.NewChan(types.SendOnly, types.Typ[types.Int])
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(tyChan, "c").
+		NewVarStart(tyRecv, "r").VarVal("c").EndInit(1).
+		NewVarStart(tySend, "s").VarVal("c").EndInit(1).
+		VarRef(ctxRef(pkg, "r")).Typ(tyRecv).VarVal("c").Call(1).Assign(1).
+		End()
+	domTest(t, pkg, `package main
+
+func main() {
+	var c chan int
+	var r <-chan int = c
+	var s chan<- int = c
+	r = (<-chan int)(c)
+}
+`)
+}
+
 func TestForRangeArrayPointer(t *testing.T) {
 	pkg := newMainPackage()
 	v := pkg.NewParam(token.NoPos, "a", types.NewPointer(types.NewArray(types.Typ[types.Float64], 3)))
@@ -2561,6 +5716,38 @@ func main() {
 `)
 }
 
+func TestReturnConv(t *testing.T) {
+	pkg := newMainPackage()
+	foo := pkg.NewType("foo").InitType(pkg, types.Typ[types.Int])
+	recv := pkg.NewParam(token.NoPos, "p", foo)
+	ret := pkg.NewParam(token.NoPos, "", types.Typ[types.String])
+	pkg.NewFunc(recv, "Error", nil, gogen.NewTuple(ret), false).BodyStart(pkg).
+		Val("foo").Return(1).
+		End()
+	retFoo := pkg.NewParam(token.NoPos, "", foo)
+	pkg.NewFunc(nil, "bar", nil, gogen.NewTuple(retFoo), false).BodyStart(pkg).
+		Val(42).Return(1).
+		End()
+	retErr := pkg.NewParam(token.NoPos, "", gogen.TyError)
+	pkg.NewFunc(nil, "baz", nil, gogen.NewTuple(retErr), false).BodyStart(pkg).
+		Val(ctxRef(pkg, "bar")).Call(0).Return(1).
+		End()
+	domTest(t, pkg, `package main
+
+type foo int
+
+func (p foo) Error() string {
+	return "foo"
+}
+func bar() foo {
+	return 42
+}
+func baz() error {
+	return bar()
+}
+`)
+}
+
 func TestReturnNamedResults(t *testing.T) {
 	pkg := newMainPackage()
 	format := pkg.NewParam(token.NoPos, "format", types.Typ[types.String])
@@ -2644,6 +5831,117 @@ func demo() {
 `, "b.go")
 }
 
+func TestPackageDoc(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
+	pkg.SetCurFile("b.go", true)
+	pkg.NewFunc(nil, "demo", nil, nil, false).BodyStart(pkg).End()
+
+	pkg.SetDoc("Package main is a demo.\n\nIt does nothing.\n")
+	domTest(t, pkg, `// Package main is a demo.
+//
+// It does nothing.
+package main
+
+func main() {
+}
+`)
+	domTestEx(t, pkg, `package main
+
+func demo() {
+}
+`, "b.go")
+
+	err := gogen.WriteFile("_xgo_autogen_doc.go", pkg)
+	if err != nil {
+		t.Fatal("gogen.WriteFile failed:", err)
+	}
+	b, err := os.ReadFile("_xgo_autogen_doc.go")
+	os.Remove("_xgo_autogen_doc.go")
+	if err != nil || !strings.HasPrefix(string(b), gogen.GeneratedHeader+"// Package main is a demo.\n") {
+		t.Fatalf("TestPackageDoc: %q, %v", b, err)
+	}
+
+	pkg.SetDoc("Package main is a demo.", "b.go")
+	if doc, fname := pkg.Doc(); doc == nil || fname != "b.go" {
+		t.Fatal("pkg.Doc:", doc, fname)
+	}
+	domTest(t, pkg, `package main
+
+func main() {
+}
+`)
+	domTestEx(t, pkg, `// Package main is a demo.
+package main
+
+func demo() {
+}
+`, "b.go")
+
+	pkg.SetDoc("")
+	if doc, _ := pkg.Doc(); doc != nil {
+		t.Fatal("pkg.Doc:", doc)
+	}
+}
+
+func TestFloatingComment(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	f := pkg.CurFile()
+	f.AddFloatingComment(nil, "//go:generate stringer -type=Kind")
+	pkg.NewType("Kind").InitType(pkg, types.Typ[types.Int])
+	pkg.NewVarEx(pkg.Types.Scope(), token.NoPos, types.Typ[types.Int], "a", "b")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).Val("Hi").Call(1).EndStmt().
+		End()
+	decls := pkg.ASTFile().Decls
+	if len(decls) != 4 {
+		t.Fatal("TestFloatingComment: unexpected decls -", len(decls))
+	}
+	f.AddFloatingComment(decls[1], "//nolint:gochecknoglobals\nglobals below are shared\n")
+	f.AddFloatingComment(decls[1], "end of types")
+	f.AddFloatingComment(decls[len(decls)-1], "//go:generate go run gen.go")
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+//go:generate stringer -type=Kind
+
+type Kind int
+
+//nolint:gochecknoglobals
+// globals below are shared
+
+// end of types
+
+var a, b int
+
+func main() {
+	fmt.Println("Hi")
+}
+
+//go:generate go run gen.go
+`)
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatal("TestFloatingComment: no error?")
+		}
+	}()
+	f.AddFloatingComment(&ast.GenDecl{Tok: token.VAR}, "x")
+}
+
+func TestFloatingCommentNoImports(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.CurFile().AddFloatingComment(nil, "Code below is generated.")
+	pkg.NewVar(token.NoPos, types.Typ[types.Int], "a")
+	domTest(t, pkg, `package main
+
+// Code below is generated.
+
+var a int
+`)
+}
+
 type FmtPatchImporter struct{}
 
 func (m *FmtPatchImporter) Import(path string) (pkg *types.Package, err error) {
@@ -2733,40 +6031,159 @@ func TestImportMultiFiles(t *testing.T) {
 		Val(fmt.Ref("Println")).Val("Hello").Call(1).EndStmt().
 		End()
 
-	domTestEx(t, pkg, `package main
+	domTestEx(t, pkg, `package main
+
+import fmt1 "fmt"
+
+func fmt(v []byte) {
+}
+func main() {
+	fmt1.Println("Hello")
+}
+`, "a.go")
+
+	domTestEx(t, pkg, `package main
+
+import fmt1 "fmt"
+
+func demo() {
+	fmt1.Println("Hello")
+}
+`, "b.go")
+}
+
+func TestImportUnused(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.Import("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
+	domTest(t, pkg, `package main
+
+func main() {
+}
+`)
+}
+
+func TestImportedRefs(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	pkgStrings := pkg.Import("strings")
+	pkgStrconv := pkg.Import("strconv")
+	bytes := pkg.Import("bytes")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(bytes.Ref("Buffer").Type(), "b").
+		Val(fmt.Ref("Println")).Val(pkgStrings.Ref("ToUpper")).Val("a").Call(1).Call(1).EndStmt().
+		Val(fmt.Ref("Println")).Val(pkgStrings.Ref("ToLower")).Val("b").Call(1).Call(1).EndStmt().
+		Val(pkgStrconv.Ref("Itoa")).Val(1).Call(1).ResetStmt()
+	pkg.CB().End()
+	refs := pkg.ImportedRefs()
+	want := map[string][]string{
+		"bytes":   {"Buffer"},
+		"fmt":     {"Println"},
+		"strings": {"ToLower", "ToUpper"},
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Fatal("TestImportedRefs:", refs)
+	}
+}
+
+func TestImportForceUsed(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.ForceImport("fmt")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
+	domTest(t, pkg, `package main
+
+import _ "fmt"
+
+func main() {
+}
+`)
+}
+
+func TestImportComment(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.ForceImport("image/png")
+	pkg.SetImportComment("image/png", comment("// registers the PNG decoder"))
+	fmt := pkg.Import("fmt")
+	pkg.SetImportComment("fmt", comment("/* print */"))
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).Call(0).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import (
+	"fmt"         /* print */
+	_ "image/png" // registers the PNG decoder
+)
+
+func main() {
+	fmt.Println()
+}
+`)
+}
+
+func TestImportComment2(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.ForceImport("image/png")
+	pkg.SetImportComment("image/png", comment("// registers the PNG decoder"))
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
+	domTest(t, pkg, `package main
+
+import _ "image/png" // registers the PNG decoder
+
+func main() {
+}
+`)
+}
+
+func TestImportForceUsed2(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.Import("fmt").MarkForceUsed(pkg)
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
+	domTest(t, pkg, `package main
 
-import fmt1 "fmt"
+import _ "fmt"
 
-func fmt(v []byte) {
-}
 func main() {
-	fmt1.Println("Hello")
 }
-`, "a.go")
-
-	domTestEx(t, pkg, `package main
-
-import fmt1 "fmt"
+`)
+}
 
-func demo() {
-	fmt1.Println("Hello")
+type countingImporter struct {
+	n map[string]int
 }
-`, "b.go")
+
+func (p *countingImporter) Import(path string) (*types.Package, error) {
+	p.n[path]++
+	return gblImp.Import(path)
 }
 
-func TestImportUnused(t *testing.T) {
-	pkg := newMainPackage()
-	pkg.Import("fmt")
-	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
+func TestImportDedup(t *testing.T) {
+	imp := &countingImporter{n: make(map[string]int)}
+	pkg := gogen.NewPackage("", "main", &gogen.Config{Fset: gblFset, Importer: imp})
+	fmt := pkg.Import("fmt")
+	pkg.ForceImport("fmt")
+	if fmt2 := pkg.Import("fmt"); fmt2.Types != fmt.Types {
+		t.Fatal("TestImportDedup: Import returns a different package")
+	}
+	if imp.n["fmt"] != 1 {
+		t.Fatal("TestImportDedup: imported", imp.n["fmt"], "times")
+	}
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(fmt.Ref("Println")).Call(0).EndStmt().
+		End()
 	domTest(t, pkg, `package main
 
+import "fmt"
+
 func main() {
+	fmt.Println()
 }
 `)
 }
 
-func TestImportForceUsed(t *testing.T) {
+func TestImportForceUsed3(t *testing.T) {
 	pkg := newMainPackage()
+	pkg.Import("fmt")
 	pkg.ForceImport("fmt")
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
 	domTest(t, pkg, `package main
@@ -2778,9 +6195,10 @@ func main() {
 `)
 }
 
-func TestImportForceUsed2(t *testing.T) {
+func TestImportForceUsed4(t *testing.T) {
 	pkg := newMainPackage()
-	pkg.Import("fmt").MarkForceUsed(pkg)
+	pkg.ForceImport("fmt")
+	fmt := pkg.Import("fmt")
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).End()
 	domTest(t, pkg, `package main
 
@@ -2789,6 +6207,9 @@ import _ "fmt"
 func main() {
 }
 `)
+	if fmt.Types == nil {
+		t.Fatal("TestImportForceUsed4: nil package")
+	}
 }
 
 func TestImportAnyWhere(t *testing.T) {
@@ -2969,6 +6390,54 @@ func bar(v foo.NodeSeter) {
 `)
 }
 
+func TestOverloadInterfaceMethod2(t *testing.T) {
+	pkg := newMainPackage()
+	bar := pkg.Import("github.com/goplus/gogen/internal/overload")
+	v := pkg.NewParam(token.NoPos, "w", bar.Ref("Writer").Type())
+	pkg.NewFunc(nil, "foo", types.NewTuple(v), nil, false).BodyStart(pkg).
+		Val(v).MemberVal("Write").Val("Hi").Call(1).EndStmt().
+		Val(v).MemberVal("Write").Val(nil).Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import "github.com/goplus/gogen/internal/overload"
+
+func foo(w overload.Writer) {
+	w.WriteString("Hi")
+	w.WriteBytes(nil)
+}
+`)
+}
+
+func TestOverloadInterfaceMethod3(t *testing.T) {
+	pkg := newMainPackage()
+	tyBytes := types.NewSlice(types.Typ[types.Byte])
+	m0 := types.NewFunc(token.NoPos, pkg.Types, "Write__0", types.NewSignatureType(
+		nil, nil, nil, types.NewTuple(pkg.NewParam(token.NoPos, "s", types.Typ[types.String])), nil, false))
+	m1 := types.NewFunc(token.NoPos, pkg.Types, "Write__1", types.NewSignatureType(
+		nil, nil, nil, types.NewTuple(pkg.NewParam(token.NoPos, "b", tyBytes)), nil, false))
+	iface := types.NewInterfaceType([]*types.Func{m0, m1}, nil).Complete()
+	writer := pkg.NewType("Writer").InitType(pkg, iface)
+	gogen.NewOverloadMethod(writer, token.NoPos, pkg.Types, "Write", m0, m1)
+	v := pkg.NewParam(token.NoPos, "w", writer)
+	pkg.NewFunc(nil, "foo", types.NewTuple(v), nil, false).BodyStart(pkg).
+		Val(v).MemberVal("Write").Val("Hi").Call(1).EndStmt().
+		Val(v).MemberVal("Write").Val(nil).Call(1).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+type Writer interface {
+	Write__0(s string)
+	Write__1(b []uint8)
+}
+
+func foo(w Writer) {
+	w.Write__0("Hi")
+	w.Write__1(nil)
+}
+`)
+}
+
 func TestPkgVar(t *testing.T) {
 	pkg := newMainPackage()
 	flag := pkg.Import("flag")
@@ -3055,6 +6524,48 @@ func foo(t *testing.T) {
 `)
 }
 
+func TestMethodRecv(t *testing.T) {
+	pkg := newMainPackage()
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "x", types.Typ[types.Int], false),
+	}
+	foo := pkg.NewType("foo").InitType(pkg, types.NewStruct(fields, nil))
+	recv := pkg.NewParam(token.NoPos, "p", types.NewPointer(foo))
+	pkg.NewFunc(recv, "Get", nil, nil, false).BodyStart(pkg).End()
+	fn := pkg.NewFunc(recv, "Set", nil, nil, false)
+	if fn.Recv() != recv {
+		t.Fatal("fn.Recv:", fn.Recv())
+	}
+	fn.BodyStart(pkg).
+		Val(fn.Recv()).MemberRef("x").Val(1).Assign(1).
+		VarRef(fn.Recv()).Val(ctxRef(pkg, "p")).Assign(1).
+		Val(fn.Recv()).MemberVal("Get").Call(0).EndStmt().
+		VarRef(nil).Val(ctxRef(pkg, "p")).MemberVal("x").Assign(1).
+		End()
+	if f := pkg.NewFunc(nil, "main", nil, nil, false); f.Recv() != nil {
+		t.Fatal("main.Recv:", f.Recv())
+	} else {
+		f.BodyStart(pkg).End()
+	}
+	domTest(t, pkg, `package main
+
+type foo struct {
+	x int
+}
+
+func (p *foo) Get() {
+}
+func (p *foo) Set() {
+	p.x = 1
+	p = p
+	p.Get()
+	_ = p.x
+}
+func main() {
+}
+`)
+}
+
 func TestStructMember(t *testing.T) {
 	pkg := newMainPackage()
 	fields := []*types.Var{
@@ -3155,6 +6666,33 @@ func main() {
 `)
 }
 
+func TestAssignCommaOk(t *testing.T) {
+	pkg := newMainPackage()
+	tyBool := pkg.NewType("B").InitType(pkg, types.Typ[types.Bool])
+	y := pkg.NewParam(token.NoPos, "y", types.NewMap(types.Typ[types.String], types.Typ[types.Int]))
+	x := pkg.NewParam(token.NoPos, "x", gogen.TyEmptyInterface)
+	ch := pkg.NewParam(token.NoPos, "ch", types.NewChan(types.SendRecv, types.Typ[types.Int]))
+	pkg.NewFunc(nil, "foo", gogen.NewTuple(x, y, ch), nil, false).BodyStart(pkg).
+		NewVar(types.Typ[types.Int], "v").
+		NewVar(tyBool, "ok").
+		VarRef(ctxRef(pkg, "v")).VarRef(ctxRef(pkg, "ok")).Val(y).Val("a").Index(1, true).Assign(2, 1).
+		VarRef(ctxRef(pkg, "v")).VarRef(ctxRef(pkg, "ok")).Val(x).TypeAssert(types.Typ[types.Int], true).Assign(2, 1).
+		VarRef(ctxRef(pkg, "v")).VarRef(ctxRef(pkg, "ok")).Val(ch).UnaryOp(token.ARROW, true).Assign(2, 1).
+		End()
+	domTest(t, pkg, `package main
+
+type B bool
+
+func foo(x interface{}, y map[string]int, ch chan int) {
+	var v int
+	var ok B
+	v, ok = y["a"]
+	v, ok = x.(int)
+	v, ok = <-ch
+}
+`)
+}
+
 func TestIndex(t *testing.T) {
 	pkg := newMainPackage()
 
@@ -3369,6 +6907,35 @@ func main() {
 `)
 }
 
+func TestBinaryOpNamedBasic(t *testing.T) {
+	pkg := newMainPackage()
+	celsius := pkg.NewType("Celsius").InitType(pkg, types.Typ[types.Float64])
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(celsius, "a", "b").
+		DefineVarStart(0, "c").VarVal("a").VarVal("b").BinaryOp(token.ADD).EndInit(1).
+		DefineVarStart(0, "d").Val(2).VarVal("a").BinaryOp(token.MUL).EndInit(1).
+		DefineVarStart(0, "e").VarVal("a").UnaryOp(token.SUB).EndInit(1).
+		DefineVarStart(0, "ok").VarVal("a").Val(1.5).BinaryOp(token.GTR).EndInit(1)
+	for _, name := range []string{"c", "d", "e"} {
+		if typ := ctxRef(pkg, name).Type(); typ != celsius {
+			t.Fatal("TestBinaryOpNamedBasic:", name, typ)
+		}
+	}
+	cb.End()
+	domTest(t, pkg, `package main
+
+type Celsius float64
+
+func main() {
+	var a, b Celsius
+	c := a + b
+	d := 2 * a
+	e := -a
+	ok := a > 1.5
+}
+`)
+}
+
 func TestBinaryOpSHL(t *testing.T) {
 	pkg := newMainPackage()
 	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
@@ -3454,6 +7021,101 @@ func main() {
 `)
 }
 
+func TestCurriedFunc(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	a := pkg.NewParam(token.NoPos, "a", tyInt)
+	b := pkg.NewParam(token.NoPos, "b", tyInt)
+	c := pkg.NewParam(token.NoPos, "c", tyInt)
+	sigInner := types.NewSignatureType(nil, nil, nil,
+		types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), false)
+	sigOuter := types.NewSignatureType(nil, nil, nil,
+		types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), types.NewTuple(pkg.NewParam(token.NoPos, "", sigInner)), false)
+	pkg.NewFunc(nil, "add", types.NewTuple(a), types.NewTuple(pkg.NewParam(token.NoPos, "", sigInner)), false).
+		BodyStart(pkg).
+		/**/ NewClosure(types.NewTuple(b), types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), false).BodyStart(pkg).
+		/****/ Val(a).Val(b).BinaryOp(token.ADD).Return(1).
+		/**/ End().
+		/**/ Return(1).
+		End()
+	pkg.NewFunc(nil, "add3", types.NewTuple(a), types.NewTuple(pkg.NewParam(token.NoPos, "", sigOuter)), false).
+		BodyStart(pkg).
+		/**/ NewClosure(types.NewTuple(b), types.NewTuple(pkg.NewParam(token.NoPos, "", sigInner)), false).BodyStart(pkg).
+		/****/ NewClosure(types.NewTuple(c), types.NewTuple(pkg.NewParam(token.NoPos, "", tyInt)), false).BodyStart(pkg).
+		/******/ Val(a).Val(b).BinaryOp(token.ADD).Val(c).BinaryOp(token.ADD).Return(1).
+		/****/ End().
+		/****/ Return(1).
+		/**/ End().
+		/**/ Return(1).
+		End()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "x").
+		/**/ Val(ctxRef(pkg, "add3")).Val(1).Call(1).Val(2).Call(1).Val(3).Call(1).
+		/**/ Val(ctxRef(pkg, "add")).Val(4).Call(1).Val(5).Call(1).BinaryOp(token.ADD).
+		EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+func add(a int) func(int) int {
+	return func(b int) int {
+		return a + b
+	}
+}
+func add3(a int) func(int) func(int) int {
+	return func(b int) func(int) int {
+		return func(c int) int {
+			return a + b + c
+		}
+	}
+}
+func main() {
+	x := add3(1)(2)(3) + add(4)(5)
+}
+`)
+}
+
+func TestCompositeLitArgs(t *testing.T) {
+	pkg := newMainPackage()
+	tyInt := types.Typ[types.Int]
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "X", tyInt, false),
+		types.NewField(token.NoPos, pkg.Types, "Y", tyInt, false),
+	}
+	point := pkg.NewType("Point").InitType(pkg, types.NewStruct(fields, nil))
+	tyPoints := types.NewSlice(point)
+	tyMap := types.NewMap(types.Typ[types.String], point)
+	params := types.NewTuple(
+		pkg.NewParam(token.NoPos, "p", point),
+		pkg.NewParam(token.NoPos, "pp", types.NewPointer(point)),
+		pkg.NewParam(token.NoPos, "ps", tyPoints),
+		pkg.NewParam(token.NoPos, "m", tyMap),
+		pkg.NewParam(token.NoPos, "a", types.NewArray(tyInt, 2)),
+	)
+	pkg.NewFunc(nil, "draw", params, nil, false).BodyStart(pkg).End()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(ctxRef(pkg, "draw")).
+		/**/ Val(1).Val(2).StructLit(point, 2, false).
+		/**/ Val(0).Val(3).StructLit(point, 2, true).UnaryOp(token.AND).
+		/**/ Val(1).Val(2).StructLit(point, 2, false).SliceLit(tyPoints, 1).
+		/**/ Val("o").StructLit(point, 0, false).MapLit(tyMap, 2).
+		/**/ Val(1).Val(2).ArrayLit(types.NewArray(tyInt, 2), 2).
+		/**/ Call(5).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+type Point struct {
+	X int
+	Y int
+}
+
+func draw(p Point, pp *Point, ps []Point, m map[string]Point, a [2]int) {
+}
+func main() {
+	draw(Point{1, 2}, &Point{X: 3}, []Point{Point{1, 2}}, map[string]Point{"o": Point{}}, [2]int{1, 2})
+}
+`)
+}
+
 func TestClosureAutoParamRet(t *testing.T) {
 	pkg := newMainPackage()
 	ret := pkg.NewAutoParam("ret")
@@ -3596,6 +7258,129 @@ func main() {
 `)
 }
 
+func TestSession(t *testing.T) {
+	pkg := newMainPackage()
+	sess := gogen.NewSession()
+	pkg.NewFunc(nil, "line1", nil, nil, false).BodyStartWith(pkg, sess).
+		DefineVarStart(0, "x").Val(1).EndInit(1).
+		End()
+	if vars := sess.Commit(); len(vars) != 1 || vars[0].Name() != "x" {
+		t.Fatal("line1: Commit", vars)
+	}
+	pkg.NewFunc(nil, "line2", nil, nil, false).BodyStartWith(pkg, sess).
+		DefineVarStart(0, "y").Val(ctxRef(pkg, "x")).Val(1).BinaryOp(token.ADD).EndInit(1).
+		DefineVarStart(0, "x", "z").Val(2).Val("hi").EndInit(2).
+		End()
+	vars := sess.Commit()
+	if len(vars) != 2 || vars[0].Name() != "y" || vars[1].Name() != "z" {
+		t.Fatal("line2: Commit", vars)
+	}
+	if names := sess.Names(); strings.Join(names, ",") != "x,y,z" {
+		t.Fatal("sess.Names:", names)
+	}
+	if sess.Lookup("z").Type() != types.Typ[types.String] {
+		t.Fatal("sess.Lookup z:", sess.Lookup("z"))
+	}
+	fmt := pkg.Import("fmt")
+	pkg.NewFunc(nil, "line3", nil, nil, false).BodyStartWith(pkg, sess).
+		Val(fmt.Ref("Println")).Val(ctxRef(pkg, "z")).Val(ctxRef(pkg, "y")).Call(2).EndStmt().
+		VarRef(ctxRef(pkg, "y")).Val(3).Assign(1).
+		End()
+	if vars := sess.Commit(); len(vars) != 0 {
+		t.Fatal("line3: Commit", vars)
+	}
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+func line1() {
+	x := 1
+}
+func line2() {
+	var x int
+	y := x + 1
+	x, z := 2, "hi"
+}
+func line3() {
+	var y int
+	var z string
+	fmt.Println(z, y)
+	y = 3
+}
+`)
+}
+
+func TestMangleName(t *testing.T) {
+	pkg := gogen.NewPackage("", "main", &gogen.Config{
+		MangleName: func(name string) string {
+			return strings.ReplaceAll(name, "-", "_") + "_"
+		},
+	})
+	pkg.NewVar(token.NoPos, types.Typ[types.Int], "type", "_", "a")
+	pkg.NewType("func").InitType(pkg, types.Typ[types.Int])
+	pkg.NewFunc(nil, "select", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "my-var").Val(1).EndInit(1).
+		VarRef(nil).Val(ctxRef(pkg, "my_var_")).Assign(1).
+		End()
+	domTest(t, pkg, `package main
+
+var type_, _, a int
+
+type func_ int
+
+func select_() {
+	my_var_ := 1
+	_ = my_var_
+}
+`)
+}
+
+func TestAutoNamePerFunc(t *testing.T) {
+	gen := func(withBar bool) string {
+		pkg := newMainPackage()
+		fmt := pkg.Import("fmt")
+		ret := pkg.NewAutoParam("ret")
+		sig := types.NewSignatureType(nil, nil, nil, nil, gogen.NewTuple(ret), false)
+		newFn := func(name string) {
+			pkg.NewFunc(nil, name, nil, nil, false).BodyStart(pkg).
+				Val(fmt.Ref("Println")).
+				CallInlineClosureStart(sig, 0, false).
+				/**/ Val(name).Return(1).
+				/**/ End().
+				Call(1).EndStmt().
+				End()
+		}
+		newFn("foo")
+		if withBar {
+			newFn("bar")
+		}
+		newFn("main")
+		var b bytes.Buffer
+		if err := gogen.WriteTo(&b, pkg, ""); err != nil {
+			t.Fatal("gogen.WriteTo failed:", err)
+		}
+		return b.String()
+	}
+	const bar = `func bar() {
+	var _autoGo_1 string
+	{
+		_autoGo_1 = "bar"
+		goto _autoGo_2
+	_autoGo_2:
+	}
+	fmt.Println(_autoGo_1)
+}
+`
+	old, ret := gen(false), gen(true)
+	pos := strings.Index(ret, bar)
+	if pos < 0 {
+		t.Fatal("TestAutoNamePerFunc: func bar not found\n", ret)
+	}
+	if v := ret[:pos] + ret[pos+len(bar):]; v != old {
+		t.Fatalf("TestAutoNamePerFunc:\nResult:\n%s\nExpected:\n%s\n", v, old)
+	}
+}
+
 // ----------------------------------------------------------------------------
 
 func TestExample(t *testing.T) {
@@ -3748,6 +7533,42 @@ func main() {
 `)
 }
 
+func TestImportVendorInternal(t *testing.T) {
+	gt := newGoxTest()
+	if _, err := gt.LoadGoPackage("example.com/m/vendor/github.com/x/y", "y.go", "package y\n\nfunc F() {}\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gt.LoadGoPackage("example.com/m/internal/z", "z.go", "package z\n\nfunc G() {}\n"); err != nil {
+		t.Fatal(err)
+	}
+	pkg := gt.NewPackageEx("example.com/m/cmd/gen", "main", &gogen.Config{
+		Fset:     gt.fset,
+		Importer: gt.imp,
+		DestPath: "example.com/m/cmd/gen",
+	})
+	y := pkg.Import("example.com/m/vendor/github.com/x/y")
+	z := pkg.Import("example.com/m/internal/z")
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(y.Ref("F")).Call(0).EndStmt().
+		Val(z.Ref("G")).Call(0).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+import (
+	"example.com/m/internal/z"
+	"github.com/x/y"
+)
+
+func main() {
+	y.F()
+	z.G()
+}
+`)
+	if pkg.TryImport("example.com/other/internal/w").Types != nil {
+		t.Fatal("TestImportVendorInternal: import invisible internal package")
+	}
+}
+
 func TestPackageName(t *testing.T) {
 	const src = `package foo2
 
@@ -3828,6 +7649,103 @@ func main() {
 `)
 }
 
+func TestRefLater(t *testing.T) {
+	pkg := newMainPackage()
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(pkg.RefLater("foo")).Val(1).Call(1).EndStmt().
+		End()
+	params := types.NewTuple(pkg.NewParam(token.NoPos, "v", types.Typ[types.Int]))
+	pkg.NewFunc(nil, "foo", params, nil, false).BodyStart(pkg).End()
+	if pkg.RefLater("foo") != pkg.Types.Scope().Lookup("foo") {
+		t.Fatal("RefLater: foo is declared")
+	}
+	domTest(t, pkg, `package main
+
+func main() {
+	foo(1)
+}
+func foo(v int) {
+}
+`)
+}
+
+func TestRefLaterErr(t *testing.T) {
+	pkg := newMainPackage()
+	cb := pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(pkg.RefLater("foo"), source("foo", 2, 2)).Val("hi", source(`"hi"`, 2, 6)).
+		CallWith(1, 0, source(`foo("hi")`, 2, 2)).EndStmt().
+		Val(pkg.RefLater("bar"), source("bar", 3, 2)).CallWith(0, 0, source("bar()", 3, 2)).EndStmt().
+		Val(pkg.RefLater("baz"), source("baz", 4, 2)).CallWith(0, 0, source("baz()", 4, 2))
+	cb.ResetStmt() // discarded
+	cb.End()
+	params := types.NewTuple(pkg.NewParam(token.NoPos, "v", types.Typ[types.Int]))
+	pkg.NewFunc(nil, "foo", params, nil, false).BodyStart(pkg).End()
+	err := gogen.WriteTo(&bytes.Buffer{}, pkg, "")
+	if _, ok := err.(gogen.LaterRefError); !ok {
+		t.Fatal("RefLater: LaterRefError expected -", err)
+	}
+	const expected = `./foo.gop:3:2: undefined: bar
+./foo.gop:2:6: cannot use "hi" (type untyped string) as type int in argument to foo("hi")`
+	if ret := err.Error(); ret != expected {
+		t.Fatalf("\nError: \"%s\"\nExpected: \"%s\"\n", ret, expected)
+	}
+}
+
+func TestPtrMethodAddressable(t *testing.T) {
+	pkg := newMainPackage()
+
+	tyM := pkg.NewType("M").InitType(pkg, types.NewStruct(nil, nil))
+	recv := pkg.NewParam(token.NoPos, "p", types.NewPointer(tyM))
+	pkg.NewFunc(recv, "Set", nil, nil, false).BodyStart(pkg).End()
+	recv2 := pkg.NewParam(token.NoPos, "p", tyM)
+	pkg.NewFunc(recv2, "Get", nil, nil, false).BodyStart(pkg).End()
+
+	fields := []*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "m", tyM, false),
+	}
+	tyT := pkg.NewType("T").InitType(pkg, types.NewStruct(fields, nil))
+
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		NewVar(tyT, "t").
+		NewVar(types.NewSlice(tyM), "a").
+		NewVar(types.NewPointer(tyT), "pt").
+		NewVar(types.NewMap(types.Typ[types.String], tyM), "mv").
+		NewVar(types.NewMap(types.Typ[types.String], types.NewPointer(tyM)), "mp").
+		VarVal("t").MemberVal("m").MemberVal("Set").Call(0).EndStmt().
+		VarVal("a").Val(0).Index(1, false).MemberVal("Set").Call(0).EndStmt().
+		VarVal("pt").MemberVal("m").MemberVal("Set").Call(0).EndStmt().
+		VarVal("mv").Val("a").Index(1, false).MemberVal("Get").Call(0).EndStmt().
+		VarVal("mp").Val("a").Index(1, false).MemberVal("Set").Call(0).EndStmt().
+		End()
+	domTest(t, pkg, `package main
+
+type M struct {
+}
+
+func (p *M) Set() {
+}
+func (p M) Get() {
+}
+
+type T struct {
+	m M
+}
+
+func main() {
+	var t T
+	var a []M
+	var pt *T
+	var mv map[string]M
+	var mp map[string]*M
+	t.m.Set()
+	a[0].Set()
+	pt.m.Set()
+	mv["a"].Get()
+	mp["a"].Set()
+}
+`)
+}
+
 func TestTypeAliasInFunc(t *testing.T) {
 	if !isLeastGo122() {
 		t.Skip("skip")
@@ -17,7 +17,6 @@ import (
 	"go/ast"
 	"go/token"
 	"go/types"
-	"log"
 
 	"github.com/goplus/gogen/internal"
 )
@@ -242,11 +241,12 @@ func (p *caseStmt) End(cb *CodeBuilder, src ast.Node) {
 //
 // end
 type selectStmt struct {
-	old codeBlockCtx
+	old        codeBlockCtx
+	hasDefault bool
 }
 
 func (p *selectStmt) CommCase(cb *CodeBuilder, src ...ast.Node) {
-	stmt := &commCase{}
+	stmt := &commCase{sel: p}
 	cb.startBlockStmt(stmt, src, "comm case statement", &stmt.old)
 }
 
@@ -259,13 +259,18 @@ func (p *selectStmt) End(cb *CodeBuilder, src ast.Node) {
 type commCase struct {
 	old  codeBlockCtx
 	comm ast.Stmt
+	sel  *selectStmt
 }
 
 func (p *commCase) Then(cb *CodeBuilder, src ...ast.Node) {
 	switch len(cb.current.stmts) {
 	case 1:
 		p.comm = cb.popStmt()
-	case 0:
+	case 0: // default
+		if p.sel.hasDefault {
+			cb.panicCodeError(getPos(src), getEnd(src), "multiple defaults in select")
+		}
+		p.sel.hasDefault = true
 	default:
 		panic("multi commStmt in comm clause?")
 	}
@@ -317,7 +322,8 @@ func (p *typeSwitchStmt) TypeAssertThen(cb *CodeBuilder) {
 	x := cb.stk.Pop()
 	xType, ok := cb.checkInterface(x.Type)
 	if !ok {
-		panic("TODO: can't type assert on non interface expr")
+		code, pos, end := cb.loadExpr(x.Src)
+		cb.panicCodeErrorf(pos, end, "%s (type %v) is not an interface", code, x.Type)
 	}
 	p.x, p.xSrc, p.xType = x.Val, x.Src, xType
 }
@@ -349,6 +355,7 @@ func (p *typeSwitchStmt) End(cb *CodeBuilder, src ast.Node) {
 type typeCaseStmt struct {
 	pss  *typeSwitchStmt
 	list []ast.Expr
+	obj  *types.Var // variable implicitly declared in this clause
 	old  codeBlockCtx
 }
 
@@ -363,13 +370,13 @@ func (p *typeCaseStmt) Then(cb *CodeBuilder, src ...ast.Node) {
 			typ = arg.Type
 			if tt, ok := typ.(*TypeType); ok {
 				typ = tt.Type()
-				if missing := cb.missingMethod(typ, pss.xType); missing != "" {
+				if reason := cb.missingMethod(typ, pss.xType); reason != "" {
 					xsrc, _, _ := cb.loadExpr(pss.xSrc)
 					pos := getSrcPos(arg.Src)
 					end := getSrcEnd(arg.Src)
 					cb.panicCodeErrorf(
-						pos, end, "impossible type switch case: %s (type %v) cannot have dynamic type %v (missing %s method)",
-						xsrc, pss.xType, typ, missing)
+						pos, end, "impossible type switch case: %s (type %v) cannot have dynamic type %v (%s)",
+						xsrc, pss.xType, typ, reason)
 				}
 			} else if typ != types.Typ[types.UntypedNil] {
 				src, pos, end := cb.loadExpr(arg.Src)
@@ -380,18 +387,23 @@ func (p *typeCaseStmt) Then(cb *CodeBuilder, src ...ast.Node) {
 		cb.stk.PopN(n)
 	}
 	if pss.name != "" {
-		if n != 1 { // default, or case with multi expr
+		if n != 1 || typ == types.Typ[types.UntypedNil] { // default, case nil, or case with multi expr
 			typ = pss.xType
 		}
-		name := types.NewParam(token.NoPos, cb.pkg.Types, pss.name, typ)
-		cb.current.scope.Insert(name)
+		p.obj = types.NewVar(token.NoPos, cb.pkg.Types, pss.name, typ)
+		cb.useLocalName(pss.name, token.NoPos, token.NoPos)
+		cb.current.scope.Insert(p.obj)
 	}
 }
 
 func (p *typeCaseStmt) End(cb *CodeBuilder, src ast.Node) {
 	body, flows := cb.endBlockStmt(&p.old)
 	cb.current.flows |= flows
-	cb.emitStmt(&ast.CaseClause{List: p.list, Body: body})
+	clause := &ast.CaseClause{List: p.list, Body: body}
+	if p.obj != nil {
+		cb.pkg.setImplicit(clause, p.obj)
+	}
+	cb.emitStmt(clause)
 }
 
 // ----------------------------------------------------------------------------
@@ -418,6 +430,132 @@ func InsertStmtFront(body *ast.BlockStmt, stmt ast.Stmt) {
 	body.List = list
 }
 
+// ----------------------------------------------------------------------------
+
+// loopVar records references to a loop variable, when the generated code
+// targets a Go version before go1.22 (see Config.GoVersion), where a loop
+// variable is shared by all iterations instead of being per-iteration.
+type loopVar struct {
+	refs     []*ast.Ident
+	assigned bool // assigned in the loop body
+	inPost   bool // building the post statement of a 3-clause for statement
+}
+
+// startLoopVars starts to record references to loop variables names, which
+// are declared in the current scope.
+func (p *CodeBuilder) startLoopVars(names []string) (vars []*types.Var) {
+	if p.pkg.allowGoVersion(22) {
+		return nil
+	}
+	scope := p.current.scope
+	for _, name := range names {
+		if v, ok := scope.Lookup(name).(*types.Var); ok {
+			if p.loopVars == nil {
+				p.loopVars = make(map[types.Object]*loopVar)
+			}
+			p.loopVars[v] = new(loopVar)
+			vars = append(vars, v)
+		}
+	}
+	return
+}
+
+func (p *CodeBuilder) loopVarRef(v types.Object, x ast.Expr, assign bool) {
+	if lv, ok := p.loopVars[v]; ok {
+		if id, ok := x.(*ast.Ident); ok {
+			lv.refs = append(lv.refs, id)
+			if assign && !lv.inPost {
+				lv.assigned = true
+			}
+		}
+	}
+}
+
+// endLoopVars inserts `v := v` at the front of the loop body for each loop
+// variable v which is captured by a closure escaping the iteration, so that
+// the closure sees the value of v in its own iteration as go1.22 does.
+//
+// A variable of a 3-clause for statement which is assigned in the body isn't
+// copied, because the copy would change how the loop iterates.
+func (p *CodeBuilder) endLoopVars(vars []*types.Var, body *ast.BlockStmt, threeClause bool) {
+	var escaping map[*ast.Ident]null
+	var copies []ast.Stmt
+	for _, v := range vars {
+		lv := p.loopVars[v]
+		delete(p.loopVars, v)
+		if lv.refs == nil || (threeClause && lv.assigned) {
+			continue
+		}
+		if escaping == nil {
+			escaping = escapingIdents(body)
+		}
+		for _, id := range lv.refs {
+			if _, ok := escaping[id]; ok {
+				name := v.Name()
+				copies = append(copies, &ast.AssignStmt{
+					Lhs: []ast.Expr{ident(name)}, Tok: token.DEFINE, Rhs: []ast.Expr{ident(name)},
+				})
+				break
+			}
+		}
+	}
+	if copies != nil {
+		body.List = append(copies, body.List...)
+	}
+}
+
+// escapingIdents returns identifiers in closures which may escape the current
+// iteration of a loop. To be conservative, a closure is assumed to escape
+// unless it's called immediately (eg. `func() { ... }()`) and not by a go or
+// defer statement: a closure stored in a variable, passed to a function (eg.
+// append), returned or sent to a channel escapes.
+func escapingIdents(body *ast.BlockStmt) map[*ast.Ident]null {
+	deferred := make(map[*ast.CallExpr]null)
+	called := make(map[*ast.FuncLit]null)
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.GoStmt:
+			deferred[n.Call] = null{}
+		case *ast.DeferStmt:
+			deferred[n.Call] = null{}
+		case *ast.CallExpr:
+			if lit, ok := n.Fun.(*ast.FuncLit); ok {
+				if _, ok = deferred[n]; !ok {
+					called[lit] = null{}
+				}
+			}
+		}
+		return true
+	})
+	ret := make(map[*ast.Ident]null)
+	var escapes []bool
+	var n int // number of escaping closures enclosing the current node
+	ast.Inspect(body, func(node ast.Node) bool {
+		if node == nil {
+			if escapes[len(escapes)-1] {
+				n--
+			}
+			escapes = escapes[:len(escapes)-1]
+			return true
+		}
+		escape := false
+		switch x := node.(type) {
+		case *ast.FuncLit:
+			if _, ok := called[x]; !ok {
+				escape = true
+				n++
+			}
+		case *ast.Ident:
+			if n > 0 {
+				ret[x] = null{}
+			}
+		}
+		escapes = append(escapes, escape)
+		return true
+	})
+	return ret
+}
+
 // ----------------------------------------------------------------------------
 //
 // for init; cond then
@@ -432,6 +570,7 @@ type forStmt struct {
 	body *ast.BlockStmt
 	old  codeBlockCtx
 	old2 codeBlockCtx
+	vars []*types.Var // loop variables, see startLoopVars
 	loopBodyHandler
 }
 
@@ -451,10 +590,22 @@ func (p *forStmt) Then(cb *CodeBuilder, src ...ast.Node) {
 	default:
 		panic("TODO: for condition has too many init statements")
 	}
+	if init, ok := p.init.(*ast.AssignStmt); ok && init.Tok == token.DEFINE {
+		names := make([]string, 0, len(init.Lhs))
+		for _, lhs := range init.Lhs {
+			if id, ok := lhs.(*ast.Ident); ok {
+				names = append(names, id.Name)
+			}
+		}
+		p.vars = cb.startLoopVars(names)
+	}
 	cb.startBlockStmt(p, src, "for body", &p.old2)
 }
 
 func (p *forStmt) Post(cb *CodeBuilder) {
+	for _, v := range p.vars {
+		cb.loopVars[v].inPost = true
+	}
 	stmts, flows := cb.endBlockStmt(&p.old2)
 	cb.current.flows |= (flows &^ (flowFlagBreak | flowFlagContinue))
 	p.body = &ast.BlockStmt{List: stmts}
@@ -474,6 +625,7 @@ func (p *forStmt) End(cb *CodeBuilder, src ast.Node) {
 		p.body = &ast.BlockStmt{List: stmts}
 		cb.endBlockStmt(&p.old)
 	}
+	cb.endLoopVars(p.vars, p.body, true)
 	cb.emitStmt(&ast.ForStmt{
 		Init: p.init, Cond: p.cond, Post: post, Body: p.handleFor(p.body, 0),
 	})
@@ -498,7 +650,8 @@ type forRangeStmt struct {
 	x     *internal.Elem
 	old   codeBlockCtx
 	kvt   []types.Type
-	udt   int // 0: non-udt, 2: (elem,ok), 3: (key,elem,ok)
+	udt   int          // 0: non-udt, 2: (elem,ok), 3: (key,elem,ok)
+	vars  []*types.Var // loop variables, see startLoopVars
 	loopBodyHandler
 }
 
@@ -529,12 +682,15 @@ func (p *forRangeStmt) RangeAssignThen(cb *CodeBuilder, pos token.Pos) {
 			if name == "_" {
 				continue
 			}
+			cb.useLocalName(name, pos, pos)
 			if scope.Insert(types.NewVar(token.NoPos, pkg.Types, name, typs[i])) != nil {
-				log.Panicln("TODO: variable already defined -", name)
+				panicln("TODO: variable already defined -", name)
 			}
 		}
 		if p.udt != 0 {
 			p.x = x
+		} else {
+			p.vars = cb.startLoopVars(names)
 		}
 		p.stmt = &ast.RangeStmt{
 			Key:   ident(names[0]),
@@ -702,7 +858,9 @@ func (p *forRangeStmt) End(cb *CodeBuilder, src ast.Node) {
 	stmts, flows := cb.endBlockStmt(&p.old)
 	cb.current.flows |= (flows &^ (flowFlagBreak | flowFlagContinue))
 	if n := p.udt; n == 0 {
-		p.stmt.Body = p.handleFor(&ast.BlockStmt{List: stmts}, 1)
+		body := &ast.BlockStmt{List: stmts}
+		cb.endLoopVars(p.vars, body, false)
+		p.stmt.Body = p.handleFor(body, 1)
 		cb.emitStmt(p.stmt)
 	} else if n > 0 {
 		cb.stk.Push(p.x)
@@ -119,6 +119,10 @@ func boundType(pkg *Package, arg, param types.Type, parg *internal.Elem) error {
 	case *unboundFuncParam: // template function param
 		if p.tBound == nil {
 			if !p.typ.contract.Match(pkg, arg) {
+				if parg != nil && parg.Src != nil {
+					src, _, _ := pkg.cb.loadExpr(parg.Src)
+					return pkg.cb.newCodeErrorf(pos, end, "invalid argument: %s (type %v) does not satisfy %v", src, arg, p.typ.contract)
+				}
 				return fmt.Errorf("TODO: contract.Match %v => %v failed", arg, p.typ.contract)
 			}
 			p.boundTo(pkg, arg, parg)
@@ -158,7 +162,7 @@ func boundType(pkg *Package, arg, param types.Type, parg *internal.Elem) error {
 		case *types.Struct:
 			panic("TODO: boundType struct")
 		default:
-			log.Panicln("TODO: boundType - unknown type:", param)
+			panicln("TODO: boundType - unknown type:", param)
 		}
 		return fmt.Errorf("TODO: bound %v => unboundProxyParam", arg)
 	case *types.Slice:
@@ -203,7 +207,7 @@ func DefaultConv(pkg *Package, t types.Type, pv *Element) types.Type {
 							typ = typesalias.Unalias(typ)
 						}
 						if ok = assignable(pkg, t, typ.(*types.Named), pv); !ok {
-							log.Panicln("==> DefaultConv failed:", t, typ)
+							panicln("==> DefaultConv failed:", t, typ)
 						}
 						if debugMatch {
 							log.Println("==> DefaultConv", t, typ)
@@ -224,7 +228,7 @@ func DefaultConv(pkg *Package, t types.Type, pv *Element) types.Type {
 				}
 				return o.Type()
 			}
-			log.Panicln("==> DefaultConv failed: overload functions have no default type")
+			panicln("==> DefaultConv failed: overload functions have no default type")
 		}
 	default:
 		return types.Default(t)
@@ -276,7 +280,7 @@ func AssignableConv(pkg *Package, V, T types.Type, pv *Element) bool {
 		V = getElemTypeIf(V, pv)
 	}
 	if types.AssignableTo(V, T) {
-		return assignableTo(V, T, pv)
+		return assignableTo(pkg, V, T, pv)
 	}
 	if t, ok := T.(*types.Named); ok {
 		ok = assignable(pkg, V, t, pv)
@@ -291,12 +295,12 @@ func AssignableConv(pkg *Package, V, T types.Type, pv *Element) bool {
 	return false
 }
 
-func assignableTo(V, T types.Type, pv *Element) bool {
+func assignableTo(pkg *Package, V, T types.Type, pv *Element) bool {
 	if t, ok := T.Underlying().(*types.Basic); ok { // untyped type
 		if v, ok := V.Underlying().(*types.Basic); ok {
 			tkind, vkind := t.Kind(), v.Kind()
 			if vkind >= types.UntypedInt && vkind <= types.UntypedComplex {
-				if tkind <= types.Uintptr && pv != nil && outOfRange(tkind, pv.CVal) {
+				if tkind <= types.Uintptr && pv != nil && outOfRange(pkg, tkind, pv.CVal) {
 					if debugMatch {
 						log.Printf("==> AssignableConv %v (%v): value is out of %v range", V, pv.CVal, T)
 					}
@@ -320,7 +324,7 @@ func assignableTo(V, T types.Type, pv *Element) bool {
 	return true
 }
 
-func outOfRange(tkind types.BasicKind, cval constant.Value) bool {
+func outOfRange(pkg *Package, tkind types.BasicKind, cval constant.Value) bool {
 	// untyped int may not a constant. For an example:
 	//    func GetValue(shift uint) uint {
 	//       return 1 << shift
@@ -328,7 +332,7 @@ func outOfRange(tkind types.BasicKind, cval constant.Value) bool {
 	if cval == nil {
 		return false
 	}
-	rg := tkindRanges[tkind]
+	rg := pkg.intRanges[tkind]
 	return constant.Compare(cval, token.LSS, rg[0]) || constant.Compare(cval, token.GTR, rg[1])
 }
 
@@ -353,8 +357,10 @@ const (
 	maxInt64   = (1 << (64 - 1)) - 1
 )
 
+type intRanges = [types.Uintptr + 1][2]constant.Value
+
 var (
-	tkindRanges = [...][2]constant.Value{
+	tkindRanges = intRanges{
 		types.Int:     {constant.MakeInt64(minInt), constant.MakeInt64(maxInt)},
 		types.Int8:    {constant.MakeInt64(minInt8), constant.MakeInt64(maxInt8)},
 		types.Int16:   {constant.MakeInt64(minInt16), constant.MakeInt64(maxInt16)},
@@ -369,6 +375,24 @@ var (
 	}
 )
 
+// newIntRanges returns value ranges of integer kinds according to sizes of
+// int, uint and uintptr on the target platform.
+func newIntRanges(sizes types.Sizes) *intRanges {
+	ret := tkindRanges
+	for _, kind := range [...]types.BasicKind{types.Int, types.Uint, types.Uintptr} {
+		bits := uint(sizes.Sizeof(types.Typ[kind]) * 8)
+		if kind == types.Int {
+			min := new(big.Int).Lsh(big.NewInt(-1), bits-1)
+			max := new(big.Int).Sub(new(big.Int).Neg(min), big.NewInt(1))
+			ret[kind] = [2]constant.Value{constant.Make(min), constant.Make(max)}
+		} else {
+			max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bits), big.NewInt(1))
+			ret[kind] = [2]constant.Value{constant.MakeInt64(0), constant.Make(max)}
+		}
+	}
+	return &ret
+}
+
 func assignable(pkg *Package, v types.Type, t *types.Named, pv *internal.Elem) bool {
 	o := t.Obj()
 	if at := o.Pkg(); at != nil {
@@ -423,9 +447,6 @@ func ComparableTo(pkg *Package, varg, targ *Element) bool {
 			return untypedComparable(pkg, t, targ, V)
 		}
 	}
-	if getUnderlying(pkg, V) == getUnderlying(pkg, T) {
-		return true
-	}
 	return AssignableConv(pkg, V, T, varg) || AssignableConv(pkg, T, V, targ)
 }
 
@@ -580,7 +601,7 @@ func toNormalize(tparams []*unboundFuncParam, typ types.Type) (types.Type, bool)
 	switch tt := typ.(type) {
 	case *unboundFuncParam:
 		if tt.tBound == nil {
-			log.Panicln("TODO: unbound type -", tt.typ.name)
+			panicln("TODO: unbound type -", tt.typ.name)
 		}
 		return tt.tBound, true
 	case *unboundProxyParam:
@@ -601,11 +622,11 @@ func toNormalize(tparams []*unboundFuncParam, typ types.Type) (types.Type, bool)
 		case *types.Struct:
 			panic("TODO: toNormalize struct")
 		default:
-			log.Panicln("TODO: toNormalize - unknown type:", t)
+			panicln("TODO: toNormalize - unknown type:", t)
 		}
 	case *unboundType:
 		if tt.tBound == nil {
-			log.Panicln("TODO: unbound type")
+			panicln("TODO: unbound type")
 		}
 		return tt.tBound, true
 	case *types.Slice:
@@ -753,7 +774,7 @@ func toInstantiate(tparams []*unboundFuncParam, typ types.Type) (types.Type, boo
 		case *types.Struct:
 			panic("TODO: instantiate struct")
 		default:
-			log.Panicln("TODO: toInstantiate - unknown type:", t)
+			panicln("TODO: toInstantiate - unknown type:", t)
 		}
 	case *types.Slice:
 		if elem, ok := toInstantiate(tparams, tt.Elem()); ok {
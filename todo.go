@@ -0,0 +1,91 @@
+/*
+Copyright 2026 The XGo Authors (xgo.dev)
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gogen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"log"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+
+// Todo represents a placeholder emitted by CodeBuilder.Todo.
+type Todo struct {
+	Func *Func     // top-level func containing the placeholder (nil means in global scope)
+	Pos  token.Pos // position of the placeholder
+	End  token.Pos
+	Msg  string
+}
+
+// Todo emits a placeholder `panic("TODO: msg")` for code which isn't
+// implemented yet, and records it in the package (see Package.Todos). If
+// Config.StrictTodo is true, WriteTo/WriteFile fail if any placeholder has
+// been emitted.
+func (p *CodeBuilder) Todo(msg string, src ...ast.Node) *CodeBuilder {
+	if debugInstr {
+		log.Println("Todo", msg)
+	}
+	fn := p.current.fn
+	if fn != nil {
+		fn = fn.Ancestor()
+	}
+	s := getSrc(src)
+	p.pkg.todos = append(p.pkg.todos, Todo{Func: fn, Pos: getSrcPos(s), End: getSrcEnd(s), Msg: msg})
+	return p.Unreachable("TODO: " + msg)
+}
+
+// Todos returns placeholders emitted by CodeBuilder.Todo, in the order they
+// were emitted.
+func (p *Package) Todos() []Todo {
+	return append([]Todo(nil), p.todos...)
+}
+
+// TodoError represents placeholders (see CodeBuilder.Todo) which remain in a
+// package built with Config.StrictTodo.
+type TodoError []error
+
+func (p TodoError) Error() string {
+	msgs := make([]string, len(p)+1)
+	msgs[0] = fmt.Sprintf("%d TODO placeholder(s) in generated code:", len(p))
+	for i, err := range p {
+		msgs[i+1] = "\t" + err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (p *Package) checkTodos() error {
+	if !p.conf.StrictTodo || len(p.todos) == 0 {
+		return nil
+	}
+	return p.todoErrors()
+}
+
+func (p *Package) todoErrors() TodoError {
+	errs := make(TodoError, len(p.todos))
+	for i, todo := range p.todos {
+		name := "global scope"
+		if todo.Func != nil {
+			name = "func " + todo.Func.Name()
+		}
+		errs[i] = p.cb.newCodeErrorf(todo.Pos, todo.End, "TODO: %s (in %s)", todo.Msg, name)
+	}
+	return errs
+}
+
+// ----------------------------------------------------------------------------
@@ -173,6 +173,9 @@ var (
 	TyError          types.Type
 	TyAny            types.Type
 	TyEmptyInterface types.Type
+
+	// TyInvalid is the type of invalid operands (see CodeBuilder.Invalid).
+	TyInvalid types.Type
 )
 
 func init() {
@@ -183,6 +186,7 @@ func init() {
 	TyError = universe.Lookup("error").Type()
 	TyAny = universe.Lookup("any").Type()
 	TyEmptyInterface = types.NewInterfaceType(nil, nil)
+	TyInvalid = types.Typ[types.Invalid]
 }
 
 // ----------------------------------------------------------------------------
@@ -15,6 +15,7 @@ package gogen
 
 import (
 	"go/ast"
+	"go/constant"
 	"go/token"
 	"go/types"
 	"log"
@@ -102,6 +103,15 @@ func (p *TypeDecl) Inited() bool {
 	return p.spec.Type != nil
 }
 
+// SetTypeParams sets type parameters of a uncompleted type before InitType,
+// so that its underlying type can refer to its instances, eg. the field
+// `children []Tree[T]` of `type Tree[T any] struct`. Don't pass tparams to
+// InitType again after SetTypeParams.
+func (p *TypeDecl) SetTypeParams(pkg *Package, tparams ...*TypeParam) *TypeDecl {
+	setTypeParams(pkg, p.typ, p.spec, tparams)
+	return p
+}
+
 // InitType initializes a uncompleted type.
 func (p *TypeDecl) InitType(pkg *Package, typ types.Type, tparams ...*TypeParam) *types.Named {
 	if debugInstr {
@@ -109,7 +119,7 @@ func (p *TypeDecl) InitType(pkg *Package, typ types.Type, tparams ...*TypeParam)
 	}
 	spec := p.spec
 	if spec.Type != nil {
-		log.Panicln("TODO: type already defined -", typ)
+		panicln("TODO: type already defined -", typ)
 	}
 	if named, ok := typ.(*types.Named); ok {
 		p.typ.SetUnderlying(pkg.cb.getUnderlying(named))
@@ -233,6 +243,10 @@ func (p *CodeBuilder) NewTypeDecls() (ret *TypeDefs, defineHere func()) {
 }
 
 func (p *Package) doNewAlias(tdecl *TypeDefs, pos, end token.Pos, name string, typ types.Type, alias token.Pos) types.Type {
+	name, err := p.checkIdent(pos, end, name, "AliasType")
+	if err != nil {
+		panic(err)
+	}
 	scope := tdecl.scope
 	typName := types.NewTypeName(pos, p.Types, name, nil)
 	if old := scope.Insert(typName); old != nil {
@@ -249,6 +263,10 @@ func (p *Package) doNewAlias(tdecl *TypeDefs, pos, end token.Pos, name string, t
 }
 
 func (p *Package) doNewType(tdecl *TypeDefs, pos, end token.Pos, name string, typ types.Type, alias token.Pos) *TypeDecl {
+	name, err := p.checkIdent(pos, end, name, "NewType")
+	if err != nil {
+		panic(err)
+	}
 	scope := tdecl.scope
 	typName := types.NewTypeName(pos, p.Types, name, typ)
 	if old := scope.Insert(typName); old != nil {
@@ -331,6 +349,7 @@ func (p *ValueDecl) endInit(cb *CodeBuilder, arity int) *ValueDecl {
 	var t *types.Tuple
 	var values []ast.Expr
 	n := len(p.names)
+	cb.dispatchByResults(arity)
 	rets := cb.stk.GetArgs(arity)
 	defer func() {
 		cb.stk.PopN(arity)
@@ -343,13 +362,19 @@ func (p *ValueDecl) endInit(cb *CodeBuilder, arity int) *ValueDecl {
 		if n != t.Len() {
 			caller := getCaller(rets[0])
 			cb.panicCodeErrorf(
-				p.pos, p.pos, "assignment mismatch: %d variables but %s returns %d values", n, caller, t.Len())
+				p.pos, p.pos, "assignment mismatch: %s but %s returns %s", plural(n, "variable"), caller, plural(t.Len(), "value"))
 		}
 		*p.vals = []ast.Expr{rets[0].Val}
 		rets = make([]*internal.Elem, n)
 		for i := 0; i < n; i++ {
 			rets[i] = &internal.Elem{Type: t.At(i).Type()}
 		}
+	} else if arity == 1 && n > 1 && isInvalidType(rets[0].Type) { // v1, v2 := <invalid>
+		*p.vals = []ast.Expr{rets[0].Val}
+		rets = make([]*internal.Elem, n)
+		for i := 0; i < n; i++ {
+			rets[i] = &internal.Elem{Type: TyInvalid}
+		}
 	} else if n != arity {
 		if p.tok == token.CONST {
 			if n > arity {
@@ -357,7 +382,7 @@ func (p *ValueDecl) endInit(cb *CodeBuilder, arity int) *ValueDecl {
 			}
 			cb.panicCodeError(p.pos, p.pos, "extra expression in const declaration")
 		}
-		cb.panicCodeErrorf(p.pos, p.pos, "assignment mismatch: %d variables but %d values", n, arity)
+		cb.panicCodeErrorf(p.pos, p.pos, "assignment mismatch: %s but %s", plural(n, "variable"), plural(arity, "value"))
 	} else {
 		values = make([]ast.Expr, arity)
 		for i, ret := range rets {
@@ -427,7 +452,13 @@ type VarDecl = ValueDecl
 
 func (p *Package) newValueDecl(
 	spec ValueAt, scope *types.Scope, pos token.Pos, tok token.Token, typ types.Type, names ...string) *ValueDecl {
+	names = p.checkIdents(pos, names, valueDeclAts[tok])
 	n := len(names)
+	if scope != p.Types.Scope() {
+		for _, name := range names {
+			p.cb.useLocalName(name, pos, pos)
+		}
+	}
 	if tok == token.DEFINE { // a, b := expr
 		noNewVar := true
 		nameIdents := make([]ast.Expr, n)
@@ -477,6 +508,12 @@ func (p *Package) newValueDecl(
 		typ: typ, names: names, tok: tok, pos: pos, scope: scope, vals: &spec.Values, at: spec.at}
 }
 
+var valueDeclAts = map[token.Token]string{
+	token.VAR:    "NewVar",
+	token.CONST:  "NewConst",
+	token.DEFINE: "DefineVarStart",
+}
+
 func (p *Package) newValueDefs(scope *types.Scope, tok token.Token) *valueDefs {
 	at := -1
 	decl := &ast.GenDecl{Tok: tok}
@@ -697,6 +734,22 @@ func (p *ConstDefs) NewAt(at ValueAt, fn F, iotav int, pos token.Pos, typ types.
 	return p
 }
 
+// NewAssert creates a compile-time assertion `const _ = uint(x)`, which fails
+// to compile if x is negative. The integer constant expression x is given by
+// the callback `fn`, eg. `len(s) - 4` asserts that len(s) >= 4.
+func (p *ConstDefs) NewAssert(fn func(cb *CodeBuilder), pos token.Pos) *ConstDefs {
+	return p.New(func(cb *CodeBuilder) int {
+		cb.Typ(types.Typ[types.Uint])
+		fn(cb)
+		if x := cb.Get(-1); x.CVal == nil || x.CVal.Kind() != constant.Int {
+			src, pos, end := cb.loadExpr(x.Src)
+			cb.panicCodeErrorf(pos, end, "%s (type %v) is not an integer constant", src, x.Type)
+		}
+		cb.Call(1)
+		return 1
+	}, 0, pos, nil, "_")
+}
+
 // Next creates constants with specified `names`.
 // The values of the constants are given by the callback `fn` which is
 // specified by the last call to `New`.
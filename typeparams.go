@@ -128,7 +128,9 @@ func (p *CodeBuilder) instantiate(nidx int, args []*internal.Elem, src ...ast.No
 	} else {
 		sig := typ.(*types.Signature)
 		if nidx >= sig.TypeParams().Len() {
-			tyRet, err = types.Instantiate(p.ctxt, typ, targs, true)
+			if err = p.checkInstCycle(sig, targs); err == nil {
+				tyRet, err = types.Instantiate(p.ctxt, typ, targs, true)
+			}
 		} else {
 			tyRet = newInferFuncType(p.pkg, args[0], sig, targs, srcExpr)
 		}
@@ -303,6 +305,124 @@ func InferFunc(pkg *Package, fn *Element, sig *types.Signature, targs []types.Ty
 	return typ, err
 }
 
+// renameTParams gives type parameters of sig new identities if they occur in
+// types of args, ie. in a self-recursive call like `func f[T any](x T) { f(x) }`,
+// so that type inference can tell them from the ones to be inferred. It does
+// nothing if a constraint of sig refers to type parameters of sig.
+func renameTParams(sig *types.Signature, args []*Element) *types.Signature {
+	tp := sig.TypeParams()
+	n := tp.Len()
+	for i := 0; i < n; i++ {
+		if hasTypeParam(tp.At(i).Constraint(), tp) {
+			return sig
+		}
+	}
+	used := false
+	for _, arg := range args {
+		if hasTypeParam(arg.Type, tp) {
+			used = true
+			break
+		}
+	}
+	if !used {
+		return sig
+	}
+	tparams := make([]*types.TypeParam, n)
+	targs := make([]types.Type, n)
+	for i := 0; i < n; i++ {
+		t := tp.At(i)
+		obj := t.Obj()
+		tparams[i] = types.NewTypeParam(types.NewTypeName(obj.Pos(), obj.Pkg(), obj.Name(), nil), t.Constraint())
+		targs[i] = tparams[i]
+	}
+	inst, err := types.Instantiate(nil, sig, targs, false)
+	if err != nil {
+		return sig
+	}
+	t := inst.(*types.Signature)
+	return types.NewSignatureType(t.Recv(), nil, tparams, t.Params(), t.Results(), t.Variadic())
+}
+
+// checkInstCycle reports an error if the generic function sig is instantiated
+// in its own body with a type argument made from its own type parameters, eg.
+// f[[]T] in the body of f[T any], which requires infinite instances.
+func (p *CodeBuilder) checkInstCycle(sig *types.Signature, targs []types.Type) error {
+	for fn := p.current.fn; fn != nil; fn = fn.old.fn {
+		if fn.Type() != sig {
+			continue
+		}
+		tp := sig.TypeParams()
+		for i, targ := range targs {
+			if _, ok := targ.(*types.TypeParam); !ok && hasTypeParam(targ, tp) {
+				return fmt.Errorf("instantiation cycle: %v instantiated as %v", tp.At(i), targ)
+			}
+		}
+		break
+	}
+	return nil
+}
+
+// hasTypeParam reports whether typ refers to any type parameter in tp.
+func hasTypeParam(typ types.Type, tp *types.TypeParamList) bool {
+	switch t := typ.(type) {
+	case *types.TypeParam:
+		for i, n := 0, tp.Len(); i < n; i++ {
+			if tp.At(i) == t {
+				return true
+			}
+		}
+	case *types.Pointer:
+		return hasTypeParam(t.Elem(), tp)
+	case *types.Slice:
+		return hasTypeParam(t.Elem(), tp)
+	case *types.Array:
+		return hasTypeParam(t.Elem(), tp)
+	case *types.Chan:
+		return hasTypeParam(t.Elem(), tp)
+	case *types.Map:
+		return hasTypeParam(t.Key(), tp) || hasTypeParam(t.Elem(), tp)
+	case *types.Tuple:
+		for i, n := 0, t.Len(); i < n; i++ {
+			if hasTypeParam(t.At(i).Type(), tp) {
+				return true
+			}
+		}
+	case *types.Signature:
+		return hasTypeParam(t.Params(), tp) || hasTypeParam(t.Results(), tp)
+	case *types.Struct:
+		for i, n := 0, t.NumFields(); i < n; i++ {
+			if hasTypeParam(t.Field(i).Type(), tp) {
+				return true
+			}
+		}
+	case *types.Interface:
+		for i, n := 0, t.NumEmbeddeds(); i < n; i++ {
+			if hasTypeParam(t.EmbeddedType(i), tp) {
+				return true
+			}
+		}
+		for i, n := 0, t.NumExplicitMethods(); i < n; i++ {
+			if hasTypeParam(t.ExplicitMethod(i).Type(), tp) {
+				return true
+			}
+		}
+	case *types.Union:
+		for i, n := 0, t.Len(); i < n; i++ {
+			if hasTypeParam(t.Term(i).Type(), tp) {
+				return true
+			}
+		}
+	case *types.Named:
+		targs := t.TypeArgs()
+		for i, n := 0, targs.Len(); i < n; i++ {
+			if hasTypeParam(targs.At(i), tp) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func inferFuncTargs(pkg *Package, fn *internal.Elem, sig *types.Signature, targs []types.Type) (types.Type, error) {
 	tp := sig.TypeParams()
 	n := tp.Len()
@@ -322,18 +442,34 @@ func toFieldListX(pkg *Package, t *types.TypeParamList) *ast.FieldList {
 		return nil
 	}
 	n := t.Len()
-	flds := make([]*ast.Field, n)
+	tparams := make([]*types.TypeParam, n)
 	for i := 0; i < n; i++ {
-		item := t.At(i)
-		names := []*ast.Ident{ast.NewIdent(item.Obj().Name())}
-		typ := toType(pkg, item.Constraint())
-		flds[i] = &ast.Field{Names: names, Type: typ}
+		tparams[i] = t.At(i)
 	}
 	return &ast.FieldList{
-		List: flds,
+		List: toTypeParamFields(pkg, tparams),
 	}
 }
 
+// toTypeParamFields converts tparams to fields of a type parameter list.
+// Adjacent type parameters with identical constraints are grouped into one
+// field, eg. `[K comparable, V, W any]`.
+func toTypeParamFields(pkg *Package, tparams []*types.TypeParam) []*ast.Field {
+	var flds []*ast.Field
+	var last types.Type
+	for _, item := range tparams {
+		name := ast.NewIdent(item.Obj().Name())
+		constraint := item.Constraint()
+		if n := len(flds); n > 0 && types.Identical(last, constraint) {
+			flds[n-1].Names = append(flds[n-1].Names, name)
+			continue
+		}
+		flds = append(flds, &ast.Field{Names: []*ast.Ident{name}, Type: toType(pkg, constraint)})
+		last = constraint
+	}
+	return flds
+}
+
 func toFuncType(pkg *Package, sig *types.Signature) *ast.FuncType {
 	params := toFieldList(pkg, sig.Params())
 	results := toFieldList(pkg, sig.Results())
@@ -386,14 +522,7 @@ func setTypeParams(pkg *Package, typ *types.Named, spec *ast.TypeSpec, tparams [
 		spec.TypeParams = nil
 		return
 	}
-	flds := make([]*ast.Field, n)
-	for i := 0; i < n; i++ {
-		item := tparams[i]
-		names := []*ast.Ident{ast.NewIdent(item.Obj().Name())}
-		typ := toType(pkg, item.Constraint())
-		flds[i] = &ast.Field{Names: names, Type: typ}
-	}
-	spec.TypeParams = &ast.FieldList{List: flds}
+	spec.TypeParams = &ast.FieldList{List: toTypeParamFields(pkg, tparams)}
 }
 
 func interfaceIsImplicit(t *types.Interface) bool {
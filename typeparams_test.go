@@ -902,7 +902,7 @@ func Add[T1 any, T2 ~int](v1 T1, v2 ...T2) (sum T2) {
 	fnAdd := pkgRef.Ref("Add")
 
 	// not pass source to foo.Add
-	codeErrorTestEx(t, pkg, `./foo.gop:5:40: cannot infer T2 (-)`,
+	codeErrorTestEx(t, pkg, `./foo.gop:5:40: cannot infer T2 (foo.go:3:18)`,
 		func(pkg *gogen.Package) {
 			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
 				Val(fnAdd).Val(1).CallWith(1, 0, source("foo.Add(1)", 5, 40)).EndStmt().
@@ -1156,6 +1156,126 @@ func main() {
 `)
 }
 
+func TestGenTypeParamsGrouped(t *testing.T) {
+	pkg := newMainPackage()
+	tyAny := types.Universe.Lookup("any").Type()
+	tyComparable := types.Universe.Lookup("comparable").Type()
+	ut := types.NewUnion([]*types.Term{types.NewTerm(true, types.Typ[types.Int]), types.NewTerm(true, types.Typ[types.Float64])})
+	number := pkg.NewType("Number").InitType(pkg, types.NewInterfaceType(nil, []types.Type{ut}))
+	newTP := func(name string, constraint types.Type) *types.TypeParam {
+		return types.NewTypeParam(types.NewTypeName(token.NoPos, pkg.Types, name, nil), constraint)
+	}
+
+	k, v := newTP("K", tyComparable), newTP("V", tyAny)
+	m := types.NewParam(token.NoPos, pkg.Types, "m", types.NewMap(k, v))
+	sigF := types.NewSignatureType(nil, nil, []*types.TypeParam{k, v}, types.NewTuple(m), nil, false)
+	fnF := pkg.NewFuncDecl(token.NoPos, "F", sigF)
+	fnF.BodyStart(pkg).End()
+	if tp := sigF.TypeParams().At(0); tp.Constraint() != tyComparable {
+		t.Fatal("TestGenTypeParamsGrouped: unexpected constraint", tp.Constraint())
+	}
+
+	k2, v2 := newTP("K", tyAny), newTP("V", tyAny)
+	sigG := types.NewSignatureType(nil, nil, []*types.TypeParam{k2, v2},
+		types.NewTuple(types.NewParam(token.NoPos, pkg.Types, "k", k2), types.NewParam(token.NoPos, pkg.Types, "v", v2)), nil, false)
+	pkg.NewFuncDecl(token.NoPos, "G", sigG).BodyStart(pkg).End()
+
+	a, b, c := newTP("A", number), newTP("B", number), newTP("C", tyAny)
+	sigH := types.NewSignatureType(nil, nil, []*types.TypeParam{a, b, c},
+		types.NewTuple(types.NewParam(token.NoPos, pkg.Types, "a", a), types.NewParam(token.NoPos, pkg.Types, "b", b),
+			types.NewParam(token.NoPos, pkg.Types, "c", c)), nil, false)
+	pkg.NewFuncDecl(token.NoPos, "H", sigH).BodyStart(pkg).End()
+
+	pk, pv := newTP("K", tyAny), newTP("V", tyAny)
+	pkg.NewType("Pair").InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "Key", pk, false),
+		types.NewField(token.NoPos, pkg.Types, "Val", pv, false),
+	}, nil), pk, pv)
+
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		Val(fnF).Typ(types.NewMap(types.Typ[types.String], types.Typ[types.Int])).Val(nil).Call(1).Call(1).EndStmt().
+		End()
+
+	domTest(t, pkg, `package main
+
+type Number interface {
+	~int | ~float64
+}
+
+func F[K comparable, V any](m map[K]V) {
+}
+func G[K, V any](k K, v V) {
+}
+func H[A, B Number, C any](a A, b B, c C) {
+}
+
+type Pair[K, V any] struct {
+	Key K
+	Val V
+}
+
+func main() {
+	F(map[string]int(nil))
+}
+`)
+}
+
+func TestTypeParamsConstraintMethod(t *testing.T) {
+	pkg := newMainPackage()
+	fmt := pkg.Import("fmt")
+	tyString := types.Typ[types.String]
+	tp := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg.Types, "T", nil), fmt.Ref("Stringer").Type())
+	x := types.NewParam(token.NoPos, pkg.Types, "x", tp)
+	ret := types.NewParam(token.NoPos, pkg.Types, "", tyString)
+	sig := types.NewSignatureType(nil, nil, []*types.TypeParam{tp}, types.NewTuple(x), types.NewTuple(ret), false)
+	cb := pkg.NewFuncDecl(token.NoPos, "str", sig).BodyStart(pkg).Val(x).MemberVal("String")
+	if typ := cb.Get(-1).Type.String(); typ != "func() string" {
+		t.Fatal("TestTypeParamsConstraintMethod: unexpected type", typ)
+	}
+	cb.Call(0).Return(1).End()
+
+	// type Number interface { ~int | ~float64; String() string }
+	ut := types.NewUnion([]*types.Term{types.NewTerm(true, types.Typ[types.Int]), types.NewTerm(true, types.Typ[types.Float64])})
+	mString := types.NewFunc(token.NoPos, pkg.Types, "String", types.NewSignatureType(nil, nil, nil, nil, types.NewTuple(ret), false))
+	number := pkg.NewType("Number").InitType(pkg, types.NewInterfaceType([]*types.Func{mString}, []types.Type{ut}))
+	tp2 := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg.Types, "N", nil), number)
+	n := types.NewParam(token.NoPos, pkg.Types, "n", tp2)
+	sig2 := types.NewSignatureType(nil, nil, []*types.TypeParam{tp2}, types.NewTuple(n), types.NewTuple(ret), false)
+	pkg.NewFuncDecl(token.NoPos, "describe", sig2).BodyStart(pkg).
+		Val(n).MemberVal("String").Call(0).Val(" ").BinaryOp(token.ADD).
+		Val(fmt.Ref("Sprint")).Val(n).Call(1).BinaryOp(token.ADD).
+		Return(1).End()
+	domTest(t, pkg, `package main
+
+import "fmt"
+
+func str[T fmt.Stringer](x T) string {
+	return x.String()
+}
+
+type Number interface {
+	~int | ~float64
+	String() string
+}
+
+func describe[N Number](n N) string {
+	return n.String() + " " + fmt.Sprint(n)
+}
+`)
+}
+
+func TestTypeParamsErrConstraintMethod(t *testing.T) {
+	pkg := newMainPackage()
+	tp := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg.Types, "T", nil), types.Universe.Lookup("any").Type())
+	x := types.NewParam(token.NoPos, pkg.Types, "x", tp)
+	sig := types.NewSignatureType(nil, nil, []*types.TypeParam{tp}, types.NewTuple(x), nil, false)
+	codeErrorTestEx(t, pkg, "./foo.gop:2:9: x.String undefined (type T has no field or method String)", func(pkg *gogen.Package) {
+		pkg.NewFuncDecl(token.NoPos, "str", sig).BodyStart(pkg).
+			Val(x, source("x", 2, 9)).MemberVal("String", source("x.String", 2, 9)).EndStmt().
+			End()
+	})
+}
+
 func TestTypeParamsArgumentsSignature(t *testing.T) {
 	const src = `package foo
 
@@ -1279,3 +1399,215 @@ func main() {
 }
 `)
 }
+
+func TestRecursiveGenerics(t *testing.T) {
+	pkg := newMainPackage()
+	anyT := types.Universe.Lookup("any").Type()
+	newT := func() *types.TypeParam {
+		return types.NewTypeParam(types.NewTypeName(token.NoPos, pkg.Types, "T", nil), anyT)
+	}
+
+	// type Tree[T any] struct { val T; children []Tree[T] }
+	tp := newT()
+	decl := pkg.NewType("Tree").SetTypeParams(pkg, tp)
+	tree := decl.Type()
+	inst, err := types.Instantiate(nil, tree, []types.Type{tp}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decl.InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "val", tp, false),
+		types.NewField(token.NoPos, pkg.Types, "children", types.NewSlice(inst), false)}, nil))
+
+	// type A[T any] struct { b *B[T] }; type B[T any] struct { a *A[T] }
+	ta, tb := newT(), newT()
+	defs := pkg.NewTypeDefs()
+	da := defs.NewType("A").SetTypeParams(pkg, ta)
+	db := defs.NewType("B").SetTypeParams(pkg, tb)
+	ib, _ := types.Instantiate(nil, db.Type(), []types.Type{ta}, true)
+	ia, _ := types.Instantiate(nil, da.Type(), []types.Type{tb}, true)
+	da.InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "b", types.NewPointer(ib), false)}, nil))
+	db.InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "a", types.NewPointer(ia), false)}, nil))
+
+	// func Walk[T any](t Tree[T])
+	tw := newT()
+	itw, _ := types.Instantiate(nil, tree, []types.Type{tw}, true)
+	param := types.NewParam(token.NoPos, pkg.Types, "t", itw)
+	sig := types.NewSignatureType(nil, nil, []*types.TypeParam{tw}, types.NewTuple(param), nil, false)
+	walk := pkg.NewFuncDecl(token.NoPos, "Walk", sig)
+	walk.BodyStart(pkg).
+		ForRange("_", "c").Val(param).MemberVal("children").RangeAssignThen(token.NoPos).
+		Val(walk).Typ(tw).Index(1, false).VarVal("c").Call(1).EndStmt().
+		Val(walk).VarVal("c").Call(1).EndStmt().
+		End().
+		End()
+	domTest(t, pkg, `package main
+
+type Tree[T any] struct {
+	val      T
+	children []Tree[T]
+}
+type (
+	A[T any] struct {
+		b *B[T]
+	}
+	B[T any] struct {
+		a *A[T]
+	}
+)
+
+func Walk[T any](t Tree[T]) {
+	for _, c := range t.children {
+		Walk[T](c)
+		Walk(c)
+	}
+}
+`)
+}
+
+func TestErrInstantiationCycle(t *testing.T) {
+	newWalk := func(pkg *gogen.Package) (*gogen.Func, *types.TypeParam, *types.Named) {
+		anyT := types.Universe.Lookup("any").Type()
+		tp := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg.Types, "T", nil), anyT)
+		decl := pkg.NewType("Tree").SetTypeParams(pkg, tp)
+		decl.InitType(pkg, types.NewStruct([]*types.Var{
+			types.NewField(token.NoPos, pkg.Types, "val", tp, false)}, nil))
+		tw := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg.Types, "T", nil), anyT)
+		itw, _ := types.Instantiate(nil, decl.Type(), []types.Type{tw}, true)
+		param := types.NewParam(token.NoPos, pkg.Types, "t", itw)
+		sig := types.NewSignatureType(nil, nil, []*types.TypeParam{tw}, types.NewTuple(param), nil, false)
+		return pkg.NewFuncDecl(token.NoPos, "Walk", sig), tw, decl.Type()
+	}
+	codeErrorTest(t, "./foo.gop:2:3: instantiation cycle: T instantiated as []T",
+		func(pkg *gogen.Package) {
+			walk, tw, _ := newWalk(pkg)
+			walk.BodyStart(pkg).
+				Val(walk).Typ(types.NewSlice(tw)).Index(1, false, source("Walk[[]T]", 2, 3)).
+				End()
+		})
+	codeErrorTest(t, "./foo.gop:2:3: instantiation cycle: T instantiated as []T",
+		func(pkg *gogen.Package) {
+			walk, tw, tree := newWalk(pkg)
+			inst, _ := types.Instantiate(nil, tree, []types.Type{types.NewSlice(tw)}, true)
+			walk.BodyStart(pkg).
+				Val(walk).ZeroLit(inst).CallWith(1, 0, source("Walk(Tree[[]T]{})", 2, 3)).EndStmt().
+				End()
+		})
+}
+
+func TestImplementStubGeneric(t *testing.T) {
+	pkg := newMainPackage()
+	anyT := types.Universe.Lookup("any").Type()
+	tp := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg.Types, "T", nil), anyT)
+	sig := types.NewSignatureType(nil, nil, nil, nil, types.NewTuple(types.NewParam(token.NoPos, pkg.Types, "", tp)), false)
+	get := types.NewFunc(token.NoPos, pkg.Types, "Get", sig)
+	writerTo := pkg.Import("io").Ref("WriterTo").Type()
+	getter := pkg.NewType("Getter").InitType(pkg, types.NewInterfaceType([]*types.Func{get}, []types.Type{writerTo}), tp)
+
+	impl := pkg.NewType("Impl").InitType(pkg, types.NewStruct(nil, nil))
+	buf := types.NewPointer(pkg.Import("bytes").Ref("Buffer").Type())
+	inst := pkg.Instantiate(getter, []types.Type{buf})
+	pkg.ImplementStub(impl, inst.Underlying().(*types.Interface), true)
+
+	tb := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg.Types, "T", nil), anyT)
+	box := pkg.NewType("Box").InitType(pkg, types.NewStruct(nil, nil), tb)
+	inst = pkg.Instantiate(getter, []types.Type{tb})
+	pkg.ImplementStub(box, inst.Underlying().(*types.Interface), false)
+	domTest(t, pkg, `package main
+
+import (
+	"bytes"
+	"io"
+)
+
+type Getter[T any] interface {
+	io.WriterTo
+	Get() T
+}
+type Impl struct {
+}
+
+// TODO: implement Get.
+func (i *Impl) Get() *bytes.Buffer {
+	panic("not implemented")
+}
+
+// TODO: implement WriteTo.
+func (i *Impl) WriteTo(w io.Writer) (n int64, err error) {
+	panic("not implemented")
+}
+
+type Box[T any] struct {
+}
+
+// TODO: implement Get.
+func (b Box[T]) Get() T {
+	panic("not implemented")
+}
+
+// TODO: implement WriteTo.
+func (b Box[T]) WriteTo(w io.Writer) (n int64, err error) {
+	panic("not implemented")
+}
+`)
+}
+
+func TestGenericStructLit(t *testing.T) {
+	pkg := newMainPackage()
+	anyT := types.Universe.Lookup("any").Type()
+	tp := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg.Types, "T", nil), anyT)
+	box := pkg.NewType("Box").InitType(pkg, types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, pkg.Types, "value", tp, false),
+		types.NewField(token.NoPos, pkg.Types, "next", types.NewPointer(tp), false)}, nil), tp)
+
+	// func New[T any](v T) Box[T]
+	tn := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg.Types, "T", nil), anyT)
+	ret := pkg.Instantiate(box, []types.Type{tn})
+	v := types.NewParam(token.NoPos, pkg.Types, "v", tn)
+	sig := types.NewSignatureType(nil, nil, []*types.TypeParam{tn}, types.NewTuple(v),
+		types.NewTuple(types.NewParam(token.NoPos, pkg.Types, "", ret)), false)
+	pkg.NewFuncDecl(token.NoPos, "New", sig).BodyStart(pkg).
+		Val(v).UnaryOp(token.AND).StructLitByName(ret, []string{"next"}).Return(1).
+		End()
+
+	inst := pkg.Instantiate(box, []types.Type{types.Typ[types.Int]})
+	pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+		DefineVarStart(token.NoPos, "a").Val(0).Val(3).StructLit(inst, 2, true).EndInit(1).
+		DefineVarStart(token.NoPos, "b").Val(3).Val(nil).StructLit(inst, 2, false).EndInit(1).
+		DefineVarStart(token.NoPos, "c").Val(ctxRef(pkg, "a")).MemberVal("value").
+		Val(ctxRef(pkg, "b")).MemberVal("value").UnaryOp(token.AND).
+		StructLitByName(inst, []string{"value", "next"}).EndInit(1).
+		End()
+	domTest(t, pkg, `package main
+
+type Box[T any] struct {
+	value T
+	next  *T
+}
+
+func New[T any](v T) Box[T] {
+	return Box[T]{next: &v}
+}
+func main() {
+	a := Box[int]{value: 3}
+	b := Box[int]{3, nil}
+	c := Box[int]{value: a.value, next: &b.value}
+}
+`)
+}
+
+func TestErrGenericStructLit(t *testing.T) {
+	codeErrorTest(t, `./foo.gop:1:15: cannot use "x" (type untyped string) as type int in value of field value`,
+		func(pkg *gogen.Package) {
+			tp := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg.Types, "T", nil), types.Universe.Lookup("any").Type())
+			box := pkg.NewType("Box").InitType(pkg, types.NewStruct([]*types.Var{
+				types.NewField(token.NoPos, pkg.Types, "value", tp, false)}, nil), tp)
+			inst := pkg.Instantiate(box, []types.Type{types.Typ[types.Int]})
+			pkg.NewFunc(nil, "main", nil, nil, false).BodyStart(pkg).
+				NewVarStart(inst, "a").
+				Val(0).Val("x", source(`"x"`, 1, 15)).StructLit(inst, 2, true).EndInit(1).
+				End()
+		})
+}